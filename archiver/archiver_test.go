@@ -220,3 +220,38 @@ func (c *Logger) Fatalf(format string, v ...interface{}) {
 	str := fmt.Sprintf(format, v...)
 	fmt.Printf("\n%v ts-archiver.Logger.Fatalf(): %s", time.Now(), str)
 }
+
+func Test004ArchiverReorderSkipsBadRequests(t *testing.T) {
+
+	cv.Convey("under -reorder-lateness, a request that does not parse should be refused whole, and a good one held until it is stored", t, func() {
+		tmp, err := ioutil.TempDir("", "test-archiver-reorder")
+		panicOn(err)
+		defer os.RemoveAll(tmp)
+		fm := NewFileMgr(&ArchiverConfig{WriteDir: tmp, Lateness: 10 * time.Second})
+
+		tm1, err := time.Parse(time.RFC3339, "2016-01-01T00:00:00Z")
+		panicOn(err)
+		frame1, err := ts.NewFrame(tm1, ts.EvUtf8, 0, 0, []byte("data1"))
+		panicOn(err)
+		by1, err := frame1.Marshal(nil)
+		panicOn(err)
+
+		err = fm.StoreReordered("test", append(append([]byte{}, by1...), 1, 2, 3), "reply", "id1")
+		_, bad := err.(*BadRequestError)
+		cv.So(bad, cv.ShouldBeTrue)
+		cv.So(len(fm.acks), cv.ShouldEqual, 0)
+		cv.So(len(fm.reorder), cv.ShouldEqual, 0)
+
+		panicOn(fm.StoreReordered("test", by1, "reply", "id2"))
+		cv.So(len(fm.acks), cv.ShouldEqual, 1)
+		fm.FlushReordered()
+		cv.So(len(fm.acks), cv.ShouldEqual, 0)
+		fm.SyncAndCloseAllFiles()
+
+		// quiet streams are released a quarter of the lateness on,
+		// and at least every minute.
+		cv.So(fm.releaseEvery(), cv.ShouldEqual, 2500*time.Millisecond)
+		fm.Cfg.Lateness = time.Hour
+		cv.So(fm.releaseEvery(), cv.ShouldEqual, time.Minute)
+	})
+}
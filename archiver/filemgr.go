@@ -3,6 +3,7 @@ package archiver
 import (
 	"encoding/binary"
 	"fmt"
+	tf "github.com/glycerine/tmframe"
 	"github.com/nats-io/nats"
 	"io"
 	"os"
@@ -69,6 +70,25 @@ type FileMgr struct {
 	opts []nats.Option
 
 	certs certConfig
+
+	// per stream reorder buffers, when Cfg.Lateness > 0,
+	// and the wall clock time each last saw a frame.
+	reorder     map[string]*tf.Reorderer
+	reorderLast map[string]time.Time
+
+	// acks holds, for each frame still in a reorder buffer, the
+	// archive request it came in on. The request is acked only
+	// once all its frames have been stored.
+	acks map[*tf.Frame]*pendingAck
+}
+
+// pendingAck is an archive request waiting on
+// left frames to leave the reorder buffer.
+type pendingAck struct {
+	stream string
+	reply  string
+	id     string
+	left   int
 }
 
 func NewFileMgr(cfg *ArchiverConfig) *FileMgr {
@@ -84,6 +104,9 @@ func NewFileMgr(cfg *ArchiverConfig) *FileMgr {
 		ArchiveReqCh:      make(chan *nats.Msg),
 		SignalInterruptCh: make(chan os.Signal, 5),
 		NatsAsyncErrCh:    make(chan asyncErr),
+		reorder:           make(map[string]*tf.Reorderer),
+		reorderLast:       make(map[string]time.Time),
+		acks:              make(map[*tf.Frame]*pendingAck),
 	}
 	fm.certs.init(cfg.TlsDir)
 	signal.Notify(fm.SignalInterruptCh, os.Interrupt)
@@ -92,9 +115,7 @@ func NewFileMgr(cfg *ArchiverConfig) *FileMgr {
 
 // provides index into File map
 func (fm *FileMgr) GetDateNameString(tm time.Time, streamName string) (string, Date) {
-	date := TimeToDate(tm)
-	path := path.Join(fmt.Sprintf("%04d", date.Year), fmt.Sprintf("%02d", date.Month), fmt.Sprintf("%02d", date.Day), streamName)
-	return path, date
+	return tf.DateNameString(tm, streamName), TimeToDate(tm)
 }
 
 func (fm *FileMgr) GetPath(tm time.Time, streamName string) string {
@@ -208,6 +229,168 @@ writeloop:
 	return f, nil
 }
 
+func (fm *FileMgr) reorderer(streamName string) *tf.Reorderer {
+	ro, ok := fm.reorder[streamName]
+	if !ok {
+		ro = tf.NewReorderer(fm.Cfg.Lateness, 0)
+		ro.Late = func(f *tf.Frame) {
+			panicOn(fm.StoreFrame(streamName+".late", f))
+			fm.stored(f)
+		}
+		fm.reorder[streamName] = ro
+	}
+	return ro
+}
+
+// StoreFrame stores a single frame for streamName, filed by its timestamp.
+func (fm *FileMgr) StoreFrame(streamName string, f *tf.Frame) error {
+	by, err := f.Marshal(nil)
+	if err != nil {
+		return err
+	}
+	_, err = fm.Store(f.TmTime(), streamName, by)
+	return err
+}
+
+// BadRequestError is returned by StoreReordered for a
+// request whose data is not a series of TMFRAMEs.
+type BadRequestError struct {
+	Stream string
+	Err    error
+}
+
+func (e *BadRequestError) Error() string {
+	return fmt.Sprintf("FileMgr.StoreReordered() error parsing frame for stream '%s': '%s'", e.Stream, e.Err)
+}
+
+// StoreReordered parses the frames in data and passes them through
+// the reorder buffer for streamName, storing any frames that it
+// releases. Frames arriving more than Cfg.Lateness late are stored
+// under streamName + ".late".
+//
+// If reply is not empty, the request is acked, as Run acks stored
+// requests, once the last of its frames has been stored, which may
+// be up to Cfg.Lateness later. Until then, nothing about the request
+// has been acked, so a sender that resends unacked requests loses
+// nothing if the archiver stops before it stores them.
+//
+// If data does not parse, none of it is stored, and the error is
+// a *BadRequestError.
+func (fm *FileMgr) StoreReordered(streamName string, data []byte, reply string, id string) error {
+	var frames []*tf.Frame
+	for len(data) > 0 {
+		f := &tf.Frame{}
+		rest, err := f.Unmarshal(data, true)
+		if err != nil {
+			return &BadRequestError{Stream: streamName, Err: err}
+		}
+		data = rest
+		frames = append(frames, f)
+	}
+	ro := fm.reorderer(streamName)
+	fm.reorderLast[streamName] = time.Now()
+	if reply != "" {
+		if len(frames) == 0 {
+			return fm.ack(streamName, reply, id)
+		}
+		pa := &pendingAck{stream: streamName, reply: reply, id: id, left: len(frames)}
+		for _, f := range frames {
+			fm.acks[f] = pa
+		}
+	}
+	for _, f := range frames {
+		for _, g := range ro.Add(f) {
+			err := fm.storeReleased(streamName, g)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// storeReleased stores f, released by the reorder buffer of
+// streamName, and acks its request if it was the last one held.
+func (fm *FileMgr) storeReleased(streamName string, f *tf.Frame) error {
+	err := fm.StoreFrame(streamName, f)
+	if err != nil {
+		return err
+	}
+	fm.stored(f)
+	return nil
+}
+
+// stored notes that f has been stored, and acks
+// its request if f was the last frame it waited on.
+func (fm *FileMgr) stored(f *tf.Frame) {
+	pa, ok := fm.acks[f]
+	if !ok {
+		return
+	}
+	delete(fm.acks, f)
+	pa.left--
+	if pa.left == 0 {
+		panicOn(fm.ack(pa.stream, pa.reply, pa.id))
+	}
+}
+
+// ack tells the sender, on reply, and any listeners, on the
+// storage-ack subject of streamName, that request id is stored.
+func (fm *FileMgr) ack(streamName string, reply string, id string) error {
+	if fm.nc == nil {
+		return nil
+	}
+	err := fm.nc.Publish(reply, []byte(id))
+	p("FileMgr published to msg.Reply:'%s' with id '%s'",
+		reply, id)
+	if err != nil {
+		return err
+	}
+	err = fm.nc.Publish(ServiceName+".storage-ack."+streamName, []byte(id))
+	p("FileMgr published to ack.%s with id '%s'",
+		streamName, id)
+	return err
+}
+
+// ReleaseReordered stores all the frames held for any stream that
+// has received nothing for longer than Cfg.Lateness of wall clock
+// time. Such quiet streams would otherwise hold their last frames
+// until more input arrives. Wall clock time is used, rather than
+// frame timestamps, so replays of old data are not all marked late.
+func (fm *FileMgr) ReleaseReordered(now time.Time) {
+	for streamName, ro := range fm.reorder {
+		if now.Sub(fm.reorderLast[streamName]) <= fm.Cfg.Lateness {
+			continue
+		}
+		for _, f := range ro.Flush() {
+			panicOn(fm.storeReleased(streamName, f))
+		}
+	}
+}
+
+// releaseEvery is how often Run calls ReleaseReordered: a quarter
+// of Cfg.Lateness, but at least every minute, and at most every
+// 10 milliseconds.
+func (fm *FileMgr) releaseEvery() time.Duration {
+	d := fm.Cfg.Lateness / 4
+	if d > time.Minute {
+		d = time.Minute
+	}
+	if d < 10*time.Millisecond {
+		d = 10 * time.Millisecond
+	}
+	return d
+}
+
+// FlushReordered stores every frame still held for reordering.
+func (fm *FileMgr) FlushReordered() {
+	for streamName, ro := range fm.reorder {
+		for _, f := range ro.Flush() {
+			panicOn(fm.storeReleased(streamName, f))
+		}
+	}
+}
+
 // sync all open files
 func (fm *FileMgr) Sync() {
 	for _, v := range fm.Files {
@@ -293,6 +476,12 @@ func (fm *FileMgr) Run() error {
 	minuteTimer := time.After(time.Minute)
 	hourTimer := time.After(time.Hour)
 
+	// releaseTimer stays nil, and never fires, unless we reorder.
+	var releaseTimer <-chan time.Time
+	if fm.Cfg.Lateness > 0 {
+		releaseTimer = time.After(fm.releaseEvery())
+	}
+
 	i := 0
 
 	// poll and dispatch archive requests
@@ -320,6 +509,12 @@ func (fm *FileMgr) Run() error {
 			fm.Sync()
 			minuteTimer = time.After(time.Minute)
 
+		case <-releaseTimer:
+			// drain the reorder buffers of quiet streams, so
+			// their requests are acked soon after Cfg.Lateness.
+			fm.ReleaseReordered(time.Now())
+			releaseTimer = time.After(fm.releaseEvery())
+
 		case <-hourTimer:
 			// Guarantee that files unaccessed for more than two hours are closed.
 			// Keeps file handle count from growing too large.
@@ -367,20 +562,25 @@ func (fm *FileMgr) Run() error {
 				tm = time.Now()
 			}
 
+			if fm.Cfg.Lateness > 0 {
+				// acked by StoreReordered, once stored.
+				err = fm.StoreReordered(streamName, msg.Data, msg.Reply, id)
+				if _, bad := err.(*BadRequestError); bad {
+					// left unacked; one bad request must not
+					// stop the archiver.
+					p("FileMgr skipping request id '%s': %s", id, err)
+					continue
+				}
+				panicOn(err)
+				continue
+			}
 			_, err = fm.Store(tm, streamName, msg.Data)
 			panicOn(err)
 			q("id=%v", id)
 			// ack
 
 			if len(msg.Reply) > 0 {
-				err = fm.nc.Publish(msg.Reply, []byte(id))
-				p("FileMgr published to msg.Reply:'%s' with id '%s'",
-					msg.Reply, id)
-				panicOn(err)
-				err = fm.nc.Publish(ServiceName+".storage-ack."+streamName, []byte(id))
-				p("FileMgr published to ack.%s with id '%s'",
-					streamName, id)
-				panicOn(err)
+				panicOn(fm.ack(streamName, msg.Reply, id))
 			}
 		}
 
@@ -391,6 +591,10 @@ func (fm *FileMgr) Run() error {
 func (fm *FileMgr) shutdown() error {
 	err := fm.subscription.Unsubscribe()
 
+	// store, and ack, what the reorder buffers
+	// hold while we can still publish the acks.
+	fm.FlushReordered()
+
 	// avoid deadlock on the nats close callback
 	go func() { <-fm.NatsConnClosedCh }()
 
@@ -0,0 +1,321 @@
+package tm
+
+import (
+	"fmt"
+	"time"
+)
+
+// Boolean series follow the convention in the TMFRAME spec:
+// a PtiZero frame is false, and a PtiNull frame is true.
+// Any other frame, PtiNA included, is an unknown value.
+//
+// The boolean algebra here uses in-force (step) semantics: a
+// series holds the value of its most recent frame at or before
+// any given time, and is unknown before its first frame.
+// Unknowns combine under Kleene's three-valued logic, so
+// false AND unknown is false, true OR unknown is true, and
+// other combinations involving unknown stay unknown (and are
+// written as PtiNA frames).
+
+// Tribool is a three valued boolean: BoolFalse, BoolTrue, or BoolNA.
+type Tribool int8
+
+const (
+	BoolFalse Tribool = 0
+	BoolTrue  Tribool = 1
+	BoolNA    Tribool = -1
+)
+
+// String stringifies the Tribool, for printing.
+func (b Tribool) String() string {
+	switch b {
+	case BoolFalse:
+		return "false"
+	case BoolTrue:
+		return "true"
+	case BoolNA:
+		return "NA"
+	}
+	return fmt.Sprintf("Tribool.%d", int(b))
+}
+
+// GetBool reads f as a boolean: PtiNull is true,
+// PtiZero is false, and everything else is BoolNA.
+func (f *Frame) GetBool() Tribool {
+	switch f.GetPTI() {
+	case PtiNull:
+		return BoolTrue
+	case PtiZero:
+		return BoolFalse
+	}
+	return BoolNA
+}
+
+// NewBoolFrame makes a primary-word-only frame at tm holding b:
+// PtiNull for true, PtiZero for false, and PtiNA for BoolNA.
+func NewBoolFrame(tm int64, b Tribool) *Frame {
+	pti := PtiNA
+	switch b {
+	case BoolTrue:
+		pti = PtiNull
+	case BoolFalse:
+		pti = PtiZero
+	}
+	return &Frame{Prim: (tm &^ 7) | int64(pti)}
+}
+
+// BoolFromPredicate turns a numeric series into a boolean one,
+// evaluating pred on the value of each frame (see FrameValue).
+// Frames without a numeric value become BoolNA. Only changes of
+// value are written, so the result is the minimal step series.
+func BoolFromPredicate(s *Series, pred func(v float64) bool) *Series {
+	res := make([]*Frame, 0)
+	last := Tribool(-2)
+	for _, f := range s.Frames {
+		b := BoolNA
+		v, ok := FrameValue(f)
+		if ok {
+			if pred(v) {
+				b = BoolTrue
+			} else {
+				b = BoolFalse
+			}
+		}
+		if b != last {
+			res = append(res, NewBoolFrame(f.Tm(), b))
+			last = b
+		}
+	}
+	return NewSeriesFromFrames(res)
+}
+
+// BoolOp names a binary boolean operation.
+type BoolOp int
+
+const (
+	OpAnd BoolOp = 0
+	OpOr  BoolOp = 1
+	OpXor BoolOp = 2
+)
+
+func (op BoolOp) apply(a, b Tribool) Tribool {
+	switch op {
+	case OpAnd:
+		if a == BoolFalse || b == BoolFalse {
+			return BoolFalse
+		}
+		if a == BoolNA || b == BoolNA {
+			return BoolNA
+		}
+		return BoolTrue
+	case OpOr:
+		if a == BoolTrue || b == BoolTrue {
+			return BoolTrue
+		}
+		if a == BoolNA || b == BoolNA {
+			return BoolNA
+		}
+		return BoolFalse
+	case OpXor:
+		if a == BoolNA || b == BoolNA {
+			return BoolNA
+		}
+		if a != b {
+			return BoolTrue
+		}
+		return BoolFalse
+	}
+	panic(fmt.Sprintf("unknown BoolOp %d", int(op)))
+}
+
+// BoolCombine evaluates op across all of the series at every
+// timestamp in the union of their timestamps, using the value
+// in force for each series at that time. Only changes of the
+// combined value are written to the result.
+func BoolCombine(op BoolOp, ss ...*Series) *Series {
+	n := len(ss)
+	if n == 0 {
+		return NewSeriesFromFrames(nil)
+	}
+	cur := make([]Tribool, n)
+	pos := make([]int, n)
+	for i := range cur {
+		cur[i] = BoolNA
+	}
+
+	res := make([]*Frame, 0)
+	last := Tribool(-2)
+	for {
+		// find the next event time among all the inputs
+		next := int64(0)
+		found := false
+		for i, s := range ss {
+			if pos[i] < len(s.Frames) {
+				tm := s.Frames[pos[i]].Tm()
+				if !found || tm < next {
+					next = tm
+					found = true
+				}
+			}
+		}
+		if !found {
+			break
+		}
+		// advance every input past all of its frames at next;
+		// the last frame at a tied timestamp is the one in force.
+		for i, s := range ss {
+			for pos[i] < len(s.Frames) && s.Frames[pos[i]].Tm() == next {
+				cur[i] = s.Frames[pos[i]].GetBool()
+				pos[i]++
+			}
+		}
+		b := cur[0]
+		for i := 1; i < n; i++ {
+			b = op.apply(b, cur[i])
+		}
+		if b != last {
+			res = append(res, NewBoolFrame(next, b))
+			last = b
+		}
+	}
+	return NewSeriesFromFrames(res)
+}
+
+// BoolAnd is true where all the series are true.
+func BoolAnd(ss ...*Series) *Series {
+	return BoolCombine(OpAnd, ss...)
+}
+
+// BoolOr is true where any of the series is true.
+func BoolOr(ss ...*Series) *Series {
+	return BoolCombine(OpOr, ss...)
+}
+
+// BoolXor is true where an odd number of the series are true.
+func BoolXor(ss ...*Series) *Series {
+	return BoolCombine(OpXor, ss...)
+}
+
+// BoolNot inverts the series. Unknowns stay unknown.
+func BoolNot(s *Series) *Series {
+	res := make([]*Frame, len(s.Frames))
+	for i, f := range s.Frames {
+		b := f.GetBool()
+		switch b {
+		case BoolTrue:
+			b = BoolFalse
+		case BoolFalse:
+			b = BoolTrue
+		}
+		res[i] = NewBoolFrame(f.Tm(), b)
+	}
+	return NewSeriesFromFrames(res)
+}
+
+// BoolInterval is a half-open time interval [Begin, End)
+// during which a boolean series was true.
+type BoolInterval struct {
+	Begin int64
+	End   int64
+}
+
+// Dur returns the length of the interval.
+func (iv BoolInterval) Dur() time.Duration {
+	return time.Duration(iv.End - iv.Begin)
+}
+
+// String displays the interval, for printing.
+func (iv BoolInterval) String() string {
+	return fmt.Sprintf("[%v, %v) %v",
+		time.Unix(0, iv.Begin).UTC().Format(time.RFC3339Nano),
+		time.Unix(0, iv.End).UTC().Format(time.RFC3339Nano),
+		iv.Dur())
+}
+
+// BoolSummary holds the results of BoolStats.
+type BoolSummary struct {
+	TimeTrue    time.Duration
+	TimeFalse   time.Duration
+	TimeNA      time.Duration
+	Transitions int // changes between true and false
+	Intervals   []BoolInterval
+}
+
+// FractionTrue returns the share of the known (not NA)
+// time during which the series was true; the uptime.
+func (s *BoolSummary) FractionTrue() float64 {
+	known := s.TimeTrue + s.TimeFalse
+	if known == 0 {
+		return MyNaN
+	}
+	return float64(s.TimeTrue) / float64(known)
+}
+
+// BoolStats measures the boolean series s over the window [begin, end):
+// the total time true, false and unknown, the number of transitions
+// between true and false, and the list of true intervals. Time before
+// the first frame of s counts as unknown.
+func BoolStats(s *Series, begin, end time.Time) *BoolSummary {
+	sum := &BoolSummary{}
+	b0 := TimeToPrimTm(begin)
+	e0 := TimeToPrimTm(end)
+	if e0 <= b0 {
+		return sum
+	}
+	if len(s.Frames) == 0 {
+		sum.TimeNA = time.Duration(e0 - b0)
+		return sum
+	}
+
+	// the value in force at begin
+	cur := BoolNA
+	f, status, i := s.LastAtOrBefore(begin)
+	if status != InPast {
+		cur = f.GetBool()
+		i++
+	} else {
+		i = 0
+	}
+
+	lastKnown := cur
+	segStart := b0
+	account := func(val Tribool, from, to int64) {
+		d := time.Duration(to - from)
+		switch val {
+		case BoolTrue:
+			sum.TimeTrue += d
+			n := len(sum.Intervals)
+			if n > 0 && sum.Intervals[n-1].End == from {
+				sum.Intervals[n-1].End = to
+			} else {
+				sum.Intervals = append(sum.Intervals, BoolInterval{Begin: from, End: to})
+			}
+		case BoolFalse:
+			sum.TimeFalse += d
+		default:
+			sum.TimeNA += d
+		}
+	}
+
+	for ; i < len(s.Frames); i++ {
+		tm := s.Frames[i].Tm()
+		if tm >= e0 {
+			break
+		}
+		b := s.Frames[i].GetBool()
+		if b == cur {
+			continue
+		}
+		account(cur, segStart, tm)
+		if b != BoolNA {
+			if lastKnown != BoolNA && b != lastKnown {
+				sum.Transitions++
+			}
+			lastKnown = b
+		}
+		cur = b
+		segStart = tm
+	}
+	account(cur, segStart, e0)
+	return sum
+}
@@ -0,0 +1,88 @@
+package tm
+
+import (
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test090BooleanSeriesAlgebra(t *testing.T) {
+
+	at := GenTestTime
+	mk := func(pairs ...int) *Series {
+		// pairs of (second, 0 or 1)
+		frames := []*Frame{}
+		for i := 0; i < len(pairs); i += 2 {
+			b := BoolFalse
+			if pairs[i+1] == 1 {
+				b = BoolTrue
+			}
+			frames = append(frames, NewBoolFrame(TimeToPrimTm(at(pairs[i])), b))
+		}
+		return NewSeriesFromFrames(frames)
+	}
+
+	cv.Convey("PtiNull frames should read as true and PtiZero frames as false", t, func() {
+		cv.So(GenTestFrame(0, EvNull, 0, 0, nil).GetBool(), cv.ShouldEqual, BoolTrue)
+		cv.So(GenTestFrame(0, EvZero, 0, 0, nil).GetBool(), cv.ShouldEqual, BoolFalse)
+		cv.So(GenTestFrame(0, EvNA, 0, 0, nil).GetBool(), cv.ShouldEqual, BoolNA)
+	})
+
+	cv.Convey("BoolFromPredicate should emit only the changes of the predicate over a numeric series", t, func() {
+		frames, _, _ := GenTestFramesSequence(10, nil)
+		above := BoolFromPredicate(NewSeriesFromFrames(frames), func(v float64) bool { return v >= 4 && v < 7 })
+		cv.So(len(above.Frames), cv.ShouldEqual, 3)
+		cv.So(above.Frames[0].GetBool(), cv.ShouldEqual, BoolFalse)
+		cv.So(above.Frames[1].GetBool(), cv.ShouldEqual, BoolTrue)
+		cv.So(above.Frames[1].TmTime(), cv.ShouldResemble, frames[4].TmTime())
+		cv.So(above.Frames[2].TmTime(), cv.ShouldResemble, frames[7].TmTime())
+	})
+
+	cv.Convey("AND, OR, XOR and NOT should combine step series at the union of their timestamps", t, func() {
+		a := mk(0, 1, 10, 0, 20, 1)
+		b := mk(5, 1, 15, 0)
+
+		and := BoolAnd(a, b)
+		// a is true on [0,10) and [20,..); b is unknown before 5, true on [5,15).
+		// AND: unknown on [0,5), true [5,10), false thereafter.
+		cv.So(len(and.Frames), cv.ShouldEqual, 3)
+		cv.So(and.Frames[0].GetBool(), cv.ShouldEqual, BoolNA)
+		cv.So(and.Frames[1].GetBool(), cv.ShouldEqual, BoolTrue)
+		cv.So(and.Frames[1].TmTime(), cv.ShouldResemble, at(5))
+		cv.So(and.Frames[2].GetBool(), cv.ShouldEqual, BoolFalse)
+		cv.So(and.Frames[2].TmTime(), cv.ShouldResemble, at(10))
+
+		or := BoolOr(a, b)
+		// OR: true [0,15), false [15,20), true [20,..)
+		cv.So(len(or.Frames), cv.ShouldEqual, 3)
+		cv.So(or.Frames[1].TmTime(), cv.ShouldResemble, at(15))
+		cv.So(or.Frames[2].GetBool(), cv.ShouldEqual, BoolTrue)
+
+		xor := BoolXor(a, b)
+		// XOR: NA [0,5), false [5,10), true [10,15), false [15,20), true [20,..)
+		cv.So(len(xor.Frames), cv.ShouldEqual, 5)
+
+		not := BoolNot(a)
+		cv.So(not.Frames[0].GetBool(), cv.ShouldEqual, BoolFalse)
+		cv.So(not.Frames[1].GetBool(), cv.ShouldEqual, BoolTrue)
+	})
+
+	cv.Convey("BoolStats should give the time true, the transitions, and the true intervals", t, func() {
+		a := mk(0, 1, 10, 0, 20, 1, 25, 1, 30, 0)
+		sum := BoolStats(a, at(5), at(40))
+		cv.So(sum.TimeTrue, cv.ShouldEqual, 15*time.Second)
+		cv.So(sum.TimeFalse, cv.ShouldEqual, 20*time.Second)
+		cv.So(sum.TimeNA, cv.ShouldEqual, 0)
+		cv.So(sum.Transitions, cv.ShouldEqual, 3)
+		cv.So(len(sum.Intervals), cv.ShouldEqual, 2)
+		cv.So(sum.Intervals[0], cv.ShouldResemble, BoolInterval{Begin: TimeToPrimTm(at(5)), End: TimeToPrimTm(at(10))})
+		cv.So(sum.Intervals[1].Dur(), cv.ShouldEqual, 10*time.Second)
+		cv.So(sum.FractionTrue(), cv.ShouldEqual, 15.0/35.0)
+
+		// time before the first frame is unknown
+		sum = BoolStats(a, at(-10), at(10))
+		cv.So(sum.TimeNA, cv.ShouldEqual, 10*time.Second)
+		cv.So(sum.TimeTrue, cv.ShouldEqual, 10*time.Second)
+	})
+}
@@ -0,0 +1,518 @@
+package tm
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// CalcExpr is a compiled arithmetic expression over named
+// series, such as "a - 2*b" or "(a + b + c)/3". It supports
+// + - * /, unary minus, parentheses, numeric constants, and
+// the functions abs, sqrt, log, exp, min, and max.
+type CalcExpr struct {
+	Src  string
+	Vars []string // the distinct variable names, sorted.
+	root calcNode
+}
+
+// CalcValue is an operand or result value. Kind is one of
+// PtiOneFloat64 (V holds the number), PtiNA, PtiNull, or PtiNaN.
+type CalcValue struct {
+	Kind PTI
+	V    float64
+}
+
+// calcValueOf reads the in-force value of f as a CalcValue.
+// A nil f (no value in force yet) is NA. PtiNull stays Null.
+// Other frames without a number, UDE included, are NA.
+func calcValueOf(f *Frame) CalcValue {
+	if f == nil {
+		return CalcValue{Kind: PtiNA}
+	}
+	switch f.GetPTI() {
+	case PtiNull:
+		return CalcValue{Kind: PtiNull}
+	case PtiNaN:
+		return CalcValue{Kind: PtiNaN, V: MyNaN}
+	}
+	v, ok := FrameValue(f)
+	if !ok {
+		switch f.GetPTI() {
+		case PtiOneFloat64, PtiTwo64:
+			// a NaN stored in V0
+			return CalcValue{Kind: PtiNaN, V: MyNaN}
+		}
+		return CalcValue{Kind: PtiNA}
+	}
+	return CalcValue{Kind: PtiOneFloat64, V: v}
+}
+
+// Frame converts the value into a frame at tm. Numbers become
+// PtiOneFloat64 frames, unless the number is NaN, which becomes
+// a PtiNaN frame.
+func (v CalcValue) Frame(tm int64) *Frame {
+	switch v.Kind {
+	case PtiOneFloat64:
+		return NewValueFrame(tm, v.V)
+	case PtiNull, PtiNA, PtiNaN:
+		return &Frame{Prim: (tm &^ 7) | int64(v.Kind)}
+	}
+	return &Frame{Prim: (tm &^ 7) | int64(PtiNA)}
+}
+
+// ParseCalcExpr compiles src.
+func ParseCalcExpr(src string) (*CalcExpr, error) {
+	p := &calcParser{src: src}
+	err := p.lex()
+	if err != nil {
+		return nil, err
+	}
+	root, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("calc expression '%s': unexpected '%s'", src, p.toks[p.pos].text)
+	}
+	vars := map[string]bool{}
+	root.vars(vars)
+	e := &CalcExpr{Src: src, root: root}
+	for k := range vars {
+		e.Vars = append(e.Vars, k)
+	}
+	sort.Strings(e.Vars)
+	return e, nil
+}
+
+// Eval evaluates the expression, looking up variables in vars.
+// Missing variables are NA. The NA, Null and NaN rules follow
+// the spec: if any operand is NA the result is NA, since any
+// value could have been there; otherwise if any operand is
+// Null the result is Null; otherwise arithmetic proceeds as
+// IEEE-754, so that NaN operands or 0/0 give NaN.
+func (e *CalcExpr) Eval(vars map[string]CalcValue) CalcValue {
+	na, null := false, false
+	for _, name := range e.Vars {
+		v, ok := vars[name]
+		if !ok {
+			na = true
+			continue
+		}
+		switch v.Kind {
+		case PtiNA:
+			na = true
+		case PtiNull:
+			null = true
+		}
+	}
+	if na {
+		return CalcValue{Kind: PtiNA}
+	}
+	if null {
+		return CalcValue{Kind: PtiNull}
+	}
+	r := e.root.eval(vars)
+	if math.IsNaN(r) {
+		return CalcValue{Kind: PtiNaN, V: r}
+	}
+	return CalcValue{Kind: PtiOneFloat64, V: r}
+}
+
+// CalcSeries evaluates e at every timestamp in the union of the
+// operand series' timestamps, giving each variable the value in
+// force at that time: the last frame at or before it, with the
+// last of any tied frames winning, as in LastAtOrBefore().
+// This is LastAtOrBefore(), not LastInForceBefore(), which looks
+// only strictly before: an operand frame counts at its own
+// timestamp, so a - b at b's update time uses b's new value.
+// Operands are supplied by variable name.
+func CalcSeries(e *CalcExpr, operands map[string]*Series) (*Series, error) {
+	srcs := make([]framePeeker, len(e.Vars))
+	for i, name := range e.Vars {
+		s, ok := operands[name]
+		if !ok {
+			return nil, fmt.Errorf("CalcSeries: no operand given for variable '%s'", name)
+		}
+		srcs[i] = &seriesPeeker{s: s}
+	}
+	res := []*Frame{}
+	err := calcWalk(e, srcs, func(f *Frame) error {
+		res = append(res, f)
+		return nil
+	})
+	return NewSeriesFromFrames(res), err
+}
+
+// CalcStreams is the streaming form of CalcSeries. It reads the
+// time-sorted operand streams in step, one per variable name,
+// and writes the resulting frames to w.
+func CalcStreams(e *CalcExpr, operands map[string]*BufferedFrameReader, w io.Writer) error {
+	srcs := make([]framePeeker, len(e.Vars))
+	for i, name := range e.Vars {
+		s, ok := operands[name]
+		if !ok {
+			return fmt.Errorf("CalcStreams: no operand given for variable '%s'", name)
+		}
+		srcs[i] = s
+	}
+	fw := NewFrameWriter(w, 1024*1024)
+	err := calcWalk(e, srcs, func(f *Frame) error {
+		fw.Append(f)
+		if len(fw.Frames) >= 1000 {
+			return fw.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = fw.Flush()
+	fw.Sync()
+	return err
+}
+
+// framePeeker is the part of BufferedFrameReader
+// that calcWalk needs.
+type framePeeker interface {
+	Peek() (*Frame, error)
+	Advance() error
+}
+
+// seriesPeeker presents a Series as a framePeeker.
+type seriesPeeker struct {
+	s *Series
+	i int
+}
+
+func (p *seriesPeeker) Peek() (*Frame, error) {
+	if p.i >= len(p.s.Frames) {
+		return nil, io.EOF
+	}
+	return p.s.Frames[p.i], nil
+}
+
+func (p *seriesPeeker) Advance() error {
+	p.i++
+	return nil
+}
+
+func calcWalk(e *CalcExpr, srcs []framePeeker, emit func(f *Frame) error) error {
+	n := len(srcs)
+	vars := make(map[string]CalcValue, n)
+	for _, name := range e.Vars {
+		vars[name] = CalcValue{Kind: PtiNA}
+	}
+	done := make([]bool, n)
+	for {
+		// find the next event time among the operands
+		var next int64
+		found := false
+		for i, s := range srcs {
+			if done[i] {
+				continue
+			}
+			f, err := s.Peek()
+			if err != nil {
+				if err == io.EOF {
+					done[i] = true
+					continue
+				}
+				return err
+			}
+			if !found || f.Tm() < next {
+				next = f.Tm()
+				found = true
+			}
+		}
+		if !found {
+			return nil
+		}
+		// take every operand frame at next; the last tie wins.
+		for i, s := range srcs {
+			for !done[i] {
+				f, err := s.Peek()
+				if err != nil {
+					if err == io.EOF {
+						done[i] = true
+						break
+					}
+					return err
+				}
+				if f.Tm() != next {
+					break
+				}
+				vars[e.Vars[i]] = calcValueOf(f)
+				err = s.Advance()
+				if err != nil {
+					return err
+				}
+			}
+		}
+		err := emit(e.Eval(vars).Frame(next))
+		if err != nil {
+			return err
+		}
+	}
+}
+
+//////////////////////////////////////////////////
+// expression parsing
+
+type calcNode interface {
+	eval(vars map[string]CalcValue) float64
+	vars(m map[string]bool)
+}
+
+type calcNum float64
+
+func (n calcNum) eval(vars map[string]CalcValue) float64 { return float64(n) }
+func (n calcNum) vars(m map[string]bool)                 {}
+
+type calcVar string
+
+func (n calcVar) eval(vars map[string]CalcValue) float64 {
+	v, ok := vars[string(n)]
+	if !ok {
+		return MyNaN
+	}
+	return v.V
+}
+func (n calcVar) vars(m map[string]bool) { m[string(n)] = true }
+
+type calcBinary struct {
+	op   byte
+	l, r calcNode
+}
+
+func (n *calcBinary) eval(vars map[string]CalcValue) float64 {
+	a := n.l.eval(vars)
+	b := n.r.eval(vars)
+	switch n.op {
+	case '+':
+		return a + b
+	case '-':
+		return a - b
+	case '*':
+		return a * b
+	case '/':
+		return a / b
+	}
+	panic(fmt.Sprintf("unknown calc operator '%c'", n.op))
+}
+func (n *calcBinary) vars(m map[string]bool) {
+	n.l.vars(m)
+	n.r.vars(m)
+}
+
+type calcNeg struct{ x calcNode }
+
+func (n *calcNeg) eval(vars map[string]CalcValue) float64 { return -n.x.eval(vars) }
+func (n *calcNeg) vars(m map[string]bool)                 { n.x.vars(m) }
+
+type calcCall struct {
+	name string
+	args []calcNode
+}
+
+var calcFuncArity = map[string]int{
+	"abs":  1,
+	"sqrt": 1,
+	"log":  1,
+	"exp":  1,
+	"min":  2,
+	"max":  2,
+}
+
+func (n *calcCall) eval(vars map[string]CalcValue) float64 {
+	a := n.args[0].eval(vars)
+	switch n.name {
+	case "abs":
+		return math.Abs(a)
+	case "sqrt":
+		return math.Sqrt(a)
+	case "log":
+		return math.Log(a)
+	case "exp":
+		return math.Exp(a)
+	case "min":
+		return math.Min(a, n.args[1].eval(vars))
+	case "max":
+		return math.Max(a, n.args[1].eval(vars))
+	}
+	panic(fmt.Sprintf("unknown calc function '%s'", n.name))
+}
+func (n *calcCall) vars(m map[string]bool) {
+	for _, a := range n.args {
+		a.vars(m)
+	}
+}
+
+type calcTok struct {
+	kind byte // 'n' number, 'i' identifier, or the operator/paren itself
+	text string
+	num  float64
+}
+
+type calcParser struct {
+	src  string
+	toks []calcTok
+	pos  int
+}
+
+func (p *calcParser) lex() error {
+	s := p.src
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case strings.ContainsRune("+-*/(),", c):
+			p.toks = append(p.toks, calcTok{kind: byte(c), text: string(c)})
+			i++
+		case unicode.IsDigit(c) || c == '.':
+			j := i
+			for j < len(s) && (unicode.IsDigit(rune(s[j])) || s[j] == '.' || s[j] == 'e' || s[j] == 'E' ||
+				((s[j] == '-' || s[j] == '+') && j > i && (s[j-1] == 'e' || s[j-1] == 'E'))) {
+				j++
+			}
+			x, err := strconv.ParseFloat(s[i:j], 64)
+			if err != nil {
+				return fmt.Errorf("calc expression '%s': bad number '%s'", p.src, s[i:j])
+			}
+			p.toks = append(p.toks, calcTok{kind: 'n', text: s[i:j], num: x})
+			i = j
+		case unicode.IsLetter(c) || c == '_':
+			j := i
+			for j < len(s) && (unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j])) || s[j] == '_') {
+				j++
+			}
+			p.toks = append(p.toks, calcTok{kind: 'i', text: s[i:j]})
+			i = j
+		default:
+			return fmt.Errorf("calc expression '%s': unexpected character '%c'", p.src, c)
+		}
+	}
+	if len(p.toks) == 0 {
+		return fmt.Errorf("calc expression is empty")
+	}
+	return nil
+}
+
+func (p *calcParser) peek() byte {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos].kind
+	}
+	return 0
+}
+
+// sum := product { ('+'|'-') product }
+func (p *calcParser) parseSum() (calcNode, error) {
+	l, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == '+' || p.peek() == '-' {
+		op := p.peek()
+		p.pos++
+		r, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		l = &calcBinary{op: op, l: l, r: r}
+	}
+	return l, nil
+}
+
+// product := unary { ('*'|'/') unary }
+func (p *calcParser) parseProduct() (calcNode, error) {
+	l, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == '*' || p.peek() == '/' {
+		op := p.peek()
+		p.pos++
+		r, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l = &calcBinary{op: op, l: l, r: r}
+	}
+	return l, nil
+}
+
+// unary := '-' unary | primary
+func (p *calcParser) parseUnary() (calcNode, error) {
+	if p.peek() == '-' {
+		p.pos++
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &calcNeg{x: x}, nil
+	}
+	if p.peek() == '+' {
+		p.pos++
+		return p.parseUnary()
+	}
+	return p.parsePrimary()
+}
+
+// primary := number | ident | ident '(' args ')' | '(' sum ')'
+func (p *calcParser) parsePrimary() (calcNode, error) {
+	if p.pos >= len(p.toks) {
+		return nil, fmt.Errorf("calc expression '%s': unexpected end", p.src)
+	}
+	t := p.toks[p.pos]
+	p.pos++
+	switch t.kind {
+	case 'n':
+		return calcNum(t.num), nil
+	case 'i':
+		if p.peek() != '(' {
+			return calcVar(t.text), nil
+		}
+		p.pos++
+		arity, ok := calcFuncArity[t.text]
+		if !ok {
+			return nil, fmt.Errorf("calc expression '%s': unknown function '%s'", p.src, t.text)
+		}
+		call := &calcCall{name: t.text}
+		for {
+			a, err := p.parseSum()
+			if err != nil {
+				return nil, err
+			}
+			call.args = append(call.args, a)
+			if p.peek() == ',' {
+				p.pos++
+				continue
+			}
+			break
+		}
+		if p.peek() != ')' {
+			return nil, fmt.Errorf("calc expression '%s': missing ')' after arguments to '%s'", p.src, t.text)
+		}
+		p.pos++
+		if len(call.args) != arity {
+			return nil, fmt.Errorf("calc expression '%s': %s() takes %d argument(s), got %d", p.src, t.text, arity, len(call.args))
+		}
+		return call, nil
+	case '(':
+		x, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			return nil, fmt.Errorf("calc expression '%s': missing ')'", p.src)
+		}
+		p.pos++
+		return x, nil
+	}
+	return nil, fmt.Errorf("calc expression '%s': unexpected '%s'", p.src, t.text)
+}
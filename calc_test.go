@@ -0,0 +1,119 @@
+package tm
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test100CalcOverAlignedSeries(t *testing.T) {
+
+	at := func(sec int) int64 { return GenTestTime(sec).UnixNano() }
+
+	// a: 10 at t=1, 20 at t=3, NaN at t=5
+	// b: 1 at t=2, 2 at t=3, NA at t=4
+	a := NewSeriesFromFrames([]*Frame{
+		NewValueFrame(at(1), 10),
+		NewValueFrame(at(3), 20),
+		NewValueFrame(at(5), MyNaN),
+	})
+	b := NewSeriesFromFrames([]*Frame{
+		NewValueFrame(at(2), 1),
+		NewValueFrame(at(3), 2),
+		{Prim: at(4) | int64(PtiNA)},
+	})
+
+	cv.Convey("ParseCalcExpr should honor precedence, parentheses, unary minus and functions", t, func() {
+		for src, want := range map[string]float64{
+			"1 + 2*3":         7,
+			"(1 + 2)*3":       9,
+			"-2 - -3":         1,
+			"10/4":            2.5,
+			"max(abs(-3), 2)": 3,
+			"sqrt(16) + 1e1":  14,
+		} {
+			e, err := ParseCalcExpr(src)
+			panicOn(err)
+			cv.So(len(e.Vars), cv.ShouldEqual, 0)
+			cv.So(e.Eval(nil).V, cv.ShouldEqual, want)
+		}
+		e, err := ParseCalcExpr("b - 2*a + a")
+		panicOn(err)
+		cv.So(e.Vars, cv.ShouldResemble, []string{"a", "b"})
+
+		for _, bad := range []string{"", "a +", "(a", "nosuch(a)", "min(a)", "a $ b"} {
+			_, err = ParseCalcExpr(bad)
+			cv.So(err, cv.ShouldNotBeNil)
+		}
+	})
+
+	cv.Convey("CalcSeries should evaluate at the union of timestamps using in-force values, with NA before an operand starts and after an NA, and NaN propagating", t, func() {
+		e, err := ParseCalcExpr("a - 2*b")
+		panicOn(err)
+		res, err := CalcSeries(e, map[string]*Series{"a": a, "b": b})
+		panicOn(err)
+		cv.So(len(res.Frames), cv.ShouldEqual, 5)
+
+		cv.So(res.Frames[0].Tm(), cv.ShouldEqual, at(1))
+		cv.So(res.Frames[0].GetPTI(), cv.ShouldEqual, PtiNA) // b not yet in force
+
+		cv.So(res.Frames[1].Tm(), cv.ShouldEqual, at(2))
+		cv.So(res.Frames[1].GetPTI(), cv.ShouldEqual, PtiOneFloat64)
+		cv.So(res.Frames[1].V0, cv.ShouldEqual, 8) // 10 - 2*1
+
+		cv.So(res.Frames[2].V0, cv.ShouldEqual, 16) // 20 - 2*2
+
+		cv.So(res.Frames[3].GetPTI(), cv.ShouldEqual, PtiNA) // b is NA
+		cv.So(res.Frames[4].GetPTI(), cv.ShouldEqual, PtiNA) // NA dominates NaN
+
+		e2, err := ParseCalcExpr("a/0 + 1")
+		panicOn(err)
+		res, err = CalcSeries(e2, map[string]*Series{"a": a})
+		panicOn(err)
+		cv.So(len(res.Frames), cv.ShouldEqual, 3)
+		cv.So(math.IsInf(res.Frames[0].V0, 1), cv.ShouldBeTrue)
+		cv.So(res.Frames[2].GetPTI(), cv.ShouldEqual, PtiNaN)
+
+		_, err = CalcSeries(e, map[string]*Series{"a": a})
+		cv.So(err, cv.ShouldNotBeNil)
+	})
+
+	cv.Convey("CalcStreams should give the same answer as CalcSeries when reading from streams", t, func() {
+		e, err := ParseCalcExpr("(a + b)/2")
+		panicOn(err)
+		want, err := CalcSeries(e, map[string]*Series{"a": a, "b": b})
+		panicOn(err)
+
+		rdr := func(s *Series) *BufferedFrameReader {
+			var buf bytes.Buffer
+			fw := NewFrameWriter(&buf, 1024)
+			fw.Frames = s.Frames
+			fw.Flush()
+			return NewBufferedFrameReader(&buf, 1024, "")
+		}
+		var out bytes.Buffer
+		err = CalcStreams(e, map[string]*BufferedFrameReader{"a": rdr(a), "b": rdr(b)}, &out)
+		panicOn(err)
+
+		got := []*Frame{}
+		fr := NewFrameReader(&out, 1024)
+		for {
+			f := &Frame{}
+			_, _, err, _ = fr.NextFrame(f)
+			if err != nil {
+				break
+			}
+			got = append(got, f)
+		}
+		cv.So(len(got), cv.ShouldEqual, len(want.Frames))
+		for i := range got {
+			cv.So(got[i].Prim, cv.ShouldEqual, want.Frames[i].Prim)
+			if got[i].GetPTI() == PtiOneFloat64 {
+				cv.So(got[i].V0, cv.ShouldEqual, want.Frames[i].V0)
+			}
+		}
+		cv.So(got[2].V0, cv.ShouldEqual, 11)
+	})
+}
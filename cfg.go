@@ -3,6 +3,10 @@ package tm
 import (
 	"flag"
 	"fmt"
+	"runtime"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/glycerine/zebrapack/zebra"
 )
@@ -17,8 +21,14 @@ type TfcatConfig struct {
 	ReadStdin           bool
 	Rreadable           bool
 	ZebraPackSchemaPath string
+	Format              string
+	Epoch               bool
+	Fields              string
+	RawRange            bool
+	Range               TimeRangeFlags
 
 	ZebraSchema zebra.Schema
+	FieldPaths  []*FieldPath
 }
 
 // call DefineFlags before myflags.Parse()
@@ -31,6 +41,11 @@ func (c *TfcatConfig) DefineFlags(fs *flag.FlagSet) {
 	fs.BoolVar(&c.ReadStdin, "stdin", false, "read input from stdin rather than a file. tfcat cannot also -f follow stdin.")
 	fs.BoolVar(&c.Rreadable, "r", false, "display in R consumable format")
 	fs.StringVar(&c.ZebraPackSchemaPath, "zebrapack-schema", "", "path to ZebraPack schema in msgpack2 format to read for decoding messages")
+	fs.StringVar(&c.Format, "format", "", "print frames as csv, tsv, or jsonl rows, with columns time, pti, evtnum, v0, v1, payload (-p, -s, -r are ignored)")
+	fs.BoolVar(&c.Epoch, "epoch", false, "with -format, print time as integer nanoseconds since the epoch, instead of RFC3339Nano")
+	fs.StringVar(&c.Fields, "fields", "", "with -format, a comma separated list of payload field paths (e.g. a.b[2].c,id) to add as columns")
+	fs.BoolVar(&c.RawRange, "rawrange", false, "copy the raw TMFRAME bytes of the frames between -start and -end to stdout. -raw and -rawskip then count frames within the range.")
+	c.Range.DefineFlags(fs, true)
 }
 
 // call c.ValidateConfig() after myflags.Parse()
@@ -40,6 +55,30 @@ func (c *TfcatConfig) ValidateConfig() error {
 		return fmt.Errorf("bad -zebrapack-schema path: "+
 			"'%s' does not exist.", c.ZebraPackSchemaPath)
 	}
+	switch c.Format {
+	case "", "csv", "tsv", "jsonl":
+	default:
+		return fmt.Errorf("-format '%s' unknown: use csv, tsv, or jsonl", c.Format)
+	}
+	if c.Fields != "" {
+		if c.Format == "" {
+			return fmt.Errorf("-fields needs -format")
+		}
+		c.FieldPaths = nil
+		for _, src := range strings.Split(c.Fields, ",") {
+			fp, err := ParseFieldPath(strings.TrimSpace(src))
+			if err != nil {
+				return fmt.Errorf("bad -fields: %v", err)
+			}
+			c.FieldPaths = append(c.FieldPaths, fp)
+		}
+	}
+	if err := c.Range.ValidateConfig(); err != nil {
+		return err
+	}
+	if c.RawRange && !c.Range.IsSet() {
+		return fmt.Errorf("-rawrange needs -start or -end")
+	}
 	return nil
 }
 
@@ -65,16 +104,43 @@ func (c *TfindexConfig) ValidateConfig() error {
 // configure the tfsort command utility
 type TfsortConfig struct {
 	KeepTmpFiles bool
+	MemBudgetMB  int64
+	Parallel     int
+	TmpDir       string
+	Range        TimeRangeFlags
 }
 
 // call DefineFlags before myflags.Parse()
 func (c *TfsortConfig) DefineFlags(fs *flag.FlagSet) {
 	fs.BoolVar(&c.KeepTmpFiles, "k", false, "keep .sorted intermediate temp files")
+	fs.Int64Var(&c.MemBudgetMB, "mem", 1024, "memory budget in megabytes. Files larger than this are sorted externally, in runs written to -tmpdir and then merged.")
+	fs.IntVar(&c.Parallel, "j", runtime.NumCPU(), "number of chunks, of -mem/-j each, to sort in parallel during an external sort")
+	fs.StringVar(&c.TmpDir, "tmpdir", "", "directory for external sort runs (default is the system temp directory)")
+	c.Range.DefineFlags(fs, false)
 }
 
 // call c.ValidateConfig() after myflags.Parse()
 func (c *TfsortConfig) ValidateConfig() error {
-	return nil
+	if c.MemBudgetMB <= 0 {
+		return fmt.Errorf("-mem %v illegal: must be positive.", c.MemBudgetMB)
+	}
+	if c.Parallel <= 0 {
+		return fmt.Errorf("-j %v illegal: must be positive.", c.Parallel)
+	}
+	if c.TmpDir != "" && !DirExists(c.TmpDir) {
+		return fmt.Errorf("-tmpdir '%s' does not exist.", c.TmpDir)
+	}
+	return c.Range.ValidateConfig()
+}
+
+// ExternalSortConfig converts the command line flags into an ExternalSortConfig.
+func (c *TfsortConfig) ExternalSortConfig() ExternalSortConfig {
+	return ExternalSortConfig{
+		MemBudget:    c.MemBudgetMB * 1024 * 1024,
+		Parallel:     c.Parallel,
+		TmpDir:       c.TmpDir,
+		KeepTmpFiles: c.KeepTmpFiles,
+	}
 }
 
 ////////////////////////////
@@ -84,15 +150,40 @@ func (c *TfsortConfig) ValidateConfig() error {
 type TfdedupConfig struct {
 	WriteDupsToFile string
 	WindowSize      int
+	WindowDur       time.Duration
 	DetectOnly      bool
+	KeyName         string
+	Field           string
+	PolicyName      string
+
+	StatePath  string
+	StateExact int
+	StateFP    float64
+	StateMB    int
+	TrustBloom bool
+
+	Key    DedupKey
+	Policy DedupPolicy
+
+	fs *flag.FlagSet // to tell whether -window was given
 }
 
 // call DefineFlags before myflags.Parse()
 func (c *TfdedupConfig) DefineFlags(fs *flag.FlagSet) {
+	c.fs = fs
 	fs.StringVar(&c.WriteDupsToFile, "dupsto", "", "write duplicates to this file path")
-	fs.IntVar(&c.WindowSize, "window", 1000, "window size; number of Frames in a row to check for duplicates")
+	fs.IntVar(&c.WindowSize, "window", 1000, "window size; number of Frames in a row to check for duplicates. 0 means no frame count limit, when -dur is given; that is the default when -dur is given without -window.")
+	fs.DurationVar(&c.WindowDur, "dur", 0, "window duration (e.g. 10m); frames further than this behind the newest frame seen leave the window. If both -window and -dur are given, a frame leaves the window when it falls outside either.")
 	fs.BoolVar(&c.DetectOnly, "detect", false, "detect duplicates and announce that "+
 		"fact, but do not write any Frame output")
+	fs.StringVar(&c.KeyName, "key", "frame", "what makes frames duplicates: frame (byte-identical), payload (all but the timestamp), tm-evtnum (timestamp and evtnum), or field (the payload value at -field)")
+	fs.StringVar(&c.Field, "field", "", "payload field path (e.g. msg.id or ids[0]) for -key field; JSON and msgpack payloads are searched")
+	fs.StringVar(&c.PolicyName, "keep", "first", "which copy survives: first, or last (the last copy within the window; output is delayed by the window)")
+	fs.StringVar(&c.StatePath, "state", "", "path to dedup state, remembering keys across runs (and beyond the window). Loaded at start if it exists, and saved at the end. Without -trust-bloom, only copies of the last -state-exact keys are dropped: size it to the distinct keys of a run.")
+	fs.IntVar(&c.StateExact, "state-exact", 1<<20, "number of most recent keys -state holds exactly (about 60 bytes each); older keys are held only in Bloom filters, and their copies are passed through, and counted as probable duplicates, unless -trust-bloom.")
+	fs.Float64Var(&c.StateFP, "state-fp", 0.001, "overall false positive rate of the -state Bloom filters (about 18 bits per key at 0.001). Fixed when the state is first made.")
+	fs.IntVar(&c.StateMB, "state-mb", 256, "maximum megabytes of -state Bloom filters. No filter grows past a quarter of this; beyond it, the oldest filter and its keys are forgotten as each new one is made.")
+	fs.BoolVar(&c.TrustBloom, "trust-bloom", false, "drop frames whose key -state finds only in the Bloom filters. By default only exact matches are dropped, and Bloom-only matches are passed through and counted.")
 }
 
 // call c.ValidateConfig() after myflags.Parse()
@@ -100,25 +191,106 @@ func (c *TfdedupConfig) ValidateConfig() error {
 	if c.WriteDupsToFile != "" && FileExists(c.WriteDupsToFile) {
 		return fmt.Errorf("duplicates output file '%s' already exists, aborting.", c.WriteDupsToFile)
 	}
-	if c.WindowSize <= 1 {
-		return fmt.Errorf("-window %v illegal: must be positive integer > 1.", c.WindowSize)
+	if c.WindowDur < 0 {
+		return fmt.Errorf("-dur %v illegal: must not be negative.", c.WindowDur)
+	}
+	if c.WindowDur > 0 && c.fs != nil && !flagGiven(c.fs, "window") {
+		// -dur alone bounds the window by time only.
+		c.WindowSize = 0
+	}
+	if c.WindowSize <= 1 && !(c.WindowSize == 0 && c.WindowDur > 0) {
+		return fmt.Errorf("-window %v illegal: must be positive integer > 1, or 0 along with -dur.", c.WindowSize)
+	}
+	var err error
+	c.Key, err = ParseDedupKey(c.KeyName)
+	if err != nil {
+		return fmt.Errorf("bad -key: %v", err)
+	}
+	if c.Key == DedupField {
+		if _, err = ParseFieldPath(c.Field); err != nil {
+			return fmt.Errorf("bad -field: %v", err)
+		}
+	} else if c.Field != "" {
+		return fmt.Errorf("-field requires -key field")
+	}
+	switch strings.ToLower(c.PolicyName) {
+	case "", "first":
+		c.Policy = FirstWins
+	case "last":
+		c.Policy = LastWins
+	default:
+		return fmt.Errorf("bad -keep '%s': use first or last", c.PolicyName)
+	}
+	if c.StatePath != "" {
+		if c.StateExact < 0 || c.StateMB <= 0 {
+			return fmt.Errorf("-state-exact must not be negative, and -state-mb must be positive")
+		}
+		if c.StateFP <= 0 || c.StateFP >= 1 {
+			return fmt.Errorf("-state-fp %v illegal: must be between 0 and 1", c.StateFP)
+		}
 	}
 	return nil
 }
 
+// flagGiven reports whether the flag name was set on the command line.
+func flagGiven(fs *flag.FlagSet, name string) bool {
+	given := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			given = true
+		}
+	})
+	return given
+}
+
+// DedupConfig converts the command line flags into a DedupConfig.
+func (c *TfdedupConfig) DedupConfig() DedupConfig {
+	return DedupConfig{
+		WindowSize: c.WindowSize,
+		WindowDur:  c.WindowDur,
+		Key:        c.Key,
+		Field:      c.Field,
+		Policy:     c.Policy,
+	}
+}
+
+// DedupStateConfig converts the -state flags into a DedupStateConfig.
+func (c *TfdedupConfig) DedupStateConfig() DedupStateConfig {
+	return DedupStateConfig{
+		MaxExact:      c.StateExact,
+		FPRate:        c.StateFP,
+		MaxBloomBytes: int64(c.StateMB) << 20,
+		TrustBloom:    c.TrustBloom,
+	}
+}
+
 ////////////////
 // tfsum
 
 type TfsumConfig struct {
-	Help bool
+	Help    bool
+	Jobs    int
+	Summary bool
+	Format  string
 }
 
 // call DefineFlags before myflags.Parse()
 func (c *TfsumConfig) DefineFlags(fs *flag.FlagSet) {
 	fs.BoolVar(&c.Help, "h", false, "show this help")
+	fs.IntVar(&c.Jobs, "j", 1, "scan the input file in parallel on this many cores; output stays in file order. Needs a file argument.")
+	fs.BoolVar(&c.Summary, "summary", false, "instead of checksum frames, print a statistical summary: frame and byte counts, first and last timestamps, out of order and duplicate timestamp counts, evtnum and PTI histograms, payload size quantiles, and V0 statistics")
+	fs.StringVar(&c.Format, "o", "text", "-summary output format: text or json")
 }
 
 func (c *TfsumConfig) ValidateConfig() error {
+	if c.Jobs < 1 {
+		return fmt.Errorf("-j %v illegal: must be at least 1", c.Jobs)
+	}
+	switch c.Format {
+	case "text", "json":
+	default:
+		return fmt.Errorf("-o '%s' unknown: use text or json", c.Format)
+	}
 	return nil
 }
 
@@ -128,14 +300,75 @@ func (c *TfsumConfig) ValidateConfig() error {
 type TfgroupConfig struct {
 	Help          bool
 	GroupInterval string // "min, sec, hour, ..."
+	By            string
+	Aggs          string
+	Distinct      string
+	Format        string
+	TZ            string
+	NoFill        bool
+	InputPath     string
+
+	ZebraPackSchemaPath string
+
+	Interval    GroupInterval
+	Stats       []GroupStat
+	Loc         *time.Location
+	ZebraSchema zebra.Schema
 }
 
 // call DefineFlags before myflags.Parse()
 func (c *TfgroupConfig) DefineFlags(fs *flag.FlagSet) {
 	fs.BoolVar(&c.Help, "h", false, "show this help")
+	fs.StringVar(&c.GroupInterval, "interval", "min", "bucket width: a duration (e.g. 90s, 15m) or an optional count and a calendar unit: sec, min, hour, day, week, month, quarter, year (e.g. 2day, 3month)")
+	fs.StringVar(&c.By, "by", "", "also group by 'evtnum', or by the value at this payload field path (e.g. host.name)")
+	fs.StringVar(&c.Aggs, "agg", "count", "comma separated aggregates: count, sum, min, max, mean (of V0; V1 for EvOneInt64 frames), distinct, bytes")
+	fs.StringVar(&c.Distinct, "distinct", "evtnum", "for -agg distinct: count the distinct values of 'evtnum', or of this payload field path")
+	fs.StringVar(&c.Format, "o", "text", "output format: text, csv, or frames (TMFRAME EvTwo64 frames at each bucket start: V1 is the count, V0 the first other aggregate in -agg)")
+	fs.StringVar(&c.TZ, "tz", "UTC", "time zone for calendar intervals and for printing, e.g. America/Chicago")
+	fs.BoolVar(&c.NoFill, "nofill", false, "omit buckets with no frames, rather than printing them with a count of 0. Buckets are never filled under -by.")
+	fs.StringVar(&c.InputPath, "in", "", "read this file instead of stdin")
+	fs.StringVar(&c.ZebraPackSchemaPath, "zebrapack-schema", "", "path to ZebraPack schema in msgpack2 format, for ZebraPack payload paths in -by and -distinct")
 }
 
+// call c.ValidateConfig() after myflags.Parse()
 func (c *TfgroupConfig) ValidateConfig() error {
+	var err error
+	c.Interval, err = ParseGroupInterval(c.GroupInterval)
+	if err != nil {
+		return fmt.Errorf("bad -interval: %v", err)
+	}
+	c.Stats = c.Stats[:0]
+	for _, name := range strings.Split(c.Aggs, ",") {
+		st, err := ParseGroupStat(name)
+		if err != nil {
+			return fmt.Errorf("bad -agg: %v", err)
+		}
+		c.Stats = append(c.Stats, st)
+	}
+	switch c.Format {
+	case "text", "csv", "frames":
+	default:
+		return fmt.Errorf("-o '%s' unknown: use text, csv, or frames", c.Format)
+	}
+	c.Loc, err = time.LoadLocation(c.TZ)
+	if err != nil {
+		return fmt.Errorf("bad -tz: %v", err)
+	}
+	for _, spec := range []string{c.By, c.Distinct} {
+		if spec != "" && spec != "evtnum" {
+			if _, err := ParseFieldPath(spec); err != nil {
+				return fmt.Errorf("bad field path '%s': %v", spec, err)
+			}
+		}
+	}
+	if c.InputPath != "" && !FileExists(c.InputPath) {
+		return fmt.Errorf("-in '%s' does not exist", c.InputPath)
+	}
+	if c.ZebraPackSchemaPath != "" &&
+		!FileExists(c.ZebraPackSchemaPath) {
+		return fmt.Errorf("bad -zebrapack-schema path: "+
+			"'%s' does not exist.", c.ZebraPackSchemaPath)
+	}
 	return nil
 }
 
@@ -148,6 +381,15 @@ type TffilterConfig struct {
 	RegexFile      string
 	Any            bool
 	Sub            bool
+	InputPath      string
+	Jobs           int
+	Expr           string
+	Range          TimeRangeFlags
+
+	ZebraPackSchemaPath string
+
+	Predicate   *Predicate
+	ZebraSchema zebra.Schema
 }
 
 // call DefineFlags before myflags.Parse()
@@ -157,11 +399,773 @@ func (c *TffilterConfig) DefineFlags(fs *flag.FlagSet) {
 	fs.StringVar(&c.RegexFile, "regexfile", "", "read a newline separated list of regex from this file")
 	fs.BoolVar(&c.Any, "any", false, "include the frame if any of the regex matches (effectively OR-ing the regex instead of the default AND-ing)")
 	fs.BoolVar(&c.Sub, "sub", false, "print only sub-expression matches of the regular expression")
+	fs.StringVar(&c.InputPath, "in", "", "read this file instead of stdin")
+	fs.IntVar(&c.Jobs, "j", 1, "scan the -in file in parallel on this many cores; output stays in file order")
+	fs.StringVar(&c.Expr, "e", "", "filter expression over frame fields, e.g. 'evtnum == 14 && v0 > 100 && time >= 2016-05-01 && json.symbol =~ \"^AA\"'. Regexes, if also given, are applied to the frames that pass.")
+	fs.StringVar(&c.ZebraPackSchemaPath, "zebrapack-schema", "", "path to ZebraPack schema in msgpack2 format, for zebra. paths in -e")
+	c.Range.DefineFlags(fs, true)
 }
 
 func (c *TffilterConfig) ValidateConfig() error {
 	if c.RegexFile != "" && !FileExists(c.RegexFile) {
 		return fmt.Errorf("-regexfile '%s' does not exist", c.RegexFile)
 	}
+	if c.InputPath != "" && !FileExists(c.InputPath) {
+		return fmt.Errorf("-in '%s' does not exist", c.InputPath)
+	}
+	if c.Jobs < 1 {
+		return fmt.Errorf("-j %v illegal: must be at least 1", c.Jobs)
+	}
+	if c.Jobs > 1 && c.InputPath == "" {
+		return fmt.Errorf("-j needs a file given with -in; stdin cannot be split")
+	}
+	if c.ZebraPackSchemaPath != "" &&
+		!FileExists(c.ZebraPackSchemaPath) {
+		return fmt.Errorf("bad -zebrapack-schema path: "+
+			"'%s' does not exist.", c.ZebraPackSchemaPath)
+	}
+	if c.Expr != "" {
+		var err error
+		c.Predicate, err = ParsePredicate(c.Expr)
+		if err != nil {
+			return fmt.Errorf("bad -e: %v", err)
+		}
+		if c.ZebraPackSchemaPath != "" {
+			// loaded into c.ZebraSchema by the caller
+			c.Predicate.ZSchema = &c.ZebraSchema
+		}
+	}
+	return c.Range.ValidateConfig()
+}
+
+////////////////
+// tfgrep
+
+type TfgrepConfig struct {
+	Help      bool
+	InputPath string
+	Format    string
+	Epoch     bool
+	NoHeader  bool
+	All       bool
+	Expr      string
+	Text      bool
+
+	ZebraPackSchemaPath string
+
+	Predicate   *Predicate
+	ZebraSchema zebra.Schema
+}
+
+// call DefineFlags before myflags.Parse()
+func (c *TfgrepConfig) DefineFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&c.Help, "h", false, "show this help")
+	fs.StringVar(&c.InputPath, "in", "", "read this file instead of stdin")
+	fs.StringVar(&c.Format, "o", "tsv", "output format: tsv (time and fields, tab separated), jsonl (one JSON object per line), or frames (TMFRAME: one field becomes EvOneFloat64 frames, two become EvTwo64 with the second field as V1; missing or non-numeric values become EvNA)")
+	fs.BoolVar(&c.Epoch, "epoch", false, "print time as integer nanoseconds since the epoch, instead of RFC3339")
+	fs.BoolVar(&c.NoHeader, "noheader", false, "omit the tsv header line")
+	fs.BoolVar(&c.All, "all", false, "only output frames that have all the fields; by default frames with any of them are output")
+	fs.StringVar(&c.Expr, "e", "", "only consider frames matching this filter expression (as in tffilter -e)")
+	fs.BoolVar(&c.Text, "text", false, "old behavior: grep the escaped JSON fields out of tfcat text output on stdin")
+	fs.StringVar(&c.ZebraPackSchemaPath, "zebrapack-schema", "", "path to ZebraPack schema in msgpack2 format to read for decoding messages")
+}
+
+// call c.ValidateConfig() after myflags.Parse()
+func (c *TfgrepConfig) ValidateConfig() error {
+	switch c.Format {
+	case "tsv", "jsonl", "frames":
+	default:
+		return fmt.Errorf("-o '%s' unknown: use tsv, jsonl, or frames", c.Format)
+	}
+	if c.InputPath != "" && !FileExists(c.InputPath) {
+		return fmt.Errorf("-in '%s' does not exist", c.InputPath)
+	}
+	if c.ZebraPackSchemaPath != "" &&
+		!FileExists(c.ZebraPackSchemaPath) {
+		return fmt.Errorf("bad -zebrapack-schema path: "+
+			"'%s' does not exist.", c.ZebraPackSchemaPath)
+	}
+	if c.Expr != "" {
+		var err error
+		c.Predicate, err = ParsePredicate(c.Expr)
+		if err != nil {
+			return fmt.Errorf("bad -e: %v", err)
+		}
+		if c.ZebraPackSchemaPath != "" {
+			// loaded into c.ZebraSchema by the caller
+			c.Predicate.ZSchema = &c.ZebraSchema
+		}
+	}
+	return nil
+}
+
+////////////////
+// tfroll
+
+type TfrollConfig struct {
+	Help   bool
+	Window string
+	Stat   string
+
+	RollWindow RollWindow
+	RollStats  []RollStat
+}
+
+// call DefineFlags before myflags.Parse()
+func (c *TfrollConfig) DefineFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&c.Help, "h", false, "show this help")
+	fs.StringVar(&c.Window, "window", "100", "rolling window: a frame count (e.g. 100) or a duration (e.g. 5m)")
+	fs.StringVar(&c.Stat, "stat", "mean", "statistic to compute: mean, var, sd, min, max, ewma, zscore, roc, sum, or count. A comma separated list (e.g. ewma,max) chains the operators in order.")
+}
+
+// call c.ValidateConfig() after myflags.Parse()
+func (c *TfrollConfig) ValidateConfig() error {
+	var err error
+	c.RollWindow, err = ParseRollWindow(c.Window)
+	if err != nil {
+		return fmt.Errorf("bad -window: %v", err)
+	}
+	c.RollStats = c.RollStats[:0]
+	for _, name := range strings.Split(c.Stat, ",") {
+		st, err := ParseRollStat(strings.TrimSpace(name))
+		if err != nil {
+			return fmt.Errorf("bad -stat: %v", err)
+		}
+		c.RollStats = append(c.RollStats, st)
+	}
+	return nil
+}
+
+////////////////
+// tfgaps
+
+type TfgapsConfig struct {
+	Help       bool
+	Cadence    time.Duration
+	Threshold  time.Duration
+	LearnCount int
+	Fill       string
+	MaxFill    int64
+
+	GapFill GapFill
+}
+
+// call DefineFlags before myflags.Parse()
+func (c *TfgapsConfig) DefineFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&c.Help, "h", false, "show this help")
+	fs.DurationVar(&c.Cadence, "cadence", 0, "expected spacing between frames (e.g. 1s). If not given, the cadence is learned from the data.")
+	fs.DurationVar(&c.Threshold, "threshold", 0, "report spacings longer than this as gaps. Defaults to 1.5 times the cadence.")
+	fs.IntVar(&c.LearnCount, "learn", 1000, "number of frames to learn the cadence from")
+	fs.StringVar(&c.Fill, "fill", "none", "insert NA frames into gaps: none, slots (one NA per missing slot), or boundary (one NA at the start of each gap). With slots or boundary, the filled TMFRAME stream goes to stdout and the summary to stderr.")
+	fs.Int64Var(&c.MaxFill, "maxfill", 1000000, "at most this many NA frames are inserted into any one gap under -fill slots")
+}
+
+// call c.ValidateConfig() after myflags.Parse()
+func (c *TfgapsConfig) ValidateConfig() error {
+	var err error
+	c.GapFill, err = ParseGapFill(c.Fill)
+	if err != nil {
+		return fmt.Errorf("bad -fill: %v", err)
+	}
+	if c.Cadence < 0 {
+		return fmt.Errorf("-cadence must not be negative")
+	}
+	if c.Threshold < 0 {
+		return fmt.Errorf("-threshold must not be negative")
+	}
+	if c.LearnCount < 2 {
+		return fmt.Errorf("-learn must be at least 2")
+	}
+	return nil
+}
+
+// GapConfig converts the command line flags into a GapConfig.
+func (c *TfgapsConfig) GapConfig() GapConfig {
+	return GapConfig{
+		Cadence:       c.Cadence,
+		Threshold:     c.Threshold,
+		LearnCount:    c.LearnCount,
+		Fill:          c.GapFill,
+		MaxFillPerGap: c.MaxFill,
+	}
+}
+
+////////////////
+// tfcalc
+
+type TfcalcConfig struct {
+	Help bool
+
+	Expr     *CalcExpr
+	Operands map[string]string // variable name -> file path
+}
+
+// call DefineFlags before myflags.Parse()
+func (c *TfcalcConfig) DefineFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&c.Help, "h", false, "show this help")
+}
+
+// call c.ValidateConfig() after myflags.Parse()
+func (c *TfcalcConfig) ValidateConfig() error {
+	return nil
+}
+
+// SetArgs takes the leftover command line arguments: the
+// expression followed by one name=path binding per variable.
+func (c *TfcalcConfig) SetArgs(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("no expression given")
+	}
+	var err error
+	c.Expr, err = ParseCalcExpr(args[0])
+	if err != nil {
+		return err
+	}
+	c.Operands = make(map[string]string)
+	for _, a := range args[1:] {
+		eq := strings.Index(a, "=")
+		if eq <= 0 || eq == len(a)-1 {
+			return fmt.Errorf("bad operand binding '%s': use name=path", a)
+		}
+		name, path := a[:eq], a[eq+1:]
+		if _, dup := c.Operands[name]; dup {
+			return fmt.Errorf("variable '%s' bound more than once", name)
+		}
+		if !FileExists(path) {
+			return fmt.Errorf("input file '%s' for variable '%s' does not exist", path, name)
+		}
+		c.Operands[name] = path
+	}
+	for _, name := range c.Expr.Vars {
+		if _, ok := c.Operands[name]; !ok {
+			return fmt.Errorf("no file given for variable '%s' (add %s=path)", name, name)
+		}
+	}
+	return nil
+}
+
+////////////////
+// tfmerge
+
+type TfmergeConfig struct {
+	Help       bool
+	Tie        string
+	Tag        bool
+	Skew       ClockAdjustFlags
+	SkewRef    string
+	SkewBucket time.Duration
+	KeepOrig   bool
+	Range      TimeRangeFlags
+
+	TieBreak TieBreaker
+}
+
+// ClockAdjustFlags collects repeated -skew file=adjustment
+// flags, keyed by file.
+type ClockAdjustFlags map[string]*ClockAdjust
+
+// String is for flag.Value.
+func (c *ClockAdjustFlags) String() string {
+	var parts []string
+	for f, a := range *c {
+		parts = append(parts, f+"="+a.String())
+	}
+	return strings.Join(parts, " ")
+}
+
+// Set is for flag.Value. It parses one file=adjustment.
+func (c *ClockAdjustFlags) Set(v string) error {
+	i := strings.LastIndex(v, "=")
+	if i <= 0 {
+		return fmt.Errorf("bad -skew '%s': use file=offset, or file=offset@time,offset@time...", v)
+	}
+	a, err := ParseClockAdjust(v[i+1:])
+	if err != nil {
+		return err
+	}
+	if *c == nil {
+		*c = make(ClockAdjustFlags)
+	}
+	(*c)[v[:i]] = a
 	return nil
 }
+
+// call DefineFlags before myflags.Parse()
+func (c *TfmergeConfig) DefineFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&c.Help, "h", false, "show this help")
+	fs.StringVar(&c.Tie, "tie", "position", "order of frames with equal timestamps: position (input file order), evtnum (lowest first, then file order), or hash (by frame content, independent of file order)")
+	fs.BoolVar(&c.Tag, "tag", false, "tag each output frame with its source: write a header frame listing the input files, then wrap each frame in an EvSourced envelope. Use tfdemux to split the output back apart.")
+	fs.Var(&c.Skew, "skew", "correct a file's clock before merging: file=offset (e.g. a.tf=-15ms, added to each timestamp), or file=offset@time,offset@time... (RFC3339 times) to interpolate drift. Repeat for each file.")
+	fs.StringVar(&c.SkewRef, "skewref", "", "estimate the clock offset of each file not given a -skew, relative to the first file, from reference events: frames with the same value at this payload field path (e.g. msg.id) in both")
+	fs.DurationVar(&c.SkewBucket, "skewbucket", 0, "with -skewref, fit a drift model with one knot per bucket of this duration (e.g. 1h), instead of one fixed offset")
+	fs.BoolVar(&c.KeepOrig, "keeporig", false, "keep each corrected frame's original timestamp, by wrapping it in an EvAdjusted envelope stamped with the corrected time, instead of rewriting the timestamp")
+	c.Range.DefineFlags(fs, true)
+}
+
+// call c.ValidateConfig() after myflags.Parse()
+func (c *TfmergeConfig) ValidateConfig() error {
+	switch strings.ToLower(c.Tie) {
+	case "", "position", "pos":
+		c.TieBreak = nil
+	case "evtnum":
+		c.TieBreak = TieByEvtnum
+	case "hash":
+		c.TieBreak = TieByPayloadHash
+	default:
+		return fmt.Errorf("bad -tie '%s': use position, evtnum, or hash", c.Tie)
+	}
+	if c.SkewRef != "" {
+		if _, err := ParseFieldPath(c.SkewRef); err != nil {
+			return fmt.Errorf("bad -skewref: %v", err)
+		}
+	}
+	if c.SkewBucket < 0 {
+		return fmt.Errorf("-skewbucket must not be negative")
+	}
+	return c.Range.ValidateConfig()
+}
+
+////////////////
+// tfdemux
+
+type TfdemuxConfig struct {
+	Help   bool
+	OutDir string
+	Suffix string
+}
+
+// call DefineFlags before myflags.Parse()
+func (c *TfdemuxConfig) DefineFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&c.Help, "h", false, "show this help")
+	fs.StringVar(&c.OutDir, "outdir", ".", "directory to write the per-source files into")
+	fs.StringVar(&c.Suffix, "suffix", ".demux", "suffix added to each source file name")
+}
+
+// call c.ValidateConfig() after myflags.Parse()
+func (c *TfdemuxConfig) ValidateConfig() error {
+	if !DirExists(c.OutDir) {
+		return fmt.Errorf("-outdir '%s' does not exist.", c.OutDir)
+	}
+	return nil
+}
+
+////////////////
+// tfreorder
+
+type TfreorderConfig struct {
+	Help     bool
+	Lateness time.Duration
+	LateTo   string
+}
+
+// call DefineFlags before myflags.Parse()
+func (c *TfreorderConfig) DefineFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&c.Help, "h", false, "show this help")
+	fs.DurationVar(&c.Lateness, "lateness", 5*time.Second, "how far behind the newest frame seen a frame may arrive and still be put in order")
+	fs.StringVar(&c.LateTo, "lateto", "", "write frames that arrive later than -lateness to this file path, instead of dropping them")
+}
+
+// call c.ValidateConfig() after myflags.Parse()
+func (c *TfreorderConfig) ValidateConfig() error {
+	if c.Lateness < 0 {
+		return fmt.Errorf("-lateness must not be negative")
+	}
+	if c.LateTo != "" && FileExists(c.LateTo) {
+		return fmt.Errorf("late frames output file '%s' already exists, aborting.", c.LateTo)
+	}
+	return nil
+}
+
+////////////////
+// tfimport
+
+type TfimportConfig struct {
+	Help        bool
+	Format      string
+	Delim       string
+	TimeCol     string
+	TimeFormat  string
+	Tz          string
+	V0Col       string
+	V1Col       string
+	Payload     string
+	Evtnum      int64
+	Sort        bool
+	MemBudgetMB int64
+	TmpDir      string
+	MaxBad      int64
+
+	Loc *time.Location
+}
+
+// call DefineFlags before myflags.Parse()
+func (c *TfimportConfig) DefineFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&c.Help, "h", false, "show this help")
+	fs.StringVar(&c.Format, "format", "", "input format: csv or jsonl (default from the file extension, else csv)")
+	fs.StringVar(&c.Delim, "delim", ",", "CSV field delimiter; use '\\t' for tab")
+	fs.StringVar(&c.TimeCol, "time", "time", "name of the timestamp column")
+	fs.StringVar(&c.TimeFormat, "timefmt", "rfc3339", "timestamp format: rfc3339, epoch (nanoseconds), epochs, epochms, epochus, or a Go time layout such as '2006-01-02 15:04:05'")
+	fs.StringVar(&c.Tz, "tz", "UTC", "time zone of timestamps that have none, e.g. America/Chicago")
+	fs.StringVar(&c.V0Col, "v0", "", "numeric column to store in V0, as a float64")
+	fs.StringVar(&c.V1Col, "v1", "", "integer column to store in V1")
+	fs.StringVar(&c.Payload, "payload", "", "encoding of the remaining fields: json, msgpack, or none (default json without -v0/-v1, none with them)")
+	fs.Int64Var(&c.Evtnum, "evtnum", 0, "evtnum of the payload frames (default EvJson for json, EvMsgpack for msgpack); negative numbers, from -2 down, are for user-defined types")
+	fs.BoolVar(&c.Sort, "sort", false, "sort the output by time, as tfsort does")
+	fs.Int64Var(&c.MemBudgetMB, "mem", 1024, "with -sort, memory budget in megabytes before sorting externally")
+	fs.StringVar(&c.TmpDir, "tmpdir", "", "with -sort, directory for external sort runs")
+	fs.Int64Var(&c.MaxBad, "maxbad", -1, "stop with an error after this many bad rows; -1 means never")
+}
+
+// call c.ValidateConfig() after myflags.Parse()
+func (c *TfimportConfig) ValidateConfig() error {
+	switch c.Format {
+	case "", "csv", "jsonl":
+	default:
+		return fmt.Errorf("-format '%s' unknown: use csv or jsonl", c.Format)
+	}
+	if c.Delim == "\\t" {
+		c.Delim = "\t"
+	}
+	if utf8.RuneCountInString(c.Delim) != 1 {
+		return fmt.Errorf("-delim '%s' must be a single character", c.Delim)
+	}
+	if c.TimeCol == "" {
+		return fmt.Errorf("-time must name the timestamp column")
+	}
+	switch c.Payload {
+	case "", "json", "msgpack", "none":
+	default:
+		return fmt.Errorf("-payload '%s' unknown: use json, msgpack, or none", c.Payload)
+	}
+	if c.Payload == "none" && c.V0Col == "" && c.V1Col == "" {
+		return fmt.Errorf("-payload none needs -v0 or -v1, or there is nothing to import")
+	}
+	if !ValidEvtnum(Evtnum(c.Evtnum)) {
+		return fmt.Errorf("-evtnum %v illegal: must be from -1048576 to 1048575", c.Evtnum)
+	}
+	if c.Evtnum > 0 && c.Evtnum < int64(EvUDE) {
+		return fmt.Errorf("-evtnum %v illegal: %v frames cannot carry a payload", c.Evtnum, Evtnum(c.Evtnum))
+	}
+	if c.MemBudgetMB <= 0 {
+		return fmt.Errorf("-mem %v illegal: must be positive.", c.MemBudgetMB)
+	}
+	if c.TmpDir != "" && !DirExists(c.TmpDir) {
+		return fmt.Errorf("-tmpdir '%s' does not exist.", c.TmpDir)
+	}
+	loc, err := time.LoadLocation(c.Tz)
+	if err != nil {
+		return fmt.Errorf("-tz '%s': %v", c.Tz, err)
+	}
+	c.Loc = loc
+	return nil
+}
+
+// ImportConfig converts the command line flags into an ImportConfig.
+func (c *TfimportConfig) ImportConfig() ImportConfig {
+	comma, _ := utf8.DecodeRuneInString(c.Delim)
+	return ImportConfig{
+		Format:     c.Format,
+		Comma:      comma,
+		TimeCol:    c.TimeCol,
+		TimeFormat: c.TimeFormat,
+		Loc:        c.Loc,
+		V0Col:      c.V0Col,
+		V1Col:      c.V1Col,
+		Payload:    c.Payload,
+		Evtnum:     Evtnum(c.Evtnum),
+	}
+}
+
+////////////////
+// tfsplit
+
+type TfsplitConfig struct {
+	Help      bool
+	Dir       string
+	Stream    string
+	Every     string
+	By        string
+	Size      string
+	Template  string
+	Index     bool
+	TZ        string
+	MaxOpen   int
+	InputPath string
+
+	ZebraPackSchemaPath string
+
+	Interval    *GroupInterval
+	MaxBytes    int64
+	Loc         *time.Location
+	ZebraSchema zebra.Schema
+}
+
+// call DefineFlags before myflags.Parse()
+func (c *TfsplitConfig) DefineFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&c.Help, "h", false, "show this help")
+	fs.StringVar(&c.Dir, "dir", ".", "directory to write the output files under")
+	fs.StringVar(&c.Stream, "stream", "stream", "stream name, used in the output file names")
+	fs.StringVar(&c.Every, "every", "", "start a new file each time period: a duration (e.g. 15m) or an optional count and a calendar unit: min, hour, day, week, month, quarter, year (e.g. hour, 2day)")
+	fs.StringVar(&c.By, "by", "", "start a new file for each 'evtnum', or for each value at this payload field path (e.g. host.name)")
+	fs.StringVar(&c.Size, "size", "", "start a new file before one would exceed this size, e.g. 1G or 500MB")
+	fs.StringVar(&c.Template, "o", "", "output file name template, relative to -dir, using {stream} {key} {seq} {yyyy} {mm} {dd} {hh} {min} {start}, e.g. '{stream}-{yyyy}{mm}{dd}{hh}.tf'. The default is the archiver's YYYY/MM/DD/stream layout.")
+	fs.BoolVar(&c.Index, "index", false, "write a .idx index beside each output file, as tfindex does")
+	fs.StringVar(&c.TZ, "tz", "UTC", "time zone for calendar periods and for {yyyy} ... {min} in -o, e.g. America/Chicago")
+	fs.IntVar(&c.MaxOpen, "maxopen", 64, "most output files to hold open at once")
+	fs.StringVar(&c.InputPath, "in", "", "read this file instead of stdin")
+	fs.StringVar(&c.ZebraPackSchemaPath, "zebrapack-schema", "", "path to ZebraPack schema in msgpack2 format, for ZebraPack payload paths in -by")
+}
+
+// call c.ValidateConfig() after myflags.Parse()
+func (c *TfsplitConfig) ValidateConfig() error {
+	var err error
+	if c.Every == "" && c.By == "" && c.Size == "" {
+		return fmt.Errorf("give at least one of -every, -by, or -size")
+	}
+	c.Interval = nil
+	if c.Every != "" {
+		iv, err := ParseGroupInterval(c.Every)
+		if err != nil {
+			return fmt.Errorf("bad -every: %v", err)
+		}
+		c.Interval = &iv
+	}
+	c.MaxBytes = 0
+	if c.Size != "" {
+		c.MaxBytes, err = ParseByteSize(c.Size)
+		if err != nil {
+			return fmt.Errorf("bad -size: %v", err)
+		}
+		if c.MaxBytes <= 0 {
+			return fmt.Errorf("-size must be positive")
+		}
+	}
+	if c.By != "" && c.By != "evtnum" {
+		if _, err := ParseFieldPath(c.By); err != nil {
+			return fmt.Errorf("bad -by field path '%s': %v", c.By, err)
+		}
+	}
+	if c.Stream == "" || strings.Contains(c.Stream, "/") {
+		return fmt.Errorf("-stream '%s' must be a non-empty name without slashes", c.Stream)
+	}
+	if c.Template != "" {
+		if c.Interval != nil && !strings.Contains(c.Template, "{") {
+			return fmt.Errorf("-o '%s' must use a {...} placeholder, or every output would share the one name", c.Template)
+		}
+		if c.By != "" && !strings.Contains(c.Template, "{key}") {
+			return fmt.Errorf("-o '%s' must use {key} with -by", c.Template)
+		}
+		if c.MaxBytes > 0 && !strings.Contains(c.Template, "{seq}") {
+			return fmt.Errorf("-o '%s' must use {seq} with -size", c.Template)
+		}
+	}
+	if c.MaxOpen <= 0 {
+		return fmt.Errorf("-maxopen %v illegal: must be positive.", c.MaxOpen)
+	}
+	if !DirExists(c.Dir) {
+		return fmt.Errorf("-dir '%s' does not exist.", c.Dir)
+	}
+	c.Loc, err = time.LoadLocation(c.TZ)
+	if err != nil {
+		return fmt.Errorf("bad -tz: %v", err)
+	}
+	if c.InputPath != "" && !FileExists(c.InputPath) {
+		return fmt.Errorf("-in '%s' does not exist", c.InputPath)
+	}
+	if c.ZebraPackSchemaPath != "" &&
+		!FileExists(c.ZebraPackSchemaPath) {
+		return fmt.Errorf("bad -zebrapack-schema path: "+
+			"'%s' does not exist.", c.ZebraPackSchemaPath)
+	}
+	return nil
+}
+
+////////////////
+// tfdiff
+
+type TfdiffConfig struct {
+	Help       bool
+	Tolerance  time.Duration
+	IgnoreList string
+	Quiet      bool
+	MaxDetails int64
+
+	ZebraPackSchemaPath string
+
+	Ignore      []string
+	ZebraSchema zebra.Schema
+}
+
+// call DefineFlags before myflags.Parse()
+func (c *TfdiffConfig) DefineFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&c.Help, "h", false, "show this help")
+	fs.DurationVar(&c.Tolerance, "tol", 0, "match frames whose timestamps differ by up to this much, e.g. 1ms")
+	fs.StringVar(&c.IgnoreList, "ignore", "", "comma separated payload field paths not to compare, e.g. 'seq,hdr.host'; also v0, v1, pti")
+	fs.BoolVar(&c.Quiet, "q", false, "print only the summary, not each difference")
+	fs.Int64Var(&c.MaxDetails, "max", 0, "print at most this many differences before the summary; 0 means all")
+	fs.StringVar(&c.ZebraPackSchemaPath, "zebrapack-schema", "", "path to ZebraPack schema in msgpack2 format, to compare ZebraPack payloads field by field")
+}
+
+// call c.ValidateConfig() after myflags.Parse()
+func (c *TfdiffConfig) ValidateConfig() error {
+	if c.Tolerance < 0 {
+		return fmt.Errorf("-tol must not be negative")
+	}
+	if c.MaxDetails < 0 {
+		return fmt.Errorf("-max %v illegal: must not be negative", c.MaxDetails)
+	}
+	c.Ignore = c.Ignore[:0]
+	if c.IgnoreList != "" {
+		for _, p := range strings.Split(c.IgnoreList, ",") {
+			p = strings.TrimSpace(p)
+			if _, err := ParseFieldPath(p); err != nil {
+				return fmt.Errorf("bad -ignore field path '%s': %v", p, err)
+			}
+			c.Ignore = append(c.Ignore, p)
+		}
+	}
+	if c.ZebraPackSchemaPath != "" &&
+		!FileExists(c.ZebraPackSchemaPath) {
+		return fmt.Errorf("bad -zebrapack-schema path: "+
+			"'%s' does not exist.", c.ZebraPackSchemaPath)
+	}
+	return nil
+}
+
+////////////////
+// -start and -end, for tfcat, tffilter, tfsort, and tfmerge
+
+// TimeRangeFlags adds -start, -end, and -unsorted to a command.
+type TimeRangeFlags struct {
+	StartArg string
+	EndArg   string
+	Unsorted bool
+
+	TimeRange
+}
+
+// call DefineFlags before myflags.Parse()
+func (c *TimeRangeFlags) DefineFlags(fs *flag.FlagSet, sortedInput bool) {
+	fs.StringVar(&c.StartArg, "start", "", "skip frames before this time: a timestamp (2016-05-01T09:30:00Z, or a prefix, in UTC), a date (2016/05/01), now, or relative to now (-2h, -3d)")
+	fs.StringVar(&c.EndArg, "end", "", "skip frames at or after this time, given as for -start, or relative to -start (+1h)")
+	if sortedInput {
+		fs.BoolVar(&c.Unsorted, "unsorted", false, "the input is not in time order: with -start/-end, read it all, instead of seeking with its .idx index and stopping after -end")
+	}
+}
+
+// call c.ValidateConfig() after myflags.Parse()
+func (c *TimeRangeFlags) ValidateConfig() error {
+	var err error
+	c.TimeRange, err = ParseTimeRange(c.StartArg, c.EndArg, time.Now())
+	if err != nil {
+		return fmt.Errorf("bad -start/-end: %v", err)
+	}
+	return nil
+}
+
+////////////////
+// tfgen
+
+type TfgenConfig struct {
+	Help     bool
+	Count    int64
+	Duration time.Duration
+	Seed     int64
+	StartArg string
+	Rate     float64
+	Jitter   float64
+	Evtnums  string
+	Model    string
+	Base     float64
+	Step     float64
+	Amp      float64
+	Period   time.Duration
+	Payload  string
+	Dup      float64
+	Gap      float64
+	GapLen   time.Duration
+	OOO      float64
+	OOOMax   int
+	OutPath  string
+	Stats    bool
+
+	Gen GenConfig
+}
+
+// call DefineFlags before myflags.Parse()
+func (c *TfgenConfig) DefineFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&c.Help, "h", false, "show this help")
+	fs.Int64Var(&c.Count, "n", 1000, "number of frames to generate, not counting -dup copies; 0 means no limit (use -dur)")
+	fs.DurationVar(&c.Duration, "dur", 0, "stop after this much time from -start, e.g. 24h")
+	fs.Int64Var(&c.Seed, "seed", 1, "random seed; the same seed and flags always give the same output")
+	fs.StringVar(&c.StartArg, "start", "2016-02-16T00:00:00Z", "time of the first frame: a timestamp, a date (2016/05/01), now, or relative to now (-2h)")
+	fs.Float64Var(&c.Rate, "rate", 1, "frames per second")
+	fs.Float64Var(&c.Jitter, "jitter", 0, "delay each frame by a random fraction, up to this, of 1/rate; from 0 to 1")
+	fs.StringVar(&c.Evtnums, "evtnums", "2", "evtnums to pick from at random: a list of numbers and ranges, e.g. 2,3,14 or 0..7 or -5..-2 (user-defined), or 'all' for every PTI and built-in evtnum, EvErr included")
+	fs.StringVar(&c.Model, "model", "walk", "value model: walk (random walk from -base) or seasonal (-base + -amp*sin(2*pi*t/-period), plus noise)")
+	fs.Float64Var(&c.Base, "base", 100, "starting or mean value")
+	fs.Float64Var(&c.Step, "step", 1, "standard deviation of the walk steps, or of the seasonal noise")
+	fs.Float64Var(&c.Amp, "amp", 10, "amplitude of the seasonal model")
+	fs.DurationVar(&c.Period, "period", 24*time.Hour, "period of the seasonal model")
+	fs.StringVar(&c.Payload, "payload", "64", "payload sizes in bytes: 64, fixed:64, uniform:16-1024, normal:200,50, or exp:200")
+	fs.Float64Var(&c.Dup, "dup", 0, "probability that a frame is duplicated")
+	fs.Float64Var(&c.Gap, "gap", 0, "probability of a gap, of -gaplen without frames, before a frame")
+	fs.DurationVar(&c.GapLen, "gaplen", time.Minute, "length of -gap gaps")
+	fs.Float64Var(&c.OOO, "ooo", 0, "probability that a frame is held back and written out of order")
+	fs.IntVar(&c.OOOMax, "ooomax", 5, "an -ooo frame comes after at most this many later frames")
+	fs.StringVar(&c.OutPath, "o", "", "write to this file instead of stdout")
+	fs.BoolVar(&c.Stats, "stats", false, "print counts of frames, duplicates, gaps, and out of order frames to stderr")
+}
+
+// call c.ValidateConfig() after myflags.Parse()
+func (c *TfgenConfig) ValidateConfig() error {
+	if c.Count < 0 {
+		return fmt.Errorf("-n %v illegal: must not be negative", c.Count)
+	}
+	if c.Duration < 0 {
+		return fmt.Errorf("-dur %v illegal: must not be negative", c.Duration)
+	}
+	if c.Count == 0 && c.Duration == 0 {
+		return fmt.Errorf("-n 0 needs a -dur, or the output never ends")
+	}
+	start, err := parseTimeBound(c.StartArg, time.Now())
+	if err != nil {
+		return fmt.Errorf("bad -start: %v", err)
+	}
+	evtnums, err := ParseGenEvtnums(c.Evtnums)
+	if err != nil {
+		return fmt.Errorf("bad -evtnums: %v", err)
+	}
+	payload, err := ParseSizeDist(c.Payload)
+	if err != nil {
+		return fmt.Errorf("bad -payload: %v", err)
+	}
+	if c.OOOMax <= 0 {
+		return fmt.Errorf("-ooomax %v illegal: must be positive", c.OOOMax)
+	}
+	c.Gen = GenConfig{
+		Seed:      c.Seed,
+		Start:     time.Unix(0, start).UTC(),
+		Count:     c.Count,
+		Rate:      c.Rate,
+		Jitter:    c.Jitter,
+		Evtnums:   evtnums,
+		Model:     c.Model,
+		Base:      c.Base,
+		Step:      c.Step,
+		Amplitude: c.Amp,
+		Period:    c.Period,
+		Payload:   payload,
+		DupProb:   c.Dup,
+		GapProb:   c.Gap,
+		GapLen:    c.GapLen,
+		OOOProb:   c.OOO,
+		OOOMax:    c.OOOMax,
+	}
+	if c.Duration > 0 {
+		c.Gen.End = c.Gen.Start.Add(c.Duration)
+	}
+	// NewGenerator checks the rest
+	_, err = NewGenerator(c.Gen)
+	return err
+}
@@ -3,12 +3,12 @@ package tm
 import (
 	"github.com/nats-io/nats"
 	"io"
-	"sort"
 )
 
 // FrameChWriter provides merge-sort (via Merge) with
-// output sent to a channel rather than an io.Writer.
-// Output frames are sent on the SendOnMe channel.
+// output sent to a FramePublisher rather than an io.Writer.
+// By default, output frames are sent as nats.Msg on the
+// SendOnMe channel.
 // FrameChWriter may buffer frames and does not force
 // sending immediately.
 type FrameChWriter struct {
@@ -16,6 +16,12 @@ type FrameChWriter struct {
 	fr       *FrameReader
 	buf      []byte
 	SendOnMe chan *nats.Msg
+
+	// Pub receives the merged frames.
+	Pub FramePublisher
+
+	// Cancel, if closed, stops a Merge in progress.
+	Cancel <-chan struct{}
 }
 
 // NewFrameToChannelWriter construts a new FrameChWriter for buffering
@@ -27,10 +33,19 @@ func NewFrameChWriter(maxFrameBytes int64, sendOnMe chan *nats.Msg) *FrameChWrit
 	s := &FrameChWriter{
 		SendOnMe: sendOnMe,
 		buf:      make([]byte, maxFrameBytes),
+		Pub:      &NatsChPublisher{Ch: sendOnMe},
 	}
 	return s
 }
 
+// NewFrameChWriterTo constructs a FrameChWriter that
+// publishes through pub.
+func NewFrameChWriterTo(pub FramePublisher) *FrameChWriter {
+	return &FrameChWriter{
+		Pub: pub,
+	}
+}
+
 // SendOnCh sends f on the fw.SendOnMe channel, using
 // a nats.Msg with subject: "tseries.replay." + subject + "." + datestr.
 // SendOnCh marshals the frame, effectively copying it.
@@ -43,72 +58,36 @@ func (fw *FrameChWriter) SendOnCh(f *Frame, subject string, datestr string) {
 
 // Merge merges the strms input into timestamp order, based on
 // the Frame.Tm() timestamp, and writes the ordered sequence
-// out to fw.SendOnMe
+// out to fw.Pub. Frames with equal timestamps are sent
+// in the order of their input streams. If fw.Pub is a Drainer,
+// Merge waits for it to drain before returning.
 func (fw *FrameChWriter) Merge(datestr string, strms ...*BufferedFrameReader) error {
+	return fw.MergeBy(datestr, nil, strms...)
+}
 
-	n := len(strms)
-	peeks := make([]*frameElem, n)
-	for i := 0; i < n; i++ {
-		peeks[i] = &frameElem{bfr: strms[i], index: i, name: strms[i].Name}
+// MergeBy is Merge, with ties between equal timestamps from
+// different streams ordered by tie first, and by input
+// position second.
+func (fw *FrameChWriter) MergeBy(datestr string, tie TieBreaker, strms ...*BufferedFrameReader) error {
+	m, err := NewFrameMerger(tie, strms...)
+	if err != nil {
+		return err
 	}
-
-	var err error
-
-	// initialize the Frames in peeks
-	newlist := []*frameElem{}
-	for i := range peeks {
-		peeks[i].frame, err = peeks[i].bfr.Peek()
+	for {
+		fr, i, err := m.Next()
 		if err != nil {
 			if err == io.EOF {
-				// peeks[i].frame will be nil.
-				p("err = %v, peeks[i=%v]=%#v", err, i, peeks[i].frame)
-			} else {
-				return err
-			}
-		} else {
-			newlist = append(newlist, peeks[i])
-		}
-	}
-	peeks = newlist
-
-	for len(peeks) > 0 {
-		if len(peeks) == 1 {
-			// just copy over the rest of this stream and we're done
-			err = nil
-			//q("down to just one (%v), copying en-mass", peeks[0].index)
-
-			//q("sending %v", peeks[0].frame.String())
-			fw.SendOnCh(peeks[0].frame, peeks[0].name, datestr)
-			var fr *Frame
-			//q("top of bulk loop , err = %v", err)
-			for err == nil {
-				fr, err = peeks[0].bfr.ReadOne()
-				//q("ReadOne() gave err = %v, fr = %v", err, fr.String())
-				if err == nil {
-					//q("bulk, sending %v", fr.String())
-					fw.SendOnCh(fr, peeks[0].name, datestr)
-				} else {
-					//q("bulk, err I see: %v", err)
-				}
-			}
-			if err == io.EOF {
-				return nil
+				break
 			}
 			return err
 		}
-		// have 2 or more source left, sort and pick the earliest
-		sort.Sort(frameSorter(peeks))
-		fw.SendOnCh(peeks[0].frame, peeks[0].name, datestr)
-		peeks[0].bfr.Advance()
-		peeks[0].frame, err = peeks[0].bfr.Peek()
+		err = fw.Pub.Publish(fr, strms[i].Name, datestr, fw.Cancel)
 		if err != nil {
-			if err == io.EOF {
-				//q("saw err '%v',  finished with stream %v", err, peeks[0].index)
-				peeks = peeks[1:]
-			} else {
-				return err
-			}
+			return err
 		}
 	}
+	if d, ok := fw.Pub.(Drainer); ok {
+		return d.Drain(fw.Cancel)
+	}
 	return nil
 }
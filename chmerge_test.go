@@ -1,11 +1,13 @@
 package tm
 
 import (
+	"bytes"
 	"fmt"
 	cv "github.com/glycerine/goconvey/convey"
 	"github.com/nats-io/nats"
 	"os"
 	"testing"
+	"time"
 )
 
 func Test050MergeSortToChannel(t *testing.T) {
@@ -104,3 +106,77 @@ func SendDirOnChannel(ch chan *nats.Msg, inputFiles []string, dir string, datest
 	// okay, now create and merge streams
 	return outputStream.Merge(datestr, strms...)
 }
+
+func Test051MergeThroughPublisherWithFlowControl(t *testing.T) {
+
+	// two interleaved streams of 50 frames each
+	frames, _, _ := GenTestFramesSequence(100, nil)
+	var ab [2]bytes.Buffer
+	for i, f := range frames {
+		panicOn(writeFrames(&ab[i%2], []*Frame{f}))
+	}
+	streams := func() []*BufferedFrameReader {
+		return []*BufferedFrameReader{
+			NewBufferedFrameReader(bytes.NewReader(ab[0].Bytes()), 1024, "even"),
+			NewBufferedFrameReader(bytes.NewReader(ab[1].Bytes()), 1024, "odd"),
+		}
+	}
+
+	cv.Convey("Merge through a flow controlled channel of *Frame should deliver every frame in order, never over the in-flight bound", t, func() {
+		ch := make(chan *Frame, 100)
+		fc := NewFlowControl(&FrameChPublisher{Ch: ch}, 3, time.Second)
+		maxInFlight := 0
+		var got []*Frame
+		done := make(chan bool)
+		go func() {
+			for f := range ch {
+				if n := fc.InFlight(); n > maxInFlight {
+					maxInFlight = n
+				}
+				got = append(got, f)
+				fc.Ack()
+			}
+			close(done)
+		}()
+		err := NewFrameChWriterTo(fc).Merge("2016/02/16", streams()...)
+		close(ch)
+		<-done
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(len(got), cv.ShouldEqual, 100)
+		cv.So(maxInFlight, cv.ShouldBeLessThanOrEqualTo, 3)
+		cv.So(fc.Acked(), cv.ShouldEqual, 100)
+		for i := range got {
+			cv.So(FramesEqual(got[i], frames[i]), cv.ShouldBeTrue)
+		}
+	})
+
+	cv.Convey("A consumer that stops acknowledging should fail the Merge with ErrPublishStalled, rather than hang", t, func() {
+		var n int
+		fc := NewFlowControl(FuncPublisher(func(f *Frame, src, datestr string) error {
+			n++
+			return nil
+		}), 5, 20*time.Millisecond)
+		err := NewFrameChWriterTo(fc).Merge("2016/02/16", streams()...)
+		cv.So(err, cv.ShouldEqual, ErrPublishStalled)
+		cv.So(n, cv.ShouldEqual, 5)
+	})
+
+	cv.Convey("Closing Cancel should stop a Merge blocked on a slow consumer", t, func() {
+		cancel := make(chan struct{})
+		fw := NewFrameChWriterTo(&FrameChPublisher{Ch: make(chan *Frame)})
+		fw.Cancel = cancel
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			close(cancel)
+		}()
+		err := fw.Merge("2016/02/16", streams()...)
+		cv.So(err, cv.ShouldEqual, ErrPublishCanceled)
+	})
+
+	cv.Convey("A WriterPublisher should produce the same bytes as FrameWriter.Merge", t, func() {
+		var viaPub, viaWriter bytes.Buffer
+		panicOn(NewFrameChWriterTo(&WriterPublisher{W: &viaPub}).Merge("", streams()...))
+		panicOn(NewFrameWriter(&viaWriter, 1024).Merge(streams()...))
+		cv.So(bytes.Equal(viaPub.Bytes(), viaWriter.Bytes()), cv.ShouldBeTrue)
+	})
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	tf "github.com/glycerine/tmframe"
+	"os"
+)
+
+func showUse(myflags *flag.FlagSet) {
+	fmt.Fprintf(os.Stderr, "%s computes an arithmetic expression over aligned TMFRAME streams, writing PtiOneFloat64 frames to stdout at every timestamp in the union of the inputs. Each variable takes its value in force at that time. Usage: %s 'a - 2*b' a=file1 b=file2\n", os.Args[0], os.Args[0])
+	myflags.PrintDefaults()
+}
+
+func usage(err error, myflags *flag.FlagSet) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+	}
+	showUse(myflags)
+	os.Exit(1)
+}
+
+func main() {
+	myflags := flag.NewFlagSet("tfcalc", flag.ExitOnError)
+	cfg := &tf.TfcalcConfig{}
+	cfg.DefineFlags(myflags)
+
+	err := myflags.Parse(os.Args[1:])
+	err = cfg.ValidateConfig()
+	if err != nil || cfg.Help {
+		usage(err, myflags)
+	}
+
+	err = cfg.SetArgs(myflags.Args())
+	if err != nil {
+		usage(err, myflags)
+	}
+
+	operands := make(map[string]*tf.BufferedFrameReader)
+	for _, name := range cfg.Expr.Vars {
+		path := cfg.Operands[name]
+		f, err := os.Open(path)
+		panicOn(err)
+		defer f.Close()
+		operands[name] = tf.NewBufferedFrameReader(f, 1024*1024, path)
+	}
+
+	err = tf.CalcStreams(cfg.Expr, operands, os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tfcalc error: '%v'\n", err)
+		os.Exit(1)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bufio"
 	"flag"
 	"fmt"
 	tf "github.com/glycerine/tmframe"
@@ -11,7 +12,7 @@ import (
 )
 
 func showUse(myflags *flag.FlagSet) {
-	fmt.Fprintf(os.Stderr, "%s displays TMFRAME files. Usage: %s {-p} {-s} {-f} <file1> <file2> ...\n", os.Args[0], os.Args[0])
+	fmt.Fprintf(os.Stderr, "%s displays TMFRAME files. Usage: %s {-p} {-s} {-f} {-format csv|tsv|jsonl {-epoch} {-fields a.b,c}} {-start time} {-end time} {-rawrange} <file1> <file2> ...\n", os.Args[0], os.Args[0])
 	myflags.PrintDefaults()
 }
 
@@ -55,6 +56,8 @@ func main() {
 		os.Exit(1)
 	}
 	GlobalPrettyPrint = cfg.PrettyPrint
+	disp := newDisplay(cfg)
+	defer disp.flush()
 
 	if cfg.Follow {
 		if len(leftover) != 1 {
@@ -67,7 +70,7 @@ func main() {
 			showUse(myflags)
 			os.Exit(1)
 		}
-		FollowFile(leftover[0], cfg)
+		FollowFile(leftover[0], disp)
 		return
 	}
 
@@ -80,6 +83,18 @@ func main() {
 		leftover = []string{"stdin"}
 	}
 
+	if cfg.RawRange || ((cfg.RawCount > 0 || cfg.RawSkip > 0) && cfg.Range.IsSet()) {
+		if len(leftover) != 1 {
+			fmt.Fprintf(os.Stderr, "can only copy raw messages from one file\n")
+			showUse(myflags)
+			os.Exit(1)
+		}
+		in := openInput(leftover[0], cfg)
+		defer in.Close()
+		SendRawRange(in, cfg.RawCount, os.Stdout, cfg.RawSkip)
+		return
+	}
+
 	if cfg.RawCount > 0 || cfg.RawSkip > 0 {
 		if len(leftover) != 1 {
 			fmt.Fprintf(os.Stderr, "can only copy raw messages from one file\n")
@@ -94,7 +109,7 @@ func main() {
 nextfile:
 	for _, inputFile := range leftover {
 
-		f := prepInput(inputFile)
+		f := openInput(inputFile, cfg)
 		defer f.Close()
 		//P("starting on inputFile '%s'", inputFile)
 
@@ -108,15 +123,16 @@ nextfile:
 				if err == io.EOF {
 					continue nextfile
 				}
+				disp.flush()
 				fmt.Fprintf(os.Stderr, "tfcat error from fr.NextFrame() at i=%v: '%v'\n", i, err)
 				os.Exit(1)
 			}
-			frame.DisplayFrame(os.Stdout, i, cfg.PrettyPrint, cfg.SkipPayload, cfg.Rreadable, &cfg.ZebraSchema)
+			disp.show(&frame, i)
 		}
 	}
 }
 
-func FollowFile(path string, cfg *tf.TfcatConfig) {
+func FollowFile(path string, disp *display) {
 
 	if !FileExists(path) {
 		fmt.Fprintf(os.Stderr, "input file '%s' does not exist.\n", path)
@@ -156,11 +172,54 @@ nextFrame:
 			fmt.Fprintf(os.Stderr, "tfcat error from fr.NextFrame(): '%v'\n", err)
 			os.Exit(1)
 		}
-		frame.DisplayFrame(os.Stdout, i, cfg.PrettyPrint, cfg.SkipPayload, cfg.Rreadable, &cfg.ZebraSchema)
+		if !disp.cfg.Range.Contains(frame.Tm()) {
+			continue
+		}
+		disp.show(&frame, i)
+		disp.flush()
 		i++
 	}
 }
 
+// display prints frames, in the DisplayFrame text formats,
+// or as table rows under -format.
+type display struct {
+	cfg   *tf.TfcatConfig
+	w     *bufio.Writer
+	table *tf.TableWriter
+}
+
+func newDisplay(cfg *tf.TfcatConfig) *display {
+	d := &display{cfg: cfg}
+	if cfg.Format != "" {
+		d.w = bufio.NewWriter(os.Stdout)
+		var err error
+		d.table, err = tf.NewTableWriter(d.w, cfg.Format)
+		panicOn(err)
+		d.table.EpochTime = cfg.Epoch
+		d.table.Fields = cfg.FieldPaths
+		if cfg.ZebraPackSchemaPath != "" {
+			d.table.ZSchema = &cfg.ZebraSchema
+		}
+	}
+	return d
+}
+
+func (d *display) show(frame *tf.Frame, i int64) {
+	if d.table == nil {
+		frame.DisplayFrame(os.Stdout, i, d.cfg.PrettyPrint, d.cfg.SkipPayload, d.cfg.Rreadable, &d.cfg.ZebraSchema)
+		return
+	}
+	panicOn(d.table.Write(frame))
+}
+
+func (d *display) flush() {
+	if d.table != nil {
+		panicOn(d.table.Flush())
+		panicOn(d.w.Flush())
+	}
+}
+
 func prepInput(inputPath string) *os.File {
 
 	if inputPath != "stdin" && !FileExists(inputPath) {
@@ -180,6 +239,46 @@ func prepInput(inputPath string) *os.File {
 	return f
 }
 
+// openInput opens inputPath, or stdin, for reading the frames
+// between -start and -end, if they are given.
+func openInput(inputPath string, cfg *tf.TfcatConfig) io.ReadCloser {
+	if !cfg.Range.IsSet() {
+		return prepInput(inputPath)
+	}
+	sorted := !cfg.Range.Unsorted
+	if inputPath == "stdin" {
+		return ioutil.NopCloser(tf.NewTimeRangeReader(os.Stdin, cfg.Range.TimeRange, sorted))
+	}
+	if !FileExists(inputPath) {
+		fmt.Fprintf(os.Stderr, "input file '%s' does not exist.\n", inputPath)
+		os.Exit(1)
+	}
+	r, err := tf.OpenTimeRange(inputPath, cfg.Range.TimeRange, sorted)
+	panicOn(err)
+	return r
+}
+
+// SendRawRange copies the raw TMFRAME bytes of writeFrameCount
+// frames (or all, if 0) read from r, after skipping skipFrameCount,
+// to w.
+func SendRawRange(r io.Reader, writeFrameCount int, w io.Writer, skipFrameCount int) {
+	fr := tf.NewFrameReader(r, 1024*1024)
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+	var frame tf.Frame
+	for i := 0; writeFrameCount == 0 || i < skipFrameCount+writeFrameCount; i++ {
+		_, _, err, raw := fr.NextFrame(&frame)
+		if err == io.EOF {
+			return
+		}
+		panicOn(err)
+		if i >= skipFrameCount {
+			_, err = bw.Write(raw)
+			panicOn(err)
+		}
+	}
+}
+
 // copy the raw TMFRAME bytes of messageCount messages read from
 // inputPath to w
 func SendRawBytes(inputPath string, writeFrameCount int, w io.Writer, skipFrameCount int) {
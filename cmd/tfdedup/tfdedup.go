@@ -9,8 +9,8 @@ import (
 )
 
 func showUse(myflags *flag.FlagSet) {
-	fmt.Fprintf(os.Stderr, "%s reads through a file and deduplicates a 10 "+
-		"minutes sliding window. Usage: %s {-dupsto file} {-window size} <file_to_dedup>; if no file given stdin is read instead.\n",
+	fmt.Fprintf(os.Stderr, "%s reads through a file and deduplicates it over a "+
+		"sliding window of frames (-window) and/or time (-dur). Usage: %s {-dupsto file} {-window size} {-dur 10m} {-key frame|payload|tm-evtnum|field} {-field path} {-keep first|last} {-state path} <file_to_dedup>; if no file given stdin is read instead. With -state, copies of keys that have aged out of the -state-exact most recent are found only by the Bloom filters; these are passed through, and counted as probable duplicates at exit, unless -trust-bloom is given.\n",
 		os.Args[0], os.Args[0])
 	myflags.PrintDefaults()
 }
@@ -59,13 +59,24 @@ func main() {
 		r = os.Stdin
 	}
 
-	var dupf *os.File
+	var dupw io.Writer
 	if cfg.WriteDupsToFile != "" {
-		dupf, err = os.Create(cfg.WriteDupsToFile)
+		dupf, err := os.Create(cfg.WriteDupsToFile)
 		panicOn(err)
+		defer dupf.Close()
+		dupw = dupf
 	}
 
-	err = tf.Dedup(r, os.Stdout, cfg.WindowSize, dupf, cfg.DetectOnly)
+	dcfg := cfg.DedupConfig()
+	if cfg.StatePath != "" {
+		dcfg.State, err = tf.LoadDedupStateFile(cfg.StatePath, cfg.DedupStateConfig())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "tfdedup error loading -state '%s': '%v'\n", cfg.StatePath, err)
+			os.Exit(1)
+		}
+	}
+
+	stats, err := tf.DedupWith(r, os.Stdout, dcfg, dupw, cfg.DetectOnly)
 	if cfg.DetectOnly {
 		asDup, isDup := err.(*tf.DupDetectedErr)
 		if isDup {
@@ -78,6 +89,19 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	if dcfg.State != nil && !cfg.DetectOnly {
+		err = dcfg.State.SaveFile(cfg.StatePath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "tfdedup error saving -state '%s': '%v'\n", cfg.StatePath, err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "tfdedup: %d of %d frames were duplicates, %d of them from -state; %d matched only its Bloom filters.\n",
+			stats.Dups, stats.Frames, stats.StateDups, stats.Probable)
+		if stats.Probable > 0 && !cfg.TrustBloom {
+			fmt.Fprintf(os.Stderr, "tfdedup: warning: the %d probable duplicates were passed through, since their keys were no longer among the -state-exact %d most recent. Raise -state-exact, or use -trust-bloom, to drop them.\n",
+				stats.Probable, cfg.StateExact)
+		}
+	}
 	os.Stdout.Sync()
 	os.Stdout.Close()
 }
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	tf "github.com/glycerine/tmframe"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+func showUse(myflags *flag.FlagSet) {
+	fmt.Fprintf(os.Stderr, "%s splits a source-tagged TMFRAME stream, as written by tfmerge -tag, back into one file per source. Each source is written to -outdir under its base name plus -suffix. Frames without a source tag go to 'untagged' plus -suffix. It reads stdin, or the single file given. Usage: %s {-outdir dir} {-suffix .demux} {file}\n", os.Args[0], os.Args[0])
+	myflags.PrintDefaults()
+}
+
+func usage(err error, myflags *flag.FlagSet) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+	}
+	showUse(myflags)
+	os.Exit(1)
+}
+
+func main() {
+	myflags := flag.NewFlagSet("tfdemux", flag.ExitOnError)
+	cfg := &tf.TfdemuxConfig{}
+	cfg.DefineFlags(myflags)
+
+	err := myflags.Parse(os.Args[1:])
+	err = cfg.ValidateConfig()
+	if err != nil || cfg.Help {
+		usage(err, myflags)
+	}
+
+	leftover := myflags.Args()
+	if len(leftover) > 1 {
+		usage(fmt.Errorf("too many arguments on command line"), myflags)
+	}
+
+	var r io.Reader = os.Stdin
+	if len(leftover) == 1 {
+		if !FileExists(leftover[0]) {
+			fmt.Fprintf(os.Stderr, "input file '%s' does not exist.\n", leftover[0])
+			os.Exit(1)
+		}
+		f, err := os.Open(leftover[0])
+		panicOn(err)
+		defer f.Close()
+		r = f
+	}
+
+	files := []*os.File{}
+	used := make(map[string]bool)
+	open := func(src int, name string) (io.Writer, error) {
+		base := filepath.Base(name)
+		switch {
+		case src < 0:
+			base = "untagged"
+		case name == "" || used[base]:
+			// no name, or two sources with the same base name
+			base = fmt.Sprintf("source.%03d", src)
+		}
+		used[base] = true
+		path := filepath.Join(cfg.OutDir, base+cfg.Suffix)
+		if FileExists(path) {
+			return nil, fmt.Errorf("output file '%s' already exists, aborting.", path)
+		}
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+		return f, nil
+	}
+
+	stats, err := tf.Demux(r, open)
+	for _, f := range files {
+		f.Close()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tfdemux error: '%v'\n", err)
+		os.Exit(1)
+	}
+
+	srcs := []int{}
+	for src := range stats.Counts {
+		srcs = append(srcs, src)
+	}
+	sort.Ints(srcs)
+	for _, src := range srcs {
+		name := ""
+		if src < len(stats.Sources) {
+			name = stats.Sources[src]
+		}
+		fmt.Fprintf(os.Stderr, "source %d '%s': %d frames\n", src, name, stats.Counts[src])
+	}
+	if stats.Untagged > 0 {
+		fmt.Fprintf(os.Stderr, "untagged: %d frames\n", stats.Untagged)
+	}
+}
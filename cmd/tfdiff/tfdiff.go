@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	tf "github.com/glycerine/tmframe"
+)
+
+func showUse(myflags *flag.FlagSet) {
+	fmt.Fprintf(os.Stderr, "%s compares two time ordered TMFRAME files, aligning their frames by timestamp, and lists the frames only in a (<), only in b (>), and changed (~), with JSON, msgpack, and ZebraPack payloads compared field by field; then a summary. It exits 0 if the files match, 1 if they differ, and 2 on trouble. Usage: %s {-tol 1ms} {-ignore path,...} {-q} {-max n} a b\n", os.Args[0], os.Args[0])
+	myflags.PrintDefaults()
+}
+
+func usage(err error, myflags *flag.FlagSet) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+	}
+	showUse(myflags)
+	os.Exit(2)
+}
+
+var GlobalPrettyPrint bool
+
+func main() {
+	myflags := flag.NewFlagSet("tfdiff", flag.ExitOnError)
+	cfg := &tf.TfdiffConfig{}
+	cfg.DefineFlags(myflags)
+
+	err := myflags.Parse(os.Args[1:])
+	err = cfg.ValidateConfig()
+	if err != nil || cfg.Help {
+		usage(err, myflags)
+	}
+
+	leftover := myflags.Args()
+	if len(leftover) != 2 {
+		usage(fmt.Errorf("tfdiff needs exactly two files to compare"), myflags)
+	}
+
+	dcfg := tf.DiffConfig{Tolerance: cfg.Tolerance, Ignore: cfg.Ignore}
+	if cfg.ZebraPackSchemaPath != "" {
+		by, err := ioutil.ReadFile(cfg.ZebraPackSchemaPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "tfdiff error reading -zebrapack-schema file '%s': %v\n", cfg.ZebraPackSchemaPath, err)
+			os.Exit(2)
+		}
+		_, err = cfg.ZebraSchema.UnmarshalMsg(by)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "tfdiff error Unmarshalling the -zebrapack-schema file '%s': %v\n", cfg.ZebraPackSchemaPath, err)
+			os.Exit(2)
+		}
+		dcfg.ZSchema = &cfg.ZebraSchema
+	}
+
+	var strms [2]*tf.BufferedFrameReader
+	for i, path := range leftover {
+		if !FileExists(path) {
+			fmt.Fprintf(os.Stderr, "input file '%s' does not exist.\n", path)
+			os.Exit(2)
+		}
+		f, err := os.Open(path)
+		panicOn(err)
+		defer f.Close()
+		strms[i] = tf.NewBufferedFrameReader(f, 1024*1024, path)
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	var shown int64
+	emit := func(d *tf.Diff) error {
+		if cfg.Quiet || (cfg.MaxDetails > 0 && shown >= cfg.MaxDetails) {
+			return nil
+		}
+		shown++
+		_, err := fmt.Fprintln(w, d.String())
+		return err
+	}
+	st, err := tf.DiffStreams(strms[0], strms[1], dcfg, emit)
+	if err != nil {
+		w.Flush()
+		fmt.Fprintf(os.Stderr, "tfdiff error: '%v'\n", err)
+		os.Exit(2)
+	}
+	if n := st.OnlyA + st.OnlyB + st.Changed; n > shown && !cfg.Quiet {
+		fmt.Fprintf(w, "... %d more differences not shown.\n", n-shown)
+	}
+	if !cfg.Quiet && shown > 0 {
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "a: %s (%d frames)\n", leftover[0], st.FramesA)
+	fmt.Fprintf(w, "b: %s (%d frames)\n", leftover[1], st.FramesB)
+	fmt.Fprintf(w, "same:      %d", st.Same)
+	if st.Shifted > 0 {
+		fmt.Fprintf(w, " (%d at timestamps within -tol %v)", st.Shifted, cfg.Tolerance)
+	}
+	fmt.Fprintf(w, "\nchanged:   %d\nonly in a: %d\nonly in b: %d\n", st.Changed, st.OnlyA, st.OnlyB)
+	w.Flush()
+	if st.Differ() {
+		os.Exit(1)
+	}
+}
@@ -1,17 +1,20 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
 	tf "github.com/glycerine/tmframe"
 	"io"
+	"io/ioutil"
 	"os"
 	"regexp"
 	"strings"
 )
 
 func showUse(myflags *flag.FlagSet) {
-	fmt.Fprintf(os.Stderr, "tffilter filters raw TMFRAME streams on stdin by one or more regexes. It writes to stdout a reduced TMFRAME stream of frames that matched all regexes. Usage: tffilter regex1 {regex2}...\n")
+	fmt.Fprintf(os.Stderr, "tffilter filters raw TMFRAME streams on stdin (or the -in file) by one or more regexes, and/or by a -e filter expression. It writes to stdout a reduced TMFRAME stream of frames that matched the expression and all regexes. Usage: tffilter {-in file {-j N}} {-start time} {-end time} {-e expr} regex1 {regex2}...\n")
+	fmt.Fprintf(os.Stderr, "A -e expression compares frame fields (evtnum, pti, v0, v1, time, len) and payload fields (json.a.b[2].c, msgpack.x, zebra.x, payload.x) with == != < <= > >= and =~ !~ (regex), joined by && || ! and parentheses; is_na, is_null, is_nan, is_ude, and exists(path) test a frame. e.g. tffilter -e 'evtnum == 14 && time >= 2016-05-01T09:30 && json.price > 100'\n")
 	myflags.PrintDefaults()
 }
 
@@ -40,6 +43,19 @@ func main() {
 		usage(nil, myflags)
 	}
 
+	if cfg.ZebraPackSchemaPath != "" {
+		by, err := ioutil.ReadFile(cfg.ZebraPackSchemaPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "tffilter error reading -zebrapack-schema file '%s': %v\n", cfg.ZebraPackSchemaPath, err)
+			os.Exit(1)
+		}
+		_, err = cfg.ZebraSchema.UnmarshalMsg(by)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "tffilter error Unmarshalling the -zebrapack-schema file '%s': %v\n", cfg.ZebraPackSchemaPath, err)
+			os.Exit(1)
+		}
+	}
+
 	leftover := myflags.Args()
 
 	regs := leftover
@@ -55,7 +71,7 @@ func main() {
 			showUse(myflags)
 			os.Exit(1)
 		}
-	} else {
+	} else if cfg.Predicate == nil || len(regs) > 0 {
 		if len(regs) == 0 || (len(regs) == 1 && strings.HasPrefix(regs[0], "-h")) {
 			fmt.Fprintf(os.Stderr, "no regex given: specify at least one regex to filter with.\n")
 			showUse(myflags)
@@ -63,7 +79,13 @@ func main() {
 		}
 	}
 
-	// INVAR: regs specified, and len(regs) > 0
+	if cfg.Sub && len(regs) == 0 {
+		fmt.Fprintf(os.Stderr, "-sub needs a regex with sub-expressions.\n")
+		showUse(myflags)
+		os.Exit(1)
+	}
+
+	// INVAR: regs specified and len(regs) > 0, or a -e predicate given
 
 	arrRegex := make([]*regexp.Regexp, 0)
 	for i := range regs {
@@ -73,120 +95,172 @@ func main() {
 		arrRegex = append(arrRegex, re)
 	}
 
-	i := int64(1)
+	n := len(regs)
+	filt := &filter{cfg: cfg, arrRegex: arrRegex, n: n}
+
+	if cfg.Jobs > 1 {
+		err = tf.ScanFilter(cfg.InputPath, tf.ScanConfig{Workers: cfg.Jobs}, os.Stdout,
+			func(frame *tf.Frame, raw []byte, out *bytes.Buffer) error {
+				return filt.filterFrame(frame, raw, out)
+			})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "tffilter error: '%v'\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	fr := tf.NewFrameReader(os.Stdin, 1024*1024)
+	var in io.Reader = os.Stdin
+	if cfg.InputPath != "" {
+		f, err := tf.OpenTimeRange(cfg.InputPath, cfg.Range.TimeRange, !cfg.Range.Unsorted)
+		panicOn(err)
+		defer f.Close()
+		in = f
+	} else if cfg.Range.IsSet() {
+		in = tf.NewTimeRangeReader(in, cfg.Range.TimeRange, !cfg.Range.Unsorted)
+	}
+	fr := tf.NewFrameReader(in, 1024*1024)
 
 	var frame tf.Frame
 	var raw []byte
-	n := len(regs)
-	var allMatched, anyMatched bool
-
-toploop:
-	for ; err == nil; i++ {
+	for i := int64(1); err == nil; i++ {
 		_, _, err, raw = fr.NextFrame(&frame)
 		if err != nil {
 			if err == io.EOF {
-				break toploop
+				break
 			}
 			fmt.Fprintf(os.Stderr, "tffilter error from fr.NextFrame() at i=%v: '%v'\n", i, err)
 			os.Exit(1)
 		}
-		str := frame.Stringify(-1, false, false, false)
-		// match regex
-		matchN := 0
-		var o string
-		var sub []string
-		for _, r := range arrRegex {
-			if cfg.Sub {
-				sub = r.FindStringSubmatch(str)
-				//p("sub back from r.FindStringSubmatch(str='%s')  is '%#v' from regex '%s'", str, sub, regs[j])
-				if sub == nil {
-					o = ""
-				} else {
-					o = "hit"
-				}
-			} else {
-				o = r.FindString(str)
+		err = filt.filterFrame(&frame, raw, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "tffilter stopping at: '%s'", err)
+		}
+	}
+
+	//fmt.Fprintf(os.Stderr, "field='%s': found %v matches.\n", field, matchCount)
+}
+
+type filter struct {
+	cfg      *tf.TffilterConfig
+	arrRegex []*regexp.Regexp
+	n        int
+}
+
+// filterFrame writes frame to out if it passes the filter: its
+// raw bytes, or under -sub, the sub-expression matches. It is
+// safe for concurrent use, for -j.
+func (filt *filter) filterFrame(frame *tf.Frame, raw []byte, out io.Writer) (err error) {
+	cfg := filt.cfg
+	var allMatched, anyMatched bool
+
+	if !cfg.Range.Contains(frame.Tm()) {
+		return nil
+	}
+
+	if cfg.Predicate != nil {
+		if filt.n == 0 {
+			// -e alone: -x inverts the expression
+			if cfg.Predicate.Match(frame) == cfg.ExcludeMatches {
+				return nil
 			}
-			//fmt.Fprintf(os.Stderr, "tffilter at i=%v, matching frame '%s' against regex '%s': output is: '%s'\n", j, str, regs[j], o)
-			if o != "" {
-				matchN++
+			_, err = out.Write(raw)
+			return err
+		}
+		if !cfg.Predicate.Match(frame) {
+			return nil
+		}
+	}
 
-				switch {
-				// we have a match
-				case cfg.Any:
-					// found at least one match, we can stop under Any
-					switch {
-					case cfg.ExcludeMatches:
-						continue toploop
-					case !cfg.ExcludeMatches:
-						goto writeout
-					}
-				}
+	str := frame.Stringify(-1, false, false, false)
+	// match regex
+	matchN := 0
+	var o string
+	var sub []string
+	for _, r := range filt.arrRegex {
+		if cfg.Sub {
+			sub = r.FindStringSubmatch(str)
+			if sub == nil {
+				o = ""
 			} else {
-				// not a match
+				o = "hit"
+			}
+		} else {
+			o = r.FindString(str)
+		}
+		if o != "" {
+			matchN++
+
+			switch {
+			// we have a match
+			case cfg.Any:
+				// found at least one match, we can stop under Any
 				switch {
-				case !cfg.Any && cfg.ExcludeMatches:
-					// we've got to match all n in order to exclude, so we know now that we will include.
+				case cfg.ExcludeMatches:
+					return nil
+				case !cfg.ExcludeMatches:
 					goto writeout
-
-				case !cfg.Any && !cfg.ExcludeMatches:
-					// not a match, and all must match to survive the filter, so we can stop
-					continue toploop
 				}
 			}
+		} else {
+			// not a match
+			switch {
+			case !cfg.Any && cfg.ExcludeMatches:
+				// we've got to match all n in order to exclude, so we know now that we will include.
+				goto writeout
+
+			case !cfg.Any && !cfg.ExcludeMatches:
+				// not a match, and all must match to survive the filter, so we can stop
+				return nil
+			}
 		}
+	}
 
-		allMatched = (matchN == n)
-		anyMatched = (matchN > 0)
+	allMatched = (matchN == filt.n)
+	anyMatched = (matchN > 0)
 
-		switch {
-		case cfg.Any && cfg.ExcludeMatches:
-			if anyMatched {
-				continue toploop
-			}
+	switch {
+	case cfg.Any && cfg.ExcludeMatches:
+		if anyMatched {
+			return nil
+		}
+		goto writeout
+	case cfg.Any && !cfg.ExcludeMatches:
+		if anyMatched {
 			goto writeout
-		case cfg.Any && !cfg.ExcludeMatches:
-			if anyMatched {
-				goto writeout
-			}
-			continue toploop
-		case !cfg.Any && cfg.ExcludeMatches:
-			if allMatched {
-				continue toploop
-			}
+		}
+		return nil
+	case !cfg.Any && cfg.ExcludeMatches:
+		if allMatched {
+			return nil
+		}
+		goto writeout
+	case !cfg.Any && !cfg.ExcludeMatches:
+		if allMatched {
 			goto writeout
-		case !cfg.Any && !cfg.ExcludeMatches:
-			if allMatched {
-				goto writeout
-			}
-			continue toploop
 		}
-	writeout:
-		if cfg.Sub {
-			// sub-expression matching and reporting only the sub matches
-			//p("sub = %#v", sub)
-			sub = sub[1:]
-			//p("sub[1:] = %#v", sub)
-			nsub := len(sub)
-			if nsub > 0 {
-				for k := range sub {
-					fmt.Printf("%s", sub[k])
-					if k < nsub-1 {
-						fmt.Printf(" ", sub[k])
-					}
+		return nil
+	}
+writeout:
+	if cfg.Sub {
+		// sub-expression matching and reporting only the sub matches
+		if len(sub) == 0 {
+			return nil
+		}
+		sub = sub[1:]
+		nsub := len(sub)
+		if nsub > 0 {
+			for k := range sub {
+				fmt.Fprintf(out, "%s", sub[k])
+				if k < nsub-1 {
+					fmt.Fprintf(out, " ")
 				}
-				fmt.Printf("\n")
-			}
-		} else {
-			// full record matching
-			_, err = os.Stdout.Write(raw)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "tffilter stopping at: '%s'", err)
 			}
+			fmt.Fprintf(out, "\n")
 		}
-	} // end for toploop
-
-	//fmt.Fprintf(os.Stderr, "field='%s': found %v matches.\n", field, matchCount)
+		return nil
+	}
+	// full record matching
+	_, err = out.Write(raw)
+	return err
 }
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	tf "github.com/glycerine/tmframe"
+	"io"
+	"os"
+)
+
+func showUse(myflags *flag.FlagSet) {
+	fmt.Fprintf(os.Stderr, "%s reports gaps in a time-sorted TMFRAME stream, and can fill them with NA frames. It reads stdin, or the single file given. Usage: %s {-cadence 1s} {-threshold 5s} {-fill none|slots|boundary} {file}\n", os.Args[0], os.Args[0])
+	myflags.PrintDefaults()
+}
+
+func usage(err error, myflags *flag.FlagSet) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+	}
+	showUse(myflags)
+	os.Exit(1)
+}
+
+func main() {
+	myflags := flag.NewFlagSet("tfgaps", flag.ExitOnError)
+	cfg := &tf.TfgapsConfig{}
+	cfg.DefineFlags(myflags)
+
+	err := myflags.Parse(os.Args[1:])
+	err = cfg.ValidateConfig()
+	if err != nil || cfg.Help {
+		usage(err, myflags)
+	}
+
+	leftover := myflags.Args()
+	if len(leftover) > 1 {
+		usage(fmt.Errorf("too many arguments on command line"), myflags)
+	}
+
+	var r io.Reader = os.Stdin
+	if len(leftover) == 1 {
+		if !FileExists(leftover[0]) {
+			fmt.Fprintf(os.Stderr, "input file '%s' does not exist.\n", leftover[0])
+			os.Exit(1)
+		}
+		f, err := os.Open(leftover[0])
+		panicOn(err)
+		defer f.Close()
+		r = f
+	}
+
+	// with filling on, stdout carries frames, so report on stderr.
+	var w io.Writer
+	report := os.Stdout
+	if cfg.GapFill != tf.GapFillNone {
+		w = os.Stdout
+		report = os.Stderr
+	}
+
+	summary, err := tf.FindGaps(r, w, cfg.GapConfig())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tfgaps error: '%v'\n", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(report, "%s", summary)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	tf "github.com/glycerine/tmframe"
+	"io"
+	"os"
+)
+
+func showUse(myflags *flag.FlagSet) {
+	fmt.Fprintf(os.Stderr, "%s generates synthetic TMFRAME data, for tests, benchmarks, and load testing. Values follow a random walk or a seasonal curve; frames come at -rate per second, with -jitter; and duplicates, gaps, and out of order frames can be injected. The same -seed and flags always give the same output. It writes stdout, or the -o file. Usage: %s {-n 1000 | -dur 24h} {-seed 1} {-start time} {-rate 1} {-jitter 0.5} {-evtnums all} {-model walk|seasonal} {-payload uniform:16-1024} {-dup p} {-gap p {-gaplen 1m}} {-ooo p {-ooomax 5}} {-o file}\n", os.Args[0], os.Args[0])
+	myflags.PrintDefaults()
+}
+
+func usage(err error, myflags *flag.FlagSet) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+	}
+	showUse(myflags)
+	os.Exit(1)
+}
+
+func main() {
+	myflags := flag.NewFlagSet("tfgen", flag.ExitOnError)
+	cfg := &tf.TfgenConfig{}
+	cfg.DefineFlags(myflags)
+
+	err := myflags.Parse(os.Args[1:])
+	err = cfg.ValidateConfig()
+	if err != nil || cfg.Help {
+		usage(err, myflags)
+	}
+	if len(myflags.Args()) > 0 {
+		usage(fmt.Errorf("tfgen takes no arguments; use -o to name the output file"), myflags)
+	}
+
+	var out io.Writer = os.Stdout
+	if cfg.OutPath != "" {
+		f, err := os.Create(cfg.OutPath)
+		panicOn(err)
+		defer f.Close()
+		out = f
+	}
+	w := bufio.NewWriter(out)
+
+	gen, err := tf.NewGenerator(cfg.Gen)
+	panicOn(err)
+	_, err = gen.WriteTo(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tfgen error: '%v'\n", err)
+		os.Exit(1)
+	}
+	if cfg.Stats {
+		s := gen.Stats
+		fmt.Fprintf(os.Stderr, "tfgen: wrote %d frames: %d duplicates, %d gaps, %d out of order.\n", s.Frames, s.Dups, s.Gaps, s.OutOfOrder)
+	}
+}
@@ -3,32 +3,248 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
+
+	tf "github.com/glycerine/tmframe"
+	"github.com/glycerine/zebrapack/zebra"
 )
 
-func showUse() {
-	fmt.Fprintf(os.Stderr, "%s greps for escaped-json fields in TMFRAME payloads. Usage: tfcat | %s fieldname\n", os.Args[0], os.Args[0])
+func showUse(myflags *flag.FlagSet) {
+	fmt.Fprintf(os.Stderr, "%s extracts fields from the JSON, msgpack, and ZebraPack payloads of a raw TMFRAME stream on stdin (or the -in file), by path (e.g. a.b[2].c), and prints them with each frame's timestamp as tsv or JSON lines, or writes them as numeric TMFRAME frames. Usage: %s {-o tsv|jsonl|frames} {-e expr} path1 {path2}...\n", os.Args[0], os.Args[0])
+	myflags.PrintDefaults()
 }
 
-func usage(err error) {
-	fmt.Fprintf(os.Stderr, "%s\n", err)
+func usage(err error, myflags *flag.FlagSet) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+	}
+	showUse(myflags)
 	os.Exit(1)
 }
 
 var GlobalPrettyPrint bool
 
 func main() {
-	leftover := os.Args[1:]
-	//p("leftover = %v", leftover)
-	if len(leftover) == 0 || (len(leftover) == 1 && strings.HasPrefix(leftover[0], "-h")) {
-		fmt.Fprintf(os.Stderr, "no fieldname given: specify a field to search for.\n")
-		showUse()
+	myflags := flag.NewFlagSet("tfgrep", flag.ExitOnError)
+	cfg := &tf.TfgrepConfig{}
+	cfg.DefineFlags(myflags)
+
+	err := myflags.Parse(os.Args[1:])
+	err = cfg.ValidateConfig()
+	if err != nil {
+		usage(err, myflags)
+	}
+	if cfg.Help {
+		fmt.Fprintf(os.Stderr, "help requested:\n")
+		usage(nil, myflags)
+	}
+
+	leftover := myflags.Args()
+	if len(leftover) == 0 {
+		fmt.Fprintf(os.Stderr, "no field path given: specify at least one field to extract.\n")
+		showUse(myflags)
+		os.Exit(1)
+	}
+	if cfg.Text {
+		grepText(leftover)
+		return
+	}
+	if cfg.Format == "frames" && len(leftover) > 2 {
+		fmt.Fprintf(os.Stderr, "-o frames takes one field (for V0), or two (for V0 and V1).\n")
 		os.Exit(1)
 	}
+
+	if cfg.ZebraPackSchemaPath != "" {
+		by, err := ioutil.ReadFile(cfg.ZebraPackSchemaPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "tfgrep error reading -zebrapack-schema file '%s': %v\n", cfg.ZebraPackSchemaPath, err)
+			os.Exit(1)
+		}
+		_, err = cfg.ZebraSchema.UnmarshalMsg(by)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "tfgrep error Unmarshalling the -zebrapack-schema file '%s': %v\n", cfg.ZebraPackSchemaPath, err)
+			os.Exit(1)
+		}
+	}
+
+	paths := make([]*tf.FieldPath, len(leftover))
+	for i, src := range leftover {
+		paths[i], err = tf.ParseFieldPath(src)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "tfgrep: bad field path '%s': %v\n", src, err)
+			os.Exit(1)
+		}
+	}
+
+	in := os.Stdin
+	if cfg.InputPath != "" {
+		in, err = os.Open(cfg.InputPath)
+		panicOn(err)
+		defer in.Close()
+	}
+
+	g := &grepper{cfg: cfg, paths: paths, w: bufio.NewWriter(os.Stdout)}
+	if cfg.ZebraPackSchemaPath != "" {
+		g.zSchema = &cfg.ZebraSchema
+	}
+	if cfg.Format == "frames" {
+		g.fw = tf.NewFrameWriter(g.w, 1024*1024)
+	} else if cfg.Format == "tsv" && !cfg.NoHeader {
+		fmt.Fprintf(g.w, "time\t%s\n", strings.Join(leftover, "\t"))
+	}
+
+	fr := tf.NewFrameReader(in, 1024*1024)
+	var frame tf.Frame
+	for i := int64(1); ; i++ {
+		_, _, err, _ = fr.NextFrame(&frame)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "tfgrep error from fr.NextFrame() at i=%v: '%v'\n", i, err)
+			os.Exit(1)
+		}
+		err = g.grepFrame(&frame)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "tfgrep error at frame %v: '%v'\n", i, err)
+			os.Exit(1)
+		}
+	}
+	err = g.flush()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tfgrep error writing output: '%v'\n", err)
+		os.Exit(1)
+	}
+}
+
+type grepper struct {
+	cfg     *tf.TfgrepConfig
+	paths   []*tf.FieldPath
+	zSchema *zebra.Schema
+	w       *bufio.Writer
+	fw      *tf.FrameWriter
+	n       int
+}
+
+// grepFrame writes the fields of frame, if it has them.
+func (g *grepper) grepFrame(frame *tf.Frame) error {
+	cfg := g.cfg
+	if cfg.Predicate != nil && !cfg.Predicate.Match(frame) {
+		return nil
+	}
+	vals, found := tf.ExtractFields(frame, g.paths, g.zSchema)
+	nfound := 0
+	for _, ok := range found {
+		if ok {
+			nfound++
+		}
+	}
+	if nfound == 0 || (cfg.All && nfound < len(found)) {
+		return nil
+	}
+
+	switch cfg.Format {
+	case "frames":
+		g.fw.Append(numericFrame(frame.Tm(), vals, found))
+		g.n++
+		if g.n%1000 == 0 {
+			return g.fw.Flush()
+		}
+		return nil
+
+	case "jsonl":
+		var buf bytes.Buffer
+		buf.WriteString(`{"time":`)
+		if cfg.Epoch {
+			buf.WriteString(strconv.FormatInt(frame.Tm(), 10))
+		} else {
+			buf.WriteString(strconv.Quote(formatTm(frame.Tm())))
+		}
+		for i, p := range g.paths {
+			buf.WriteString(",")
+			by, _ := tf.PayloadJSON(p.String())
+			buf.Write(by)
+			buf.WriteString(":")
+			if !found[i] {
+				buf.WriteString("null")
+				continue
+			}
+			by, err := tf.PayloadJSON(vals[i])
+			if err != nil {
+				return err
+			}
+			buf.Write(by)
+		}
+		buf.WriteString("}\n")
+		_, err := buf.WriteTo(g.w)
+		return err
+	}
+
+	// tsv
+	if cfg.Epoch {
+		fmt.Fprintf(g.w, "%d", frame.Tm())
+	} else {
+		g.w.WriteString(formatTm(frame.Tm()))
+	}
+	for i := range g.paths {
+		g.w.WriteString("\t")
+		if !found[i] {
+			g.w.WriteString("NA")
+			continue
+		}
+		g.w.WriteString(tsvEscape.Replace(tf.PayloadString(vals[i])))
+	}
+	_, err := g.w.WriteString("\n")
+	return err
+}
+
+func (g *grepper) flush() error {
+	if g.fw != nil {
+		if err := g.fw.Flush(); err != nil {
+			return err
+		}
+	}
+	return g.w.Flush()
+}
+
+var tsvEscape = strings.NewReplacer("\\", "\\\\", "\t", "\\t", "\n", "\\n", "\r", "\\r")
+
+func formatTm(tm int64) string {
+	return time.Unix(0, tm).UTC().Format(time.RFC3339Nano)
+}
+
+// numericFrame makes an EvOneFloat64 frame from one value, or an
+// EvTwo64 from two, with the second as V1. If a value is missing
+// or not a number, the frame is EvNA, to keep the timeline.
+func numericFrame(tm int64, vals []interface{}, found []bool) *tf.Frame {
+	na := &tf.Frame{Prim: (tm &^ 7) | int64(tf.PtiNA)}
+	nums := make([]float64, len(vals))
+	for i := range vals {
+		var ok bool
+		if found[i] {
+			nums[i], ok = tf.PayloadFloat(vals[i])
+		}
+		if !ok {
+			return na
+		}
+	}
+	if len(nums) == 1 {
+		return tf.NewValueFrame(tm, nums[0])
+	}
+	return &tf.Frame{Prim: (tm &^ 7) | int64(tf.PtiTwo64), V0: nums[0], Ude: int64(nums[1])}
+}
+
+// grepText is the old tfgrep: it greps for escaped-json fields
+// in the text output of tfcat on stdin.
+func grepText(leftover []string) {
 	are := make([]*regexp.Regexp, 0)
 	for i := range leftover {
 		field := leftover[i]
@@ -65,7 +281,7 @@ func main() {
 			}
 		}
 		if len(s) > 0 {
-			fmt.Printf(s)
+			fmt.Print(s)
 		}
 
 		buf.Reset()
@@ -75,6 +291,4 @@ func main() {
 			break
 		}
 	}
-
-	//fmt.Fprintf(os.Stderr, "field='%s': found %v matches.\n", field, matchCount)
 }
@@ -1,16 +1,22 @@
 package main
 
 import (
+	"bufio"
+	"encoding/csv"
 	"flag"
 	"fmt"
-	tf "github.com/glycerine/tmframe"
 	"io"
+	"io/ioutil"
 	"os"
+	"strconv"
 	"time"
+
+	tf "github.com/glycerine/tmframe"
+	"github.com/glycerine/zebrapack/zebra"
 )
 
 func showUse(myflags *flag.FlagSet) {
-	fmt.Fprintf(os.Stderr, "%s counts frames per sec, min, hour", os.Args[0])
+	fmt.Fprintf(os.Stderr, "%s aggregates a time ordered TMFRAME stream on stdin (or the -in file) into time buckets (per minute by default), optionally per evtnum or payload key, and reports count, sum, min, max, mean, distinct keys, or bytes per bucket, as text, csv, or TMFRAME. Usage: %s {-interval 15min|day|month...} {-by evtnum|path} {-agg count,mean,...} {-o text|csv|frames}\n", os.Args[0], os.Args[0])
 	myflags.PrintDefaults()
 }
 
@@ -38,25 +44,48 @@ func main() {
 	leftover := myflags.Args()
 	//p("leftover = %v", leftover)
 	if len(leftover) != 0 {
-		fmt.Fprintf(os.Stderr, "tfgroup reads stdin and writes stdout, no args allowed.\n")
+		fmt.Fprintf(os.Stderr, "tfgroup reads stdin (or -in) and writes stdout, no args allowed.\n")
 		showUse(myflags)
 		os.Exit(1)
 	}
 
-	i := int64(1)
+	var zSchema *zebra.Schema
+	if cfg.ZebraPackSchemaPath != "" {
+		by, err := ioutil.ReadFile(cfg.ZebraPackSchemaPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "tfgroup error reading -zebrapack-schema file '%s': %v\n", cfg.ZebraPackSchemaPath, err)
+			os.Exit(1)
+		}
+		_, err = cfg.ZebraSchema.UnmarshalMsg(by)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "tfgroup error Unmarshalling the -zebrapack-schema file '%s': %v\n", cfg.ZebraPackSchemaPath, err)
+			os.Exit(1)
+		}
+		zSchema = &cfg.ZebraSchema
+	}
 
-	f := os.Stdin
+	gr := tf.NewGrouper(cfg.Interval, cfg.Loc)
+	gr.Fill = !cfg.NoFill
+	if cfg.By != "" {
+		gr.Key, err = tf.ParseKeyFunc(cfg.By, zSchema)
+		panicOn(err)
+	}
+	gr.Distinct, err = tf.ParseKeyFunc(cfg.Distinct, zSchema)
 	panicOn(err)
-	//buf := make([]byte, 1024*1024)
+
+	f := os.Stdin
+	if cfg.InputPath != "" {
+		f, err = os.Open(cfg.InputPath)
+		panicOn(err)
+		defer f.Close()
+	}
 	fr := tf.NewFrameReader(f, 1024*1024)
 
+	out := newGroupWriter(cfg, os.Stdout)
 	var frame tf.Frame
-
-	var nextMin time.Time
-	var countLastMin int64
-	incr := time.Minute
-	for ; err == nil; i++ {
-		_, _, err = fr.NextFrame(&frame)
+	var nbytes int64
+	for i := int64(1); ; i++ {
+		_, nbytes, err, _ = fr.NextFrame(&frame)
 		if err != nil {
 			if err == io.EOF {
 				break
@@ -64,16 +93,127 @@ func main() {
 			fmt.Fprintf(os.Stderr, "tfgroup error from fr.NextFrame() at i=%v: '%v'\n", i, err)
 			os.Exit(1)
 		}
-		cur := time.Unix(0, frame.Tm())
-		if nextMin.IsZero() {
-			nextMin = cur.Add(incr)
+		panicOn(out.write(gr.Add(&frame, nbytes)))
+	}
+	panicOn(out.write(gr.Flush()))
+	panicOn(out.flush())
+
+	if gr.NoKey > 0 {
+		fmt.Fprintf(os.Stderr, "tfgroup: %v frames had no -by key, and were skipped.\n", gr.NoKey)
+	}
+	if gr.Late > 0 {
+		fmt.Fprintf(os.Stderr, "tfgroup: %v frames were out of time order, and were skipped. Sort the input with tfsort first.\n", gr.Late)
+	}
+}
+
+type groupWriter struct {
+	cfg    *tf.TfgroupConfig
+	w      *bufio.Writer
+	csv    *csv.Writer
+	fw     *tf.FrameWriter
+	header bool
+}
+
+func newGroupWriter(cfg *tf.TfgroupConfig, w io.Writer) *groupWriter {
+	gw := &groupWriter{cfg: cfg, w: bufio.NewWriter(w)}
+	switch cfg.Format {
+	case "csv":
+		gw.csv = csv.NewWriter(gw.w)
+	case "frames":
+		gw.fw = tf.NewFrameWriter(gw.w, 1024*1024)
+	}
+	return gw
+}
+
+func (gw *groupWriter) formatTm(tm int64) string {
+	return time.Unix(0, tm).In(gw.cfg.Loc).Format(time.RFC3339Nano)
+}
+
+func formatStat(g *tf.Group, st tf.GroupStat) string {
+	switch st {
+	case tf.GroupCount:
+		return strconv.FormatInt(g.Count, 10)
+	case tf.GroupBytes:
+		return strconv.FormatInt(g.Bytes, 10)
+	case tf.GroupDistinct:
+		return strconv.Itoa(g.Distinct())
+	}
+	return strconv.FormatFloat(g.Stat(st), 'g', -1, 64)
+}
+
+func (gw *groupWriter) write(groups []*tf.Group) error {
+	cfg := gw.cfg
+	for _, g := range groups {
+		switch cfg.Format {
+		case "frames":
+			gw.fw.Append(groupFrame(g, cfg.Stats))
+
+		case "csv":
+			if !gw.header {
+				gw.header = true
+				rec := []string{"start", "end"}
+				if cfg.By != "" {
+					rec = append(rec, cfg.By)
+				}
+				for _, st := range cfg.Stats {
+					rec = append(rec, st.String())
+				}
+				if err := gw.csv.Write(rec); err != nil {
+					return err
+				}
+			}
+			rec := []string{gw.formatTm(g.Start), gw.formatTm(g.End)}
+			if cfg.By != "" {
+				rec = append(rec, g.Key)
+			}
+			for _, st := range cfg.Stats {
+				rec = append(rec, formatStat(g, st))
+			}
+			if err := gw.csv.Write(rec); err != nil {
+				return err
+			}
+
+		default:
+			fmt.Fprintf(gw.w, "%s", gw.formatTm(g.Start))
+			if cfg.By != "" {
+				fmt.Fprintf(gw.w, " %s=%s", cfg.By, g.Key)
+			}
+			for _, st := range cfg.Stats {
+				fmt.Fprintf(gw.w, " %s=%s", st, formatStat(g, st))
+			}
+			fmt.Fprintf(gw.w, "\n")
+		}
+	}
+	if gw.fw != nil {
+		return gw.fw.Flush()
+	}
+	return nil
+}
+
+// groupFrame makes an EvTwo64 frame at the bucket start, with the
+// count in V1, and the first other aggregate of stats in V0.
+func groupFrame(g *tf.Group, stats []tf.GroupStat) *tf.Frame {
+	v0 := float64(g.Count)
+	for _, st := range stats {
+		if st != tf.GroupCount {
+			v0 = g.Stat(st)
+			break
+		}
+	}
+	return &tf.Frame{Prim: (g.Start &^ 7) | int64(tf.PtiTwo64), V0: v0, Ude: g.Count}
+}
+
+func (gw *groupWriter) flush() error {
+	if gw.csv != nil {
+		gw.csv.Flush()
+		if err := gw.csv.Error(); err != nil {
+			return err
 		}
-		for cur.After(nextMin) {
-			fmt.Printf("%v %v\n", countLastMin, nextMin)
-			nextMin = nextMin.Add(incr)
-			countLastMin = 0
+	}
+	if gw.fw != nil {
+		if err := gw.fw.Flush(); err != nil {
+			return err
 		}
-		countLastMin++
 	}
-	fmt.Printf("%v %v\n", countLastMin, nextMin)
+	return gw.w.Flush()
 }
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	tf "github.com/glycerine/tmframe"
+	"io"
+	"os"
+	"strings"
+)
+
+func showUse(myflags *flag.FlagSet) {
+	fmt.Fprintf(os.Stderr, "%s converts CSV (with a header row) or JSON Lines into TMFRAME, one row at a time. The -time column gives the timestamp; -v0 and -v1 columns give the values; and the remaining fields go into a JSON or msgpack payload. Empty, NA, and NaN values become Null, NA, and NaN frames. Bad rows are reported on stderr, with their line numbers, and skipped. It reads stdin, or the single file given, and writes stdout. Usage: %s {-format csv|jsonl} {-time col} {-timefmt rfc3339} {-v0 col} {-v1 col} {-payload json|msgpack|none} {-evtnum n} {-sort} {file}\n", os.Args[0], os.Args[0])
+	myflags.PrintDefaults()
+}
+
+func usage(err error, myflags *flag.FlagSet) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+	}
+	showUse(myflags)
+	os.Exit(1)
+}
+
+func main() {
+	myflags := flag.NewFlagSet("tfimport", flag.ExitOnError)
+	cfg := &tf.TfimportConfig{}
+	cfg.DefineFlags(myflags)
+
+	err := myflags.Parse(os.Args[1:])
+	err = cfg.ValidateConfig()
+	if err != nil || cfg.Help {
+		usage(err, myflags)
+	}
+
+	leftover := myflags.Args()
+	if len(leftover) > 1 {
+		usage(fmt.Errorf("too many arguments on command line"), myflags)
+	}
+
+	var r io.Reader = os.Stdin
+	if len(leftover) == 1 {
+		if !FileExists(leftover[0]) {
+			fmt.Fprintf(os.Stderr, "input file '%s' does not exist.\n", leftover[0])
+			os.Exit(1)
+		}
+		f, err := os.Open(leftover[0])
+		panicOn(err)
+		defer f.Close()
+		r = f
+		if cfg.Format == "" && (strings.HasSuffix(leftover[0], ".jsonl") || strings.HasSuffix(leftover[0], ".ndjson")) {
+			cfg.Format = "jsonl"
+		}
+	}
+	if cfg.Format == "" {
+		cfg.Format = "csv"
+	}
+
+	// with -sort, the frames go through a pipe to the external sort.
+	var out io.Writer = os.Stdout
+	var sorted chan error
+	var pw *io.PipeWriter
+	if cfg.Sort {
+		var pr *io.PipeReader
+		pr, pw = io.Pipe()
+		out = pw
+		sorted = make(chan error, 1)
+		go func() {
+			_, err := tf.ExternalSort(pr, os.Stdout, tf.ExternalSortConfig{
+				MemBudget: cfg.MemBudgetMB * 1024 * 1024,
+				Parallel:  1,
+				TmpDir:    cfg.TmpDir,
+			})
+			pr.CloseWithError(err)
+			sorted <- err
+		}()
+	}
+	w := bufio.NewWriter(out)
+
+	var nbad int64
+	emit := func(f *tf.Frame) error {
+		by, err := f.Marshal(nil)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(by)
+		return err
+	}
+	bad := func(e *tf.ImportRowError) {
+		nbad++
+		fmt.Fprintf(os.Stderr, "tfimport: bad row: %v\n", e)
+		if cfg.MaxBad >= 0 && nbad > cfg.MaxBad {
+			fmt.Fprintf(os.Stderr, "tfimport: more than -maxbad %d bad rows, stopping.\n", cfg.MaxBad)
+			os.Exit(1)
+		}
+	}
+
+	stats, err := tf.Import(r, cfg.ImportConfig(), emit, bad)
+	if err == nil {
+		err = w.Flush()
+	}
+	if pw != nil {
+		pw.CloseWithError(err)
+		if serr := <-sorted; err == nil {
+			err = serr
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tfimport error: '%v'\n", err)
+		os.Exit(1)
+	}
+	if stats.BadRows > 0 {
+		fmt.Fprintf(os.Stderr, "tfimport: skipped %d bad rows of %d; wrote %d frames.\n", stats.BadRows, stats.Rows, stats.Frames)
+	}
+}
@@ -1,30 +1,43 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	tf "github.com/glycerine/tmframe"
 	"os"
 )
 
-func showUse() {
-	fmt.Fprintf(os.Stderr, "%s merges TMFRAME files. Usage: %s <file1> <file2> ...\n",
+func showUse(myflags *flag.FlagSet) {
+	fmt.Fprintf(os.Stderr, "%s merges TMFRAME files. Frames with equal timestamps are ordered by -tie. With -tag, each frame is tagged with its source file. Clock skew between files can be corrected first, with -skew or -skewref. With -start and -end, only the frames in that time range (by the files' own clocks, before any -skew) are merged. Usage: %s {-tie position|evtnum|hash} {-tag} {-skew file=offset}... {-skewref field {-skewbucket 1h}} {-keeporig} {-start time} {-end time} <file1> <file2> ...\n",
 		os.Args[0], os.Args[0])
+	myflags.PrintDefaults()
+}
+
+func usage(err error, myflags *flag.FlagSet) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+	}
+	showUse(myflags)
+	os.Exit(1)
 }
 
 var GlobalPrettyPrint bool
 
 func main() {
-	if len(os.Args) <= 1 {
-		showUse()
-		os.Exit(1)
+	myflags := flag.NewFlagSet("tfmerge", flag.ExitOnError)
+	cfg := &tf.TfmergeConfig{}
+	cfg.DefineFlags(myflags)
+
+	err := myflags.Parse(os.Args[1:])
+	err = cfg.ValidateConfig()
+	if err != nil || cfg.Help {
+		usage(err, myflags)
 	}
 
-	inputFiles := os.Args[1:]
+	inputFiles := myflags.Args()
 	n := len(inputFiles)
 	if n == 0 {
-		fmt.Fprintf(os.Stderr, "no input files given\n")
-		showUse()
-		os.Exit(1)
+		usage(fmt.Errorf("no input files given"), myflags)
 	}
 
 	const MB = 1024 * 1024
@@ -36,17 +49,62 @@ func main() {
 			fmt.Fprintf(os.Stderr, "path '%s' not found\n", inputFiles[i])
 			os.Exit(1)
 		}
-		f, err := os.Open(inputFiles[i])
+		f, err := tf.OpenTimeRange(inputFiles[i], cfg.Range.TimeRange, !cfg.Range.Unsorted)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "could not open path '%s': '%s'\n",
 				inputFiles[i], err)
 			os.Exit(1)
 		}
-		strms[i] = tf.NewBufferedFrameReader(f, MB, "")
+		strms[i] = tf.NewBufferedFrameReader(f, MB, inputFiles[i])
+	}
+
+	// correct for clock skew
+	for name := range cfg.Skew {
+		found := false
+		for _, in := range inputFiles {
+			found = found || in == name
+		}
+		if !found {
+			usage(fmt.Errorf("-skew file '%s' is not among the input files", name), myflags)
+		}
+	}
+	for i := 0; i < n; i++ {
+		adj := cfg.Skew[inputFiles[i]]
+		if adj == nil && cfg.SkewRef != "" && i > 0 {
+			adj = estimateSkew(cfg, inputFiles[0], inputFiles[i])
+		}
+		if adj != nil {
+			strms[i].Transform = adj.Transform(cfg.KeepOrig)
+		}
 	}
 
 	// okay, now create and merge streams
-	err := outputStream.Merge(strms...)
+	if cfg.Tag {
+		err = outputStream.MergeTagged(cfg.TieBreak, strms...)
+	} else {
+		err = outputStream.MergeBy(cfg.TieBreak, strms...)
+	}
 	outputStream.Sync()
 	panicOn(err)
 }
+
+// estimateSkew estimates the clock correction for path relative to
+// ref, from the events sharing a -skewref field value.
+func estimateSkew(cfg *tf.TfmergeConfig, ref, path string) *tf.ClockAdjust {
+	key, err := tf.PayloadFieldKey(cfg.SkewRef, nil)
+	panicOn(err)
+	rf, err := os.Open(ref)
+	panicOn(err)
+	defer rf.Close()
+	sf, err := os.Open(path)
+	panicOn(err)
+	defer sf.Close()
+
+	est, err := tf.EstimateClockAdjust(rf, sf, key, cfg.SkewBucket)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tfmerge: could not estimate the clock skew of '%s' against '%s': '%v'\n", path, ref, err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "tfmerge: -skew %s=%s  (from %d reference events)\n", path, est.Adjust, est.Samples)
+	return est.Adjust
+}
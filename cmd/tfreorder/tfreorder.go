@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	tf "github.com/glycerine/tmframe"
+	"io"
+	"os"
+)
+
+func showUse(myflags *flag.FlagSet) {
+	fmt.Fprintf(os.Stderr, "%s puts a nearly time-sorted TMFRAME stream back in order, holding frames for up to -lateness behind the newest frame seen. Frames later than that are dropped, or written to -lateto. It reads stdin, or the single file given, and writes stdout. Usage: %s {-lateness 5s} {-lateto path} {file}\n", os.Args[0], os.Args[0])
+	myflags.PrintDefaults()
+}
+
+func usage(err error, myflags *flag.FlagSet) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+	}
+	showUse(myflags)
+	os.Exit(1)
+}
+
+func main() {
+	myflags := flag.NewFlagSet("tfreorder", flag.ExitOnError)
+	cfg := &tf.TfreorderConfig{}
+	cfg.DefineFlags(myflags)
+
+	err := myflags.Parse(os.Args[1:])
+	err = cfg.ValidateConfig()
+	if err != nil || cfg.Help {
+		usage(err, myflags)
+	}
+
+	leftover := myflags.Args()
+	if len(leftover) > 1 {
+		usage(fmt.Errorf("too many arguments on command line"), myflags)
+	}
+
+	var r io.Reader = os.Stdin
+	if len(leftover) == 1 {
+		if !FileExists(leftover[0]) {
+			fmt.Fprintf(os.Stderr, "input file '%s' does not exist.\n", leftover[0])
+			os.Exit(1)
+		}
+		f, err := os.Open(leftover[0])
+		panicOn(err)
+		defer f.Close()
+		r = f
+	}
+
+	var late io.Writer
+	if cfg.LateTo != "" {
+		lf, err := os.Create(cfg.LateTo)
+		panicOn(err)
+		defer lf.Close()
+		late = lf
+	}
+
+	stats, err := tf.Reorder(r, os.Stdout, cfg.Lateness, late)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tfreorder error: '%v'\n", err)
+		os.Exit(1)
+	}
+	if stats.Late > 0 {
+		fmt.Fprintf(os.Stderr, "tfreorder: %d of %d frames arrived more than %v late (the latest by %v)\n",
+			stats.Late, stats.Frames, cfg.Lateness, stats.MaxLateness)
+	}
+}
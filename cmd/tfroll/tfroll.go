@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	tf "github.com/glycerine/tmframe"
+	"os"
+)
+
+func showUse(myflags *flag.FlagSet) {
+	fmt.Fprintf(os.Stderr, "%s computes rolling-window statistics over a TMFRAME stream. Each output frame keeps its input's timestamp and carries the statistic in V0. It reads stdin and writes stdout. Usage: tfcat -raw 1000 file | %s -window 5m -stat mean\n", os.Args[0], os.Args[0])
+	myflags.PrintDefaults()
+}
+
+func usage(err error, myflags *flag.FlagSet) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+	}
+	showUse(myflags)
+	os.Exit(1)
+}
+
+func main() {
+	myflags := flag.NewFlagSet("tfroll", flag.ExitOnError)
+	cfg := &tf.TfrollConfig{}
+	cfg.DefineFlags(myflags)
+
+	err := myflags.Parse(os.Args[1:])
+	err = cfg.ValidateConfig()
+	if err != nil || cfg.Help {
+		usage(err, myflags)
+	}
+
+	leftover := myflags.Args()
+	if len(leftover) != 0 {
+		fmt.Fprintf(os.Stderr, "tfroll reads stdin and writes stdout, no args allowed.\n")
+		showUse(myflags)
+		os.Exit(1)
+	}
+
+	rollers := make([]*tf.Roller, 0, len(cfg.RollStats))
+	for _, st := range cfg.RollStats {
+		ro, err := tf.NewRoller(st, cfg.RollWindow)
+		panicOn(err)
+		rollers = append(rollers, ro)
+	}
+
+	err = tf.Roll(os.Stdin, os.Stdout, rollers...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tfroll error: '%v'\n", err)
+		os.Exit(1)
+	}
+}
@@ -5,11 +5,10 @@ import (
 	"fmt"
 	tf "github.com/glycerine/tmframe"
 	"os"
-	"sort"
 )
 
 func showUse(myflags *flag.FlagSet) {
-	fmt.Fprintf(os.Stderr, "%s sorts each TMFRAME `file` into temp file `file.sorted`. Then files are merged and written to stdout. Temp files are deleted unless -k is given. Usage: %s {-k} <file_to_sort>+\n", os.Args[0], os.Args[0])
+	fmt.Fprintf(os.Stderr, "%s sorts each TMFRAME `file` into temp file `file.sorted`. Then files are merged and written to stdout. Files larger than the -mem budget are sorted externally, using temp runs that are merged. Temp files are deleted unless -k is given. With -start and -end, only the frames in that time range are sorted. Usage: %s {-k} {-mem 1024} {-j 8} {-tmpdir dir} {-start time} {-end time} <file_to_sort>+\n", os.Args[0], os.Args[0])
 	myflags.PrintDefaults()
 }
 
@@ -49,38 +48,36 @@ func main() {
 			os.Exit(1)
 		}
 
-		frames, err := tf.ReadAllFrames(inputFile)
+		// the input is not sorted yet, so the time
+		// range cannot seek or stop early.
+		inf, err := tf.OpenTimeRange(inputFile, cfg.Range.TimeRange, false)
 		panicOn(err)
 
-		sort.Stable(tf.TimeSorter(frames))
-
 		writeFile := inputFile + ".sorted"
 		of, err := os.Create(writeFile)
 		panicOn(err)
 		wrote = append(wrote, of)
 		wroteTmp = append(wroteTmp, writeFile)
 
-		fw := tf.NewFrameWriter(of, 1024*1024)
-		fw.Frames = frames
-		_, err = fw.WriteTo(of)
-		panicOn(err)
-		fw.Sync()
-		of.Seek(0, 0)
+		// sorts in memory when the file fits the -mem budget,
+		// and externally, in parallel, when it does not.
+		_, err = tf.ExternalSort(inf, of, cfg.ExternalSortConfig())
+		inf.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "tfsort error sorting '%s': '%v'\n", inputFile, err)
+			os.Exit(1)
+		}
+		of.Sync()
 	}
 
-	// INVAR: individual files are sorted, now merge to stdout
-
-	const MB = 1024 * 1024
-	outputStream := tf.NewFrameWriter(os.Stdout, MB)
-
-	strms := make([]*tf.BufferedFrameReader, len(wrote))
-	for i := range wrote {
-		strms[i] = tf.NewBufferedFrameReader(wrote[i], MB, "")
+	// INVAR: individual files are sorted, now merge to stdout.
+	// MergeFiles flushes as it goes, and breaks ties in
+	// command line order.
+	for _, of := range wrote {
+		of.Close()
 	}
-
-	// okay, now create and merge streams
-	err = outputStream.Merge(strms...)
-	outputStream.Sync()
+	const MB = 1024 * 1024
+	err = tf.MergeFiles(os.Stdout, MB, wroteTmp...)
 	panicOn(err)
 
 	if !cfg.KeepTmpFiles {
@@ -0,0 +1,7 @@
+package main
+
+func panicOn(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
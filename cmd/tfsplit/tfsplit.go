@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	tf "github.com/glycerine/tmframe"
+	"github.com/glycerine/zebrapack/zebra"
+)
+
+func showUse(myflags *flag.FlagSet) {
+	fmt.Fprintf(os.Stderr, "%s splits a TMFRAME stream on stdin (or the -in file) into files: one per time period, per evtnum or payload key, or per chunk of at most -size bytes, or any combination. Files go under -dir in the archiver's YYYY/MM/DD/stream layout, or as named by the -o template. Existing files are never overwritten. Usage: %s {-every hour|day|15m...} {-by evtnum|path} {-size 1G} {-o template} {-index} {-dir outdir} {-stream name}\n", os.Args[0], os.Args[0])
+	myflags.PrintDefaults()
+}
+
+func usage(err error, myflags *flag.FlagSet) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+	}
+	showUse(myflags)
+	os.Exit(1)
+}
+
+var GlobalPrettyPrint bool
+
+func main() {
+	myflags := flag.NewFlagSet("tfsplit", flag.ExitOnError)
+	cfg := &tf.TfsplitConfig{}
+	cfg.DefineFlags(myflags)
+
+	err := myflags.Parse(os.Args[1:])
+	err = cfg.ValidateConfig()
+	if err != nil || cfg.Help {
+		usage(err, myflags)
+	}
+
+	leftover := myflags.Args()
+	if len(leftover) != 0 {
+		fmt.Fprintf(os.Stderr, "tfsplit reads stdin (or -in), no args allowed.\n")
+		showUse(myflags)
+		os.Exit(1)
+	}
+
+	var zSchema *zebra.Schema
+	if cfg.ZebraPackSchemaPath != "" {
+		by, err := ioutil.ReadFile(cfg.ZebraPackSchemaPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "tfsplit error reading -zebrapack-schema file '%s': %v\n", cfg.ZebraPackSchemaPath, err)
+			os.Exit(1)
+		}
+		_, err = cfg.ZebraSchema.UnmarshalMsg(by)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "tfsplit error Unmarshalling the -zebrapack-schema file '%s': %v\n", cfg.ZebraPackSchemaPath, err)
+			os.Exit(1)
+		}
+		zSchema = &cfg.ZebraSchema
+	}
+
+	sp := tf.NewSplitter(cfg.Dir)
+	sp.Stream = cfg.Stream
+	sp.Interval = cfg.Interval
+	sp.Loc = cfg.Loc
+	sp.MaxBytes = cfg.MaxBytes
+	sp.Template = cfg.Template
+	sp.Index = cfg.Index
+	sp.MaxOpen = cfg.MaxOpen
+	if cfg.By != "" {
+		sp.Key, err = tf.ParseKeyFunc(cfg.By, zSchema)
+		panicOn(err)
+	}
+
+	f := os.Stdin
+	if cfg.InputPath != "" {
+		f, err = os.Open(cfg.InputPath)
+		panicOn(err)
+		defer f.Close()
+	}
+	fr := tf.NewFrameReader(f, 1024*1024)
+
+	var frame tf.Frame
+	var raw []byte
+	for i := int64(1); ; i++ {
+		_, _, err, raw = fr.NextFrame(&frame)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			fmt.Fprintf(os.Stderr, "tfsplit error from fr.NextFrame() at i=%v: '%v'\n", i, err)
+			sp.Close()
+			os.Exit(1)
+		}
+		err = sp.Write(&frame, raw)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "tfsplit error: '%v'\n", err)
+			sp.Close()
+			os.Exit(1)
+		}
+	}
+	err = sp.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tfsplit error: '%v'\n", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "tfsplit: wrote %d files.\n", len(sp.Paths))
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"bytes"
 	"encoding/binary"
+	"encoding/json"
 	"flag"
 	"fmt"
 	tf "github.com/glycerine/tmframe"
@@ -11,7 +13,7 @@ import (
 )
 
 func showUse(myflags *flag.FlagSet) {
-	fmt.Fprintf(os.Stderr, "%s replaces TMFRAME payloads with their 64-bit checksum. It reads stdin and writes stdout\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "%s replaces TMFRAME payloads with their 64-bit checksum, or with -summary, reports statistics of the frames, as text or JSON. It reads stdin, or the one file given, and writes stdout. Usage: %s {-summary {-o json}} {-j N file}\n", os.Args[0], os.Args[0])
 	myflags.PrintDefaults()
 }
 
@@ -38,16 +40,60 @@ func main() {
 
 	leftover := myflags.Args()
 	//p("leftover = %v", leftover)
-	if len(leftover) != 0 {
-		fmt.Fprintf(os.Stderr, "tfsum reads stdin and writes stdout, no args allowed.\n")
+	if len(leftover) > 1 || (cfg.Jobs > 1 && len(leftover) != 1) {
+		fmt.Fprintf(os.Stderr, "tfsum reads stdin, or the one file given, and writes stdout. -j needs the file.\n")
 		showUse(myflags)
 		os.Exit(1)
 	}
 
+	if cfg.Summary {
+		var sum *tf.Summary
+		if len(leftover) == 1 {
+			sum, err = summarizeFile(leftover[0], cfg.Jobs)
+		} else {
+			sum, err = summarize(os.Stdin)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "tfsum error: '%v'\n", err)
+			os.Exit(1)
+		}
+		rep := sum.Report()
+		if cfg.Format == "json" {
+			by, err := json.MarshalIndent(rep, "", "  ")
+			panicOn(err)
+			fmt.Printf("%s\n", by)
+		} else {
+			panicOn(rep.WriteText(os.Stdout))
+		}
+		return
+	}
+
+	if cfg.Jobs > 1 {
+		err = tf.ScanFilter(leftover[0], tf.ScanConfig{Workers: cfg.Jobs}, os.Stdout,
+			func(frame *tf.Frame, raw []byte, out *bytes.Buffer) error {
+				var buf [16]byte
+				newf, err := checksumFrame(buf[:], frame)
+				if err != nil {
+					return err
+				}
+				_, err = out.Write(newf)
+				return err
+			})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "tfsum error: '%v'\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	i := int64(1)
 
 	f := os.Stdin
-	panicOn(err)
+	if len(leftover) == 1 {
+		f, err = os.Open(leftover[0])
+		panicOn(err)
+		defer f.Close()
+	}
 	buf := make([]byte, 1024*1024)
 	fr := tf.NewFrameReader(f, 1024*1024)
 
@@ -62,11 +108,59 @@ func main() {
 			fmt.Fprintf(os.Stderr, "tfcat error from fr.NextFrame() at i=%v: '%v'\n", i, err)
 			os.Exit(1)
 		}
-		hash := frame.Blake2b()
-		chk := int64(binary.LittleEndian.Uint64(hash[:8]))
-		newf, err := tf.NewMarshalledFrame(buf, time.Unix(0, frame.Tm()), tf.EvOneInt64, 0, chk, nil)
+		newf, err := checksumFrame(buf, &frame)
 		panicOn(err)
 		_, err = os.Stdout.Write(newf)
 		panicOn(err)
 	}
 }
+
+// checksumFrame marshals into buf a frame carrying, in place of
+// frame's payload, the first 64 bits of its Blake2b checksum.
+func checksumFrame(buf []byte, frame *tf.Frame) ([]byte, error) {
+	hash := frame.Blake2b()
+	chk := int64(binary.LittleEndian.Uint64(hash[:8]))
+	return tf.NewMarshalledFrame(buf, time.Unix(0, frame.Tm()), tf.EvOneInt64, 0, chk, nil)
+}
+
+// summarize reads all of r.
+func summarize(r io.Reader) (*tf.Summary, error) {
+	return summarizeFrames(tf.NewFrameReader(r, 1024*1024))
+}
+
+func summarizeFrames(fr *tf.FrameReader) (*tf.Summary, error) {
+	sum := tf.NewSummary()
+	var frame tf.Frame
+	for i := int64(1); ; i++ {
+		_, nbytes, err, _ := fr.NextFrame(&frame)
+		if err == io.EOF {
+			return sum, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error from fr.NextFrame() at i=%v: '%v'", i, err)
+		}
+		sum.Add(&frame, nbytes)
+	}
+}
+
+// summarizeFile summarizes path, scanning it on jobs cores.
+func summarizeFile(path string, jobs int) (*tf.Summary, error) {
+	if jobs <= 1 {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return summarize(f)
+	}
+	total := tf.NewSummary()
+	err := tf.ScanFile(path, tf.ScanConfig{Workers: jobs},
+		func(s tf.Shard, fr *tf.FrameReader) (interface{}, error) {
+			return summarizeFrames(fr)
+		},
+		func(s tf.Shard, res interface{}) error {
+			total.Merge(res.(*tf.Summary))
+			return nil
+		})
+	return total, err
+}
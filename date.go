@@ -2,6 +2,7 @@ package tm
 
 import (
 	"fmt"
+	"path"
 	"strconv"
 	"strings"
 	"time"
@@ -166,3 +167,10 @@ func TimeToDate(tm time.Time) Date {
 		Day:   utc.Day(),
 	}
 }
+
+// DateNameString returns the path of streamName's file for the UTC
+// day of tm, in the archiver's layout: YYYY/MM/DD/streamName.
+func DateNameString(tm time.Time, streamName string) string {
+	d := TimeToDate(tm)
+	return path.Join(fmt.Sprintf("%04d", d.Year), fmt.Sprintf("%02d", d.Month), fmt.Sprintf("%02d", d.Day), streamName)
+}
@@ -1,9 +1,13 @@
 package tm
 
 import (
+	"encoding/binary"
 	"fmt"
-	"github.com/nats-io/gnatsd/hashmap"
 	"io"
+	"strings"
+	"time"
+
+	"github.com/glycerine/zebrapack/zebra"
 )
 
 type DupDetectedErr struct {
@@ -20,6 +24,317 @@ func NewDupDetectedErr(msg string) *DupDetectedErr {
 	}
 }
 
+// DedupKey selects what makes two frames duplicates.
+type DedupKey int
+
+const (
+	// DedupFullFrame: the frames are byte-identical
+	// (the same Blake2b hash).
+	DedupFullFrame DedupKey = 0
+
+	// DedupPayload: everything but the timestamp matches,
+	// so a re-sent message stamped later is still a duplicate.
+	DedupPayload DedupKey = 1
+
+	// DedupTimeEvtnum: the timestamp and evtnum match.
+	DedupTimeEvtnum DedupKey = 2
+
+	// DedupField: the value at DedupConfig.Field, a path
+	// into the JSON, msgpack, or ZebraPack payload (such
+	// as a message id), matches. Frames lacking the field
+	// are never duplicates.
+	DedupField DedupKey = 3
+)
+
+// String stringifies the DedupKey, for printing.
+func (k DedupKey) String() string {
+	switch k {
+	case DedupFullFrame:
+		return "frame"
+	case DedupPayload:
+		return "payload"
+	case DedupTimeEvtnum:
+		return "tm-evtnum"
+	case DedupField:
+		return "field"
+	}
+	return fmt.Sprintf("DedupKey.%d", int(k))
+}
+
+// ParseDedupKey converts "frame", "payload", "tm-evtnum", or "field"
+// into a DedupKey.
+func ParseDedupKey(s string) (DedupKey, error) {
+	switch strings.ToLower(s) {
+	case "", "frame", "full":
+		return DedupFullFrame, nil
+	case "payload":
+		return DedupPayload, nil
+	case "tm-evtnum", "tmevtnum", "time-evtnum":
+		return DedupTimeEvtnum, nil
+	case "field":
+		return DedupField, nil
+	}
+	return DedupFullFrame, fmt.Errorf("unknown dedup key '%s': use frame, payload, tm-evtnum, or field", s)
+}
+
+// DedupPolicy decides which copy of a duplicate survives.
+type DedupPolicy int
+
+const (
+	// FirstWins keeps the first copy, and writes it at once.
+	FirstWins DedupPolicy = 0
+
+	// LastWins keeps the last copy seen within the window. Each
+	// frame is held until its window passes without a newer
+	// copy arriving, so output lags input by the window.
+	LastWins DedupPolicy = 1
+)
+
+// DedupConfig configures a Deduper. A frame is a duplicate if
+// an earlier frame with the same key (or a duplicate of it) is
+// still within the window. The window can be a count of frames,
+// a span of time, or both, in which case an earlier frame leaves
+// the window as soon as it falls outside either one. With
+// neither, the window is unbounded.
+type DedupConfig struct {
+	WindowSize int           // in frames
+	WindowDur  time.Duration // in time, behind the newest frame seen
+
+	Key     DedupKey
+	Field   string        // the payload path, for DedupField
+	ZSchema *zebra.Schema // for ZebraPack payloads under DedupField
+
+	Policy DedupPolicy
+
+	// State, if not nil, remembers keys beyond the window, and
+	// across runs. A frame whose key is in State is a duplicate
+	// even when no copy of it is in the window. New keys are
+	// added to State as they are seen.
+	State *DedupState
+}
+
+// keyDesc describes the keys made under c, so that
+// a saved DedupState is only reused with like keys.
+func (c *DedupConfig) keyDesc() string {
+	if c.Key == DedupField {
+		return c.Key.String() + ":" + c.Field
+	}
+	return c.Key.String()
+}
+
+// DedupStats counts what a Deduper saw.
+type DedupStats struct {
+	Frames int64
+	Unique int64 // frames kept
+	Dups   int64
+	NoKey  int64 // frames without the DedupField, passed through
+
+	StateDups int64 // of Dups, those found only in the DedupState
+	Probable  int64 // Bloom-only DedupState hits, passed through unless TrustBloom
+}
+
+type dedupEntry struct {
+	key   string
+	tm    int64
+	idx   int64
+	frame *Frame // under LastWins, the frame waiting to be written
+}
+
+// Deduper removes duplicate frames from a stream, as
+// configured by DedupConfig.
+type Deduper struct {
+	Cfg   DedupConfig
+	Stats DedupStats
+
+	path   *FieldPath
+	fifo   []*dedupEntry // the window, oldest first, from head
+	head   int
+	latest map[string]*dedupEntry
+	maxTm  int64
+}
+
+// NewDeduper makes a Deduper for cfg.
+func NewDeduper(cfg DedupConfig) (*Deduper, error) {
+	d := &Deduper{
+		Cfg:    cfg,
+		latest: make(map[string]*dedupEntry),
+	}
+	if cfg.Key == DedupField {
+		var err error
+		d.path, err = ParseFieldPath(cfg.Field)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if st := cfg.State; st != nil {
+		desc := cfg.keyDesc()
+		if st.KeyDesc == "" {
+			st.KeyDesc = desc
+		} else if st.KeyDesc != desc {
+			return nil, fmt.Errorf("dedup state was made with key '%s', not '%s'", st.KeyDesc, desc)
+		}
+	}
+	return d, nil
+}
+
+// Key returns the dedup key of f, or false if f has none.
+func (d *Deduper) Key(f *Frame) (string, bool) {
+	switch d.Cfg.Key {
+	case DedupPayload:
+		cp := *f
+		cp.Prim = f.Prim & 7 // keep only the PTI
+		return string(cp.Blake2b()), true
+	case DedupTimeEvtnum:
+		var b [12]byte
+		binary.LittleEndian.PutUint64(b[:8], uint64(f.Tm()))
+		binary.LittleEndian.PutUint32(b[8:], uint32(f.GetEvtnum()))
+		return string(b[:]), true
+	case DedupField:
+		v, ok := d.path.LookupFrame(f, d.Cfg.ZSchema)
+		if !ok {
+			return "", false
+		}
+		return PayloadString(v), true
+	}
+	return string(f.Blake2b()), true
+}
+
+// Process takes ownership of f and returns the frames now ready
+// to be written, in input order, along with dup: whether f was a
+// duplicate. Superseded copies are returned in dups.
+func (d *Deduper) Process(f *Frame) (out []*Frame, dups []*Frame, dup bool) {
+	idx := d.Stats.Frames
+	d.Stats.Frames++
+	tm := f.Tm()
+	if idx == 0 || tm > d.maxTm {
+		d.maxTm = tm
+	}
+	out = d.expire(idx, out)
+
+	key, ok := d.Key(f)
+	if !ok {
+		d.Stats.NoKey++
+		d.Stats.Unique++
+		if d.Cfg.Policy == LastWins && d.Len() > 0 {
+			// keep output in input order behind the held frames.
+			d.push(&dedupEntry{key: "\x00nokey", tm: tm, idx: idx, frame: f}, false)
+			return out, nil, false
+		}
+		return append(out, f), nil, false
+	}
+
+	e := &dedupEntry{key: key, tm: tm, idx: idx}
+	prev, dup := d.latest[key]
+	if !dup && d.Cfg.State != nil && d.inState(key) {
+		// a copy was seen long ago, or in an earlier run, and
+		// has already been written.
+		d.Stats.Dups++
+		d.Stats.StateDups++
+		return out, append(dups, f), true
+	}
+	if dup {
+		d.Stats.Dups++
+	} else {
+		d.Stats.Unique++
+	}
+	switch d.Cfg.Policy {
+	case LastWins:
+		if dup && prev.frame != nil {
+			dups = append(dups, prev.frame)
+			prev.frame = nil
+		}
+		e.frame = f
+	default:
+		if dup {
+			dups = append(dups, f)
+		} else {
+			out = append(out, f)
+		}
+	}
+	d.push(e, true)
+	return out, dups, dup
+}
+
+// inState checks key against Cfg.State, adding it if new.
+func (d *Deduper) inState(key string) bool {
+	st := d.Cfg.State
+	h := HashDedupKey(key)
+	seen, exact := st.Lookup(h)
+	if seen && !exact {
+		d.Stats.Probable++
+		if st.Cfg.TrustBloom {
+			return true
+		}
+		// unconfirmed: let it through, and confirm
+		// any later copies.
+		seen = false
+	}
+	if !seen {
+		st.Add(h)
+	}
+	return seen
+}
+
+// Finish returns the frames still held under LastWins.
+// Call it at the end of the stream.
+func (d *Deduper) Finish() []*Frame {
+	var out []*Frame
+	for ; d.head < len(d.fifo); d.head++ {
+		e := d.fifo[d.head]
+		if e.frame != nil {
+			out = append(out, e.frame)
+			e.frame = nil
+		}
+	}
+	d.fifo = d.fifo[:0]
+	d.head = 0
+	d.latest = make(map[string]*dedupEntry)
+	return out
+}
+
+// Len returns the number of frames in the window.
+func (d *Deduper) Len() int {
+	return len(d.fifo) - d.head
+}
+
+func (d *Deduper) push(e *dedupEntry, keyed bool) {
+	if keyed {
+		d.latest[e.key] = e
+	}
+	if d.head > 1024 && d.head > len(d.fifo)/2 {
+		n := copy(d.fifo, d.fifo[d.head:])
+		for i := n; i < len(d.fifo); i++ {
+			d.fifo[i] = nil
+		}
+		d.fifo = d.fifo[:n]
+		d.head = 0
+	}
+	d.fifo = append(d.fifo, e)
+}
+
+// expire drops the entries that have left the window as of frame
+// idx, appending any frames they were holding to out.
+func (d *Deduper) expire(idx int64, out []*Frame) []*Frame {
+	ws := int64(d.Cfg.WindowSize)
+	wd := int64(d.Cfg.WindowDur)
+	for d.head < len(d.fifo) {
+		e := d.fifo[d.head]
+		if !((ws > 0 && idx-e.idx > ws) || (wd > 0 && d.maxTm-e.tm > wd)) {
+			break
+		}
+		if d.latest[e.key] == e {
+			delete(d.latest, e.key)
+		}
+		if e.frame != nil {
+			out = append(out, e.frame)
+			e.frame = nil
+		}
+		d.fifo[d.head] = nil
+		d.head++
+	}
+	return out
+}
+
 // Dedup dedups over a window of windowSize Frames a
 // stream of frames from r into w. dupsW can be nil. If
 // dupsW is supplied, recognized duplicate events will
@@ -29,6 +344,27 @@ func NewDupDetectedErr(msg string) *DupDetectedErr {
 // With detectOnly set, no dedupped output Frames
 // are written.
 func Dedup(r io.Reader, w io.Writer, windowSize int, dupsW io.Writer, detectOnly bool) error {
+	_, err := DedupWith(r, w, DedupConfig{WindowSize: windowSize}, dupsW, detectOnly)
+	return err
+}
+
+// DedupWith is Dedup with the window, key, and policy given by cfg.
+//
+// Note that a duplicate also refreshes the window, so a run of
+// copies spaced less than a window apart is all recognized, even
+// when the first copy has long since rolled out. e.g. with window
+// size 2 and this sequence
+//
+//	index:     0 1 2 3 4
+//	values:  [ 1 2 1 3 1 ]
+//	           ^   ^   ^    <-- highlight the duplicates
+//
+// the value at index 4 is a duplicate of the one at index 2.
+func DedupWith(r io.Reader, w io.Writer, cfg DedupConfig, dupsW io.Writer, detectOnly bool) (*DedupStats, error) {
+	d, err := NewDeduper(cfg)
+	if err != nil {
+		return nil, err
+	}
 	fr := NewFrameReader(r, 1024*1024)
 	fw := NewFrameWriter(w, 1024*1024)
 
@@ -37,9 +373,6 @@ func Dedup(r io.Reader, w io.Writer, windowSize int, dupsW io.Writer, detectOnly
 		dupsWriter = NewFrameWriter(dupsW, 1024*1024)
 	}
 
-	window := make([]*dedup, windowSize)
-	present := hashmap.New()
-
 	defer func() {
 		fw.Flush()
 		fw.Sync()
@@ -49,68 +382,29 @@ func Dedup(r io.Reader, w io.Writer, windowSize int, dupsW io.Writer, detectOnly
 		}
 	}()
 
-	var err error
-	var ptr *dedup
-	for i := 0; err == nil; i++ {
-		var frame Frame
-		_, _, err, _ = fr.NextFrame(&frame)
+	for i := 0; ; i++ {
+		frame := &Frame{}
+		_, _, err, _ = fr.NextFrame(frame)
 		if err != nil {
 			if err != io.EOF {
-				return fmt.Errorf("dedup error from fr.NextFrame(): '%v'", err)
+				return &d.Stats, fmt.Errorf("dedup error from fr.NextFrame(): '%v'", err)
 			}
-		} else { // err == nil
-
-			// got a frame, check if it is a dup
-			hash := frame.Blake2b()
-			p := present.Get(hash)
-			if p == nil {
-				// not already seen
-				if !detectOnly {
-					fw.Append(&frame)
-				}
-				// memorize the new
-				ptr = &dedup{count: 1, hash: hash}
-				present.Set(hash, ptr)
-			} else {
-				// else skip duplicates, but keep a count so
-				// our window is always correct. Otherwise
-				// a duplicate can be missed if masked
-				// by an even earlier pre-window duplicate.
-				// e.g. with window size 3 and this sequence
-				//  index:     0 1 2 3 4
-				//  values:  [ 1 2 1 3 1 ]
-				//             ^   ^   ^    <-- highlight the duplicates
-				// Without the count, the dup at index 2
-				// would be forgotten about when the index 0
-				// value rolls out of the 'present' hash,
-				// meaning that the dup at index 4 would
-				// not be recognized.
-
-				if detectOnly {
-					return NewDupDetectedErr(frame.Stringify(int64(i), false, true, false))
-				}
-				ptr = p.(*dedup)
-				ptr.count++
-				if dupsWriter != nil {
-					if !detectOnly {
-						dupsWriter.Append(&frame)
-					}
-				}
+			break
+		}
+		out, dups, dup := d.Process(frame)
+		if dup && detectOnly {
+			return &d.Stats, NewDupDetectedErr(frame.Stringify(int64(i), false, true, false))
+		}
+		if !detectOnly {
+			for _, f := range out {
+				fw.Append(f)
 			}
-
-			if i >= windowSize {
-				// deal with rolling off the last first, so
-				// we have space to write
-				last := present.Get(window[i%windowSize].hash).(*dedup)
-				last.count--
-				if last.count == 0 {
-					present.Remove(last.hash)
+			if dupsWriter != nil {
+				for _, f := range dups {
+					dupsWriter.Append(f)
 				}
 			}
-			// and write our hash into our window ring
-			window[i%windowSize] = ptr
-		} // end else err == nil from NextFrame()
-
+		}
 		if i%1000 == 999 {
 			fw.Flush()
 			if dupsWriter != nil {
@@ -118,11 +412,10 @@ func Dedup(r io.Reader, w io.Writer, windowSize int, dupsW io.Writer, detectOnly
 			}
 		}
 	}
-	return nil
-}
-
-// deduper is used during dedup.
-type dedup struct {
-	hash  []byte
-	count int
+	if !detectOnly {
+		for _, f := range d.Finish() {
+			fw.Append(f)
+		}
+	}
+	return &d.Stats, nil
 }
@@ -0,0 +1,272 @@
+package tm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test160DedupTimeWindowAndKeys(t *testing.T) {
+
+	msg := func(sec int, id string, body string) *Frame {
+		return GenTestFrame(sec, EvJson, 0, 0, []byte(fmt.Sprintf(`{"id":"%s","body":"%s"}`, id, body)))
+	}
+	run := func(cfg DedupConfig, frames []*Frame) (kept []*Frame, dups []*Frame, stats *DedupStats) {
+		d, err := NewDeduper(cfg)
+		panicOn(err)
+		for _, f := range frames {
+			o, ds, _ := d.Process(f)
+			kept = append(kept, o...)
+			dups = append(dups, ds...)
+		}
+		kept = append(kept, d.Finish()...)
+		return kept, dups, &d.Stats
+	}
+
+	cv.Convey("DedupPayload should ignore timestamps, and a time window should forget copies that fall too far behind", t, func() {
+		frames := []*Frame{
+			msg(0, "a", "x"),
+			msg(1, "b", "y"),
+			msg(5, "a", "x"),  // re-sent 5s later: dup
+			msg(20, "a", "x"), // 15s after the last copy: outside a 10s window
+		}
+		kept, dups, stats := run(DedupConfig{Key: DedupPayload, WindowDur: 10 * time.Second}, frames)
+		cv.So(stats.Dups, cv.ShouldEqual, 1)
+		cv.So(len(kept), cv.ShouldEqual, 3)
+		cv.So(len(dups), cv.ShouldEqual, 1)
+		cv.So(dups[0].Tm(), cv.ShouldEqual, frames[2].Tm())
+
+		// full frame hashing sees no dups at all, as the timestamps differ.
+		_, _, stats = run(DedupConfig{WindowDur: 10 * time.Second}, frames)
+		cv.So(stats.Dups, cv.ShouldEqual, 0)
+	})
+
+	cv.Convey("DedupField keyed on a message id should apply first-wins or last-wins", t, func() {
+		frames := []*Frame{
+			msg(0, "a", "v1"),
+			msg(1, "b", "v1"),
+			msg(2, "a", "v2"),
+			msg(3, "c", "v1"),
+			msg(4, "a", "v3"),
+		}
+		nokey := GenTestFrame(5, EvJson, 0, 0, []byte(`{"other":1}`))
+		frames = append(frames, nokey)
+
+		kept, dups, stats := run(DedupConfig{Key: DedupField, Field: "id"}, frames)
+		cv.So(stats.Dups, cv.ShouldEqual, 2)
+		cv.So(stats.NoKey, cv.ShouldEqual, 1)
+		cv.So(len(kept), cv.ShouldEqual, 4)
+		cv.So(string(kept[0].Data), cv.ShouldContainSubstring, "v1")
+		cv.So(len(dups), cv.ShouldEqual, 2)
+
+		kept, dups, _ = run(DedupConfig{Key: DedupField, Field: "id", Policy: LastWins}, frames)
+		cv.So(len(kept), cv.ShouldEqual, 4)
+		cv.So(len(dups), cv.ShouldEqual, 2)
+		// survivors are in input order: b@1, c@3, a@4 (the last copy), nokey@5
+		cv.So(kept[0].Tm(), cv.ShouldEqual, frames[1].Tm())
+		cv.So(kept[1].Tm(), cv.ShouldEqual, frames[3].Tm())
+		cv.So(kept[2].Tm(), cv.ShouldEqual, frames[4].Tm())
+		cv.So(string(kept[2].Data), cv.ShouldContainSubstring, "v3")
+		cv.So(kept[3].Tm(), cv.ShouldEqual, nokey.Tm())
+	})
+
+	cv.Convey("DedupTimeEvtnum should treat same-time same-evtnum frames as copies, and DedupWith should stream", t, func() {
+		frames := []*Frame{msg(0, "a", "x"), msg(0, "b", "y"), msg(1, "a", "x")}
+		var in, out bytes.Buffer
+		panicOn(writeFrames(&in, frames))
+		stats, err := DedupWith(&in, &out, DedupConfig{Key: DedupTimeEvtnum, WindowSize: 10}, nil, false)
+		panicOn(err)
+		cv.So(stats.Dups, cv.ShouldEqual, 1)
+		cv.So(out.Len(), cv.ShouldEqual, int(frames[0].NumBytes()+frames[2].NumBytes()))
+	})
+
+	cv.Convey("tfdedup -dur without -window should bound the window by time alone", t, func() {
+		parse := func(args ...string) *TfdedupConfig {
+			c := &TfdedupConfig{}
+			fs := flag.NewFlagSet("tfdedup", flag.ContinueOnError)
+			c.DefineFlags(fs)
+			panicOn(fs.Parse(args))
+			panicOn(c.ValidateConfig())
+			return c
+		}
+		cv.So(parse().WindowSize, cv.ShouldEqual, 1000)
+		cv.So(parse("-dur", "10m").WindowSize, cv.ShouldEqual, 0)
+		cv.So(parse("-dur", "10m", "-window", "1000").WindowSize, cv.ShouldEqual, 1000)
+	})
+}
+
+func Test161DedupStatePersistsAcrossRuns(t *testing.T) {
+
+	run := func(st *DedupState, from, to int) (*bytes.Buffer, *DedupStats) {
+		var frames []*Frame
+		for i := from; i < to; i++ {
+			frames = append(frames, GenTestFrame(i, EvOneInt64, 0, int64(i), nil))
+		}
+		var in, out bytes.Buffer
+		panicOn(writeFrames(&in, frames))
+		stats, err := DedupWith(&in, &out, DedupConfig{WindowSize: 10, State: st}, nil, false)
+		panicOn(err)
+		return &out, stats
+	}
+
+	cv.Convey("A saved and restored DedupState should find duplicates from an earlier, overlapping run", t, func() {
+		st := NewDedupState(DedupStateConfig{})
+		_, stats := run(st, 0, 100)
+		cv.So(stats.Dups, cv.ShouldEqual, 0)
+
+		var saved bytes.Buffer
+		panicOn(st.Save(&saved))
+		st2, err := LoadDedupState(&saved, DedupStateConfig{})
+		panicOn(err)
+		cv.So(st2.KeyDesc, cv.ShouldEqual, "frame")
+		cv.So(st2.ExactLen(), cv.ShouldEqual, 100)
+
+		// day two overlaps the last 30 frames of day one.
+		out, stats := run(st2, 70, 150)
+		cv.So(stats.Dups, cv.ShouldEqual, 30)
+		cv.So(stats.StateDups, cv.ShouldEqual, 30)
+		cv.So(out.Len(), cv.ShouldEqual, 16*50)
+
+		// keys of another kind must not be mixed in.
+		_, err = NewDeduper(DedupConfig{Key: DedupPayload, State: st2})
+		cv.So(err, cv.ShouldNotBeNil)
+	})
+
+	cv.Convey("LoadDedupState should keep only the newest MaxExact keys, and refuse a key count its file cannot hold", t, func() {
+		st := NewDedupState(DedupStateConfig{})
+		run(st, 0, 100)
+		var saved bytes.Buffer
+		panicOn(st.Save(&saved))
+		by := saved.Bytes()
+
+		st2, err := LoadDedupState(bytes.NewReader(by), DedupStateConfig{MaxExact: 10})
+		panicOn(err)
+		cv.So(st2.ExactLen(), cv.ShouldEqual, 10)
+		_, stats := run(st2, 90, 100)
+		cv.So(stats.StateDups, cv.ShouldEqual, 10)
+
+		// the exact key count follows the magic, the key
+		// description, FPRate, nextCap, and nextFP.
+		off := len(dedupStateMagic) + 4 + len("frame") + 24
+		bad := append([]byte{}, by...)
+		binary.LittleEndian.PutUint64(bad[off:], 1<<60)
+		dir, err := ioutil.TempDir("", "dedupstate")
+		panicOn(err)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "state")
+		panicOn(ioutil.WriteFile(path, bad, 0644))
+		_, err = LoadDedupStateFile(path, DedupStateConfig{})
+		cv.So(err, cv.ShouldNotBeNil)
+		_, err = LoadDedupState(bytes.NewReader(bad), DedupStateConfig{})
+		cv.So(err, cv.ShouldNotBeNil)
+
+		// nor should a corrupt Bloom filter size or probe count
+		// be trusted. The first filter's m and k follow the 100
+		// exact keys and the filter count.
+		off += 8 + 100*16 + 4
+		for _, c := range []struct {
+			at  int
+			val []byte
+		}{
+			{off, []byte{0, 0, 0, 0, 0x80, 0, 0, 0}}, // m = 1<<39
+			{off + 8, []byte{0, 0, 0, 0}},            // k = 0
+			{off + 8, []byte{65, 0, 0, 0}},           // k = 65
+		} {
+			bad := append([]byte{}, by...)
+			copy(bad[c.at:], c.val)
+			panicOn(ioutil.WriteFile(path, bad, 0644))
+			_, err = LoadDedupStateFile(path, DedupStateConfig{})
+			cv.So(err, cv.ShouldNotBeNil)
+			_, err = LoadDedupState(bytes.NewReader(bad), DedupStateConfig{})
+			cv.So(err, cv.ShouldNotBeNil)
+		}
+
+		// filters over MaxBloomBytes are skipped.
+		st3, err := LoadDedupState(bytes.NewReader(by), DedupStateConfig{MaxBloomBytes: 1})
+		panicOn(err)
+		cv.So(st3.BloomBytes(), cv.ShouldEqual, 0)
+		cv.So(st3.ExactLen(), cv.ShouldEqual, 100)
+	})
+
+	cv.Convey("Keys evicted from the exact set should be Bloom-only hits: passed through unless TrustBloom", t, func() {
+		st := NewDedupState(DedupStateConfig{MaxExact: 10, InitialCapacity: 50})
+		run(st, 0, 100)
+		cv.So(st.ExactLen(), cv.ShouldEqual, 10)
+		cv.So(st.BloomKeys(), cv.ShouldEqual, 100)
+
+		_, stats := run(st, 90, 100)
+		cv.So(stats.Dups, cv.ShouldEqual, 10)
+		cv.So(stats.Probable, cv.ShouldEqual, 0)
+
+		_, stats = run(st, 0, 90)
+		cv.So(stats.Dups, cv.ShouldEqual, 0)
+		cv.So(stats.Probable, cv.ShouldEqual, 90)
+
+		st.Cfg.TrustBloom = true
+		_, stats = run(st, 0, 50)
+		cv.So(stats.Dups, cv.ShouldEqual, 50)
+	})
+
+	cv.Convey("The scalable Bloom filter should stay under its false positive rate as it grows", t, func() {
+		st := NewDedupState(DedupStateConfig{MaxExact: 1, InitialCapacity: 1000, FPRate: 0.01})
+		for i := 0; i < 20000; i++ {
+			st.Add(HashDedupKey(fmt.Sprintf("in-%d", i)))
+		}
+		cv.So(len(st.blooms), cv.ShouldEqual, 5)
+		fp := 0
+		for i := 0; i < 20000; i++ {
+			if seen, _ := st.Lookup(HashDedupKey(fmt.Sprintf("out-%d", i))); seen {
+				fp++
+			}
+		}
+		cv.So(float64(fp)/20000, cv.ShouldBeLessThan, 0.01)
+
+		// bounded memory: the oldest filters are dropped.
+		st.Cfg.MaxBloomBytes = st.BloomBytes() - 1
+		st.trim()
+		cv.So(st.BloomBytes(), cv.ShouldBeLessThanOrEqualTo, st.Cfg.MaxBloomBytes)
+		cv.So(len(st.blooms), cv.ShouldEqual, 4)
+	})
+
+	cv.Convey("Bloom filters should stop growing at a quarter of MaxBloomBytes, and forget the oldest keys a filter at a time", t, func() {
+		st := NewDedupState(DedupStateConfig{MaxExact: 1, InitialCapacity: 1000, FPRate: 0.01, MaxBloomBytes: 16 << 10})
+		over := false
+		for i := 0; i < 200000; i++ {
+			st.Add(HashDedupKey(fmt.Sprintf("in-%d", i)))
+			if st.BloomBytes() > st.Cfg.MaxBloomBytes {
+				over = true
+			}
+		}
+		cv.So(over, cv.ShouldBeFalse)
+		for _, b := range st.blooms {
+			cv.So(b.numBytes(), cv.ShouldBeLessThanOrEqualTo, st.Cfg.MaxBloomBytes/4)
+		}
+		cv.So(len(st.blooms), cv.ShouldEqual, 4)
+
+		// the three newest filters are full, so hold at least
+		// the last 3*cap keys.
+		missed := 0
+		for i := 200000 - 3*int(st.blooms[0].cap); i < 200000; i++ {
+			if seen, _ := st.Lookup(HashDedupKey(fmt.Sprintf("in-%d", i))); !seen {
+				missed++
+			}
+		}
+		cv.So(missed, cv.ShouldEqual, 0)
+
+		fp := 0
+		for i := 0; i < 20000; i++ {
+			if seen, _ := st.Lookup(HashDedupKey(fmt.Sprintf("out-%d", i))); seen {
+				fp++
+			}
+		}
+		cv.So(float64(fp)/20000, cv.ShouldBeLessThan, 0.01)
+	})
+}
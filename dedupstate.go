@@ -0,0 +1,506 @@
+package tm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+
+	"github.com/glycerine/blake2b"
+)
+
+// DedupStateConfig bounds the memory used by a DedupState.
+type DedupStateConfig struct {
+	// MaxExact is the number of the most recent keys kept
+	// exactly, 16 bytes of Blake2b hash apiece (about 60 bytes
+	// each in memory, with map overhead). Older keys are only
+	// remembered by the Bloom filters. Default 1<<20.
+	MaxExact int
+
+	// FPRate bounds the false positive rate of the Bloom filters
+	// taken together. The first filter gets FPRate/4, and each
+	// new one half the rate of the one before, so the growing
+	// filters stay under FPRate/2. Default 0.001, which costs
+	// about 18 bits per key.
+	FPRate float64
+
+	// InitialCapacity is the number of keys the first Bloom
+	// filter holds. Each new filter holds twice as many as the
+	// one before, until a filter would pass MaxBloomBytes/4.
+	// Default 1<<20.
+	InitialCapacity int
+
+	// MaxBloomBytes bounds the Bloom filters. No filter grows
+	// past MaxBloomBytes/4: from there on, each new filter is
+	// that size, at FPRate/8, so the four of them that fit are
+	// under FPRate/2 together. As each new filter comes, the
+	// oldest are dropped to make room, and the keys in them are
+	// forgotten. Default 256MB.
+	MaxBloomBytes int64
+
+	// TrustBloom, if set, treats keys found only in the Bloom
+	// filters as duplicates. By default a key must be confirmed
+	// by the exact set, and Bloom-only hits are passed through
+	// and counted as probable duplicates, so a false positive
+	// never costs a frame.
+	//
+	// Without TrustBloom, then, only copies of the last MaxExact
+	// keys are dropped. If a run (a day, say) has more distinct
+	// keys than that, copies of its older keys in the next run
+	// are passed through, and show up only in DedupStats.Probable.
+	// Size MaxExact to the distinct keys of a run, or set
+	// TrustBloom, to dedup across runs in full.
+	TrustBloom bool
+}
+
+func (c *DedupStateConfig) setDefaults() {
+	if c.MaxExact <= 0 {
+		c.MaxExact = 1 << 20
+	}
+	if c.FPRate <= 0 || c.FPRate >= 1 {
+		c.FPRate = 0.001
+	}
+	if c.InitialCapacity <= 0 {
+		c.InitialCapacity = 1 << 20
+	}
+	if c.MaxBloomBytes <= 0 {
+		c.MaxBloomBytes = 256 << 20
+	}
+}
+
+// DedupState remembers the keys a Deduper has seen, across runs,
+// so that re-ingesting overlapping files or days finds the
+// duplicates between them. Set it as DedupConfig.State, and
+// Save it at the end of the run.
+//
+// Keys are hashed with Blake2b. The most recent MaxExact keys are
+// kept exactly; all keys go into a scalable Bloom filter, a chain
+// of filters that each double in capacity and halve in false
+// positive rate, up to a quarter of MaxBloomBytes apiece, so the
+// chain as a whole stays under FPRate.
+type DedupState struct {
+	Cfg DedupStateConfig
+
+	// KeyDesc records which DedupKey (and field) made the keys,
+	// since keys of one kind say nothing about another.
+	KeyDesc string
+
+	exact     map[[16]byte]struct{}
+	exactFifo [][16]byte // ring, oldest at exactHead
+	exactHead int
+
+	blooms     []*bloomFilter
+	bloomBytes int64
+	nextCap    uint64
+	nextFP     float64
+}
+
+// NewDedupState makes an empty DedupState.
+func NewDedupState(cfg DedupStateConfig) *DedupState {
+	cfg.setDefaults()
+	return &DedupState{
+		Cfg:     cfg,
+		exact:   make(map[[16]byte]struct{}),
+		nextCap: uint64(cfg.InitialCapacity),
+		nextFP:  cfg.FPRate / 4,
+	}
+}
+
+// DedupHash is the hash under which DedupState remembers key.
+type DedupHash [32]byte
+
+// HashDedupKey hashes a Deduper key for DedupState.
+func HashDedupKey(key string) DedupHash {
+	h, err := blake2b.New(nil)
+	panicOn(err)
+	h.Write([]byte(key))
+	var dh DedupHash
+	copy(dh[:], h.Sum(nil))
+	return dh
+}
+
+func (h DedupHash) exactKey() (k [16]byte) {
+	copy(k[:], h[:16])
+	return
+}
+
+func (h DedupHash) bloomHashes() (uint64, uint64) {
+	return binary.LittleEndian.Uint64(h[16:24]), binary.LittleEndian.Uint64(h[24:32]) | 1
+}
+
+// Lookup reports whether h has been seen, and if so, whether that
+// is exact (confirmed) or only a Bloom filter hit, which is wrong
+// with probability up to Cfg.FPRate.
+func (s *DedupState) Lookup(h DedupHash) (seen bool, exact bool) {
+	if _, ok := s.exact[h.exactKey()]; ok {
+		return true, true
+	}
+	h1, h2 := h.bloomHashes()
+	for i := len(s.blooms) - 1; i >= 0; i-- {
+		if s.blooms[i].has(h1, h2) {
+			return true, false
+		}
+	}
+	return false, false
+}
+
+// Add remembers h.
+func (s *DedupState) Add(h DedupHash) {
+	ek := h.exactKey()
+	if _, ok := s.exact[ek]; !ok {
+		s.addExact(ek)
+	}
+	h1, h2 := h.bloomHashes()
+	if len(s.blooms) > 0 && s.blooms[len(s.blooms)-1].has(h1, h2) {
+		return
+	}
+	if len(s.blooms) == 0 || s.blooms[len(s.blooms)-1].full() {
+		s.grow()
+	}
+	s.blooms[len(s.blooms)-1].add(h1, h2)
+}
+
+func (s *DedupState) addExact(ek [16]byte) {
+	if len(s.exactFifo) < s.Cfg.MaxExact {
+		s.exactFifo = append(s.exactFifo, ek)
+	} else {
+		delete(s.exact, s.exactFifo[s.exactHead])
+		s.exactFifo[s.exactHead] = ek
+		s.exactHead = (s.exactHead + 1) % len(s.exactFifo)
+	}
+	s.exact[ek] = struct{}{}
+}
+
+// bloomSlices is the number of the largest Bloom
+// filters that fit in MaxBloomBytes.
+const bloomSlices = 4
+
+func (s *DedupState) grow() {
+	s.capNext()
+	b := newBloomFilter(s.nextCap, s.nextFP)
+	s.nextCap *= 2
+	s.nextFP /= 2
+	s.capNext()
+	s.blooms = append(s.blooms, b)
+	s.bloomBytes += b.numBytes()
+	s.trim()
+}
+
+// capNext keeps the next Bloom filter within MaxBloomBytes/bloomSlices.
+// A filter that would be larger is made that size instead, at a
+// rate of FPRate/(2*bloomSlices), and so are all the filters after
+// it, as doubling it again always goes past the cap.
+func (s *DedupState) capNext() {
+	bits := uint64(s.Cfg.MaxBloomBytes/bloomSlices) * 8 / 64 * 64
+	if bloomBits(s.nextCap, s.nextFP) <= bits {
+		return
+	}
+	s.nextFP = s.Cfg.FPRate / (2 * bloomSlices)
+	s.nextCap = uint64(float64(bits) * math.Ln2 * math.Ln2 / -math.Log(s.nextFP))
+	if s.nextCap < 1 {
+		s.nextCap = 1
+	}
+}
+
+// trim drops the oldest Bloom filters until we fit
+// in MaxBloomBytes, always keeping the newest. Once
+// the filters have reached their size cap, that is
+// one old filter for each new one.
+func (s *DedupState) trim() {
+	for len(s.blooms) > 1 && s.bloomBytes > s.Cfg.MaxBloomBytes {
+		s.bloomBytes -= s.blooms[0].numBytes()
+		s.blooms[0] = nil
+		s.blooms = s.blooms[1:]
+	}
+}
+
+// ExactLen returns the number of keys held exactly.
+func (s *DedupState) ExactLen() int {
+	return len(s.exact)
+}
+
+// BloomKeys returns the number of keys held by the Bloom filters.
+func (s *DedupState) BloomKeys() (n uint64) {
+	for _, b := range s.blooms {
+		n += b.n
+	}
+	return
+}
+
+// BloomBytes returns the memory used by the Bloom filters.
+func (s *DedupState) BloomBytes() int64 {
+	return s.bloomBytes
+}
+
+const dedupStateMagic = "TFDDST01"
+
+// Save writes s to w. Load reads it back.
+func (s *DedupState) Save(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	le := binary.LittleEndian
+	var err error
+	put := func(v interface{}) {
+		if err == nil {
+			err = binary.Write(bw, le, v)
+		}
+	}
+	bw.WriteString(dedupStateMagic)
+	put(uint32(len(s.KeyDesc)))
+	bw.WriteString(s.KeyDesc)
+	put(s.Cfg.FPRate)
+	put(s.nextCap)
+	put(s.nextFP)
+
+	// exact keys, oldest first
+	n := len(s.exactFifo)
+	put(uint64(n))
+	for i := 0; i < n && err == nil; i++ {
+		ek := s.exactFifo[(s.exactHead+i)%n]
+		_, err = bw.Write(ek[:])
+	}
+
+	put(uint32(len(s.blooms)))
+	for _, b := range s.blooms {
+		put(b.m)
+		put(b.k)
+		put(b.n)
+		put(b.cap)
+		put(b.bits)
+	}
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// SaveFile saves s to path, replacing it only
+// once the new state is completely written.
+func (s *DedupState) SaveFile(path string) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = s.Save(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// LoadDedupState reads a DedupState written by Save. The Bloom
+// filters keep the FPRate they were made with; cfg supplies the
+// memory bounds, and whether to TrustBloom. A Bloom filter larger
+// than cfg.MaxBloomBytes is skipped over.
+func LoadDedupState(r io.Reader, cfg DedupStateConfig) (*DedupState, error) {
+	cr := &countingReader{r: r}
+	br := bufio.NewReader(cr)
+	size, sized := dedupStateFileSize(r)
+	// left returns the bytes of a state file we have yet to read.
+	left := func() int64 {
+		return size - (cr.n - int64(br.Buffered()))
+	}
+	le := binary.LittleEndian
+	var err error
+	get := func(v interface{}) {
+		if err == nil {
+			err = binary.Read(br, le, v)
+		}
+	}
+	magic := make([]byte, len(dedupStateMagic))
+	if _, err = io.ReadFull(br, magic); err != nil || string(magic) != dedupStateMagic {
+		return nil, fmt.Errorf("LoadDedupState: not a dedup state file")
+	}
+	var descLen uint32
+	get(&descLen)
+	if err == nil && descLen > 4096 {
+		err = fmt.Errorf("key description length %v too long", descLen)
+	}
+	desc := make([]byte, descLen)
+	if err == nil {
+		_, err = io.ReadFull(br, desc)
+	}
+	get(&cfg.FPRate)
+	s := NewDedupState(cfg)
+	s.KeyDesc = string(desc)
+	get(&s.nextCap)
+	get(&s.nextFP)
+	if err == nil && (s.nextCap == 0 || !(s.nextFP > 0 && s.nextFP < 1)) {
+		err = fmt.Errorf("bad next bloom filter capacity %v or rate %v", s.nextCap, s.nextFP)
+	}
+
+	var nExact uint64
+	get(&nExact)
+	if err == nil && nExact > 1<<40 {
+		err = fmt.Errorf("bad exact key count %v", nExact)
+	}
+	if err == nil && sized && nExact > uint64(left())/16 {
+		err = fmt.Errorf("%v exact keys cannot fit in the %v bytes left in the file", nExact, left())
+	}
+	// keys are saved oldest first, so when there are more than
+	// we can keep, the oldest are skipped over.
+	if err == nil && nExact > uint64(s.Cfg.MaxExact) {
+		skip := nExact - uint64(s.Cfg.MaxExact)
+		_, err = io.CopyN(ioutil.Discard, br, int64(skip)*16)
+		nExact -= skip
+	}
+	var ek [16]byte
+	for i := uint64(0); i < nExact && err == nil; i++ {
+		if _, err = io.ReadFull(br, ek[:]); err == nil {
+			s.addExact(ek)
+		}
+	}
+
+	var nBloom uint32
+	get(&nBloom)
+	for i := uint32(0); i < nBloom && err == nil; i++ {
+		b := &bloomFilter{}
+		get(&b.m)
+		get(&b.k)
+		get(&b.n)
+		get(&b.cap)
+		if err != nil {
+			break
+		}
+		if b.m == 0 || b.m%64 != 0 || b.m > 1<<40 {
+			err = fmt.Errorf("bad bloom filter size %v", b.m)
+			break
+		}
+		if b.k < 1 || b.k > 64 {
+			err = fmt.Errorf("bad bloom filter probe count %v", b.k)
+			break
+		}
+		if sized && b.numBytes() > left() {
+			err = fmt.Errorf("bloom filter of %v bytes cannot fit in the %v bytes left in the file", b.numBytes(), left())
+			break
+		}
+		if b.numBytes() > s.Cfg.MaxBloomBytes {
+			_, err = io.CopyN(ioutil.Discard, br, b.numBytes())
+			continue
+		}
+		b.bits = make([]uint64, b.m/64)
+		get(b.bits)
+		s.blooms = append(s.blooms, b)
+		s.bloomBytes += b.numBytes()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("LoadDedupState: %v", err)
+	}
+	s.trim()
+	return s, nil
+}
+
+// dedupStateFileSize returns the size of r, if it is a regular
+// file, so that LoadDedupState can reject counts and sizes that
+// the file is too small to hold, before it allocates for them.
+func dedupStateFileSize(r io.Reader) (int64, bool) {
+	f, ok := r.(interface {
+		Stat() (os.FileInfo, error)
+	})
+	if !ok {
+		return 0, false
+	}
+	fi, err := f.Stat()
+	if err != nil || !fi.Mode().IsRegular() {
+		return 0, false
+	}
+	return fi.Size(), true
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// LoadDedupStateFile loads the DedupState saved at path, or
+// returns a new, empty one if path does not exist.
+func LoadDedupStateFile(path string, cfg DedupStateConfig) (*DedupState, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return NewDedupState(cfg), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return LoadDedupState(f, cfg)
+}
+
+// bloomFilter is one filter in the scalable chain. Its k
+// probes are made by double hashing: h1 + i*h2, mod m.
+type bloomFilter struct {
+	bits []uint64
+	m    uint64 // bits, a multiple of 64
+	k    uint32 // probes
+	n    uint64 // keys added
+	cap  uint64 // keys it was sized for
+}
+
+// newBloomFilter sizes a filter for capacity keys at false
+// positive rate fp, with the usual m = -n ln(p) / ln(2)^2
+// bits and k = -log2(p) probes.
+func newBloomFilter(capacity uint64, fp float64) *bloomFilter {
+	m := bloomBits(capacity, fp)
+	k := uint32(math.Ceil(-math.Log2(fp)))
+	if k < 1 {
+		k = 1
+	}
+	return &bloomFilter{
+		bits: make([]uint64, m/64),
+		m:    m,
+		k:    k,
+		cap:  capacity,
+	}
+}
+
+// bloomBits returns the size in bits, a multiple of 64,
+// of the filter for capacity keys at false positive rate fp.
+func bloomBits(capacity uint64, fp float64) uint64 {
+	m := uint64(math.Ceil(-float64(capacity) * math.Log(fp) / (math.Ln2 * math.Ln2)))
+	m = (m + 63) / 64 * 64
+	if m == 0 {
+		m = 64
+	}
+	return m
+}
+
+func (b *bloomFilter) add(h1, h2 uint64) {
+	for i := uint64(0); i < uint64(b.k); i++ {
+		j := (h1 + i*h2) % b.m
+		b.bits[j/64] |= 1 << (j % 64)
+	}
+	b.n++
+}
+
+func (b *bloomFilter) has(h1, h2 uint64) bool {
+	for i := uint64(0); i < uint64(b.k); i++ {
+		j := (h1 + i*h2) % b.m
+		if b.bits[j/64]&(1<<(j%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *bloomFilter) full() bool {
+	return b.n >= b.cap
+}
+
+func (b *bloomFilter) numBytes() int64 {
+	return int64(b.m / 8)
+}
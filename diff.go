@@ -0,0 +1,450 @@
+package tm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/glycerine/zebrapack/zebra"
+)
+
+// DiffConfig configures DiffStreams.
+type DiffConfig struct {
+	// Tolerance lets frames whose timestamps differ by
+	// up to Tolerance be matched with each other.
+	Tolerance time.Duration
+
+	// Ignore lists payload field paths, such as "seq" or
+	// "hdr.host", whose values, and everything below them,
+	// are not compared. "v0", "v1", and "pti" ignore
+	// those parts of the frames.
+	Ignore []string
+
+	// ZSchema is needed to compare ZebraPack payloads.
+	ZSchema *zebra.Schema
+}
+
+// DiffKind says how a frame differs between two streams.
+type DiffKind int
+
+const (
+	DiffOnlyA   DiffKind = 1
+	DiffOnlyB   DiffKind = 2
+	DiffChanged DiffKind = 3
+)
+
+func (k DiffKind) String() string {
+	switch k {
+	case DiffOnlyA:
+		return "only in A"
+	case DiffOnlyB:
+		return "only in B"
+	case DiffChanged:
+		return "changed"
+	}
+	return fmt.Sprintf("DiffKind.%d", int(k))
+}
+
+// FieldDiff is one difference between two matched frames: a
+// payload field path, or "v0", "v1", "pti", or, for payloads
+// that are not JSON, msgpack, or ZebraPack, "payload". A field
+// missing from one side is absent (InA or InB is false).
+type FieldDiff struct {
+	Path string
+	A    interface{}
+	B    interface{}
+	InA  bool
+	InB  bool
+}
+
+// Diff reports one difference between streams A and B. A is nil
+// for DiffOnlyB, and B for DiffOnlyA.
+type Diff struct {
+	Kind   DiffKind
+	A      *Frame
+	B      *Frame
+	Fields []FieldDiff
+}
+
+// DiffStats counts what DiffStreams found.
+type DiffStats struct {
+	FramesA int64
+	FramesB int64
+
+	// Same counts the matched frames that do not differ,
+	// and Shifted those of them whose timestamps do.
+	Same    int64
+	Shifted int64
+
+	OnlyA   int64
+	OnlyB   int64
+	Changed int64
+}
+
+// Differ reports whether any differences were found.
+func (s *DiffStats) Differ() bool {
+	return s.OnlyA+s.OnlyB+s.Changed > 0
+}
+
+// DiffStreams compares two time ordered streams, a and b, frame
+// by frame, aligning them by timestamp, and passes each difference
+// to emit, in time order. A frame of one stream is matched to a
+// frame of the other within cfg.Tolerance of it: first to one that
+// is identical (by FramesEqual, apart from the timestamp), and
+// failing that, to the nearest with the same evtnum that is not
+// identical to another frame. Matched frames with JSON, msgpack,
+// or ZebraPack payloads are compared field by field, so that key
+// order and number encodings do not matter. Frames left unmatched
+// are only in A, or only in B.
+func DiffStreams(a, b *BufferedFrameReader, cfg DiffConfig, emit func(d *Diff) error) (*DiffStats, error) {
+	return diffWalk(a, b, &cfg, emit)
+}
+
+// diffSide holds the frames of one stream that have
+// been read, but not yet matched or reported.
+type diffSide struct {
+	name string
+	src  framePeeker
+	pend []*Frame
+	last int64
+	eof  bool
+	n    *int64
+}
+
+// fill reads frames until the next one is after until, or
+// at least one frame if none are pending.
+func (s *diffSide) fill(until int64) error {
+	for !s.eof {
+		f, err := s.src.Peek()
+		if err == io.EOF {
+			s.eof = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		tm := f.Tm()
+		if len(s.pend) > 0 && tm > until {
+			return nil
+		}
+		if *s.n > 0 && tm < s.last {
+			return fmt.Errorf("stream %s is not in time order at frame %d (%v after %v); sort it with tfsort first",
+				s.name, *s.n+1, time.Unix(0, tm).UTC().Format(time.RFC3339Nano), time.Unix(0, s.last).UTC().Format(time.RFC3339Nano))
+		}
+		cp := *f
+		s.pend = append(s.pend, &cp)
+		s.last = tm
+		*s.n++
+		if err := s.src.Advance(); err != nil && err != io.EOF {
+			return err
+		}
+	}
+	return nil
+}
+
+func diffWalk(a, b framePeeker, cfg *DiffConfig, emit func(d *Diff) error) (*DiffStats, error) {
+	st := &DiffStats{}
+	sides := [2]*diffSide{
+		{name: "A", src: a, n: &st.FramesA},
+		{name: "B", src: b, n: &st.FramesB},
+	}
+	tol := int64(cfg.Tolerance)
+	for {
+		for _, s := range sides {
+			if len(s.pend) == 0 {
+				if err := s.fill(math.MinInt64); err != nil {
+					return st, err
+				}
+			}
+		}
+		// the earliest pending frame, from A on ties.
+		i := 0
+		switch {
+		case len(sides[0].pend) == 0 && len(sides[1].pend) == 0:
+			return st, nil
+		case len(sides[0].pend) == 0:
+			i = 1
+		case len(sides[1].pend) > 0 && sides[1].pend[0].Tm() < sides[0].pend[0].Tm():
+			i = 1
+		}
+		me, other := sides[i], sides[1-i]
+		f := me.pend[0]
+		t := f.Tm()
+		// everything that f, or what f could match, could match.
+		for _, s := range sides {
+			if err := s.fill(t + 2*tol); err != nil {
+				return st, err
+			}
+		}
+		me.pend = me.pend[1:]
+
+		j := diffMatch(f, other.pend, me.pend, tol)
+		if j < 0 {
+			var d *Diff
+			if i == 0 {
+				d = &Diff{Kind: DiffOnlyA, A: f}
+				st.OnlyA++
+			} else {
+				d = &Diff{Kind: DiffOnlyB, B: f}
+				st.OnlyB++
+			}
+			if err := emit(d); err != nil {
+				return st, err
+			}
+			continue
+		}
+		g := other.pend[j]
+		other.pend = append(other.pend[:j], other.pend[j+1:]...)
+		fa, fb := f, g
+		if i == 1 {
+			fa, fb = g, f
+		}
+		fields := DiffFrames(fa, fb, cfg)
+		if len(fields) == 0 {
+			st.Same++
+			if fa.Tm() != fb.Tm() {
+				st.Shifted++
+			}
+			continue
+		}
+		st.Changed++
+		if err := emit(&Diff{Kind: DiffChanged, A: fa, B: fb, Fields: fields}); err != nil {
+			return st, err
+		}
+	}
+}
+
+// diffMatch finds the frame of cands to match f with, or -1.
+// mine are f's own stream's pending frames, which may have
+// a better claim to a candidate.
+func diffMatch(f *Frame, cands []*Frame, mine []*Frame, tol int64) int {
+	t := f.Tm()
+	near := func(g *Frame) (int64, bool) {
+		d := g.Tm() - t
+		if d < 0 {
+			d = -d
+		}
+		return d, d <= tol
+	}
+	best, bestD := -1, int64(0)
+	for j, g := range cands {
+		d, ok := near(g)
+		if ok && (best < 0 || d < bestD) && sameContent(f, g) {
+			best, bestD = j, d
+		}
+	}
+	if best >= 0 {
+		return best
+	}
+	for j, g := range cands {
+		d, ok := near(g)
+		if !ok || g.GetEvtnum() != f.GetEvtnum() || (best >= 0 && d >= bestD) {
+			continue
+		}
+		claimed := false
+		for _, h := range mine {
+			if dh := h.Tm() - g.Tm(); dh <= tol && dh >= -tol && sameContent(h, g) {
+				claimed = true
+				break
+			}
+		}
+		if !claimed {
+			best, bestD = j, d
+		}
+	}
+	return best
+}
+
+// sameContent reports whether a and b are FramesEqual,
+// apart from their timestamps.
+func sameContent(a, b *Frame) bool {
+	if a.GetPTI() != b.GetPTI() || a.GetEvtnum() != b.GetEvtnum() || len(a.Data) != len(b.Data) {
+		return false
+	}
+	bb := *b
+	bb.Prim = (a.Prim &^ 7) | (b.Prim & 7)
+	return FramesEqual(a, &bb)
+}
+
+// DiffFrames lists the differences between frames a and b, other
+// than of their timestamps, in order of path. cfg.Tolerance is
+// not used.
+func DiffFrames(a, b *Frame, cfg *DiffConfig) []FieldDiff {
+	ignored := func(path string) bool {
+		for _, p := range cfg.Ignore {
+			if path == p || strings.HasPrefix(path, p+".") || strings.HasPrefix(path, p+"[") {
+				return true
+			}
+		}
+		return false
+	}
+	var res []FieldDiff
+	add := func(d FieldDiff) {
+		if !ignored(d.Path) {
+			res = append(res, d)
+		}
+	}
+
+	pa, pb := a.GetPTI(), b.GetPTI()
+	if pa != pb {
+		add(FieldDiff{Path: "pti", A: pa.String(), B: pb.String(), InA: true, InB: true})
+	}
+	va, hasA := diffV0(a)
+	vb, hasB := diffV0(b)
+	if hasA != hasB || (hasA && va != vb && !(va != va && vb != vb)) {
+		add(FieldDiff{Path: "v0", A: va, B: vb, InA: hasA, InB: hasB})
+	}
+	ia, hasA := diffV1(a)
+	ib, hasB := diffV1(b)
+	if hasA != hasB || ia != ib {
+		add(FieldDiff{Path: "v1", A: ia, B: ib, InA: hasA, InB: hasB})
+	}
+	if pa != PtiUDE && pb != PtiUDE {
+		return res
+	}
+
+	ea, eb := a.GetEvtnum(), b.GetEvtnum()
+	if HasStructuredPayload(ea) && HasStructuredPayload(eb) {
+		da, errA := a.DecodePayload(cfg.ZSchema)
+		db, errB := b.DecodePayload(cfg.ZSchema)
+		if errA == nil && errB == nil {
+			la := make(map[string]interface{})
+			lb := make(map[string]interface{})
+			flattenPayload("", jsonable(da), la)
+			flattenPayload("", jsonable(db), lb)
+			paths := make([]string, 0, len(la)+len(lb))
+			for p := range la {
+				paths = append(paths, p)
+			}
+			for p := range lb {
+				if _, ok := la[p]; !ok {
+					paths = append(paths, p)
+				}
+			}
+			sort.Strings(paths)
+			for _, p := range paths {
+				x, inA := la[p]
+				y, inB := lb[p]
+				if inA && inB && payloadValuesEqual(x, y) {
+					continue
+				}
+				add(FieldDiff{Path: p, A: x, B: y, InA: inA, InB: inB})
+			}
+			return res
+		}
+	}
+	if ea != eb || !bytes.Equal(a.Data, b.Data) {
+		add(FieldDiff{Path: "payload", A: string(a.Data), B: string(b.Data), InA: pa == PtiUDE, InB: pb == PtiUDE})
+	}
+	return res
+}
+
+func diffV0(f *Frame) (float64, bool) {
+	switch f.GetPTI() {
+	case PtiZero, PtiOneFloat64, PtiTwo64:
+		return f.GetV0(), true
+	}
+	return 0, false
+}
+
+func diffV1(f *Frame) (int64, bool) {
+	switch f.GetPTI() {
+	case PtiOneInt64, PtiTwo64:
+		return f.Ude, true
+	}
+	return 0, false
+}
+
+// flattenPayload records the leaves of v, a jsonable value,
+// in leaves, by their field paths. Empty maps and arrays
+// are leaves.
+func flattenPayload(path string, v interface{}, leaves map[string]interface{}) {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		if len(x) > 0 {
+			for k, e := range x {
+				p := k
+				if path != "" {
+					p = path + "." + k
+				}
+				flattenPayload(p, e, leaves)
+			}
+			return
+		}
+	case []interface{}:
+		if len(x) > 0 {
+			for i, e := range x {
+				flattenPayload(fmt.Sprintf("%s[%d]", path, i), e, leaves)
+			}
+			return
+		}
+	}
+	leaves[path] = v
+}
+
+// payloadValuesEqual compares decoded values, taking numbers
+// as equal whatever their encoding.
+func payloadValuesEqual(x, y interface{}) bool {
+	fx, okx := PayloadFloat(x)
+	fy, oky := PayloadFloat(y)
+	if okx || oky {
+		return okx && oky && fx == fy
+	}
+	return reflect.DeepEqual(x, y)
+}
+
+// String shows the diff over one or more lines: frames only in A
+// with a leading "<", those only in B with ">", and changed frames
+// with "~", followed by a line per field.
+func (d *Diff) String() string {
+	var b bytes.Buffer
+	switch d.Kind {
+	case DiffOnlyA:
+		fmt.Fprintf(&b, "< %s", diffFrameString(d.A))
+	case DiffOnlyB:
+		fmt.Fprintf(&b, "> %s", diffFrameString(d.B))
+	case DiffChanged:
+		fmt.Fprintf(&b, "~ %s", d.A.String())
+		if d.A.Tm() != d.B.Tm() {
+			fmt.Fprintf(&b, " (B at %s)", time.Unix(0, d.B.Tm()).UTC().Format(time.RFC3339Nano))
+		}
+		for _, fd := range d.Fields {
+			fmt.Fprintf(&b, "\n    %s: %s -> %s", fd.Path, diffValueString(fd.A, fd.InA), diffValueString(fd.B, fd.InB))
+		}
+	}
+	return b.String()
+}
+
+func diffValueString(v interface{}, present bool) string {
+	if !present {
+		return "(absent)"
+	}
+	if s, ok := v.(string); ok {
+		return jsonString(s)
+	}
+	return PayloadString(v)
+}
+
+// diffFrameString shows f's header, and the start of its payload.
+func diffFrameString(f *Frame) string {
+	s := f.String()
+	if len(f.Data) == 0 {
+		return s
+	}
+	p := string(f.Data)
+	if HasStructuredPayload(f.GetEvtnum()) {
+		if v, err := f.DecodePayload(nil); err == nil {
+			p = PayloadString(v)
+		}
+	}
+	const max = 120
+	if len(p) > max {
+		p = p[:max] + "..."
+	}
+	return s + " " + p
+}
@@ -0,0 +1,114 @@
+package tm
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+	"github.com/ugorji/go/codec"
+)
+
+func Test280DiffStreams(t *testing.T) {
+
+	at := func(ms int) time.Time { return GenTestT0.Add(time.Duration(ms) * time.Millisecond) }
+	mk := func(tm time.Time, evtnum Evtnum, v0 float64, data string) *Frame {
+		f, err := NewFrame(tm, evtnum, v0, 0, []byte(data))
+		panicOn(err)
+		return f
+	}
+	msgp := func(tm time.Time, m map[string]interface{}) *Frame {
+		var by []byte
+		panicOn(codec.NewEncoderBytes(&by, &msgpHelper.mh).Encode(m))
+		f, err := NewFrame(tm, EvMsgpack, 0, 0, by)
+		panicOn(err)
+		return f
+	}
+	rdr := func(frames []*Frame) *BufferedFrameReader {
+		var buf bytes.Buffer
+		fw := NewFrameWriter(&buf, 1024)
+		fw.Frames = frames
+		panicOn(fw.Flush())
+		return NewBufferedFrameReader(&buf, 1024, "")
+	}
+	diff := func(a, b []*Frame, cfg DiffConfig) ([]*Diff, *DiffStats) {
+		var diffs []*Diff
+		st, err := DiffStreams(rdr(a), rdr(b), cfg, func(d *Diff) error {
+			diffs = append(diffs, d)
+			return nil
+		})
+		panicOn(err)
+		return diffs, st
+	}
+
+	a := []*Frame{
+		mk(at(0), EvOneFloat64, 1, ""),
+		mk(at(0), EvJson, 0, `{"sym":"A","px":1,"seq":1}`),
+		mk(at(1000), EvJson, 0, `{"sym":"B","px":2,"seq":2}`),
+		mk(at(2000), EvOneFloat64, 3, ""),
+		msgp(at(3000), map[string]interface{}{"id": 7, "tags": []interface{}{"x", "y"}}),
+		mk(at(4000), EvOneFloat64, 4, ""),
+	}
+	b := []*Frame{
+		mk(at(0), EvJson, 0, `{"px":1,"sym":"A","seq":1}`), // reordered keys
+		mk(at(0), EvOneFloat64, 1, ""),
+		mk(at(1000), EvJson, 0, `{"sym":"B","px":2.5,"seq":9,"new":true}`),
+		mk(at(2001), EvOneFloat64, 3, ""), // shifted by 1ms
+		msgp(at(3000), map[string]interface{}{"id": 7, "tags": []interface{}{"x", "z"}}),
+		mk(at(5000), EvOneFloat64, 5, ""),
+	}
+
+	cv.Convey("DiffStreams should align identical streams, in any order within a timestamp", t, func() {
+		diffs, st := diff(a, a, DiffConfig{})
+		cv.So(len(diffs), cv.ShouldEqual, 0)
+		cv.So(st.Same, cv.ShouldEqual, len(a))
+		cv.So(st.Differ(), cv.ShouldBeFalse)
+	})
+
+	cv.Convey("DiffStreams should report frames only in A or B, and changed payload fields, field by field", t, func() {
+		diffs, st := diff(a, b, DiffConfig{})
+		cv.So(st.FramesA, cv.ShouldEqual, 6)
+		cv.So(st.FramesB, cv.ShouldEqual, 6)
+		cv.So(st.Same, cv.ShouldEqual, 2)
+		cv.So(st.Changed, cv.ShouldEqual, 2)
+		cv.So(st.OnlyA, cv.ShouldEqual, 2)
+		cv.So(st.OnlyB, cv.ShouldEqual, 2)
+
+		cv.So(diffs[0].Kind, cv.ShouldEqual, DiffChanged)
+		f := diffs[0].Fields
+		cv.So(len(f), cv.ShouldEqual, 3)
+		cv.So(f[0].Path, cv.ShouldEqual, "new")
+		cv.So(f[0].InA, cv.ShouldBeFalse)
+		cv.So(f[1].Path, cv.ShouldEqual, "px")
+		cv.So(f[1].B, cv.ShouldEqual, 2.5)
+		cv.So(f[2].Path, cv.ShouldEqual, "seq")
+
+		// without -tol, the shifted frame is in each only.
+		cv.So(diffs[1].Kind, cv.ShouldEqual, DiffOnlyA)
+		cv.So(diffs[2].Kind, cv.ShouldEqual, DiffOnlyB)
+		cv.So(diffs[3].Kind, cv.ShouldEqual, DiffChanged)
+		cv.So(diffs[3].Fields, cv.ShouldResemble, []FieldDiff{{Path: "tags[1]", A: "y", B: "z", InA: true, InB: true}})
+		cv.So(diffs[4].Kind, cv.ShouldEqual, DiffOnlyA)
+		cv.So(diffs[4].A.V0, cv.ShouldEqual, 4)
+		cv.So(diffs[5].Kind, cv.ShouldEqual, DiffOnlyB)
+	})
+
+	cv.Convey("DiffStreams should match frames within the tolerance, and skip ignored fields", t, func() {
+		diffs, st := diff(a, b, DiffConfig{Tolerance: time.Millisecond, Ignore: []string{"seq", "new", "tags"}})
+		cv.So(st.Same, cv.ShouldEqual, 4)
+		cv.So(st.Shifted, cv.ShouldEqual, 1)
+		cv.So(st.Changed, cv.ShouldEqual, 1)
+		cv.So(len(diffs[0].Fields), cv.ShouldEqual, 1)
+		cv.So(diffs[0].Fields[0].Path, cv.ShouldEqual, "px")
+		cv.So(diffs[0].String(), cv.ShouldContainSubstring, "px: 2 -> 2.5")
+	})
+
+	cv.Convey("DiffStreams should compare V0 when evtnums match, and fail on unsorted input", t, func() {
+		diffs, _ := diff(a[:1], []*Frame{mk(at(0), EvOneFloat64, 2, "")}, DiffConfig{})
+		cv.So(len(diffs), cv.ShouldEqual, 1)
+		cv.So(diffs[0].Fields[0].Path, cv.ShouldEqual, "v0")
+
+		_, err := DiffStreams(rdr([]*Frame{a[3], a[0]}), rdr(a), DiffConfig{}, func(d *Diff) error { return nil })
+		cv.So(err, cv.ShouldNotBeNil)
+	})
+}
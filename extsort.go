@@ -0,0 +1,226 @@
+package tm
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// ExternalSortConfig configures ExternalSort.
+type ExternalSortConfig struct {
+	// MemBudget bounds the bytes of frames held in memory at
+	// once, across all the chunk sorts in flight. Defaults to 1GB.
+	MemBudget int64
+
+	// Parallel is the number of chunks sorted at once.
+	// Defaults to runtime.NumCPU().
+	Parallel int
+
+	// TmpDir holds the sorted runs. Defaults to os.TempDir().
+	TmpDir string
+
+	// KeepTmpFiles leaves the sorted runs in TmpDir.
+	KeepTmpFiles bool
+
+	// MaxFrameBytes is the largest frame accepted. Defaults to 1MB.
+	MaxFrameBytes int64
+}
+
+// ExternalSortStats reports what ExternalSort did.
+type ExternalSortStats struct {
+	Frames   int64
+	Bytes    int64
+	Runs     int      // count of sorted runs written to disk
+	RunPaths []string // set if KeepTmpFiles
+	InMemory bool     // true if the input fit the budget and no runs were needed
+}
+
+// frameOverhead approximates the memory used by a
+// Frame beyond its serialized size, for budgeting.
+const frameOverhead = 64
+
+// ExternalSort does a stable sort of the frames read from r into
+// timestamp order, writing them to w. Input that fits within
+// cfg.MemBudget is sorted in memory. Larger input is cut into
+// chunks of cfg.MemBudget/cfg.Parallel that are sorted in parallel
+// and written to temporary run files, and then the runs are merged. Ties keep their input order
+// throughout, just as sort.Stable(TimeSorter(frames)) would.
+func ExternalSort(r io.Reader, w io.Writer, cfg ExternalSortConfig) (*ExternalSortStats, error) {
+	if cfg.MemBudget <= 0 {
+		cfg.MemBudget = 1 << 30
+	}
+	if cfg.Parallel <= 0 {
+		cfg.Parallel = runtime.NumCPU()
+	}
+	if cfg.MaxFrameBytes <= 0 {
+		cfg.MaxFrameBytes = 1024 * 1024
+	}
+	chunkBudget := cfg.MemBudget / int64(cfg.Parallel)
+	if chunkBudget < 64*1024 {
+		chunkBudget = 64 * 1024
+	}
+
+	stats := &ExternalSortStats{}
+	fr := NewFrameReader(r, cfg.MaxFrameBytes)
+
+	// at most cfg.Parallel chunks, including the
+	// one being read, are in memory at any time.
+	tokens := make(chan struct{}, cfg.Parallel)
+
+	var wg sync.WaitGroup
+	var mut sync.Mutex
+	var runErr error
+	paths := []string{}
+
+	cleanup := func() {
+		if !cfg.KeepTmpFiles {
+			for _, p := range paths {
+				if p != "" {
+					os.Remove(p)
+				}
+			}
+		}
+	}
+
+	// startRun sorts and writes out chunk as the next run, in the
+	// background. The caller holds a token for it. The runs already
+	// started write into paths, so it grows under mut.
+	startRun := func(chunk []*Frame) {
+		mut.Lock()
+		run := len(paths)
+		paths = append(paths, "")
+		mut.Unlock()
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-tokens
+				wg.Done()
+			}()
+			sort.Stable(TimeSorter(chunk))
+			path, err := writeRun(cfg.TmpDir, chunk)
+			mut.Lock()
+			paths[run] = path
+			if err != nil && runErr == nil {
+				runErr = err
+			}
+			mut.Unlock()
+		}()
+	}
+
+	// read up to the whole budget first: if the input
+	// ends within it, no runs are needed.
+	first, nbytes, err := readChunk(fr, cfg.MemBudget)
+	if err != nil && err != io.EOF {
+		return stats, fmt.Errorf("ExternalSort error reading frame %v: '%v'", len(first), err)
+	}
+	stats.Frames += int64(len(first))
+	stats.Bytes += nbytes
+	if err == io.EOF {
+		stats.InMemory = true
+		sort.Stable(TimeSorter(first))
+		return stats, writeFrames(w, first)
+	}
+
+	// otherwise cut what we have into runs of chunkBudget,
+	// and then carry on a chunk at a time.
+	for len(first) > 0 {
+		var used int64
+		i := 0
+		for i < len(first) && used < chunkBudget {
+			used += first[i].NumBytes() + frameOverhead
+			i++
+		}
+		tokens <- struct{}{}
+		startRun(first[:i])
+		first = first[i:]
+	}
+	for eof := false; !eof; {
+		tokens <- struct{}{}
+		chunk, nbytes, err := readChunk(fr, chunkBudget)
+		if err != nil && err != io.EOF {
+			wg.Wait()
+			cleanup()
+			return stats, fmt.Errorf("ExternalSort error reading frame %v: '%v'", stats.Frames+int64(len(chunk)), err)
+		}
+		eof = (err == io.EOF)
+		stats.Frames += int64(len(chunk))
+		stats.Bytes += nbytes
+		if len(chunk) == 0 {
+			<-tokens
+			break
+		}
+		startRun(chunk)
+	}
+	wg.Wait()
+	stats.Runs = len(paths)
+	if runErr != nil {
+		cleanup()
+		return stats, runErr
+	}
+	if cfg.KeepTmpFiles {
+		stats.RunPaths = paths
+	}
+	err = MergeFiles(w, cfg.MaxFrameBytes, paths...)
+	cleanup()
+	return stats, err
+}
+
+// readChunk reads frames until their estimated memory
+// reaches budget, or the input ends. It returns io.EOF
+// along with the last, possibly empty, chunk.
+func readChunk(fr *FrameReader, budget int64) ([]*Frame, int64, error) {
+	chunk := []*Frame{}
+	var used, nbytes int64
+	for used < budget {
+		frame, n, err, _ := fr.NextFrame(nil)
+		if err != nil {
+			return chunk, nbytes, err
+		}
+		chunk = append(chunk, frame)
+		nbytes += n
+		used += n + frameOverhead
+	}
+	return chunk, nbytes, nil
+}
+
+// writeRun writes frames to a new temp file in dir, returning its path.
+func writeRun(dir string, frames []*Frame) (string, error) {
+	f, err := ioutil.TempFile(dir, "tfsort-run-")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	err = writeFrames(f, frames)
+	if err != nil {
+		return f.Name(), err
+	}
+	return f.Name(), nil
+}
+
+func writeFrames(w io.Writer, frames []*Frame) error {
+	fw := NewFrameWriter(w, 1024*1024)
+	fw.Frames = frames
+	_, err := fw.WriteTo(w)
+	return err
+}
+
+// MergeFiles merges the time-sorted frame files at paths into
+// timestamp order on w. Ties go to the earlier path in the list,
+// so merging the runs of a stable sort keeps it stable.
+func MergeFiles(w io.Writer, maxFrameBytes int64, paths ...string) error {
+	strms := make([]*BufferedFrameReader, len(paths))
+	for i, p := range paths {
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		strms[i] = NewBufferedFrameReader(f, maxFrameBytes, p)
+	}
+	fw := NewFrameWriter(w, maxFrameBytes)
+	return fw.Merge(strms...)
+}
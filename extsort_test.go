@@ -0,0 +1,91 @@
+package tm
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sort"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test120ExternalSortIsStable(t *testing.T) {
+
+	// many ties: only 100 distinct timestamps. Ude records
+	// the input position so we can check stability.
+	n := 10000
+	rnd := rand.New(rand.NewSource(42))
+	frames := make([]*Frame, n)
+	for i := range frames {
+		frames[i] = GenTestFrame(rnd.Intn(100), EvTwo64, float64(i), int64(i), nil)
+	}
+	var in bytes.Buffer
+	panicOn(writeFrames(&in, frames))
+	inBytes := in.Bytes()
+
+	expected := make([]*Frame, n)
+	copy(expected, frames)
+	sort.Stable(TimeSorter(expected))
+
+	readBack := func(r io.Reader) []*Frame {
+		res := []*Frame{}
+		fr := NewFrameReader(r, 1024)
+		for {
+			f, _, err, _ := fr.NextFrame(nil)
+			if err != nil {
+				return res
+			}
+			res = append(res, f)
+		}
+	}
+
+	cv.Convey("ExternalSort within budget should sort in memory, matching sort.Stable", t, func() {
+		var out bytes.Buffer
+		stats, err := ExternalSort(bytes.NewBuffer(inBytes), &out, ExternalSortConfig{})
+		panicOn(err)
+		cv.So(stats.InMemory, cv.ShouldBeTrue)
+		cv.So(stats.Runs, cv.ShouldEqual, 0)
+		cv.So(stats.Frames, cv.ShouldEqual, n)
+		got := readBack(&out)
+		cv.So(len(got), cv.ShouldEqual, n)
+		for i := range got {
+			cv.So(got[i].Ude, cv.ShouldEqual, expected[i].Ude)
+		}
+
+		// the whole budget counts, not just one of the Parallel chunks.
+		stats, err = ExternalSort(bytes.NewBuffer(inBytes), &out, ExternalSortConfig{MemBudget: 1 << 20, Parallel: 16})
+		panicOn(err)
+		cv.So(stats.InMemory, cv.ShouldBeTrue)
+	})
+
+	cv.Convey("ExternalSort past budget should sort runs in parallel and merge them, still matching sort.Stable", t, func() {
+		tmp, err := ioutil.TempDir("", "test-extsort")
+		panicOn(err)
+		defer os.RemoveAll(tmp)
+
+		var out bytes.Buffer
+		cfg := ExternalSortConfig{MemBudget: 256 * 1024, Parallel: 4, TmpDir: tmp}
+		stats, err := ExternalSort(bytes.NewBuffer(inBytes), &out, cfg)
+		panicOn(err)
+		cv.So(stats.InMemory, cv.ShouldBeFalse)
+		cv.So(stats.Runs, cv.ShouldBeGreaterThan, 4)
+		got := readBack(&out)
+		cv.So(len(got), cv.ShouldEqual, n)
+		same := true
+		for i := range got {
+			if got[i].Ude != expected[i].Ude || got[i].Tm() != expected[i].Tm() {
+				same = false
+				break
+			}
+		}
+		cv.So(same, cv.ShouldBeTrue)
+
+		// runs are cleaned up
+		left, err := ioutil.ReadDir(tmp)
+		panicOn(err)
+		cv.So(len(left), cv.ShouldEqual, 0)
+	})
+}
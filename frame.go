@@ -33,6 +33,29 @@ const (
 	PtiUDE        PTI = 7
 )
 
+// String returns the name of the PTI, such as "PtiTwo64".
+func (p PTI) String() string {
+	switch p {
+	case PtiZero:
+		return "PtiZero"
+	case PtiOneInt64:
+		return "PtiOneInt64"
+	case PtiOneFloat64:
+		return "PtiOneFloat64"
+	case PtiTwo64:
+		return "PtiTwo64"
+	case PtiNull:
+		return "PtiNull"
+	case PtiNA:
+		return "PtiNA"
+	case PtiNaN:
+		return "PtiNaN"
+	case PtiUDE:
+		return "PtiUDE"
+	}
+	return fmt.Sprintf("Pti.%d", byte(p))
+}
+
 // The Evtnum is the message type when pti = PtiUDE and
 // UDE descriptors are in use for describing TMFRAME
 // message longer than just the one Primary word.
@@ -63,6 +86,8 @@ const (
 	EvJson      Evtnum = 14
 	EvMsgpKafka Evtnum = 15
 	EvZebraPack Evtnum = 16
+	EvSourced   Evtnum = 17
+	EvAdjusted  Evtnum = 18
 )
 
 // Frame holds a fully parsed TMFRAME message.
@@ -481,6 +506,10 @@ func (e Evtnum) String() string {
 		return "EvJson"
 	case EvMsgpKafka:
 		return "EvMsgpKafka"
+	case EvSourced:
+		return "EvSourced"
+	case EvAdjusted:
+		return "EvAdjusted"
 	}
 	return fmt.Sprintf("Ev.%d", e)
 }
@@ -0,0 +1,387 @@
+package tm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// GapFill selects whether and how a GapDetector
+// inserts PtiNA frames into the gaps it finds.
+type GapFill int
+
+const (
+	// GapFillNone only reports gaps, adding no frames.
+	GapFillNone GapFill = 0
+
+	// GapFillSlots writes an NA frame at every expected
+	// slot (one cadence apart) that is missing in the gap.
+	GapFillSlots GapFill = 1
+
+	// GapFillBoundary writes a single NA frame at the start
+	// of each gap, one cadence after the last frame seen. Under
+	// the in-force semantics of Series.LastInForceBefore(), the
+	// NA is then in force for the remainder of the gap.
+	GapFillBoundary GapFill = 2
+)
+
+// String stringifies the GapFill, for printing.
+func (g GapFill) String() string {
+	switch g {
+	case GapFillNone:
+		return "none"
+	case GapFillSlots:
+		return "slots"
+	case GapFillBoundary:
+		return "boundary"
+	}
+	return fmt.Sprintf("GapFill.%d", int(g))
+}
+
+// ParseGapFill converts "none", "slots", or "boundary" into a GapFill.
+func ParseGapFill(s string) (GapFill, error) {
+	switch strings.ToLower(s) {
+	case "", "none":
+		return GapFillNone, nil
+	case "slots", "slot":
+		return GapFillSlots, nil
+	case "boundary", "boundaries":
+		return GapFillBoundary, nil
+	}
+	return GapFillNone, fmt.Errorf("unknown gap fill mode '%s': use none, slots, or boundary", s)
+}
+
+// Gap describes a stretch of time with no frames. Before is the
+// timestamp of the last frame preceding the gap, and After is
+// the timestamp of the first frame following it. Missing is the
+// number of expected cadence slots that had no frame.
+type Gap struct {
+	Before  int64
+	After   int64
+	Missing int64
+}
+
+// Dur returns the length of the gap.
+func (g Gap) Dur() time.Duration {
+	return time.Duration(g.After - g.Before)
+}
+
+// String displays the gap, for printing.
+func (g Gap) String() string {
+	return fmt.Sprintf("gap of %v from %v to %v (%d missing)",
+		g.Dur(),
+		time.Unix(0, g.Before).UTC().Format(time.RFC3339Nano),
+		time.Unix(0, g.After).UTC().Format(time.RFC3339Nano),
+		g.Missing)
+}
+
+// GapConfig configures a GapDetector.
+type GapConfig struct {
+	// Cadence is the expected spacing between frames. If zero,
+	// the cadence is learned as the median spacing of the
+	// first LearnCount frames.
+	Cadence time.Duration
+
+	// Threshold is the spacing beyond which a gap is reported.
+	// If zero, it defaults to 1.5 times the Cadence.
+	Threshold time.Duration
+
+	// LearnCount is how many frames to look at when learning
+	// the cadence. Defaults to 1000.
+	LearnCount int
+
+	// Fill selects the NA frames, if any, to insert.
+	Fill GapFill
+
+	// MaxFillPerGap bounds the number of NA frames inserted by
+	// GapFillSlots into any single gap, so one long outage does
+	// not explode the output. Defaults to 1,000,000.
+	MaxFillPerGap int64
+}
+
+// GapSummary reports what a GapDetector found.
+type GapSummary struct {
+	Frames       int64
+	First        int64
+	Last         int64
+	Cadence      time.Duration
+	Learned      bool // true if Cadence was learned from the data
+	Threshold    time.Duration
+	Gaps         []Gap
+	TotalMissing int64
+	TotalGapDur  time.Duration
+	Longest      Gap
+	Filled       int64 // count of NA frames inserted
+}
+
+// String renders the summary as a human readable report.
+func (s *GapSummary) String() string {
+	var b bytes.Buffer
+	how := "given"
+	if s.Learned {
+		how = "learned"
+	}
+	fmt.Fprintf(&b, "frames: %d\n", s.Frames)
+	if s.Frames > 0 {
+		fmt.Fprintf(&b, "first: %s\n", time.Unix(0, s.First).UTC().Format(time.RFC3339Nano))
+		fmt.Fprintf(&b, "last: %s\n", time.Unix(0, s.Last).UTC().Format(time.RFC3339Nano))
+	}
+	fmt.Fprintf(&b, "cadence: %v (%s)\n", s.Cadence, how)
+	fmt.Fprintf(&b, "threshold: %v\n", s.Threshold)
+	fmt.Fprintf(&b, "gaps: %d\n", len(s.Gaps))
+	fmt.Fprintf(&b, "total-missing: %d\n", s.TotalMissing)
+	fmt.Fprintf(&b, "total-gap-duration: %v\n", s.TotalGapDur)
+	if len(s.Gaps) > 0 {
+		fmt.Fprintf(&b, "longest: %s\n", s.Longest)
+	}
+	if s.Filled > 0 {
+		fmt.Fprintf(&b, "na-frames-inserted: %d\n", s.Filled)
+	}
+	for i, g := range s.Gaps {
+		fmt.Fprintf(&b, "%06d %s\n", i, g)
+	}
+	return b.String()
+}
+
+// GapDetector finds gaps in a time ordered stream of frames,
+// and optionally generates the PtiNA frames that make the
+// missingness explicit. Call Process() on each frame in turn;
+// when the cadence is being learned, output is held back
+// until LearnCount frames have been seen or Finish() is called.
+type GapDetector struct {
+	Cfg     GapConfig
+	Summary GapSummary
+
+	learning []*Frame
+	known    bool // cadence is known
+	prevTm   int64
+	started  bool
+}
+
+// NewGapDetector makes a GapDetector for cfg, filling in defaults.
+func NewGapDetector(cfg GapConfig) *GapDetector {
+	if cfg.LearnCount <= 0 {
+		cfg.LearnCount = 1000
+	}
+	if cfg.MaxFillPerGap <= 0 {
+		cfg.MaxFillPerGap = 1000000
+	}
+	d := &GapDetector{Cfg: cfg}
+	d.setCadence(cfg.Cadence)
+	return d
+}
+
+func (d *GapDetector) setCadence(c time.Duration) {
+	if c <= 0 {
+		return
+	}
+	d.known = true
+	d.Summary.Cadence = c
+	d.Summary.Threshold = d.Cfg.Threshold
+	if d.Summary.Threshold <= 0 {
+		d.Summary.Threshold = c + c/2
+	}
+}
+
+// Process takes the next frame of the stream and returns the
+// frames to pass downstream: any NA fill frames, followed by f
+// itself. While learning the cadence, it returns nothing and
+// holds f until the cadence is known.
+func (d *GapDetector) Process(f *Frame) ([]*Frame, error) {
+	if !d.known {
+		d.learning = append(d.learning, f)
+		if len(d.learning) < d.Cfg.LearnCount {
+			return nil, nil
+		}
+		return d.learn()
+	}
+	return d.step(f, nil)
+}
+
+// Finish flushes any frames held while learning the cadence.
+// Call it once at the end of the stream.
+func (d *GapDetector) Finish() ([]*Frame, error) {
+	if !d.known && len(d.learning) > 0 {
+		return d.learn()
+	}
+	return nil, nil
+}
+
+func (d *GapDetector) learn() ([]*Frame, error) {
+	c := LearnCadence(d.learning)
+	if c <= 0 {
+		// no positive spacing to learn from: pass
+		// the frames through, reporting no gaps.
+		d.known = true
+		d.Summary.Threshold = time.Duration(1<<63 - 1)
+	} else {
+		d.setCadence(c)
+		d.Summary.Learned = true
+	}
+	held := d.learning
+	d.learning = nil
+	var out []*Frame
+	var err error
+	for _, f := range held {
+		out, err = d.step(f, out)
+		if err != nil {
+			return out, err
+		}
+	}
+	return out, nil
+}
+
+func (d *GapDetector) step(f *Frame, out []*Frame) ([]*Frame, error) {
+	tm := f.Tm()
+	s := &d.Summary
+	if !d.started {
+		d.started = true
+		s.First = tm
+	} else {
+		if tm < d.prevTm {
+			return out, fmt.Errorf("GapDetector: frame at %v is before the previous frame; input must be in time order", f.TmTime())
+		}
+		delta := tm - d.prevTm
+		if delta > int64(s.Threshold) {
+			c := int64(s.Cadence)
+			// round to the nearest slot
+			missing := (delta+c/2)/c - 1
+			if missing < 1 {
+				missing = 1
+			}
+			g := Gap{Before: d.prevTm, After: tm, Missing: missing}
+			s.Gaps = append(s.Gaps, g)
+			s.TotalMissing += missing
+			s.TotalGapDur += g.Dur()
+			if g.Dur() > s.Longest.Dur() {
+				s.Longest = g
+			}
+			out = d.fill(g, out)
+		}
+	}
+	s.Frames++
+	s.Last = tm
+	d.prevTm = tm
+	return append(out, f), nil
+}
+
+func (d *GapDetector) fill(g Gap, out []*Frame) []*Frame {
+	c := int64(d.Summary.Cadence)
+	switch d.Cfg.Fill {
+	case GapFillBoundary:
+		out = append(out, &Frame{Prim: IntToPrimTm(g.Before+c) | int64(PtiNA)})
+		d.Summary.Filled++
+	case GapFillSlots:
+		var k int64
+		for k = 1; k <= g.Missing && k <= d.Cfg.MaxFillPerGap; k++ {
+			tm := IntToPrimTm(g.Before + k*c)
+			if tm >= g.After {
+				break
+			}
+			out = append(out, &Frame{Prim: tm | int64(PtiNA)})
+			d.Summary.Filled++
+		}
+	}
+	return out
+}
+
+// LearnCadence estimates the regular spacing of frames as
+// the median of the positive differences between successive
+// timestamps. It returns 0 if there are no such differences.
+func LearnCadence(frames []*Frame) time.Duration {
+	deltas := make([]int64, 0, len(frames))
+	for i := 1; i < len(frames); i++ {
+		d := frames[i].Tm() - frames[i-1].Tm()
+		if d > 0 {
+			deltas = append(deltas, d)
+		}
+	}
+	if len(deltas) == 0 {
+		return 0
+	}
+	sort.Sort(int64Slice(deltas))
+	return time.Duration(deltas[len(deltas)/2])
+}
+
+type int64Slice []int64
+
+func (p int64Slice) Len() int           { return len(p) }
+func (p int64Slice) Less(i, j int) bool { return p[i] < p[j] }
+func (p int64Slice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// FillGaps runs a GapDetector over frames, which must be in time
+// order, and returns the frames with any NA fill inserted, along
+// with the summary of gaps found.
+func FillGaps(frames []*Frame, cfg GapConfig) ([]*Frame, *GapSummary, error) {
+	d := NewGapDetector(cfg)
+	res := make([]*Frame, 0, len(frames))
+	for _, f := range frames {
+		out, err := d.Process(f)
+		res = append(res, out...)
+		if err != nil {
+			return res, &d.Summary, err
+		}
+	}
+	out, err := d.Finish()
+	res = append(res, out...)
+	return res, &d.Summary, err
+}
+
+// FindGaps reads frames from r and reports the gaps found. If w
+// is not nil, the frames are also written to w, along with
+// any NA fill frames requested by cfg.Fill.
+func FindGaps(r io.Reader, w io.Writer, cfg GapConfig) (*GapSummary, error) {
+	fr := NewFrameReader(r, 1024*1024)
+	var fw *FrameWriter
+	if w != nil {
+		fw = NewFrameWriter(w, 1024*1024)
+	}
+	d := NewGapDetector(cfg)
+
+	emit := func(out []*Frame) error {
+		if fw == nil {
+			return nil
+		}
+		for _, f := range out {
+			fw.Append(f)
+		}
+		if len(fw.Frames) >= 1000 {
+			return fw.Flush()
+		}
+		return nil
+	}
+
+	var err error
+	for i := 0; err == nil; i++ {
+		frame := &Frame{}
+		_, _, err, _ = fr.NextFrame(frame)
+		if err != nil {
+			if err != io.EOF {
+				return &d.Summary, fmt.Errorf("FindGaps error from fr.NextFrame() at i=%v: '%v'", i, err)
+			}
+			break
+		}
+		out, err := d.Process(frame)
+		if err != nil {
+			return &d.Summary, err
+		}
+		if err = emit(out); err != nil {
+			return &d.Summary, err
+		}
+	}
+	out, err := d.Finish()
+	if err != nil {
+		return &d.Summary, err
+	}
+	if err = emit(out); err != nil {
+		return &d.Summary, err
+	}
+	if fw != nil {
+		err = fw.Flush()
+		fw.Sync()
+	}
+	return &d.Summary, err
+}
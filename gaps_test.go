@@ -0,0 +1,69 @@
+package tm
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test080GapDetectionAndNAFill(t *testing.T) {
+
+	// one frame a second, with seconds 3,4,5 and 8 missing.
+	tm := GenTestT0
+	seq := []float64{1, 1, 1, 0, 0, 0, 1, 1, 0, 1}
+	typ := make([]int64, len(seq))
+	sec := []int64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}
+
+	cv.Convey("Given a 1 second cadence, FillGaps should report the gaps and, under GapFillSlots, put an NA frame in each missing slot", t, func() {
+		frames := MakeTwo64Frames(tm, seq, typ, sec)
+		filled, sum, err := FillGaps(frames, GapConfig{Cadence: time.Second, Fill: GapFillSlots})
+		panicOn(err)
+		cv.So(sum.Frames, cv.ShouldEqual, 6)
+		cv.So(len(sum.Gaps), cv.ShouldEqual, 2)
+		cv.So(sum.TotalMissing, cv.ShouldEqual, 4)
+		cv.So(sum.Longest.Dur(), cv.ShouldEqual, 4*time.Second)
+		cv.So(sum.Filled, cv.ShouldEqual, 4)
+		cv.So(len(filled), cv.ShouldEqual, 10)
+		for i, f := range filled {
+			cv.So(f.TmTime(), cv.ShouldResemble, tm.Add(time.Duration(i)*time.Second))
+			if seq[i] == 0 {
+				cv.So(f.GetPTI(), cv.ShouldEqual, PtiNA)
+			} else {
+				cv.So(f.GetPTI(), cv.ShouldEqual, PtiTwo64)
+			}
+		}
+	})
+
+	cv.Convey("When the cadence is learned and GapFillBoundary is used, a Series lookup inside the gap should see NA in force", t, func() {
+		frames := MakeTwo64Frames(tm, seq, typ, sec)
+		filled, sum, err := FillGaps(frames, GapConfig{Fill: GapFillBoundary})
+		panicOn(err)
+		cv.So(sum.Learned, cv.ShouldBeTrue)
+		cv.So(sum.Cadence, cv.ShouldEqual, time.Second)
+		cv.So(sum.Filled, cv.ShouldEqual, 2)
+		cv.So(len(filled), cv.ShouldEqual, 8)
+
+		ser := NewSeriesFromFrames(filled)
+		at, status, _ := ser.LastInForceBefore(tm.Add(5 * time.Second))
+		cv.So(status, cv.ShouldEqual, Avail)
+		cv.So(at.GetPTI(), cv.ShouldEqual, PtiNA)
+		at, _, _ = ser.LastInForceBefore(tm.Add(7 * time.Second))
+		cv.So(at.GetPTI(), cv.ShouldEqual, PtiTwo64)
+	})
+
+	cv.Convey("FindGaps should stream the filled frames to the writer", t, func() {
+		frames := MakeTwo64Frames(tm, seq, typ, sec)
+		var in, out bytes.Buffer
+		fw := NewFrameWriter(&in, 1024)
+		fw.Frames = frames
+		fw.Flush()
+
+		sum, err := FindGaps(&in, &out, GapConfig{Cadence: time.Second, Fill: GapFillSlots})
+		panicOn(err)
+		cv.So(len(sum.Gaps), cv.ShouldEqual, 2)
+		// 6 Two64 frames of 24 bytes, 4 NA frames of 8 bytes.
+		cv.So(out.Len(), cv.ShouldEqual, 6*24+4*8)
+	})
+}
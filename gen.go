@@ -0,0 +1,495 @@
+package tm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/glycerine/tmframe/testdata"
+	"github.com/ugorji/go/codec"
+)
+
+// GenConfig configures a Generator of synthetic frames. The same
+// GenConfig, Seed included, always generates the same frames.
+type GenConfig struct {
+	Seed int64
+
+	// Start is the time of the first frame; default
+	// 2016-02-16T00:00:00Z, as the GenTest helpers use.
+	Start time.Time
+
+	// Count stops the Generator after this many frames from the
+	// value model, not counting injected duplicates; End stops it
+	// at this time. Zero means no limit; with neither, the
+	// Generator never stops.
+	Count int64
+	End   time.Time
+
+	// Rate is in frames per second; default 1. Jitter, from 0 up
+	// to 1, delays each frame by a random fraction of 1/Rate, of
+	// up to Jitter. Jitter alone never puts frames out of order.
+	Rate   float64
+	Jitter float64
+
+	// Evtnums are picked from at random, one per frame;
+	// default EvOneFloat64. See ParseGenEvtnums.
+	Evtnums []Evtnum
+
+	// Model is "walk" (default), a random walk from Base taking
+	// normal steps of standard deviation Step, or "seasonal",
+	// Base + Amplitude*sin(2*pi*t/Period) plus normal noise
+	// of standard deviation Step. Step defaults to 1, and
+	// Period to a day.
+	Model     string
+	Base      float64
+	Step      float64
+	Amplitude float64
+	Period    time.Duration
+
+	// Payload sizes the payloads of the evtnums that carry
+	// one; default fixed 64 bytes.
+	Payload SizeDist
+
+	// DupProb is the chance a frame is followed by an identical
+	// copy of itself. GapProb is the chance that GapLen (default
+	// a minute) passes, without frames, before a frame. OOOProb
+	// is the chance a frame is held back, to come out after 1 to
+	// OOOMax (default 5) later frames.
+	DupProb float64
+	GapProb float64
+	GapLen  time.Duration
+	OOOProb float64
+	OOOMax  int
+}
+
+// GenStats counts what a Generator has made.
+type GenStats struct {
+	Frames     int64 // all frames returned, duplicates included
+	Dups       int64
+	Gaps       int64
+	OutOfOrder int64
+}
+
+// Generator makes a stream of synthetic frames, for tests,
+// benchmarks, and load testing, as configured by a GenConfig.
+//
+// Frames with evtnums that carry a payload get one that
+// the rest of the tools can read: a JSON object for EvJson,
+// EvHeader, and the JSON range 2000-9999; the same fields in
+// msgpack or binc for EvMsgpack, EvMsgpKafka, and EvBinc; text
+// for EvUtf8; a testdata.LogEntry for EvZebraPack; and envelopes
+// of an EvOneFloat64 frame for EvSourced and EvAdjusted; and
+// an error message, in UTF-8, for EvErr. Other evtnums, such as
+// EvUDE, EvCapnp, and user-defined negative evtnums, get random
+// bytes.
+type Generator struct {
+	Stats GenStats
+
+	cfg   GenConfig
+	rng   *rand.Rand
+	tick  int64 // time of the next frame, before jitter
+	step  int64 // nanoseconds between frames
+	made  int64 // frames from the value model
+	value float64
+	out   []*Frame
+	held  []genHeld
+	done  bool
+	binc  codec.BincHandle
+}
+
+type genHeld struct {
+	f     *Frame
+	after int // frames still to go out before f
+}
+
+// NewGenerator checks cfg, fills in its defaults, and
+// returns a Generator for it.
+func NewGenerator(cfg GenConfig) (*Generator, error) {
+	if cfg.Start.IsZero() {
+		cfg.Start = time.Date(2016, 2, 16, 0, 0, 0, 0, time.UTC)
+	}
+	if cfg.Rate == 0 {
+		cfg.Rate = 1
+	}
+	if cfg.Rate < 0 || math.IsInf(cfg.Rate, 0) || math.IsNaN(cfg.Rate) {
+		return nil, fmt.Errorf("NewGenerator: bad rate %v: must be positive", cfg.Rate)
+	}
+	step := int64(float64(time.Second) / cfg.Rate)
+	if step < 8 {
+		return nil, fmt.Errorf("NewGenerator: rate %v is too high: frame times have 8 nanosecond resolution", cfg.Rate)
+	}
+	if cfg.Jitter < 0 || cfg.Jitter > 1 {
+		return nil, fmt.Errorf("NewGenerator: bad jitter %v: must be from 0 to 1", cfg.Jitter)
+	}
+	if len(cfg.Evtnums) == 0 {
+		cfg.Evtnums = []Evtnum{EvOneFloat64}
+	}
+	for _, e := range cfg.Evtnums {
+		if !ValidEvtnum(e) {
+			return nil, fmt.Errorf("NewGenerator: bad evtnum %v", e)
+		}
+	}
+	switch cfg.Model {
+	case "":
+		cfg.Model = "walk"
+	case "walk", "seasonal":
+	default:
+		return nil, fmt.Errorf("NewGenerator: unknown model '%s': use walk or seasonal", cfg.Model)
+	}
+	if cfg.Step == 0 {
+		cfg.Step = 1
+	}
+	if cfg.Period <= 0 {
+		cfg.Period = 24 * time.Hour
+	}
+	if cfg.Payload.Kind == "" {
+		cfg.Payload = SizeDist{Kind: "fixed", A: 64}
+	}
+	for _, p := range []float64{cfg.DupProb, cfg.GapProb, cfg.OOOProb} {
+		if p < 0 || p > 1 {
+			return nil, fmt.Errorf("NewGenerator: bad probability %v: must be from 0 to 1", p)
+		}
+	}
+	if cfg.GapLen <= 0 {
+		cfg.GapLen = time.Minute
+	}
+	if cfg.OOOMax <= 0 {
+		cfg.OOOMax = 5
+	}
+	return &Generator{
+		cfg:   cfg,
+		rng:   rand.New(rand.NewSource(cfg.Seed)),
+		tick:  cfg.Start.UnixNano(),
+		step:  step,
+		value: cfg.Base,
+	}, nil
+}
+
+// Next returns the next frame, or io.EOF after the last.
+func (g *Generator) Next() (*Frame, error) {
+	for len(g.out) == 0 {
+		if g.done {
+			if len(g.held) == 0 {
+				return nil, io.EOF
+			}
+			// the end releases every frame still held back
+			g.out = append(g.out, g.held[0].f)
+			g.held = g.held[1:]
+			break
+		}
+		f, err := g.make()
+		if err != nil {
+			return nil, err
+		}
+		if f == nil {
+			g.done = true
+			continue
+		}
+		if g.cfg.OOOProb > 0 && g.rng.Float64() < g.cfg.OOOProb {
+			g.held = append(g.held, genHeld{f: f, after: 1 + g.rng.Intn(g.cfg.OOOMax)})
+			g.Stats.OutOfOrder++
+			continue
+		}
+		g.out = append(g.out, f)
+		if g.cfg.DupProb > 0 && g.rng.Float64() < g.cfg.DupProb {
+			cp := *f
+			g.out = append(g.out, &cp)
+			g.Stats.Dups++
+		}
+		keep := g.held[:0]
+		for _, h := range g.held {
+			h.after--
+			if h.after <= 0 {
+				g.out = append(g.out, h.f)
+			} else {
+				keep = append(keep, h)
+			}
+		}
+		g.held = keep
+	}
+	f := g.out[0]
+	g.out = g.out[1:]
+	g.Stats.Frames++
+	return f, nil
+}
+
+// WriteTo writes all the frames of g to w.
+func (g *Generator) WriteTo(w io.Writer) (n int64, err error) {
+	var buf []byte
+	for {
+		f, err := g.Next()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		by, err := f.Marshal(buf)
+		if err != nil {
+			return n, err
+		}
+		if len(by) > len(buf) {
+			buf = by
+		}
+		k, err := w.Write(by)
+		n += int64(k)
+		if err != nil {
+			return n, err
+		}
+	}
+}
+
+// make returns the next frame from the value
+// model, or nil once Count or End is reached.
+func (g *Generator) make() (*Frame, error) {
+	cfg := &g.cfg
+	if cfg.Count > 0 && g.made >= cfg.Count {
+		return nil, nil
+	}
+	if cfg.GapProb > 0 && g.made > 0 && g.rng.Float64() < cfg.GapProb {
+		g.tick += int64(cfg.GapLen)
+		g.Stats.Gaps++
+	}
+	tm := g.tick
+	if cfg.Jitter > 0 {
+		tm += int64(g.rng.Float64() * cfg.Jitter * float64(g.step))
+	}
+	if !cfg.End.IsZero() && tm >= cfg.End.UnixNano() {
+		return nil, nil
+	}
+	g.tick += g.step
+
+	var v float64
+	switch cfg.Model {
+	case "walk":
+		if g.made > 0 {
+			g.value += g.rng.NormFloat64() * cfg.Step
+		}
+		v = g.value
+	case "seasonal":
+		t := float64(tm-cfg.Start.UnixNano()) / float64(cfg.Period)
+		v = cfg.Base + cfg.Amplitude*math.Sin(2*math.Pi*t) + g.rng.NormFloat64()*cfg.Step
+	}
+	seq := g.made
+	g.made++
+
+	e := cfg.Evtnums[0]
+	if len(cfg.Evtnums) > 1 {
+		e = cfg.Evtnums[g.rng.Intn(len(cfg.Evtnums))]
+	}
+	return g.frame(time.Unix(0, tm), e, v, seq)
+}
+
+func (g *Generator) frame(tm time.Time, e Evtnum, v float64, seq int64) (*Frame, error) {
+	switch e {
+	case EvZero, EvNull, EvNA, EvNaN:
+		return NewFrame(tm, e, 0, 0, nil)
+	case EvOneInt64:
+		return NewFrame(tm, e, 0, int64(math.Floor(v+0.5)), nil)
+	case EvOneFloat64:
+		return NewFrame(tm, e, v, 0, nil)
+	case EvTwo64:
+		return NewFrame(tm, e, v, seq, nil)
+	case EvSourced, EvAdjusted:
+		inner, err := NewFrame(tm, EvOneFloat64, v, 0, nil)
+		if err != nil {
+			return nil, err
+		}
+		if e == EvSourced {
+			return WrapSourced(inner, 0)
+		}
+		return WrapAdjusted(inner, inner.Tm())
+	}
+	data, err := g.payload(e, v, seq, g.cfg.Payload.Sample(g.rng))
+	if err != nil {
+		return nil, err
+	}
+	return NewFrame(tm, e, 0, 0, data)
+}
+
+// genRecord is the content of generated payloads; Pad
+// brings them up to about the size asked for.
+type genRecord struct {
+	Seq int64   `json:"seq" codec:"seq" msg:"seq"`
+	V   float64 `json:"v" codec:"v" msg:"v"`
+	Pad string  `json:"pad" codec:"pad" msg:"pad"`
+}
+
+func (g *Generator) payload(e Evtnum, v float64, seq int64, size int) ([]byte, error) {
+	rec := genRecord{Seq: seq, V: v}
+	var encode func() ([]byte, error)
+	switch {
+	case IsJsonEvtnum(e) || e == EvHeader:
+		encode = func() ([]byte, error) { return json.Marshal(&rec) }
+	case e == EvMsgpack || e == EvMsgpKafka:
+		encode = func() (by []byte, err error) {
+			err = codec.NewEncoderBytes(&by, &msgpHelper.mh).Encode(&rec)
+			return
+		}
+	case e == EvBinc:
+		encode = func() (by []byte, err error) {
+			err = codec.NewEncoderBytes(&by, &g.binc).Encode(&rec)
+			return
+		}
+	case e == EvErr:
+		encode = func() ([]byte, error) {
+			return []byte(fmt.Sprintf("tfgen error %d: value %v %s", rec.Seq, rec.V, rec.Pad)), nil
+		}
+	case e == EvUtf8:
+		encode = func() ([]byte, error) {
+			return []byte(fmt.Sprintf("seq=%d v=%v %s", rec.Seq, rec.V, rec.Pad)), nil
+		}
+	case e == EvZebraPack:
+		encode = func() ([]byte, error) {
+			le := testdata.LogEntry{LogSequenceNum: seq, Operation: rec.Pad}
+			return le.MarshalMsg(nil)
+		}
+	default:
+		by := make([]byte, size)
+		g.rng.Read(by)
+		return by, nil
+	}
+	by, err := encode()
+	if err != nil || len(by) >= size {
+		return by, err
+	}
+	// a longer pad may take a longer length prefix,
+	// hence trimming back to size afterwards.
+	rec.Pad = genPad(g.rng, size-len(by))
+	by, err = encode()
+	for err == nil && len(by) > size && len(rec.Pad) > 0 {
+		rec.Pad = rec.Pad[:len(rec.Pad)-1]
+		by, err = encode()
+	}
+	return by, err
+}
+
+func genPad(rng *rand.Rand, n int) string {
+	const letters = "abcdefghijklmnopqrstuvwxyz"
+	by := make([]byte, n)
+	for i := range by {
+		by[i] = letters[rng.Intn(len(letters))]
+	}
+	return string(by)
+}
+
+// SizeDist is a distribution of payload sizes, in bytes.
+type SizeDist struct {
+	Kind string // fixed, uniform, normal, or exp
+	A, B float64
+}
+
+// ParseSizeDist parses a payload size distribution:
+//
+//	64, or fixed:64        always 64 bytes
+//	uniform:16-1024        uniform from 16 to 1024
+//	normal:200,50          normal, mean 200 and standard deviation 50
+//	exp:200                exponential, mean 200
+//
+// Sizes may use the units of ParseByteSize, as in uniform:1K-64K.
+func ParseSizeDist(s string) (SizeDist, error) {
+	s = strings.TrimSpace(s)
+	kind, args := "fixed", s
+	if i := strings.Index(s, ":"); i >= 0 {
+		kind, args = s[:i], s[i+1:]
+	}
+	bad := fmt.Errorf("bad payload sizes '%s': use 64, fixed:64, uniform:16-1024, normal:200,50, or exp:200", s)
+	num := func(a string) (float64, error) {
+		n, err := ParseByteSize(a)
+		if err != nil {
+			f, ferr := strconv.ParseFloat(strings.TrimSpace(a), 64)
+			if ferr != nil || f < 0 {
+				return 0, bad
+			}
+			return f, nil
+		}
+		return float64(n), nil
+	}
+	var sep string
+	switch kind {
+	case "fixed", "exp":
+	case "uniform":
+		sep = "-"
+	case "normal":
+		sep = ","
+	default:
+		return SizeDist{}, bad
+	}
+	d := SizeDist{Kind: kind}
+	var err error
+	if sep == "" {
+		d.A, err = num(args)
+		return d, err
+	}
+	parts := strings.Split(args, sep)
+	if len(parts) != 2 {
+		return SizeDist{}, bad
+	}
+	if d.A, err = num(parts[0]); err != nil {
+		return SizeDist{}, err
+	}
+	if d.B, err = num(parts[1]); err != nil {
+		return SizeDist{}, err
+	}
+	if kind == "uniform" && d.B < d.A {
+		return SizeDist{}, bad
+	}
+	return d, nil
+}
+
+// Sample draws a size from d.
+func (d SizeDist) Sample(rng *rand.Rand) int {
+	var x float64
+	switch d.Kind {
+	case "uniform":
+		x = d.A + rng.Float64()*(d.B-d.A+1)
+	case "normal":
+		x = d.A + rng.NormFloat64()*d.B
+	case "exp":
+		x = rng.ExpFloat64() * d.A
+	default:
+		x = d.A
+	}
+	if x < 0 {
+		return 0
+	}
+	return int(x)
+}
+
+// ParseGenEvtnums parses a comma separated list of evtnums and
+// ranges of them, such as "2,3,14", "0..6,14", or "-5..-2" for
+// user-defined evtnums. "all" means every evtnum defined here,
+// EvErr and EvZero through EvAdjusted, and so every PTI.
+func ParseGenEvtnums(s string) ([]Evtnum, error) {
+	var es []Evtnum
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "all" {
+			for e := EvErr; e <= EvAdjusted; e++ {
+				es = append(es, e)
+			}
+			continue
+		}
+		lo, hi := p, p
+		if i := strings.Index(p, ".."); i >= 0 {
+			lo, hi = p[:i], p[i+2:]
+		}
+		a, err := strconv.ParseInt(lo, 10, 32)
+		if err != nil || !ValidEvtnum(Evtnum(a)) {
+			return nil, fmt.Errorf("bad evtnum '%s' in '%s'", p, s)
+		}
+		b, err := strconv.ParseInt(hi, 10, 32)
+		if err != nil || b < a || !ValidEvtnum(Evtnum(b)) {
+			return nil, fmt.Errorf("bad evtnum '%s' in '%s'", p, s)
+		}
+		for e := a; e <= b; e++ {
+			es = append(es, Evtnum(e))
+		}
+	}
+	return es, nil
+}
@@ -0,0 +1,207 @@
+package tm
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test300GeneratorIsReproducibleAndInjectsFaults(t *testing.T) {
+
+	all, err := ParseGenEvtnums("all")
+	panicOn(err)
+	cfg := GenConfig{
+		Seed:    42,
+		Count:   2000,
+		Rate:    10,
+		Jitter:  0.9,
+		Evtnums: all,
+		Payload: SizeDist{Kind: "uniform", A: 0, B: 200},
+		DupProb: 0.05,
+		GapProb: 0.01,
+		OOOProb: 0.02,
+	}
+	gen := func(cfg GenConfig) ([]*Frame, *Generator) {
+		g, err := NewGenerator(cfg)
+		panicOn(err)
+		var frames []*Frame
+		for {
+			f, err := g.Next()
+			if err == io.EOF {
+				return frames, g
+			}
+			panicOn(err)
+			frames = append(frames, f)
+		}
+	}
+
+	cv.Convey("The same GenConfig should give the same bytes, and another seed different ones", t, func() {
+		var a, b, c bytes.Buffer
+		g, err := NewGenerator(cfg)
+		panicOn(err)
+		_, err = g.WriteTo(&a)
+		panicOn(err)
+		wrote := g.Stats.Frames
+		g, err = NewGenerator(cfg)
+		panicOn(err)
+		_, err = g.WriteTo(&b)
+		panicOn(err)
+		cfg2 := cfg
+		cfg2.Seed = 43
+		g, err = NewGenerator(cfg2)
+		panicOn(err)
+		_, err = g.WriteTo(&c)
+		panicOn(err)
+		cv.So(bytes.Equal(a.Bytes(), b.Bytes()), cv.ShouldBeTrue)
+		cv.So(bytes.Equal(a.Bytes(), c.Bytes()), cv.ShouldBeFalse)
+
+		fr := NewFrameReader(&a, 1024*1024)
+		var frame Frame
+		n := int64(0)
+		for {
+			_, _, err, _ := fr.NextFrame(&frame)
+			if err == io.EOF {
+				break
+			}
+			panicOn(err)
+			n++
+		}
+		cv.So(n, cv.ShouldEqual, wrote)
+	})
+
+	cv.Convey("The Generator should make every evtnum, and inject the duplicates, gaps, and out of order frames it counts", t, func() {
+		frames, g := gen(cfg)
+		st := g.Stats
+		cv.So(st.Frames, cv.ShouldEqual, int64(len(frames)))
+		cv.So(st.Frames, cv.ShouldEqual, cfg.Count+st.Dups)
+		cv.So(st.Dups, cv.ShouldBeGreaterThan, 0)
+		cv.So(st.Gaps, cv.ShouldBeGreaterThan, 0)
+		cv.So(st.OutOfOrder, cv.ShouldBeGreaterThan, 0)
+
+		seen := make(map[Evtnum]bool)
+		var dups, ooo, gaps int64
+		for i, f := range frames {
+			seen[f.GetEvtnum()] = true
+			if i == 0 {
+				continue
+			}
+			prev := frames[i-1]
+			d := f.Tm() - prev.Tm()
+			switch {
+			case d == 0 && FramesEqual(f, prev):
+				dups++
+			case d < 0:
+				ooo++
+			case d >= int64(time.Minute):
+				gaps++
+			}
+		}
+		for e := EvErr; e <= EvAdjusted; e++ {
+			cv.So(seen[e], cv.ShouldBeTrue)
+		}
+		cv.So(dups, cv.ShouldEqual, st.Dups)
+		cv.So(ooo, cv.ShouldBeGreaterThan, 0)
+		cv.So(ooo, cv.ShouldBeLessThanOrEqualTo, st.OutOfOrder)
+		cv.So(gaps, cv.ShouldBeGreaterThan, 0)
+	})
+
+	cv.Convey("Without injected faults, jitter should keep frames in order, and JSON payloads should be the size asked for", t, func() {
+		c := GenConfig{
+			Seed:    7,
+			Count:   500,
+			Rate:    100,
+			Jitter:  1,
+			Evtnums: []Evtnum{EvJson},
+			Payload: SizeDist{Kind: "fixed", A: 120},
+		}
+		frames, _ := gen(c)
+		cv.So(len(frames), cv.ShouldEqual, 500)
+		for i, f := range frames {
+			if i > 0 {
+				cv.So(f.Tm(), cv.ShouldBeGreaterThanOrEqualTo, frames[i-1].Tm())
+			}
+			cv.So(len(f.Data), cv.ShouldEqual, 120)
+			var rec genRecord
+			cv.So(json.Unmarshal(f.Data, &rec), cv.ShouldBeNil)
+			cv.So(rec.Seq, cv.ShouldEqual, i)
+		}
+		cv.So(frames[len(frames)-1].Tm()-frames[0].Tm(), cv.ShouldBeLessThan, int64(5*time.Second))
+	})
+
+	cv.Convey("The Generator should make user-defined negative evtnums, and an error string for EvErr", t, func() {
+		frames, _ := gen(GenConfig{Seed: 3, Count: 50, Evtnums: []Evtnum{EvErr, -7}})
+		var nerr, nuser int
+		for _, f := range frames {
+			switch f.GetEvtnum() {
+			case EvErr:
+				nerr++
+				cv.So(utf8.Valid(f.Data), cv.ShouldBeTrue)
+				cv.So(strings.HasPrefix(string(f.Data), "tfgen error "), cv.ShouldBeTrue)
+			case -7:
+				nuser++
+				cv.So(len(f.Data), cv.ShouldEqual, 64)
+			}
+		}
+		cv.So(nerr, cv.ShouldBeGreaterThan, 0)
+		cv.So(nuser, cv.ShouldBeGreaterThan, 0)
+		cv.So(nerr+nuser, cv.ShouldEqual, 50)
+	})
+
+	cv.Convey("The seasonal model should follow its curve, and -dur should end the stream", t, func() {
+		start := time.Date(2016, 5, 1, 0, 0, 0, 0, time.UTC)
+		c := GenConfig{
+			Start:     start,
+			End:       start.Add(time.Hour),
+			Rate:      1.0 / 60,
+			Model:     "seasonal",
+			Base:      50,
+			Amplitude: 10,
+			Step:      1e-9,
+			Period:    time.Hour,
+		}
+		frames, _ := gen(c)
+		cv.So(len(frames), cv.ShouldEqual, 60)
+		cv.So(math.Abs(frames[0].V0-50), cv.ShouldBeLessThan, 1e-6)
+		cv.So(math.Abs(frames[15].V0-60), cv.ShouldBeLessThan, 1e-6)
+		cv.So(math.Abs(frames[45].V0-40), cv.ShouldBeLessThan, 1e-6)
+	})
+
+	cv.Convey("ParseSizeDist and ParseGenEvtnums should parse their forms, and reject bad ones", t, func() {
+		d, err := ParseSizeDist("64")
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(d, cv.ShouldResemble, SizeDist{Kind: "fixed", A: 64})
+		d, err = ParseSizeDist("uniform:1K-64K")
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(d, cv.ShouldResemble, SizeDist{Kind: "uniform", A: 1024, B: 65536})
+		d, err = ParseSizeDist("normal:200,50")
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(d, cv.ShouldResemble, SizeDist{Kind: "normal", A: 200, B: 50})
+		_, err = ParseSizeDist("uniform:9-3")
+		cv.So(err, cv.ShouldNotBeNil)
+		_, err = ParseSizeDist("zipf:3")
+		cv.So(err, cv.ShouldNotBeNil)
+
+		es, err := ParseGenEvtnums("0..3,14,2000,-5..-3,-1")
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(es, cv.ShouldResemble, []Evtnum{0, 1, 2, 3, 14, 2000, -5, -4, -3, -1})
+		cv.So(len(all), cv.ShouldEqual, 20)
+		_, err = ParseGenEvtnums("3..1")
+		cv.So(err, cv.ShouldNotBeNil)
+		_, err = ParseGenEvtnums("0-3")
+		cv.So(err, cv.ShouldNotBeNil)
+		_, err = ParseGenEvtnums("json")
+		cv.So(err, cv.ShouldNotBeNil)
+
+		_, err = NewGenerator(GenConfig{Model: "brownian"})
+		cv.So(err, cv.ShouldNotBeNil)
+		_, err = NewGenerator(GenConfig{Jitter: 2})
+		cv.So(err, cv.ShouldNotBeNil)
+	})
+}
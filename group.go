@@ -0,0 +1,366 @@
+package tm
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GroupInterval is the width of the time buckets that a Grouper
+// aggregates frames into. Exactly one field is positive. Dur
+// buckets are aligned to multiples of Dur since the epoch. The
+// calendar buckets, of Days, Weeks (starting Monday), or Months,
+// start at local midnight in the Grouper's location, so that a
+// day is a day even across a daylight saving change.
+type GroupInterval struct {
+	Dur    time.Duration
+	Days   int
+	Weeks  int
+	Months int
+}
+
+var groupUnits = map[string]GroupInterval{
+	"s":       {Dur: time.Second},
+	"sec":     {Dur: time.Second},
+	"second":  {Dur: time.Second},
+	"m":       {Dur: time.Minute},
+	"min":     {Dur: time.Minute},
+	"minute":  {Dur: time.Minute},
+	"h":       {Dur: time.Hour},
+	"hour":    {Dur: time.Hour},
+	"d":       {Days: 1},
+	"day":     {Days: 1},
+	"w":       {Weeks: 1},
+	"week":    {Weeks: 1},
+	"mo":      {Months: 1},
+	"month":   {Months: 1},
+	"q":       {Months: 3},
+	"quarter": {Months: 3},
+	"y":       {Months: 12},
+	"year":    {Months: 12},
+}
+
+// ParseGroupInterval parses a duration that time.ParseDuration
+// accepts, such as "90s" or "1h30m", or an optional count and a
+// unit: sec, min, hour, day, week, month, quarter, or year (or
+// s, m, h, d, w, mo, q, y; with an optional plural s), as in
+// "min", "15min", "2day", or "3months".
+func ParseGroupInterval(s string) (GroupInterval, error) {
+	s = strings.TrimSpace(strings.ToLower(s))
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	n := 1
+	if i > 0 {
+		n, _ = strconv.Atoi(s[:i])
+	}
+	unit := s[i:]
+	iv, ok := groupUnits[unit]
+	if !ok && len(unit) > 2 && strings.HasSuffix(unit, "s") {
+		iv, ok = groupUnits[strings.TrimSuffix(unit, "s")]
+	}
+	if ok {
+		if n <= 0 {
+			return GroupInterval{}, fmt.Errorf("bad interval '%s': count must be positive", s)
+		}
+		iv.Dur *= time.Duration(n)
+		iv.Days *= n
+		iv.Weeks *= n
+		iv.Months *= n
+		return iv, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return GroupInterval{}, fmt.Errorf("bad interval '%s': neither a duration nor a calendar unit", s)
+	}
+	if d <= 0 {
+		return GroupInterval{}, fmt.Errorf("bad interval '%s': duration must be positive", s)
+	}
+	return GroupInterval{Dur: d}, nil
+}
+
+// String displays the interval as ParseGroupInterval would accept it.
+func (iv GroupInterval) String() string {
+	switch {
+	case iv.Days > 0:
+		return fmt.Sprintf("%dday", iv.Days)
+	case iv.Weeks > 0:
+		return fmt.Sprintf("%dweek", iv.Weeks)
+	case iv.Months > 0:
+		return fmt.Sprintf("%dmonth", iv.Months)
+	}
+	return iv.Dur.String()
+}
+
+// floorDiv rounds down, unlike / for negative a.
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
+
+// Start returns the start of the bucket holding tm.
+func (iv GroupInterval) Start(tm int64, loc *time.Location) int64 {
+	if iv.Dur > 0 {
+		return floorDiv(tm, int64(iv.Dur)) * int64(iv.Dur)
+	}
+	t := time.Unix(0, tm).In(loc)
+	y, m, d := t.Date()
+	switch {
+	case iv.Months > 0:
+		mon := int64(y-1970)*12 + int64(m-1)
+		mon = floorDiv(mon, int64(iv.Months)) * int64(iv.Months)
+		return time.Date(1970+int(floorDiv(mon, 12)), time.Month(mon-floorDiv(mon, 12)*12+1), 1, 0, 0, 0, 0, loc).UnixNano()
+	default:
+		// count days by their UTC date, which has no DST.
+		day := floorDiv(time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Unix(), 86400)
+		if iv.Weeks > 0 {
+			// 1970-01-05 was a Monday.
+			day = floorDiv(day-4, int64(7*iv.Weeks))*int64(7*iv.Weeks) + 4
+		} else {
+			day = floorDiv(day, int64(iv.Days)) * int64(iv.Days)
+		}
+		u := time.Unix(day*86400, 0).UTC()
+		return time.Date(u.Year(), u.Month(), u.Day(), 0, 0, 0, 0, loc).UnixNano()
+	}
+}
+
+// Next returns the start of the bucket after the one starting at start.
+func (iv GroupInterval) Next(start int64, loc *time.Location) int64 {
+	if iv.Dur > 0 {
+		return start + int64(iv.Dur)
+	}
+	t := time.Unix(0, start).In(loc)
+	return t.AddDate(0, iv.Months, iv.Days+7*iv.Weeks).UnixNano()
+}
+
+// GroupStat selects an aggregate that a Grouper reports.
+type GroupStat int
+
+const (
+	GroupCount    GroupStat = 0 // frames
+	GroupSum      GroupStat = 1 // of the frames' values (see FrameValue)
+	GroupMin      GroupStat = 2
+	GroupMax      GroupStat = 3
+	GroupMean     GroupStat = 4
+	GroupDistinct GroupStat = 5 // count of distinct keys, under the Grouper's Distinct
+	GroupBytes    GroupStat = 6 // total frame size
+)
+
+// String stringifies the GroupStat, for printing.
+func (s GroupStat) String() string {
+	switch s {
+	case GroupCount:
+		return "count"
+	case GroupSum:
+		return "sum"
+	case GroupMin:
+		return "min"
+	case GroupMax:
+		return "max"
+	case GroupMean:
+		return "mean"
+	case GroupDistinct:
+		return "distinct"
+	case GroupBytes:
+		return "bytes"
+	}
+	return fmt.Sprintf("GroupStat.%d", int(s))
+}
+
+// ParseGroupStat converts a name such as "count" or "mean"
+// into the corresponding GroupStat.
+func ParseGroupStat(name string) (GroupStat, error) {
+	switch strings.TrimSpace(name) {
+	case "count", "n":
+		return GroupCount, nil
+	case "sum":
+		return GroupSum, nil
+	case "min":
+		return GroupMin, nil
+	case "max":
+		return GroupMax, nil
+	case "mean", "avg":
+		return GroupMean, nil
+	case "distinct":
+		return GroupDistinct, nil
+	case "bytes":
+		return GroupBytes, nil
+	}
+	return GroupCount, fmt.Errorf("unknown group aggregate '%s'", name)
+}
+
+// Group holds the aggregates of the frames with one key
+// in one bucket [Start, End).
+type Group struct {
+	Start int64
+	End   int64
+	Key   string
+
+	Count  int64
+	Bytes  int64
+	NumVal int64 // frames with a value
+	Sum    float64
+	Min    float64
+	Max    float64
+
+	distinct map[string]bool
+}
+
+func newGroup(start, end int64, key string) *Group {
+	return &Group{Start: start, End: end, Key: key, Min: math.Inf(1), Max: math.Inf(-1)}
+}
+
+// Distinct returns the number of distinct keys seen.
+func (g *Group) Distinct() int {
+	return len(g.distinct)
+}
+
+// Stat returns the aggregate s. The min, max, and
+// mean of a group without values are NaN.
+func (g *Group) Stat(s GroupStat) float64 {
+	switch s {
+	case GroupCount:
+		return float64(g.Count)
+	case GroupSum:
+		return g.Sum
+	case GroupMin:
+		if g.NumVal == 0 {
+			return MyNaN
+		}
+		return g.Min
+	case GroupMax:
+		if g.NumVal == 0 {
+			return MyNaN
+		}
+		return g.Max
+	case GroupMean:
+		if g.NumVal == 0 {
+			return MyNaN
+		}
+		return g.Sum / float64(g.NumVal)
+	case GroupDistinct:
+		return float64(len(g.distinct))
+	case GroupBytes:
+		return float64(g.Bytes)
+	}
+	return MyNaN
+}
+
+// Grouper aggregates a time ordered stream of frames into
+// Groups: one per Interval bucket, or with a Key, one per key
+// per bucket. Frames without a key are counted in NoKey, and
+// frames older than the bucket in progress in Late; neither
+// is aggregated.
+type Grouper struct {
+	Interval GroupInterval
+	Loc      *time.Location // for calendar intervals; default UTC
+	Key      KeyFunc        // nil for one group per bucket
+	Distinct KeyFunc        // keys counted by GroupDistinct; default EvtnumKey
+
+	// Fill reports empty buckets, with no frames, between
+	// non-empty ones. Only when Key is nil.
+	Fill bool
+
+	NoKey int64
+	Late  int64
+
+	started  bool
+	start    int64
+	end      int64
+	cur      map[string]*Group
+	lastDone int64 // end of the last bucket returned
+}
+
+// NewGrouper makes a Grouper for buckets of iv in loc (nil for UTC).
+func NewGrouper(iv GroupInterval, loc *time.Location) *Grouper {
+	if loc == nil {
+		loc = time.UTC
+	}
+	return &Grouper{Interval: iv, Loc: loc, Distinct: EvtnumKey, cur: make(map[string]*Group)}
+}
+
+// Add aggregates f, nbytes long. It returns the groups of any
+// buckets that f's arrival completes, ordered by time and key.
+func (gr *Grouper) Add(f *Frame, nbytes int64) []*Group {
+	key := ""
+	if gr.Key != nil {
+		var ok bool
+		key, ok = gr.Key(f)
+		if !ok {
+			gr.NoKey++
+			return nil
+		}
+	}
+	tm := f.Tm()
+	var done []*Group
+	if !gr.started || tm >= gr.end {
+		if gr.started {
+			done = gr.Flush()
+		}
+		start := gr.Interval.Start(tm, gr.Loc)
+		if gr.Fill && gr.Key == nil && gr.started {
+			for s := gr.lastDone; s < start; s = gr.Interval.Next(s, gr.Loc) {
+				done = append(done, newGroup(s, gr.Interval.Next(s, gr.Loc), ""))
+			}
+		}
+		gr.started = true
+		gr.start = start
+		gr.end = gr.Interval.Next(start, gr.Loc)
+	} else if tm < gr.start {
+		gr.Late++
+		return nil
+	}
+
+	g := gr.cur[key]
+	if g == nil {
+		g = newGroup(gr.start, gr.end, key)
+		gr.cur[key] = g
+	}
+	g.Count++
+	g.Bytes += nbytes
+	if v, ok := FrameValue(f); ok {
+		g.NumVal++
+		g.Sum += v
+		if v < g.Min {
+			g.Min = v
+		}
+		if v > g.Max {
+			g.Max = v
+		}
+	}
+	if gr.Distinct != nil {
+		if k, ok := gr.Distinct(f); ok {
+			if g.distinct == nil {
+				g.distinct = make(map[string]bool)
+			}
+			g.distinct[k] = true
+		}
+	}
+	return done
+}
+
+// Flush returns the groups of the bucket in progress, ordered by key.
+func (gr *Grouper) Flush() []*Group {
+	if len(gr.cur) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(gr.cur))
+	for k := range gr.cur {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	res := make([]*Group, len(keys))
+	for i, k := range keys {
+		res[i] = gr.cur[k]
+	}
+	gr.cur = make(map[string]*Group)
+	gr.lastDone = gr.end
+	return res
+}
@@ -0,0 +1,125 @@
+package tm
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test230GroupIntervalsAndAggregates(t *testing.T) {
+
+	cv.Convey("ParseGroupInterval should accept durations and calendar units with counts", t, func() {
+		for src, want := range map[string]GroupInterval{
+			"min":      {Dur: time.Minute},
+			"15min":    {Dur: 15 * time.Minute},
+			"90s":      {Dur: 90 * time.Second},
+			"100ms":    {Dur: 100 * time.Millisecond},
+			"1h30m":    {Dur: 90 * time.Minute},
+			"hours":    {Dur: time.Hour},
+			"2day":     {Days: 2},
+			"week":     {Weeks: 1},
+			"3months":  {Months: 3},
+			"quarter":  {Months: 3},
+			"year":     {Months: 12},
+			"2d":       {Days: 2},
+			"1mo":      {Months: 1},
+			"10second": {Dur: 10 * time.Second},
+		} {
+			iv, err := ParseGroupInterval(src)
+			panicOn(err)
+			cv.So(iv, cv.ShouldResemble, want)
+		}
+		for _, bad := range []string{"", "0day", "fortnight", "-5m", "5"} {
+			_, err := ParseGroupInterval(bad)
+			cv.So(err, cv.ShouldNotBeNil)
+		}
+	})
+
+	cv.Convey("GroupInterval buckets should align to the calendar in the given location", t, func() {
+		chi, err := time.LoadLocation("America/Chicago")
+		panicOn(err)
+		at := func(s string) int64 {
+			t, err := time.ParseInLocation("2006-01-02T15:04", s, chi)
+			panicOn(err)
+			return t.UnixNano()
+		}
+		show := func(tm int64) string { return time.Unix(0, tm).In(chi).Format("2006-01-02T15:04") }
+
+		day := GroupInterval{Days: 1}
+		// 2016-03-13 is 23 hours long in Chicago.
+		s := day.Start(at("2016-03-13T22:00"), chi)
+		cv.So(show(s), cv.ShouldEqual, "2016-03-13T00:00")
+		cv.So(show(day.Next(s, chi)), cv.ShouldEqual, "2016-03-14T00:00")
+		cv.So(day.Next(s, chi)-s, cv.ShouldEqual, int64(23*time.Hour))
+
+		week := GroupInterval{Weeks: 1}
+		cv.So(show(week.Start(at("2016-05-01T12:00"), chi)), cv.ShouldEqual, "2016-04-25T00:00")
+		cv.So(show(week.Start(at("2016-05-02T00:00"), chi)), cv.ShouldEqual, "2016-05-02T00:00")
+
+		q := GroupInterval{Months: 3}
+		s = q.Start(at("2016-05-20T12:00"), chi)
+		cv.So(show(s), cv.ShouldEqual, "2016-04-01T00:00")
+		cv.So(show(q.Next(s, chi)), cv.ShouldEqual, "2016-07-01T00:00")
+		cv.So(show(GroupInterval{Months: 12}.Start(at("1969-07-20T20:17"), chi)), cv.ShouldEqual, "1969-01-01T00:00")
+
+		m15 := GroupInterval{Dur: 15 * time.Minute}
+		cv.So(show(m15.Start(at("2016-05-20T12:44"), chi)), cv.ShouldEqual, "2016-05-20T12:30")
+	})
+
+	mk := func(sec int, v float64) *Frame { return GenTestFrame(sec, EvOneFloat64, v, 0, nil) }
+
+	cv.Convey("Grouper should aggregate each bucket, fill empty buckets, and skip late frames", t, func() {
+		gr := NewGrouper(GroupInterval{Dur: time.Minute}, nil)
+		gr.Fill = true
+		var got []*Group
+		for _, f := range []*Frame{mk(0, 1), mk(10, 5), mk(59, 3), mk(185, 10), mk(100, 99), mk(190, 20)} {
+			got = append(got, gr.Add(f, 16)...)
+		}
+		got = append(got, gr.Flush()...)
+		cv.So(len(got), cv.ShouldEqual, 4)
+		cv.So(got[0].Count, cv.ShouldEqual, 3)
+		cv.So(got[0].Stat(GroupSum), cv.ShouldEqual, 9)
+		cv.So(got[0].Stat(GroupMin), cv.ShouldEqual, 1)
+		cv.So(got[0].Stat(GroupMax), cv.ShouldEqual, 5)
+		cv.So(got[0].Stat(GroupMean), cv.ShouldEqual, 3)
+		cv.So(got[0].Stat(GroupBytes), cv.ShouldEqual, 48)
+		cv.So(got[0].Stat(GroupDistinct), cv.ShouldEqual, 1)
+		for i := 1; i <= 2; i++ {
+			cv.So(got[i].Count, cv.ShouldEqual, 0)
+			cv.So(math.IsNaN(got[i].Stat(GroupMean)), cv.ShouldBeTrue)
+		}
+		cv.So(got[3].Start, cv.ShouldEqual, GenTestTime(180).UnixNano())
+		cv.So(got[3].End, cv.ShouldEqual, GenTestTime(240).UnixNano())
+		cv.So(got[3].Stat(GroupMean), cv.ShouldEqual, 15)
+		cv.So(gr.Late, cv.ShouldEqual, 1)
+	})
+
+	cv.Convey("Grouper with a Key should report one group per key per bucket, in key order", t, func() {
+		gr := NewGrouper(GroupInterval{Days: 1}, nil)
+		var err error
+		gr.Key, err = ParseKeyFunc("host", nil)
+		panicOn(err)
+		gr.Distinct, err = ParseKeyFunc("user", nil)
+		panicOn(err)
+		var got []*Group
+		for i, host := range []string{"b", "a", "b", "", "b"} {
+			js := fmt.Sprintf(`{"host":"%s","user":"u%d"}`, host, i%2)
+			if host == "" {
+				js = `{}`
+			}
+			got = append(got, gr.Add(GenTestFrame(i*3600, EvJson, 0, 0, []byte(js)), 10)...)
+		}
+		got = append(got, gr.Flush()...)
+		cv.So(len(got), cv.ShouldEqual, 2)
+		cv.So(got[0].Key, cv.ShouldEqual, "a")
+		cv.So(got[0].Count, cv.ShouldEqual, 1)
+		cv.So(got[1].Key, cv.ShouldEqual, "b")
+		cv.So(got[1].Count, cv.ShouldEqual, 3)
+		cv.So(got[1].Distinct(), cv.ShouldEqual, 1)
+		cv.So(got[1].NumVal, cv.ShouldEqual, 0)
+		cv.So(gr.NoKey, cv.ShouldEqual, 1)
+	})
+}
@@ -0,0 +1,381 @@
+package tm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ugorji/go/codec"
+)
+
+// ImportConfig configures Import.
+type ImportConfig struct {
+	// Format is "csv" or "jsonl".
+	Format string
+
+	// Comma separates CSV fields. Default ','.
+	Comma rune
+
+	// TimeCol names the timestamp column. Default "time".
+	TimeCol string
+
+	// TimeFormat is "rfc3339" (the default, with optional
+	// fractional seconds), "epoch" (nanoseconds), "epochs",
+	// "epochms", or "epochus", or else a Go time layout,
+	// such as "2006-01-02 15:04:05".
+	TimeFormat string
+
+	// Loc is the location of times without a zone. Default UTC.
+	Loc *time.Location
+
+	// V0Col and V1Col name the numeric columns that become V0 and
+	// V1. With only V0Col, frames are EvOneFloat64; with only V1Col,
+	// EvOneInt64; with both, EvTwo64. An empty value makes an EvNull
+	// frame, and "NA" or "NaN" an EvNA or EvNaN frame.
+	V0Col string
+	V1Col string
+
+	// Payload is "json", "msgpack", or "none". The fields other
+	// than the time and value columns become a map, encoded
+	// as the payload of an Evtnum frame. Without value columns,
+	// the default is "json"; with them, "none". If there are
+	// both, each row makes a value frame and then a payload
+	// frame, at the same time.
+	Payload string
+
+	// Evtnum of the payload frames. Default EvJson or EvMsgpack.
+	Evtnum Evtnum
+}
+
+// ImportStats reports what Import did.
+type ImportStats struct {
+	Rows    int64 // data rows read
+	Frames  int64 // frames made
+	BadRows int64
+}
+
+// ImportRowError reports a row that Import could not convert.
+type ImportRowError struct {
+	Line int64
+	Err  error
+}
+
+func (e *ImportRowError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+// Import reads CSV (with a header row naming the columns) or JSON
+// Lines from r, and converts each row into frames, which it passes
+// to emit. The frames are in row order; sort them if need be. A
+// row that cannot be converted is passed to bad, as an
+// *ImportRowError giving its line number, and skipped. The first
+// error from reading r or from emit stops the import.
+func Import(r io.Reader, cfg ImportConfig, emit func(f *Frame) error, bad func(e *ImportRowError)) (*ImportStats, error) {
+	if cfg.TimeCol == "" {
+		cfg.TimeCol = "time"
+	}
+	if cfg.Loc == nil {
+		cfg.Loc = time.UTC
+	}
+	if cfg.Payload == "" {
+		cfg.Payload = "json"
+		if cfg.V0Col != "" || cfg.V1Col != "" {
+			cfg.Payload = "none"
+		}
+	}
+	if cfg.Evtnum == 0 {
+		cfg.Evtnum = EvJson
+		if cfg.Payload == "msgpack" {
+			cfg.Evtnum = EvMsgpack
+		}
+	}
+	switch cfg.Payload {
+	case "json", "msgpack", "none":
+	default:
+		return nil, fmt.Errorf("Import: unknown payload encoding '%s': use json, msgpack, or none", cfg.Payload)
+	}
+	if cfg.Payload == "none" && cfg.V0Col == "" && cfg.V1Col == "" {
+		return nil, fmt.Errorf("Import: no value columns and no payload: nothing to import")
+	}
+
+	st := &ImportStats{}
+	row := func(line int64, rec map[string]interface{}) error {
+		st.Rows++
+		frames, err := importRow(rec, &cfg)
+		if err != nil {
+			st.BadRows++
+			if bad != nil {
+				bad(&ImportRowError{Line: line, Err: err})
+			}
+			return nil
+		}
+		for _, f := range frames {
+			st.Frames++
+			if err := emit(f); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	switch cfg.Format {
+	case "csv":
+		cr := csv.NewReader(r)
+		if cfg.Comma != 0 {
+			cr.Comma = cfg.Comma
+		}
+		cr.FieldsPerRecord = -1
+		header, err := cr.Read()
+		if err != nil {
+			return st, fmt.Errorf("Import: reading the CSV header: %v", err)
+		}
+		line := int64(1)
+		for {
+			rec, err := cr.Read()
+			if err == io.EOF {
+				return st, nil
+			}
+			line++
+			if pe, ok := err.(*csv.ParseError); ok {
+				st.Rows++
+				st.BadRows++
+				if bad != nil {
+					bad(&ImportRowError{Line: int64(pe.Line), Err: pe.Err})
+				}
+				continue
+			}
+			if err != nil {
+				return st, err
+			}
+			if len(rec) != len(header) {
+				st.Rows++
+				st.BadRows++
+				if bad != nil {
+					bad(&ImportRowError{Line: line, Err: fmt.Errorf("%d fields, but the header has %d", len(rec), len(header))})
+				}
+				continue
+			}
+			m := make(map[string]interface{}, len(rec))
+			for i, name := range header {
+				m[name] = rec[i]
+			}
+			if err := row(line, m); err != nil {
+				return st, err
+			}
+		}
+
+	case "jsonl":
+		br := bufio.NewReader(r)
+		for line := int64(1); ; line++ {
+			by, err := br.ReadBytes('\n')
+			if len(bytes.TrimSpace(by)) > 0 {
+				var m map[string]interface{}
+				dec := json.NewDecoder(bytes.NewReader(by))
+				dec.UseNumber()
+				if derr := dec.Decode(&m); derr != nil {
+					st.Rows++
+					st.BadRows++
+					if bad != nil {
+						bad(&ImportRowError{Line: line, Err: derr})
+					}
+				} else if err := row(line, m); err != nil {
+					return st, err
+				}
+			}
+			if err == io.EOF {
+				return st, nil
+			}
+			if err != nil {
+				return st, err
+			}
+		}
+	}
+	return st, fmt.Errorf("Import: unknown format '%s': use csv or jsonl", cfg.Format)
+}
+
+// importRow converts one row. CSV values are strings; JSON
+// values are strings, json.Numbers, bools, nil, maps, or slices.
+func importRow(rec map[string]interface{}, cfg *ImportConfig) ([]*Frame, error) {
+	tv, ok := rec[cfg.TimeCol]
+	if !ok {
+		return nil, fmt.Errorf("no time column '%s'", cfg.TimeCol)
+	}
+	tm, err := parseImportTime(tv, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var frames []*Frame
+	if cfg.V0Col != "" || cfg.V1Col != "" {
+		f, err := importValueFrame(tm, rec, cfg)
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, f)
+	}
+
+	if cfg.Payload != "none" {
+		m := make(map[string]interface{})
+		for k, v := range rec {
+			if k == cfg.TimeCol || k == cfg.V0Col || k == cfg.V1Col {
+				continue
+			}
+			if s, isStr := v.(string); isStr && cfg.Format == "csv" {
+				if s == "" {
+					continue
+				}
+				v = inferCSVValue(s)
+			}
+			m[k] = plainJSON(v)
+		}
+		var data []byte
+		if cfg.Payload == "json" {
+			data, err = json.Marshal(m)
+		} else {
+			enc := codec.NewEncoderBytes(&data, &msgpHelper.mh)
+			err = enc.Encode(m)
+		}
+		if err != nil {
+			return nil, err
+		}
+		f, err := NewFrame(time.Unix(0, tm), cfg.Evtnum, 0, 0, data)
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, f)
+	}
+	return frames, nil
+}
+
+// importValueFrame makes the value frame of a row.
+func importValueFrame(tm int64, rec map[string]interface{}, cfg *ImportConfig) (*Frame, error) {
+	var v0 float64
+	var v1 int64
+	for _, col := range []string{cfg.V0Col, cfg.V1Col} {
+		if col == "" {
+			continue
+		}
+		x, ok := rec[col]
+		if !ok {
+			return nil, fmt.Errorf("no value column '%s'", col)
+		}
+		s := strings.TrimSpace(fmt.Sprintf("%v", x))
+		switch {
+		case x == nil || s == "":
+			return NewFrame(time.Unix(0, tm), EvNull, 0, 0, nil)
+		case strings.EqualFold(s, "NA"):
+			return NewFrame(time.Unix(0, tm), EvNA, 0, 0, nil)
+		case strings.EqualFold(s, "NaN"):
+			return NewFrame(time.Unix(0, tm), EvNaN, 0, 0, nil)
+		}
+		if col == cfg.V0Col {
+			f, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return nil, fmt.Errorf("column '%s': '%s' is not a number", col, s)
+			}
+			v0 = f
+		} else {
+			i, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				f, ferr := strconv.ParseFloat(s, 64)
+				if ferr != nil || f != math.Trunc(f) {
+					return nil, fmt.Errorf("column '%s': '%s' is not an integer", col, s)
+				}
+				i = int64(f)
+			}
+			v1 = i
+		}
+	}
+	switch {
+	case cfg.V0Col != "" && cfg.V1Col != "":
+		return NewFrame(time.Unix(0, tm), EvTwo64, v0, v1, nil)
+	case cfg.V0Col != "":
+		return NewFrame(time.Unix(0, tm), EvOneFloat64, v0, 0, nil)
+	}
+	return NewFrame(time.Unix(0, tm), EvOneInt64, 0, v1, nil)
+}
+
+func parseImportTime(v interface{}, cfg *ImportConfig) (int64, error) {
+	s := strings.TrimSpace(fmt.Sprintf("%v", v))
+	if v == nil || s == "" {
+		return 0, fmt.Errorf("empty time")
+	}
+	scale := int64(0)
+	switch strings.ToLower(cfg.TimeFormat) {
+	case "", "rfc3339":
+		t, err := time.ParseInLocation(time.RFC3339Nano, s, cfg.Loc)
+		if err != nil {
+			return 0, fmt.Errorf("bad time '%s': %v", s, err)
+		}
+		return t.UnixNano(), nil
+	case "epoch", "epochns":
+		scale = 1
+	case "epochus":
+		scale = 1e3
+	case "epochms":
+		scale = 1e6
+	case "epochs":
+		scale = 1e9
+	default:
+		t, err := time.ParseInLocation(cfg.TimeFormat, s, cfg.Loc)
+		if err != nil {
+			return 0, fmt.Errorf("bad time '%s': %v", s, err)
+		}
+		return t.UnixNano(), nil
+	}
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i * scale, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad epoch time '%s'", s)
+	}
+	return int64(f * float64(scale)), nil
+}
+
+// inferCSVValue types a CSV field: integers, floats,
+// and true/false become numbers and bools.
+func inferCSVValue(s string) interface{} {
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f
+	}
+	switch s {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	return s
+}
+
+// plainJSON replaces json.Numbers with int64 or float64, so
+// that msgpack encodes them as numbers.
+func plainJSON(v interface{}) interface{} {
+	switch x := v.(type) {
+	case json.Number:
+		if i, err := x.Int64(); err == nil {
+			return i
+		}
+		f, _ := x.Float64()
+		return f
+	case []interface{}:
+		for i := range x {
+			x[i] = plainJSON(x[i])
+		}
+	case map[string]interface{}:
+		for k := range x {
+			x[k] = plainJSON(x[k])
+		}
+	}
+	return v
+}
@@ -0,0 +1,107 @@
+package tm
+
+import (
+	"flag"
+	"strings"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test260ImportCSVAndJSONLines(t *testing.T) {
+
+	collect := func(in string, cfg ImportConfig) ([]*Frame, []int64, *ImportStats) {
+		var frames []*Frame
+		var badLines []int64
+		st, err := Import(strings.NewReader(in), cfg,
+			func(f *Frame) error { frames = append(frames, f); return nil },
+			func(e *ImportRowError) { badLines = append(badLines, e.Line) })
+		panicOn(err)
+		return frames, badLines, st
+	}
+
+	cv.Convey("Import of CSV should map value columns to V0 and V1, give empty, NA, and NaN values their PTIs, and report bad rows by line", t, func() {
+		in := "time,px,qty,sym\n" +
+			"2016-03-10T00:00:00Z,1.5,3,A\n" +
+			"2016-03-10T00:00:01Z,,4,B\n" +
+			"2016-03-10T00:00:02Z,NA,5,C\n" +
+			"2016-03-10T00:00:03Z,NaN,6,D\n" +
+			"yesterday,2,7,E\n" +
+			"2016-03-10T00:00:05Z,abc,8,F\n" +
+			"2016-03-10T00:00:06Z,2.5,9\n" +
+			"2016-03-10T00:00:07Z,-1,10,G\n"
+		frames, bad, st := collect(in, ImportConfig{Format: "csv", V0Col: "px", V1Col: "qty", Payload: "json"})
+		cv.So(bad, cv.ShouldResemble, []int64{6, 7, 8})
+		cv.So(st.Rows, cv.ShouldEqual, 8)
+		cv.So(st.BadRows, cv.ShouldEqual, 3)
+		cv.So(len(frames), cv.ShouldEqual, 10)
+
+		cv.So(frames[0].GetEvtnum(), cv.ShouldEqual, EvTwo64)
+		cv.So(frames[0].V0, cv.ShouldEqual, 1.5)
+		cv.So(frames[0].Ude, cv.ShouldEqual, 3)
+		cv.So(frames[0].Tm(), cv.ShouldEqual, GenTestT0.UnixNano())
+		cv.So(frames[1].GetEvtnum(), cv.ShouldEqual, EvJson)
+		cv.So(string(frames[1].Data), cv.ShouldEqual, `{"sym":"A"}`)
+		cv.So(frames[1].Tm(), cv.ShouldEqual, GenTestT0.UnixNano())
+		cv.So(frames[2].GetPTI(), cv.ShouldEqual, PtiNull)
+		cv.So(frames[4].GetPTI(), cv.ShouldEqual, PtiNA)
+		cv.So(frames[6].GetPTI(), cv.ShouldEqual, PtiNaN)
+		cv.So(frames[8].V0, cv.ShouldEqual, -1)
+	})
+
+	cv.Convey("Import of JSON Lines should put the other fields in a msgpack payload, and parse epoch times", t, func() {
+		in := `{"ts":1457568000000,"v":7,"tags":{"a":[1,2]},"n":null}` + "\n" +
+			"\n" +
+			`{"ts":1457568001000,"v":null}` + "\n" +
+			`not json` + "\n" +
+			`{"ts":1457568002000,"v":"x"}` + "\n"
+		frames, bad, st := collect(in, ImportConfig{Format: "jsonl", TimeCol: "ts", TimeFormat: "epochms", V1Col: "v", Payload: "msgpack"})
+		cv.So(bad, cv.ShouldResemble, []int64{4, 5})
+		cv.So(st.Rows, cv.ShouldEqual, 4)
+		cv.So(len(frames), cv.ShouldEqual, 4)
+		cv.So(frames[0].GetEvtnum(), cv.ShouldEqual, EvOneInt64)
+		cv.So(frames[0].Ude, cv.ShouldEqual, 7)
+		cv.So(frames[0].Tm(), cv.ShouldEqual, GenTestT0.UnixNano())
+		cv.So(frames[1].GetEvtnum(), cv.ShouldEqual, EvMsgpack)
+		fp, err := ParseFieldPath("tags.a[1]")
+		panicOn(err)
+		x, ok := fp.LookupFrame(frames[1], nil)
+		cv.So(ok, cv.ShouldBeTrue)
+		f, _ := PayloadFloat(x)
+		cv.So(f, cv.ShouldEqual, 2)
+		cv.So(frames[2].GetPTI(), cv.ShouldEqual, PtiNull)
+		cv.So(frames[2].Tm(), cv.ShouldEqual, GenTestT0.Add(time.Second).UnixNano())
+	})
+
+	cv.Convey("Import should take Go time layouts in the given location, and default to a JSON payload", t, func() {
+		loc, err := time.LoadLocation("America/Chicago")
+		panicOn(err)
+		in := "when;msg\n2016-03-10 06:00:00;hi\n"
+		frames, bad, _ := collect(in, ImportConfig{Format: "csv", Comma: ';', TimeCol: "when", TimeFormat: "2006-01-02 15:04:05", Loc: loc})
+		cv.So(len(bad), cv.ShouldEqual, 0)
+		cv.So(len(frames), cv.ShouldEqual, 1)
+		cv.So(frames[0].Tm(), cv.ShouldEqual, GenTestT0.Add(12*time.Hour).UnixNano())
+		cv.So(string(frames[0].Data), cv.ShouldEqual, `{"msg":"hi"}`)
+	})
+
+	cv.Convey("Import should write user-defined negative evtnums, and tfimport should refuse evtnums that cannot carry a payload", t, func() {
+		in := "time,msg\n2016-03-10T00:00:00Z,hi\n"
+		frames, bad, _ := collect(in, ImportConfig{Format: "csv", Evtnum: -2})
+		cv.So(len(bad), cv.ShouldEqual, 0)
+		cv.So(len(frames), cv.ShouldEqual, 1)
+		cv.So(frames[0].GetEvtnum(), cv.ShouldEqual, Evtnum(-2))
+
+		validate := func(evtnum string) error {
+			fs := flag.NewFlagSet("tfimport", flag.ContinueOnError)
+			c := &TfimportConfig{}
+			c.DefineFlags(fs)
+			panicOn(fs.Parse([]string{"-evtnum", evtnum}))
+			return c.ValidateConfig()
+		}
+		cv.So(validate("-2"), cv.ShouldBeNil)
+		cv.So(validate("7"), cv.ShouldBeNil)
+		cv.So(validate("3"), cv.ShouldNotBeNil)
+		cv.So(validate("2000000"), cv.ShouldNotBeNil)
+	})
+}
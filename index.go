@@ -0,0 +1,104 @@
+package tm
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// IndexEntry is one frame of a .idx file, as written by
+// tfindex: the frame at byte Offset has timestamp Tm.
+// tfindex writes one entry per minute of data.
+type IndexEntry struct {
+	Tm     int64
+	Offset int64
+}
+
+// IndexPath returns the path of the index for the TMFRAME file path.
+func IndexPath(path string) string {
+	return path + ".idx"
+}
+
+// ReadIndex reads the index file idxPath.
+func ReadIndex(idxPath string) ([]IndexEntry, error) {
+	f, err := os.Open(idxPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fr := NewFrameReader(f, 1024)
+	var res []IndexEntry
+	var frame Frame
+	for {
+		_, _, err, _ = fr.NextFrame(&frame)
+		if err == io.EOF {
+			return res, nil
+		}
+		if err != nil {
+			return res, fmt.Errorf("ReadIndex error reading '%s': '%v'", idxPath, err)
+		}
+		if frame.GetPTI() != PtiOneInt64 || frame.Ude < 0 {
+			return res, fmt.Errorf("ReadIndex: '%s' is not an index: entry %v is %v", idxPath, len(res), frame.String())
+		}
+		res = append(res, IndexEntry{Tm: frame.Tm(), Offset: frame.Ude})
+	}
+}
+
+// ReadIndexFor reads the index of the TMFRAME file path, if
+// there is one that is no older than path itself. Otherwise
+// it returns nil, nil.
+func ReadIndexFor(path string) ([]IndexEntry, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	ii, err := os.Stat(IndexPath(path))
+	if err != nil || ii.ModTime().Before(fi.ModTime()) {
+		return nil, nil
+	}
+	return ReadIndex(IndexPath(path))
+}
+
+// IndexBuilder makes the index of a TMFRAME file as its frames are
+// written, as tfindex does: an entry for the first frame, and then
+// one for the first frame after each minute that has an entry.
+type IndexBuilder struct {
+	Entries []IndexEntry
+
+	offset int64
+	next   int64
+}
+
+// Add accounts for the next frame of the file, at time tm
+// and nbytes long.
+func (b *IndexBuilder) Add(tm int64, nbytes int64) {
+	if len(b.Entries) == 0 || tm > b.next {
+		b.Entries = append(b.Entries, IndexEntry{Tm: tm, Offset: b.offset})
+		b.next = time.Unix(0, tm).Truncate(time.Minute).Add(time.Minute).UnixNano()
+	}
+	b.offset += nbytes
+}
+
+// WriteIndex writes entries to the index file idxPath.
+func WriteIndex(idxPath string, entries []IndexEntry) error {
+	of, err := os.Create(idxPath)
+	if err != nil {
+		return err
+	}
+	fw := NewFrameWriter(of, 1024)
+	for _, e := range entries {
+		f, err := NewFrame(time.Unix(0, e.Tm), EvOneInt64, 0, e.Offset, nil)
+		if err != nil {
+			of.Close()
+			return err
+		}
+		fw.Append(f)
+	}
+	err = fw.Flush()
+	if err != nil {
+		of.Close()
+		return fmt.Errorf("WriteIndex error writing '%s': '%v'", idxPath, err)
+	}
+	return of.Close()
+}
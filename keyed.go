@@ -0,0 +1,202 @@
+package tm
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/glycerine/zebrapack/zebra"
+)
+
+// KeyFunc extracts the key that a frame belongs to.
+// It returns false if the frame has no key.
+type KeyFunc func(f *Frame) (string, bool)
+
+// EvtnumKey keys frames by their event number,
+// so that each kind of event gets its own Series.
+func EvtnumKey(f *Frame) (string, bool) {
+	return fmt.Sprintf("%d", int64(f.GetEvtnum())), true
+}
+
+// PayloadFieldKey returns a KeyFunc that keys frames by the
+// value found at path (see ParseFieldPath) in their JSON,
+// msgpack, or ZebraPack payload. zSchema is only needed
+// for ZebraPack payloads, and may be nil otherwise.
+// Frames without the field have no key.
+func PayloadFieldKey(path string, zSchema *zebra.Schema) (KeyFunc, error) {
+	fp, err := ParseFieldPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return func(f *Frame) (string, bool) {
+		v, ok := fp.LookupFrame(f, zSchema)
+		if !ok {
+			return "", false
+		}
+		return PayloadString(v), true
+	}, nil
+}
+
+// ParseKeyFunc returns EvtnumKey for "evtnum", and otherwise the
+// PayloadFieldKey for spec as a field path.
+func ParseKeyFunc(spec string, zSchema *zebra.Schema) (KeyFunc, error) {
+	if spec == "evtnum" {
+		return EvtnumKey, nil
+	}
+	return PayloadFieldKey(spec, zSchema)
+}
+
+// KeyedSeries splits one stream of frames into a Series per key,
+// such as one Series per order id or per host. Each Series is
+// kept in time order, and supports the same searches as Series,
+// addressed by key.
+type KeyedSeries struct {
+	Key    KeyFunc
+	Series map[string]*Series
+
+	// Unkeyed counts the frames that Key could not
+	// assign to a key; they are dropped.
+	Unkeyed int64
+}
+
+// NewKeyedSeries makes an empty KeyedSeries using key.
+func NewKeyedSeries(key KeyFunc) *KeyedSeries {
+	return &KeyedSeries{
+		Key:    key,
+		Series: make(map[string]*Series),
+	}
+}
+
+// NewKeyedSeriesFromFrames splits frames by key.
+func NewKeyedSeriesFromFrames(frames []*Frame, key KeyFunc) *KeyedSeries {
+	k := NewKeyedSeries(key)
+	for _, f := range frames {
+		k.Add(f)
+	}
+	return k
+}
+
+// ReadKeyedSeries reads all the frames from r and splits them by key.
+func ReadKeyedSeries(r io.Reader, key KeyFunc) (*KeyedSeries, error) {
+	k := NewKeyedSeries(key)
+	fr := NewFrameReader(r, 1024*1024)
+	var err error
+	for i := 0; err == nil; i++ {
+		frame := &Frame{}
+		_, _, err, _ = fr.NextFrame(frame)
+		if err != nil {
+			if err == io.EOF {
+				return k, nil
+			}
+			return k, fmt.Errorf("ReadKeyedSeries error from fr.NextFrame() at i=%v: '%v'", i, err)
+		}
+		k.Add(frame)
+	}
+	return k, nil
+}
+
+// Add files f under its key. It returns the key and false if f has
+// no key. Add takes ownership of f. Frames need not arrive in time
+// order; a late frame is inserted after any frames with the same
+// timestamp, so the arrival order of ties is kept.
+func (k *KeyedSeries) Add(f *Frame) (string, bool) {
+	key, ok := k.Key(f)
+	if !ok {
+		k.Unkeyed++
+		return "", false
+	}
+	s, ok := k.Series[key]
+	if !ok {
+		s = NewSeriesFromFrames(nil)
+		k.Series[key] = s
+	}
+	n := len(s.Frames)
+	tm := f.Tm()
+	if n == 0 || s.Frames[n-1].Tm() <= tm {
+		s.Frames = append(s.Frames, f)
+		return key, true
+	}
+	i := sort.Search(n, func(i int) bool { return s.Frames[i].Tm() > tm })
+	s.Frames = append(s.Frames, nil)
+	copy(s.Frames[i+1:], s.Frames[i:])
+	s.Frames[i] = f
+	return key, true
+}
+
+// Keys returns the keys seen so far, in sorted order.
+func (k *KeyedSeries) Keys() []string {
+	keys := make([]string, 0, len(k.Series))
+	for key := range k.Series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Get returns the Series for key, or nil if key has not been seen.
+func (k *KeyedSeries) Get(key string) *Series {
+	return k.Series[key]
+}
+
+// LastInForceBefore is Series.LastInForceBefore() on the Series for key.
+// An unseen key gives (nil, InPast, -1).
+func (k *KeyedSeries) LastInForceBefore(key string, tm time.Time) (*Frame, SearchStatus, int) {
+	s, ok := k.Series[key]
+	if !ok {
+		return nil, InPast, -1
+	}
+	return s.LastInForceBefore(tm)
+}
+
+// FirstInForceBefore is Series.FirstInForceBefore() on the Series for key.
+// An unseen key gives (nil, InPast, -1).
+func (k *KeyedSeries) FirstInForceBefore(key string, tm time.Time) (*Frame, SearchStatus, int) {
+	s, ok := k.Series[key]
+	if !ok {
+		return nil, InPast, -1
+	}
+	return s.FirstInForceBefore(tm)
+}
+
+// LastAtOrBefore is Series.LastAtOrBefore() on the Series for key.
+// An unseen key gives (nil, InPast, -1).
+func (k *KeyedSeries) LastAtOrBefore(key string, tm time.Time) (*Frame, SearchStatus, int) {
+	s, ok := k.Series[key]
+	if !ok {
+		return nil, InPast, -1
+	}
+	return s.LastAtOrBefore(tm)
+}
+
+// FirstAtOrBefore is Series.FirstAtOrBefore() on the Series for key.
+// An unseen key gives (nil, InPast, -1).
+func (k *KeyedSeries) FirstAtOrBefore(key string, tm time.Time) (*Frame, SearchStatus, int) {
+	s, ok := k.Series[key]
+	if !ok {
+		return nil, InPast, -1
+	}
+	return s.FirstAtOrBefore(tm)
+}
+
+// KeyedFrame pairs a frame with its key.
+type KeyedFrame struct {
+	Key   string
+	Frame *Frame
+}
+
+// Snapshot returns, for every key, the frame in force at time tm:
+// the last frame at or before tm, as found by LastAtOrBefore().
+// Keys whose first frame comes after tm are left out. The result
+// is sorted by key.
+func (k *KeyedSeries) Snapshot(tm time.Time) []KeyedFrame {
+	res := make([]KeyedFrame, 0, len(k.Series))
+	for _, key := range k.Keys() {
+		f, status, _ := k.Series[key].LastAtOrBefore(tm)
+		if status == InPast || f == nil {
+			continue
+		}
+		res = append(res, KeyedFrame{Key: key, Frame: f})
+	}
+	return res
+}
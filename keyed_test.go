@@ -0,0 +1,95 @@
+package tm
+
+import (
+	"fmt"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+	"github.com/ugorji/go/codec"
+)
+
+func Test110KeyedSeriesByPayloadField(t *testing.T) {
+
+	at := GenTestTime
+
+	jsonFrame := func(sec int, host string, load int) *Frame {
+		return GenTestFrame(sec, EvJson, 0, 0, []byte(fmt.Sprintf(`{"host":{"name":"%s"},"load":[%d,0]}`, host, load)))
+	}
+
+	cv.Convey("ParseFieldPath should follow keys and array indexes into a decoded payload", t, func() {
+		v, err := jsonFrame(0, "a", 7).DecodePayload(nil)
+		panicOn(err)
+		fp, err := ParseFieldPath("load[0]")
+		panicOn(err)
+		x, ok := fp.Lookup(v)
+		cv.So(ok, cv.ShouldBeTrue)
+		cv.So(PayloadString(x), cv.ShouldEqual, "7")
+
+		fp, err = ParseFieldPath("host.name")
+		panicOn(err)
+		x, ok = fp.Lookup(v)
+		cv.So(ok, cv.ShouldBeTrue)
+		cv.So(x, cv.ShouldEqual, "a")
+
+		fp, err = ParseFieldPath("load[5]")
+		panicOn(err)
+		_, ok = fp.Lookup(v)
+		cv.So(ok, cv.ShouldBeFalse)
+
+		for _, bad := range []string{"", ".a", "a..b", "a[x]", "a[1"} {
+			_, err = ParseFieldPath(bad)
+			cv.So(err, cv.ShouldNotBeNil)
+		}
+	})
+
+	cv.Convey("A KeyedSeries split on a JSON field should answer in-force queries and snapshots per key", t, func() {
+		key, err := PayloadFieldKey("host.name", nil)
+		panicOn(err)
+		frames := []*Frame{
+			jsonFrame(1, "a", 1),
+			jsonFrame(2, "b", 10),
+			jsonFrame(4, "a", 2),
+			jsonFrame(3, "b", 11), // late, but filed in order
+			jsonFrame(6, "c", 100),
+		}
+		nokey := GenTestFrame(5, EvJson, 0, 0, []byte(`{"other":1}`))
+		frames = append(frames, nokey)
+
+		k := NewKeyedSeriesFromFrames(frames, key)
+		cv.So(k.Keys(), cv.ShouldResemble, []string{"a", "b", "c"})
+		cv.So(k.Unkeyed, cv.ShouldEqual, 1)
+		cv.So(len(k.Get("b").Frames), cv.ShouldEqual, 2)
+		cv.So(k.Get("b").Frames[1].Tm(), cv.ShouldEqual, at(3).UnixNano())
+
+		f, status, _ := k.LastInForceBefore("a", at(4))
+		cv.So(status, cv.ShouldEqual, Avail)
+		cv.So(f.Tm(), cv.ShouldEqual, at(1).UnixNano())
+
+		_, status, _ = k.LastInForceBefore("nosuch", at(4))
+		cv.So(status, cv.ShouldEqual, InPast)
+
+		snap := k.Snapshot(at(4))
+		cv.So(len(snap), cv.ShouldEqual, 2)
+		cv.So(snap[0].Key, cv.ShouldEqual, "a")
+		cv.So(snap[0].Frame.Tm(), cv.ShouldEqual, at(4).UnixNano())
+		cv.So(snap[1].Key, cv.ShouldEqual, "b")
+		cv.So(snap[1].Frame.Tm(), cv.ShouldEqual, at(3).UnixNano())
+		cv.So(len(k.Snapshot(at(10))), cv.ShouldEqual, 3)
+	})
+
+	cv.Convey("Msgpack payloads and evtnums should also serve as keys", t, func() {
+		var by []byte
+		enc := codec.NewEncoderBytes(&by, &msgpHelper.mh)
+		panicOn(enc.Encode(map[string]interface{}{"id": 42}))
+		mf := GenTestFrame(1, EvMsgpack, 0, 0, by)
+
+		key, err := PayloadFieldKey("id", nil)
+		panicOn(err)
+		kv, ok := key(mf)
+		cv.So(ok, cv.ShouldBeTrue)
+		cv.So(kv, cv.ShouldEqual, "42")
+
+		k := NewKeyedSeriesFromFrames([]*Frame{mf, jsonFrame(2, "a", 1)}, EvtnumKey)
+		cv.So(k.Keys(), cv.ShouldResemble, []string{"14", "9"})
+	})
+}
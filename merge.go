@@ -1,8 +1,9 @@
 package tm
 
 import (
+	"bytes"
+	"container/heap"
 	"io"
-	"sort"
 )
 
 type frameElem struct {
@@ -10,83 +11,195 @@ type frameElem struct {
 	frame *Frame
 	bfr   *BufferedFrameReader
 	index int
+	key   []byte // the tie key of frame, when the heap has a tieKey
 }
 
-// frameSorter is used to do the merge sort
-type frameSorter []*frameElem
+// TieBreaker orders two frames that have the same Tm(). Tie
+// returns a negative number if a should be output first, a
+// positive number if b should be, and zero if it has no
+// preference, in which case the frame from the earlier input
+// stream goes first.
+type TieBreaker interface {
+	Tie(a, b *Frame) int
+}
+
+// TieFunc lets an ordinary function serve as a TieBreaker.
+type TieFunc func(a, b *Frame) int
+
+// Tie returns t(a, b).
+func (t TieFunc) Tie(a, b *Frame) int { return t(a, b) }
+
+// TieKey is a TieBreaker that orders ties by a key computed from
+// each frame alone, compared with bytes.Compare. The merge engine
+// computes the key once per frame, as it arrives, rather than on
+// every comparison.
+type TieKey func(f *Frame) []byte
+
+// Tie compares the keys of a and b.
+func (k TieKey) Tie(a, b *Frame) int { return bytes.Compare(k(a), k(b)) }
+
+// TieByEvtnum puts the lower event number first.
+var TieByEvtnum = TieFunc(func(a, b *Frame) int {
+	return int(a.GetEvtnum()) - int(b.GetEvtnum())
+})
+
+// TieByPayloadHash orders ties by the Blake2b hash of the frames,
+// so the output order depends only on frame content, and not on
+// which input stream a frame arrived on.
+var TieByPayloadHash = TieKey(func(f *Frame) []byte {
+	return f.Blake2b()
+})
+
+// mergeHeap is a min-heap of the next frame from each live
+// input, ordered by Tm(), then TieBreak, then input index.
+type mergeHeap struct {
+	elems  []*frameElem
+	tie    TieBreaker
+	tieKey TieKey
+}
+
+// setFrame makes f the next frame of e, with its tie key.
+func (h *mergeHeap) setFrame(e *frameElem, f *Frame) {
+	e.frame = f
+	if h.tieKey != nil {
+		e.key = h.tieKey(f)
+	}
+}
 
-// Len is the sorting Len function
-func (p frameSorter) Len() int { return len(p) }
+func (h *mergeHeap) Len() int { return len(h.elems) }
 
-// Less is the sorting Less function.
-func (p frameSorter) Less(i, j int) bool {
-	// sort nil to end
-	if p[i] == nil {
-		return false
+func (h *mergeHeap) Less(i, j int) bool {
+	a, b := h.elems[i], h.elems[j]
+	ta, tb := a.frame.Tm(), b.frame.Tm()
+	if ta != tb {
+		return ta < tb
 	}
-	if p[j] == nil {
-		return true
+	if h.tieKey != nil {
+		if c := bytes.Compare(a.key, b.key); c != 0 {
+			return c < 0
+		}
+	} else if h.tie != nil {
+		if c := h.tie.Tie(a.frame, b.frame); c != 0 {
+			return c < 0
+		}
 	}
-	return p[i].frame.Tm() < p[j].frame.Tm()
+	return a.index < b.index
 }
 
-// Swap is the sorting Swap function.
-func (p frameSorter) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (h *mergeHeap) Swap(i, j int) { h.elems[i], h.elems[j] = h.elems[j], h.elems[i] }
 
-// Merge merges the strms input into timestamp order, based on
-// the Frame.Tm() timestamp, and writes the ordered sequence
-// out to the fw.Out io.writer.
-func (fw *FrameWriter) Merge(strms ...*BufferedFrameReader) error {
+func (h *mergeHeap) Push(x interface{}) { h.elems = append(h.elems, x.(*frameElem)) }
 
-	n := len(strms)
-	peeks := make([]*frameElem, n)
-	for i := 0; i < n; i++ {
-		peeks[i] = &frameElem{bfr: strms[i], index: i}
-	}
+func (h *mergeHeap) Pop() interface{} {
+	n := len(h.elems)
+	x := h.elems[n-1]
+	h.elems = h.elems[:n-1]
+	return x
+}
 
-	var err error
+// FrameMerger is the k-way merge engine behind FrameWriter.Merge
+// and FrameChWriter.Merge. It keeps the next frame of each input
+// in a min-heap, so each output frame costs O(log k) for k inputs.
+// Frames with equal Tm() are ordered by the TieBreaker, if any,
+// and then by input position, so the output is deterministic.
+type FrameMerger struct {
+	h mergeHeap
+}
 
-	// initialize the Frames in peeks
-	newlist := []*frameElem{}
-	for i := range peeks {
-		peeks[i].frame, err = peeks[i].bfr.Peek()
+// NewFrameMerger peeks at the first frame of each of strms, which
+// must each be in time order. tie may be nil to break ties by
+// input position alone. A TieKey tie has its key computed once
+// per frame.
+func NewFrameMerger(tie TieBreaker, strms ...*BufferedFrameReader) (*FrameMerger, error) {
+	m := &FrameMerger{h: mergeHeap{tie: tie}}
+	if k, ok := tie.(TieKey); ok {
+		m.h.tieKey = k
+	}
+	for i, s := range strms {
+		f, err := s.Peek()
 		if err != nil {
 			if err == io.EOF {
-				// peeks[i].frame will be nil.
-				//p("err = %v, omitting peeks[i=%v] from newlist", err, i)
-			} else {
-				return err
+				continue
 			}
-		} else {
-			newlist = append(newlist, peeks[i])
+			return nil, err
 		}
+		e := &frameElem{bfr: s, index: i, name: s.Name}
+		m.h.setFrame(e, f)
+		m.h.elems = append(m.h.elems, e)
 	}
-	peeks = newlist
+	heap.Init(&m.h)
+	return m, nil
+}
 
-	for len(peeks) > 0 {
-		if len(peeks) == 1 {
-			// just copy over the rest of this stream and we're done
-			//p("down to just one (%v), copying it directly over", peeks[0].index)
-			_, err = peeks[0].bfr.WriteTo(fw)
-			return err
+// Live returns the number of inputs not yet exhausted.
+func (m *FrameMerger) Live() int {
+	return len(m.h.elems)
+}
+
+// Next returns a copy of the next frame in merged order, along
+// with the index of the input stream it came from. At the end
+// of all inputs, Next returns io.EOF.
+func (m *FrameMerger) Next() (*Frame, int, error) {
+	if len(m.h.elems) == 0 {
+		return nil, -1, io.EOF
+	}
+	top := m.h.elems[0]
+	cp := *(top.frame)
+	idx := top.index
+
+	top.bfr.Advance()
+	f, err := top.bfr.Peek()
+	if err != nil {
+		if err != io.EOF {
+			return &cp, idx, err
 		}
-		// have 2 or more source left, sort and pick the earliest
-		sort.Sort(frameSorter(peeks))
-		// copy frame and add it to fw
-		cp := *(peeks[0].frame)
-		fw.Append(&cp)
-		peeks[0].bfr.Advance()
-		peeks[0].frame, err = peeks[0].bfr.Peek()
+		heap.Pop(&m.h)
+	} else {
+		m.h.setFrame(top, f)
+		heap.Fix(&m.h, 0)
+	}
+	return &cp, idx, nil
+}
+
+// last returns the only remaining input, when Live() == 1.
+func (m *FrameMerger) last() *frameElem {
+	return m.h.elems[0]
+}
+
+// Merge merges the strms input into timestamp order, based on
+// the Frame.Tm() timestamp, and writes the ordered sequence
+// out to the fw.Out io.writer. Frames with equal timestamps
+// are written in the order of their input streams.
+func (fw *FrameWriter) Merge(strms ...*BufferedFrameReader) error {
+	return fw.MergeBy(nil, strms...)
+}
+
+// MergeBy is Merge, with ties between equal timestamps from
+// different streams ordered by tie first, and by input
+// position second.
+func (fw *FrameWriter) MergeBy(tie TieBreaker, strms ...*BufferedFrameReader) error {
+	m, err := NewFrameMerger(tie, strms...)
+	if err != nil {
+		return err
+	}
+	for m.Live() > 1 {
+		f, _, err := m.Next()
 		if err != nil {
-			if err == io.EOF {
-				//p("saw err '%v',  finished with stream %v", err, peeks[0].index)
-				peeks = peeks[1:]
-			} else {
+			return err
+		}
+		fw.Append(f)
+		if len(fw.Frames) >= 1000 {
+			if err = fw.Flush(); err != nil {
 				return err
 			}
 		}
 	}
-	return nil
+	if m.Live() == 1 {
+		// just copy over the rest of this stream and we're done
+		_, err = m.last().bfr.WriteTo(fw)
+		return err
+	}
+	return fw.Flush()
 }
 
 // Syncable allows us to sync os.File to disk, if they
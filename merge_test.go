@@ -1,8 +1,11 @@
 package tm
 
 import (
+	"bytes"
 	"fmt"
 	cv "github.com/glycerine/goconvey/convey"
+	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"testing"
@@ -67,3 +70,139 @@ func FilesDiff(a, b string) bool {
 	co, _ := exec.Command("diff", a, b).CombinedOutput()
 	return len(co) != 0
 }
+
+// tieStreams makes k streams of n frames each. Every stream has a
+// frame at each of the same n timestamps, so every output frame
+// ties with k-1 others. Ude holds the stream number, and the
+// evtnum counts down with the stream number.
+func tieStreams(k, n int) [][]byte {
+	res := make([][]byte, k)
+	for s := 0; s < k; s++ {
+		frames := make([]*Frame, n)
+		for i := range frames {
+			frames[i] = GenTestFrame(i, EvTwo64, float64(i), int64(s), nil)
+		}
+		var buf bytes.Buffer
+		panicOn(writeFrames(&buf, frames))
+		res[s] = buf.Bytes()
+	}
+	return res
+}
+
+func readersFor(bufs [][]byte) []*BufferedFrameReader {
+	strms := make([]*BufferedFrameReader, len(bufs))
+	for i, by := range bufs {
+		strms[i] = NewBufferedFrameReader(bytes.NewBuffer(by), 1024, fmt.Sprintf("s%d", i))
+	}
+	return strms
+}
+
+func Test130HeapMergeBreaksTiesDeterministically(t *testing.T) {
+
+	cv.Convey("FrameWriter.Merge should order equal timestamps by input position", t, func() {
+		k, n := 7, 20
+		var out bytes.Buffer
+		fw := NewFrameWriter(&out, 1024)
+		panicOn(fw.Merge(readersFor(tieStreams(k, n))...))
+
+		fr := NewFrameReader(&out, 1024)
+		for i := 0; i < n*k; i++ {
+			f, _, err, _ := fr.NextFrame(nil)
+			panicOn(err)
+			cv.So(f.V0, cv.ShouldEqual, float64(i/k))
+			cv.So(f.Ude, cv.ShouldEqual, int64(i%k))
+		}
+		_, _, err, _ := fr.NextFrame(nil)
+		cv.So(err, cv.ShouldEqual, io.EOF)
+	})
+
+	cv.Convey("A caller supplied TieBreaker should take precedence over input position", t, func() {
+		k, n := 5, 3
+		// stream s carries evtnum 2000 + (k - s), so evtnum order reverses stream order.
+		bufs := make([][]byte, k)
+		for s := 0; s < k; s++ {
+			var buf bytes.Buffer
+			for i := 0; i < n; i++ {
+				by, err := GenTestFrame(i, Evtnum(2000+k-s), 0, 0, []byte(fmt.Sprintf(`{"s":%d}`, s))).Marshal(nil)
+				panicOn(err)
+				buf.Write(by)
+			}
+			bufs[s] = buf.Bytes()
+		}
+		m, err := NewFrameMerger(TieByEvtnum, readersFor(bufs)...)
+		panicOn(err)
+		for i := 0; i < n*k; i++ {
+			f, idx, err := m.Next()
+			panicOn(err)
+			cv.So(idx, cv.ShouldEqual, k-1-i%k)
+			cv.So(f.GetEvtnum(), cv.ShouldEqual, Evtnum(2000+1+i%k))
+		}
+		_, _, err = m.Next()
+		cv.So(err, cv.ShouldEqual, io.EOF)
+
+		// hashing gives the same order whichever way the inputs are listed.
+		order := func(bufs [][]byte) []string {
+			return mergeOrder(TieByPayloadHash, bufs)
+		}
+		rev := make([][]byte, k)
+		for i := range bufs {
+			rev[k-1-i] = bufs[i]
+		}
+		cv.So(order(bufs), cv.ShouldResemble, order(rev))
+
+		// the hashes cached by the heap order ties just as
+		// comparing the frames each time does.
+		uncached := TieFunc(TieByPayloadHash.Tie)
+		cv.So(mergeOrder(uncached, bufs), cv.ShouldResemble, order(bufs))
+
+		// any TieKey, not just TieByPayloadHash, is computed once per frame.
+		calls := 0
+		key := TieKey(func(f *Frame) []byte {
+			calls++
+			return f.Blake2b()
+		})
+		cv.So(mergeOrder(key, bufs), cv.ShouldResemble, order(bufs))
+		cv.So(calls, cv.ShouldEqual, n*k)
+	})
+}
+
+// mergeOrder returns the payloads of bufs, merged with tie.
+func mergeOrder(tie TieBreaker, bufs [][]byte) []string {
+	m, err := NewFrameMerger(tie, readersFor(bufs)...)
+	panicOn(err)
+	res := []string{}
+	for {
+		f, _, err := m.Next()
+		if err != nil {
+			return res
+		}
+		res = append(res, string(f.Data))
+	}
+}
+
+func benchmarkMerge(b *testing.B, k int) {
+	benchmarkMergeBy(b, k, nil)
+}
+
+func benchmarkMergeBy(b *testing.B, k int, tie TieBreaker) {
+	n := 100
+	bufs := tieStreams(k, n)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fw := NewFrameWriter(ioutil.Discard, 1024)
+		err := fw.MergeBy(tie, readersFor(bufs)...)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMerge10Streams(b *testing.B)   { benchmarkMerge(b, 10) }
+func BenchmarkMerge100Streams(b *testing.B)  { benchmarkMerge(b, 100) }
+func BenchmarkMerge500Streams(b *testing.B)  { benchmarkMerge(b, 500) }
+func BenchmarkMerge1000Streams(b *testing.B) { benchmarkMerge(b, 1000) }
+
+func BenchmarkMerge500StreamsByHash(b *testing.B) {
+	benchmarkMergeBy(b, 500, TieByPayloadHash)
+}
@@ -0,0 +1,266 @@
+package tm
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/glycerine/zebrapack/zebra"
+	"github.com/ugorji/go/codec"
+)
+
+// IsJsonEvtnum reports whether frames with evtnum e carry a
+// JSON payload: EvJson, or the user range 2000-9999 that
+// DisplayFrame also treats as JSON.
+func IsJsonEvtnum(e Evtnum) bool {
+	return e == EvJson || (e >= 2000 && e <= 9999)
+}
+
+// HasStructuredPayload reports whether DecodePayload knows
+// how to decode the payload of frames with evtnum e.
+func HasStructuredPayload(e Evtnum) bool {
+	return IsJsonEvtnum(e) || e == EvMsgpack || e == EvMsgpKafka || e == EvZebraPack
+}
+
+// DecodePayload decodes the JSON, msgpack, or ZebraPack payload
+// of frame into generic Go values: maps are map[string]interface{},
+// arrays are []interface{}, and scalars are strings, numbers, bools
+// or nil. JSON numbers decode as float64; msgpack integers as
+// int64. ZebraPack payloads need zSchema, and are translated to
+// msgpack first, as DisplayFrame does.
+func (frame *Frame) DecodePayload(zSchema *zebra.Schema) (interface{}, error) {
+	evtnum := frame.GetEvtnum()
+	var iface interface{}
+	switch {
+	case IsJsonEvtnum(evtnum):
+		err := json.Unmarshal(frame.Data, &iface)
+		if err != nil {
+			return nil, fmt.Errorf("DecodePayload: bad JSON payload: %v", err)
+		}
+		return iface, nil
+	case evtnum == EvMsgpack || evtnum == EvMsgpKafka:
+		dec := codec.NewDecoderBytes(frame.Data, &msgpHelper.mh)
+		err := dec.Decode(&iface)
+		if err != nil {
+			return nil, fmt.Errorf("DecodePayload: bad msgpack payload: %v", err)
+		}
+		return iface, nil
+	case evtnum == EvZebraPack:
+		if zSchema == nil {
+			return nil, fmt.Errorf("DecodePayload: ZebraPack payload needs a schema")
+		}
+		m2, _, err := zSchema.ZebraToMsgp2(frame.Data, true)
+		if err != nil {
+			return nil, fmt.Errorf("DecodePayload: bad ZebraPack payload: %v", err)
+		}
+		dec := codec.NewDecoderBytes(m2, &msgpHelper.mh)
+		err = dec.Decode(&iface)
+		if err != nil {
+			return nil, fmt.Errorf("DecodePayload: bad ZebraPack payload: %v", err)
+		}
+		return iface, nil
+	}
+	return nil, fmt.Errorf("DecodePayload: evtnum %v has no structured payload", evtnum)
+}
+
+// FieldPath addresses a value inside a decoded payload, using
+// dotted keys and bracketed array indexes, as in "a.b[2].c".
+type FieldPath struct {
+	Src   string
+	steps []pathStep
+}
+
+type pathStep struct {
+	key   string
+	index int
+	isIdx bool
+}
+
+// ParseFieldPath parses a path such as "host", "a.b[2].c", or "[0].id".
+func ParseFieldPath(src string) (*FieldPath, error) {
+	p := &FieldPath{Src: src}
+	if src == "" {
+		return nil, fmt.Errorf("empty field path")
+	}
+	s := src
+	for len(s) > 0 {
+		switch s[0] {
+		case '.':
+			if len(p.steps) == 0 {
+				return nil, fmt.Errorf("field path '%s': cannot start with '.'", src)
+			}
+			s = s[1:]
+			if len(s) == 0 || s[0] == '.' || s[0] == '[' {
+				return nil, fmt.Errorf("field path '%s': empty key", src)
+			}
+		case '[':
+			end := strings.IndexByte(s, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("field path '%s': missing ']'", src)
+			}
+			k, err := strconv.Atoi(s[1:end])
+			if err != nil || k < 0 {
+				return nil, fmt.Errorf("field path '%s': bad index '%s'", src, s[1:end])
+			}
+			p.steps = append(p.steps, pathStep{index: k, isIdx: true})
+			s = s[end+1:]
+			if len(s) > 0 && s[0] != '.' && s[0] != '[' {
+				return nil, fmt.Errorf("field path '%s': expected '.' or '[' after ']'", src)
+			}
+		default:
+			end := strings.IndexAny(s, ".[")
+			if end < 0 {
+				end = len(s)
+			}
+			p.steps = append(p.steps, pathStep{key: s[:end]})
+			s = s[end:]
+		}
+	}
+	return p, nil
+}
+
+// String returns the source form of the path.
+func (p *FieldPath) String() string {
+	return p.Src
+}
+
+// Lookup follows the path into v, a value returned by
+// DecodePayload. It returns false if any step is missing.
+func (p *FieldPath) Lookup(v interface{}) (interface{}, bool) {
+	for _, st := range p.steps {
+		if st.isIdx {
+			arr, ok := v.([]interface{})
+			if !ok || st.index >= len(arr) {
+				return nil, false
+			}
+			v = arr[st.index]
+			continue
+		}
+		switch m := v.(type) {
+		case map[string]interface{}:
+			x, ok := m[st.key]
+			if !ok {
+				return nil, false
+			}
+			v = x
+		case map[interface{}]interface{}:
+			x, ok := m[st.key]
+			if !ok {
+				return nil, false
+			}
+			v = x
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// LookupFrame decodes the payload of frame and follows the path into it.
+func (p *FieldPath) LookupFrame(frame *Frame, zSchema *zebra.Schema) (interface{}, bool) {
+	if !HasStructuredPayload(frame.GetEvtnum()) {
+		return nil, false
+	}
+	v, err := frame.DecodePayload(zSchema)
+	if err != nil {
+		return nil, false
+	}
+	return p.Lookup(v)
+}
+
+// PayloadString renders a decoded payload value as text: strings
+// as is, numbers in their shortest form, and maps and arrays
+// as JSON.
+func PayloadString(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return x
+	case []byte:
+		return string(x)
+	case float64:
+		return strconv.FormatFloat(x, 'g', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(x), 'g', -1, 32)
+	case int64, uint64, int, bool:
+		return fmt.Sprintf("%v", x)
+	}
+	by, err := PayloadJSON(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(by)
+}
+
+// PayloadJSON renders a decoded payload value as JSON. Unlike
+// json.Marshal, it accepts the map[interface{}]interface{} maps
+// that msgpack decodes to, and writes []byte as a string.
+func PayloadJSON(v interface{}) ([]byte, error) {
+	return json.Marshal(jsonable(v))
+}
+
+func jsonable(v interface{}) interface{} {
+	switch x := v.(type) {
+	case []byte:
+		return string(x)
+	case []interface{}:
+		y := make([]interface{}, len(x))
+		for i := range x {
+			y[i] = jsonable(x[i])
+		}
+		return y
+	case map[string]interface{}:
+		y := make(map[string]interface{}, len(x))
+		for k, e := range x {
+			y[k] = jsonable(e)
+		}
+		return y
+	case map[interface{}]interface{}:
+		y := make(map[string]interface{}, len(x))
+		for k, e := range x {
+			y[PayloadString(k)] = jsonable(e)
+		}
+		return y
+	}
+	return v
+}
+
+// ExtractFields decodes the payload of frame once, and looks up each
+// of paths in it. found[i] reports whether paths[i] was present.
+// Frames without a structured payload, or with one that does not
+// decode, have no fields.
+func ExtractFields(frame *Frame, paths []*FieldPath, zSchema *zebra.Schema) (vals []interface{}, found []bool) {
+	vals = make([]interface{}, len(paths))
+	found = make([]bool, len(paths))
+	if !HasStructuredPayload(frame.GetEvtnum()) {
+		return
+	}
+	v, err := frame.DecodePayload(zSchema)
+	if err != nil {
+		return
+	}
+	for i, p := range paths {
+		vals[i], found[i] = p.Lookup(v)
+	}
+	return
+}
+
+// PayloadFloat converts a decoded payload value to a number,
+// if it is one. Numeric strings do not count.
+func PayloadFloat(v interface{}) (float64, bool) {
+	switch x := v.(type) {
+	case float64:
+		return x, true
+	case float32:
+		return float64(x), true
+	case int64:
+		return float64(x), true
+	case uint64:
+		return float64(x), true
+	case int:
+		return float64(x), true
+	}
+	return 0, false
+}
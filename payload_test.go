@@ -0,0 +1,50 @@
+package tm
+
+import (
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+	"github.com/ugorji/go/codec"
+)
+
+func Test220ExtractFieldsFromPayloads(t *testing.T) {
+
+	paths := []*FieldPath{}
+	for _, src := range []string{"a.b[2].c", "n", "missing"} {
+		p, err := ParseFieldPath(src)
+		panicOn(err)
+		paths = append(paths, p)
+	}
+
+	cv.Convey("ExtractFields should pull nested fields, numbers, and arrays out of JSON payloads", t, func() {
+		f := GenTestFrame(0, EvJson, 0, 0, []byte(`{"a":{"b":[0,{"c":1},{"c":{"d":[1,"x"]}}]},"n":2.5}`))
+		vals, found := ExtractFields(f, paths, nil)
+		cv.So(found, cv.ShouldResemble, []bool{true, true, false})
+		cv.So(PayloadString(vals[0]), cv.ShouldEqual, `{"d":[1,"x"]}`)
+		cv.So(vals[1], cv.ShouldEqual, 2.5)
+	})
+
+	cv.Convey("ExtractFields should read msgpack payloads, and PayloadJSON should render their maps", t, func() {
+		var by []byte
+		enc := codec.NewEncoderBytes(&by, &msgpHelper.mh)
+		panicOn(enc.Encode(map[string]interface{}{
+			"a": map[string]interface{}{"b": []interface{}{0, 1, map[string]interface{}{"c": map[string]interface{}{"k": "v"}}}},
+			"n": 7,
+		}))
+		f := GenTestFrame(0, EvMsgpack, 0, 0, by)
+		vals, found := ExtractFields(f, paths, nil)
+		cv.So(found, cv.ShouldResemble, []bool{true, true, false})
+		js, err := PayloadJSON(vals[0])
+		panicOn(err)
+		cv.So(string(js), cv.ShouldEqual, `{"k":"v"}`)
+		x, ok := PayloadFloat(vals[1])
+		cv.So(ok, cv.ShouldBeTrue)
+		cv.So(x, cv.ShouldEqual, 7)
+	})
+
+	cv.Convey("ExtractFields should find nothing in frames without a structured payload", t, func() {
+		f := GenTestFrame(0, EvOneFloat64, 3, 0, nil)
+		_, found := ExtractFields(f, paths, nil)
+		cv.So(found, cv.ShouldResemble, []bool{false, false, false})
+	})
+}
@@ -0,0 +1,620 @@
+package tm
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/glycerine/zebrapack/zebra"
+)
+
+// Predicate is a compiled boolean expression over the fields of a
+// frame, such as
+//
+//	evtnum == -37 && v0 > 100.5 && time >= 2016-05-01T09:30 && json.symbol == "AAPL"
+//
+// Operands are
+//
+//	evtnum, pti          the frame's evtnum and PTI, as numbers
+//	v0                   the float64 of EvOneFloat64 and EvTwo64 frames
+//	v1                   the int64 of EvOneInt64 and EvTwo64 frames
+//	time                 the timestamp; compare with times such as
+//	                     2016-05-01, 2016-05-01T09:30, or full
+//	                     RFC3339 (UTC unless a zone is given)
+//	len                  the payload length in bytes
+//	json.a.b[2].c        a payload field path (see ParseFieldPath);
+//	msgpack.x, zebra.x   the json, msgpack, and zebra prefixes only
+//	payload.x            look in payloads of that kind, payload in any
+//	numbers, "strings" or 'strings', true, false
+//
+// Comparisons are == != < <= > >=, and =~ !~ to match a regex
+// given as a string. Numbers compare as numbers, times as times,
+// and anything else as text. Conditions combine with && || ! (or
+// and, or, not) and parentheses. The PTI tests is_na, is_null,
+// is_nan, and is_ude take no argument; exists(path) tests for
+// a payload field. A comparison involving an absent operand,
+// such as v0 on an EvJson frame or a missing field, is false.
+//
+// A Predicate is safe for concurrent use.
+type Predicate struct {
+	Src     string
+	ZSchema *zebra.Schema // for zebra. and payload. paths
+	root    predNode
+}
+
+// ParsePredicate compiles src.
+func ParsePredicate(src string) (*Predicate, error) {
+	p := &predParser{src: src}
+	err := p.lex()
+	if err != nil {
+		return nil, err
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("filter expression '%s': unexpected '%s'", src, p.toks[p.pos].text)
+	}
+	return &Predicate{Src: src, root: root}, nil
+}
+
+// Match reports whether f satisfies the predicate.
+func (p *Predicate) Match(f *Frame) bool {
+	c := &predCtx{f: f, zSchema: p.ZSchema}
+	return p.root.eval(c)
+}
+
+// predCtx decodes the payload of f at most once, on demand.
+type predCtx struct {
+	f        *Frame
+	zSchema  *zebra.Schema
+	decoded  bool
+	payload  interface{}
+	decodeOK bool
+}
+
+func (c *predCtx) decode() (interface{}, bool) {
+	if !c.decoded {
+		c.decoded = true
+		if HasStructuredPayload(c.f.GetEvtnum()) {
+			v, err := c.f.DecodePayload(c.zSchema)
+			c.payload, c.decodeOK = v, err == nil
+		}
+	}
+	return c.payload, c.decodeOK
+}
+
+// predVal is an operand value. kind is 'n' number,
+// 's' string, 't' time (in tm), or 'b' bool.
+type predVal struct {
+	kind byte
+	num  float64
+	str  string
+	tm   int64
+	b    bool
+}
+
+func (v predVal) text() string {
+	switch v.kind {
+	case 'n':
+		return strconv.FormatFloat(v.num, 'g', -1, 64)
+	case 't':
+		return time.Unix(0, v.tm).UTC().Format(time.RFC3339Nano)
+	case 'b':
+		return strconv.FormatBool(v.b)
+	}
+	return v.str
+}
+
+// payloadVal converts a decoded payload value. JSON null is absent.
+func payloadVal(x interface{}) (predVal, bool) {
+	switch y := x.(type) {
+	case nil:
+		return predVal{}, false
+	case bool:
+		return predVal{kind: 'b', b: y}, true
+	case string:
+		return predVal{kind: 's', str: y}, true
+	case []byte:
+		return predVal{kind: 's', str: string(y)}, true
+	}
+	if n, ok := PayloadFloat(x); ok {
+		return predVal{kind: 'n', num: n}, true
+	}
+	return predVal{kind: 's', str: PayloadString(x)}, true
+}
+
+type predNode interface {
+	eval(c *predCtx) bool
+}
+
+type valNode interface {
+	value(c *predCtx) (predVal, bool)
+}
+
+type predAnd struct{ l, r predNode }
+
+func (n *predAnd) eval(c *predCtx) bool { return n.l.eval(c) && n.r.eval(c) }
+
+type predOr struct{ l, r predNode }
+
+func (n *predOr) eval(c *predCtx) bool { return n.l.eval(c) || n.r.eval(c) }
+
+type predNot struct{ x predNode }
+
+func (n *predNot) eval(c *predCtx) bool { return !n.x.eval(c) }
+
+// predPTI tests the frame's PTI.
+type predPTI PTI
+
+func (n predPTI) eval(c *predCtx) bool { return c.f.GetPTI() == PTI(n) }
+
+// predExists tests for a payload field.
+type predExists struct{ path *predPath }
+
+func (n *predExists) eval(c *predCtx) bool {
+	_, ok := n.path.value(c)
+	return ok
+}
+
+// predTruth tests a bare operand, which must be a true bool.
+type predTruth struct{ x valNode }
+
+func (n *predTruth) eval(c *predCtx) bool {
+	v, ok := n.x.value(c)
+	return ok && v.kind == 'b' && v.b
+}
+
+type predCmp struct {
+	op   string
+	l, r valNode
+	re   *regexp.Regexp // for =~ and !~
+}
+
+func (n *predCmp) eval(c *predCtx) bool {
+	a, ok := n.l.value(c)
+	if !ok {
+		return false
+	}
+	if n.re != nil {
+		return n.re.MatchString(a.text()) == (n.op == "=~")
+	}
+	b, ok := n.r.value(c)
+	if !ok {
+		return false
+	}
+	// bring times and their text forms together
+	if a.kind == 't' && b.kind == 's' {
+		if t, err := parsePredTime(b.str); err == nil {
+			b = predVal{kind: 't', tm: t}
+		}
+	} else if b.kind == 't' && a.kind == 's' {
+		if t, err := parsePredTime(a.str); err == nil {
+			a = predVal{kind: 't', tm: t}
+		}
+	}
+	var cmp int
+	switch {
+	case a.kind == 'n' && b.kind == 'n':
+		cmp = cmpFloat(a.num, b.num)
+	case a.kind == 't' && b.kind == 't':
+		cmp = cmpInt(a.tm, b.tm)
+	case a.kind == 'b' && b.kind == 'b':
+		if n.op != "==" && n.op != "!=" {
+			return false
+		}
+		if a.b != b.b {
+			cmp = 1
+		}
+	default:
+		cmp = strings.Compare(a.text(), b.text())
+	}
+	switch n.op {
+	case "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+func cmpFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func cmpInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+type predLit predVal
+
+func (n predLit) value(c *predCtx) (predVal, bool) { return predVal(n), true }
+
+// predField is one of the frame's own fields.
+type predField string
+
+func (n predField) value(c *predCtx) (predVal, bool) {
+	f := c.f
+	switch string(n) {
+	case "evtnum":
+		return predVal{kind: 'n', num: float64(f.GetEvtnum())}, true
+	case "pti":
+		return predVal{kind: 'n', num: float64(f.GetPTI())}, true
+	case "time":
+		return predVal{kind: 't', tm: f.Tm()}, true
+	case "len":
+		return predVal{kind: 'n', num: float64(len(f.Data))}, true
+	case "v0":
+		switch f.GetPTI() {
+		case PtiZero, PtiOneFloat64, PtiTwo64:
+			return predVal{kind: 'n', num: f.GetV0()}, true
+		}
+	case "v1":
+		switch f.GetPTI() {
+		case PtiOneInt64, PtiTwo64:
+			return predVal{kind: 'n', num: float64(f.Ude)}, true
+		}
+	}
+	return predVal{}, false
+}
+
+// predPath is a payload field path, limited to payloads of one
+// kind ("json", "msgpack", "zebra") or of any kind ("payload").
+type predPath struct {
+	kind string
+	path *FieldPath
+}
+
+func (n *predPath) value(c *predCtx) (predVal, bool) {
+	ev := c.f.GetEvtnum()
+	switch n.kind {
+	case "json":
+		if !IsJsonEvtnum(ev) {
+			return predVal{}, false
+		}
+	case "msgpack":
+		if ev != EvMsgpack && ev != EvMsgpKafka {
+			return predVal{}, false
+		}
+	case "zebra":
+		if ev != EvZebraPack {
+			return predVal{}, false
+		}
+	}
+	v, ok := c.decode()
+	if !ok {
+		return predVal{}, false
+	}
+	x, ok := n.path.Lookup(v)
+	if !ok {
+		return predVal{}, false
+	}
+	return payloadVal(x)
+}
+
+var predTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04",
+	"2006-01-02T15",
+	"2006-01-02",
+}
+
+func parsePredTime(s string) (int64, error) {
+	for _, layout := range predTimeLayouts {
+		t, err := time.ParseInLocation(layout, s, time.UTC)
+		if err == nil {
+			return t.UnixNano(), nil
+		}
+	}
+	return 0, fmt.Errorf("bad time '%s'", s)
+}
+
+type predTok struct {
+	kind byte // 'n' number, 's' string, 't' time, 'i' identifier, 'o' operator, or the paren/comma itself
+	text string
+	val  predVal
+}
+
+type predParser struct {
+	src  string
+	toks []predTok
+	pos  int
+}
+
+var predTimeRe = regexp.MustCompile(`^\d{4}-\d\d-\d\d(T[0-9:.]+)?(Z|[+-]\d\d:\d\d)?`)
+
+func (p *predParser) lex() error {
+	s := p.src
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case strings.ContainsRune("(),", c):
+			p.toks = append(p.toks, predTok{kind: byte(c), text: string(c)})
+			i++
+		case strings.ContainsRune("=!<>&|", c):
+			op := s[i : i+1]
+			if i+1 < len(s) {
+				switch two := s[i : i+2]; two {
+				case "==", "!=", "<=", ">=", "=~", "!~", "&&", "||":
+					op = two
+				}
+			}
+			if op == "=" || op == "&" || op == "|" {
+				return fmt.Errorf("filter expression '%s': unknown operator '%s'", p.src, op)
+			}
+			p.toks = append(p.toks, predTok{kind: 'o', text: op})
+			i += len(op)
+		case c == '"' || c == '\'':
+			j := i + 1
+			var sb bytes.Buffer
+			for ; j < len(s) && rune(s[j]) != c; j++ {
+				if s[j] == '\\' && j+1 < len(s) {
+					j++
+				}
+				sb.WriteByte(s[j])
+			}
+			if j >= len(s) {
+				return fmt.Errorf("filter expression '%s': unterminated string", p.src)
+			}
+			p.toks = append(p.toks, predTok{kind: 's', text: s[i : j+1], val: predVal{kind: 's', str: sb.String()}})
+			i = j + 1
+		case unicode.IsDigit(c) || c == '.':
+			if m := predTimeRe.FindString(s[i:]); m != "" {
+				t, err := parsePredTime(m)
+				if err != nil {
+					return fmt.Errorf("filter expression '%s': %v", p.src, err)
+				}
+				p.toks = append(p.toks, predTok{kind: 't', text: m, val: predVal{kind: 't', tm: t}})
+				i += len(m)
+				continue
+			}
+			j := i
+			for j < len(s) && (unicode.IsDigit(rune(s[j])) || s[j] == '.' || s[j] == 'e' || s[j] == 'E' ||
+				((s[j] == '-' || s[j] == '+') && j > i && (s[j-1] == 'e' || s[j-1] == 'E'))) {
+				j++
+			}
+			x, err := strconv.ParseFloat(s[i:j], 64)
+			if err != nil {
+				return fmt.Errorf("filter expression '%s': bad number '%s'", p.src, s[i:j])
+			}
+			p.toks = append(p.toks, predTok{kind: 'n', text: s[i:j], val: predVal{kind: 'n', num: x}})
+			i = j
+		case unicode.IsLetter(c) || c == '_':
+			j := i
+			for j < len(s) && (unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j])) || strings.IndexByte("_.[]", s[j]) >= 0) {
+				j++
+			}
+			p.toks = append(p.toks, predTok{kind: 'i', text: s[i:j]})
+			i = j
+		case c == '-':
+			p.toks = append(p.toks, predTok{kind: '-', text: "-"})
+			i++
+		default:
+			return fmt.Errorf("filter expression '%s': unexpected character '%c'", p.src, c)
+		}
+	}
+	if len(p.toks) == 0 {
+		return fmt.Errorf("filter expression is empty")
+	}
+	return nil
+}
+
+// is reports whether the next token is the operator or keyword op.
+func (p *predParser) is(op string) bool {
+	if p.pos >= len(p.toks) {
+		return false
+	}
+	t := p.toks[p.pos]
+	return (t.kind == 'o' || t.kind == 'i') && t.text == op
+}
+
+// or := and { ('||' | 'or') and }
+func (p *predParser) parseOr() (predNode, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.is("||") || p.is("or") {
+		p.pos++
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = &predOr{l: l, r: r}
+	}
+	return l, nil
+}
+
+// and := not { ('&&' | 'and') not }
+func (p *predParser) parseAnd() (predNode, error) {
+	l, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.is("&&") || p.is("and") {
+		p.pos++
+		r, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l = &predAnd{l: l, r: r}
+	}
+	return l, nil
+}
+
+// not := ('!' | 'not') not | cond
+func (p *predParser) parseNot() (predNode, error) {
+	if p.is("!") || p.is("not") {
+		p.pos++
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &predNot{x: x}, nil
+	}
+	return p.parseCond()
+}
+
+var predPTITests = map[string]PTI{
+	"is_na":   PtiNA,
+	"is_null": PtiNull,
+	"is_nan":  PtiNaN,
+	"is_ude":  PtiUDE,
+}
+
+// cond := '(' or ')' | is_na | exists '(' path ')' | operand [cmpop operand]
+func (p *predParser) parseCond() (predNode, error) {
+	if p.pos >= len(p.toks) {
+		return nil, fmt.Errorf("filter expression '%s': unexpected end", p.src)
+	}
+	t := p.toks[p.pos]
+	if t.kind == '(' {
+		p.pos++
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expect(')'); err != nil {
+			return nil, err
+		}
+		return x, nil
+	}
+	if t.kind == 'i' {
+		if pti, ok := predPTITests[t.text]; ok {
+			p.pos++
+			if p.pos < len(p.toks) && p.toks[p.pos].kind == '(' {
+				p.pos++
+				if err := p.expect(')'); err != nil {
+					return nil, err
+				}
+			}
+			return predPTI(pti), nil
+		}
+		if t.text == "exists" {
+			p.pos++
+			if err := p.expect('('); err != nil {
+				return nil, err
+			}
+			x, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			path, ok := x.(*predPath)
+			if !ok {
+				return nil, fmt.Errorf("filter expression '%s': exists() takes a payload path, such as json.a.b", p.src)
+			}
+			if err = p.expect(')'); err != nil {
+				return nil, err
+			}
+			return &predExists{path: path}, nil
+		}
+	}
+
+	l, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos >= len(p.toks) || p.toks[p.pos].kind != 'o' {
+		return &predTruth{x: l}, nil
+	}
+	op := p.toks[p.pos].text
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=", "=~", "!~":
+	default:
+		return &predTruth{x: l}, nil
+	}
+	p.pos++
+	r, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	n := &predCmp{op: op, l: l, r: r}
+	if op == "=~" || op == "!~" {
+		lit, ok := r.(predLit)
+		if !ok || lit.kind != 's' {
+			return nil, fmt.Errorf("filter expression '%s': %s needs a quoted regex on its right", p.src, op)
+		}
+		n.re, err = regexp.Compile(lit.str)
+		if err != nil {
+			return nil, fmt.Errorf("filter expression '%s': bad regex: %v", p.src, err)
+		}
+	}
+	return n, nil
+}
+
+// operand := ['-'] number | string | time | true | false | field | path
+func (p *predParser) parseOperand() (valNode, error) {
+	if p.pos >= len(p.toks) {
+		return nil, fmt.Errorf("filter expression '%s': unexpected end", p.src)
+	}
+	t := p.toks[p.pos]
+	p.pos++
+	switch t.kind {
+	case '-':
+		if p.pos < len(p.toks) && p.toks[p.pos].kind == 'n' {
+			v := p.toks[p.pos].val
+			p.pos++
+			v.num = -v.num
+			return predLit(v), nil
+		}
+	case 'n', 's', 't':
+		return predLit(t.val), nil
+	case 'i':
+		switch t.text {
+		case "true", "false":
+			return predLit{kind: 'b', b: t.text == "true"}, nil
+		case "evtnum", "pti", "v0", "v1", "time", "len":
+			return predField(t.text), nil
+		}
+		dot := strings.IndexByte(t.text, '.')
+		if dot > 0 {
+			switch kind := t.text[:dot]; kind {
+			case "json", "msgpack", "zebra", "payload":
+				path, err := ParseFieldPath(t.text[dot+1:])
+				if err != nil {
+					return nil, fmt.Errorf("filter expression '%s': %v", p.src, err)
+				}
+				return &predPath{kind: kind, path: path}, nil
+			}
+		}
+		return nil, fmt.Errorf("filter expression '%s': unknown name '%s'; payload fields need a json., msgpack., zebra., or payload. prefix", p.src, t.text)
+	}
+	return nil, fmt.Errorf("filter expression '%s': unexpected '%s'", p.src, t.text)
+}
+
+func (p *predParser) expect(kind byte) error {
+	if p.pos >= len(p.toks) || p.toks[p.pos].kind != kind {
+		return fmt.Errorf("filter expression '%s': expected '%c'", p.src, kind)
+	}
+	p.pos++
+	return nil
+}
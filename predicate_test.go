@@ -0,0 +1,89 @@
+package tm
+
+import (
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+	"github.com/ugorji/go/codec"
+)
+
+func Test210PredicateLanguage(t *testing.T) {
+
+	match := func(src string, f *Frame) bool {
+		p, err := ParsePredicate(src)
+		panicOn(err)
+		return p.Match(f)
+	}
+
+	cv.Convey("ParsePredicate should reject malformed expressions", t, func() {
+		for _, bad := range []string{
+			"",
+			"v0 >",
+			"(v0 > 1",
+			"v0 = 1",
+			"symbol == 1",
+			"json.a =~ 3",
+			`json.a =~ "("`,
+			`json.a == "open`,
+			"v0 > 1 v1",
+			"exists(v0)",
+		} {
+			_, err := ParsePredicate(bad)
+			cv.So(err, cv.ShouldNotBeNil)
+		}
+	})
+
+	cv.Convey("Predicates should compare evtnum, v0, v1, pti, and len numerically, with && || ! and parentheses", t, func() {
+		two := GenTestFrame(0, EvTwo64, 100.5, 7, nil)
+		cv.So(match("evtnum == -37", two), cv.ShouldBeFalse)
+		cv.So(match("evtnum == 3", two), cv.ShouldBeTrue)
+		cv.So(match("v0 > 100 && v1 == 7", two), cv.ShouldBeTrue)
+		cv.So(match("v0 > 100.5 || v1 < 7", two), cv.ShouldBeFalse)
+		cv.So(match("!(v0 > 100.5) and not v1 >= 8", two), cv.ShouldBeTrue)
+		cv.So(match("pti == 3 && len == 0", two), cv.ShouldBeTrue)
+		cv.So(match("v0 > -1e3", two), cv.ShouldBeTrue)
+
+		// v0 and v1 are absent when the PTI does not carry them
+		one := GenTestFrame(0, EvOneInt64, 0, -5, nil)
+		cv.So(match("v1 == -5", one), cv.ShouldBeTrue)
+		cv.So(match("v0 == 0", one), cv.ShouldBeFalse)
+		cv.So(match("v0 != 0", one), cv.ShouldBeFalse)
+	})
+
+	cv.Convey("Predicates should test the PTI with is_na, is_null, is_nan, and is_ude", t, func() {
+		na := GenTestFrame(0, EvNA, 0, 0, nil)
+		cv.So(match("is_na", na), cv.ShouldBeTrue)
+		cv.So(match("is_na()", na), cv.ShouldBeTrue)
+		cv.So(match("is_null || is_nan", na), cv.ShouldBeFalse)
+		cv.So(match("is_ude", GenTestFrame(0, EvJson, 0, 0, []byte(`{}`))), cv.ShouldBeTrue)
+	})
+
+	cv.Convey("Predicates should compare time with dates, times, and strings", t, func() {
+		f := GenTestFrame(90, EvZero, 0, 0, nil)
+		cv.So(match("time >= 2016-03-10", f), cv.ShouldBeTrue)
+		cv.So(match("time > 2016-03-10T00:01", f), cv.ShouldBeTrue)
+		cv.So(match("time > 2016-03-10T00:01:30", f), cv.ShouldBeFalse)
+		cv.So(match("time == 2016-03-10T00:01:30Z", f), cv.ShouldBeTrue)
+		cv.So(match("time < 2016-03-09T20:00:00-05:00", f), cv.ShouldBeTrue)
+		cv.So(match(`time < "2016-03-10T00:01:29.5"`, f), cv.ShouldBeFalse)
+	})
+
+	cv.Convey("Predicates should reach into JSON and msgpack payloads by field path, with regex matching", t, func() {
+		j := GenTestFrame(0, EvJson, 0, 0, []byte(`{"symbol":"AAPL","price":101.25,"tags":["x","y"],"gone":null,"live":true}`))
+		cv.So(match(`json.symbol == "AAPL" && json.price > 100`, j), cv.ShouldBeTrue)
+		cv.So(match(`json.symbol =~ '^AA' && json.tags[1] !~ "x"`, j), cv.ShouldBeTrue)
+		cv.So(match(`json.tags[1] == "y"`, j), cv.ShouldBeTrue)
+		cv.So(match(`json.live && json.live == true`, j), cv.ShouldBeTrue)
+		cv.So(match(`exists(json.price) && !exists(json.gone) && !exists(json.tags[2])`, j), cv.ShouldBeTrue)
+		cv.So(match(`json.missing != "AAPL"`, j), cv.ShouldBeFalse)
+		cv.So(match(`msgpack.symbol == "AAPL"`, j), cv.ShouldBeFalse)
+
+		var by []byte
+		enc := codec.NewEncoderBytes(&by, &msgpHelper.mh)
+		panicOn(enc.Encode(map[string]interface{}{"id": 42, "who": map[string]interface{}{"name": "bob"}}))
+		m := GenTestFrame(0, EvMsgpack, 0, 0, by)
+		cv.So(match(`msgpack.id == 41`, m), cv.ShouldBeFalse)
+		cv.So(match(`msgpack.id == 42 && payload.who.name == "bob"`, m), cv.ShouldBeTrue)
+		cv.So(match(`json.id == 42`, m), cv.ShouldBeFalse)
+	})
+}
@@ -0,0 +1,216 @@
+package tm
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats"
+)
+
+// ErrPublishCanceled is returned by a FramePublisher
+// when its cancel channel is closed.
+var ErrPublishCanceled = fmt.Errorf("publish canceled")
+
+// ErrPublishStalled is returned by FlowControl when no
+// acknowledgement frees an in-flight slot within its Stall.
+var ErrPublishStalled = fmt.Errorf("publish stalled: consumer stopped acknowledging")
+
+// FramePublisher delivers the frames of a FrameChWriter merge.
+// source is the Name of the input stream the frame came from,
+// and datestr the date being replayed. Publish may block to push
+// back on a fast producer, but must give up with
+// ErrPublishCanceled once cancel (which may be nil) is closed.
+// Publish must copy f if it keeps it.
+type FramePublisher interface {
+	Publish(f *Frame, source string, datestr string, cancel <-chan struct{}) error
+}
+
+// Drainer is implemented by FramePublishers that can wait for
+// the delivery of everything published. FrameChWriter.Merge
+// calls Drain at the end, so that it does not return before
+// the consumer has all the frames.
+type Drainer interface {
+	Drain(cancel <-chan struct{}) error
+}
+
+// NatsChPublisher sends each frame, marshalled, as a nats.Msg on
+// Ch, with subject "tseries.replay." + source + "." + datestr.
+type NatsChPublisher struct {
+	Ch chan *nats.Msg
+}
+
+// Publish implements FramePublisher.
+func (p *NatsChPublisher) Publish(f *Frame, source string, datestr string, cancel <-chan struct{}) error {
+	by, err := f.Marshal(nil)
+	if err != nil {
+		return err
+	}
+	select {
+	case p.Ch <- &nats.Msg{Subject: "tseries.replay." + source + "." + datestr, Data: by}:
+		return nil
+	case <-cancel:
+		return ErrPublishCanceled
+	}
+}
+
+// FrameChPublisher sends a copy of each frame on Ch.
+type FrameChPublisher struct {
+	Ch chan *Frame
+}
+
+// Publish implements FramePublisher.
+func (p *FrameChPublisher) Publish(f *Frame, source string, datestr string, cancel <-chan struct{}) error {
+	cp := *f
+	select {
+	case p.Ch <- &cp:
+		return nil
+	case <-cancel:
+		return ErrPublishCanceled
+	}
+}
+
+// WriterPublisher writes each frame to W, as a TMFRAME stream.
+type WriterPublisher struct {
+	W   io.Writer
+	buf []byte
+}
+
+// Publish implements FramePublisher.
+func (p *WriterPublisher) Publish(f *Frame, source string, datestr string, cancel <-chan struct{}) error {
+	select {
+	case <-cancel:
+		return ErrPublishCanceled
+	default:
+	}
+	var err error
+	p.buf, err = f.Marshal(p.buf)
+	if err != nil {
+		return err
+	}
+	_, err = p.W.Write(p.buf)
+	return err
+}
+
+// FuncPublisher calls itself with each frame.
+type FuncPublisher func(f *Frame, source string, datestr string) error
+
+// Publish implements FramePublisher.
+func (p FuncPublisher) Publish(f *Frame, source string, datestr string, cancel <-chan struct{}) error {
+	select {
+	case <-cancel:
+		return ErrPublishCanceled
+	default:
+	}
+	return p(f, source, datestr)
+}
+
+// FlowControl bounds the number of frames a FramePublisher has
+// in flight: published, but not yet acknowledged by the consumer
+// calling Ack. Publish blocks while MaxInFlight frames are
+// unacknowledged, failing with ErrPublishStalled if none is
+// acknowledged within Stall (if Stall > 0), so a consumer that
+// stops reading cannot hang a replay forever, and nothing is
+// dropped without an error.
+type FlowControl struct {
+	Pub         FramePublisher
+	MaxInFlight int
+	Stall       time.Duration
+
+	slots chan struct{}
+	mu    sync.Mutex
+	acked int64
+}
+
+// NewFlowControl wraps pub with flow control.
+func NewFlowControl(pub FramePublisher, maxInFlight int, stall time.Duration) *FlowControl {
+	if maxInFlight < 1 {
+		maxInFlight = 1
+	}
+	return &FlowControl{
+		Pub:         pub,
+		MaxInFlight: maxInFlight,
+		Stall:       stall,
+		slots:       make(chan struct{}, maxInFlight),
+	}
+}
+
+// Publish implements FramePublisher.
+func (c *FlowControl) Publish(f *Frame, source string, datestr string, cancel <-chan struct{}) error {
+	var stall <-chan time.Time
+	if c.Stall > 0 {
+		t := time.NewTimer(c.Stall)
+		defer t.Stop()
+		stall = t.C
+	}
+	select {
+	case c.slots <- struct{}{}:
+	case <-cancel:
+		return ErrPublishCanceled
+	case <-stall:
+		return ErrPublishStalled
+	}
+	err := c.Pub.Publish(f, source, datestr, cancel)
+	if err != nil {
+		<-c.slots
+	}
+	return err
+}
+
+// Ack acknowledges one frame, freeing its slot. Consumers
+// call it once for each frame they have finished with.
+func (c *FlowControl) Ack() {
+	select {
+	case <-c.slots:
+		c.mu.Lock()
+		c.acked++
+		c.mu.Unlock()
+	default:
+		// more acks than frames: ignore.
+	}
+}
+
+// InFlight returns the number of frames published but not acknowledged.
+func (c *FlowControl) InFlight() int {
+	return len(c.slots)
+}
+
+// Acked returns the number of frames acknowledged.
+func (c *FlowControl) Acked() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.acked
+}
+
+// Drain waits for every published frame to be acknowledged.
+// It fails with ErrPublishStalled if no acknowledgement comes
+// within Stall, reporting how many frames are still in flight.
+func (c *FlowControl) Drain(cancel <-chan struct{}) error {
+	last := c.Acked()
+	var stall <-chan time.Time
+	if c.Stall > 0 {
+		t := time.NewTimer(c.Stall)
+		defer t.Stop()
+		stall = t.C
+	}
+	tick := time.NewTicker(time.Millisecond)
+	defer tick.Stop()
+	for c.InFlight() > 0 {
+		select {
+		case <-cancel:
+			return ErrPublishCanceled
+		case <-stall:
+			if c.Acked() == last {
+				return fmt.Errorf("%v, with %d frames unacknowledged", ErrPublishStalled, c.InFlight())
+			}
+			last = c.Acked()
+			stall = time.After(c.Stall)
+		case <-tick.C:
+		}
+	}
+	if d, ok := c.Pub.(Drainer); ok {
+		return d.Drain(cancel)
+	}
+	return nil
+}
@@ -22,6 +22,11 @@ type BufferedFrameReader struct {
 	Reader   *FrameReader
 	Next     *Frame
 	TmpFrame Frame
+
+	// Transform, if set, is applied to each frame as it is
+	// read, before Peek or ReadOne return it. It may modify
+	// the frame in place, e.g. to correct its timestamp.
+	Transform func(f *Frame) error
 }
 
 // NewBufferedFrameReader makes a new BufferedFrameReader. It imposes a
@@ -68,6 +73,11 @@ func (s *BufferedFrameReader) Peek() (*Frame, error) {
 	if err != nil {
 		return nil, err
 	}
+	if s.Transform != nil {
+		if err = s.Transform(&s.TmpFrame); err != nil {
+			return nil, err
+		}
+	}
 	s.Next = &s.TmpFrame
 	return s.Next, nil
 }
@@ -85,6 +95,11 @@ func (s *BufferedFrameReader) Advance() error {
 	if err != nil {
 		return err
 	}
+	if s.Transform != nil {
+		if err = s.Transform(&s.TmpFrame); err != nil {
+			return err
+		}
+	}
 	s.Next = &s.TmpFrame
 	return nil
 }
@@ -93,9 +108,32 @@ func (s *BufferedFrameReader) Advance() error {
 // Frame handling and allows copying from the underlying
 // stream directly. It should be used to skip any further
 // Frame processing and copy the rest of the byte stream
-// directly.
+// directly. With a Transform set, frames must still be
+// decoded, to be transformed, before they are written.
 func (b *BufferedFrameReader) WriteTo(w io.Writer) (n int64, err error) {
 	var nn int
+	if b.Transform != nil {
+		var by []byte
+		for {
+			f, err := b.Peek()
+			if err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				return n, err
+			}
+			by, err = f.Marshal(by)
+			if err != nil {
+				return n, err
+			}
+			nn, err = w.Write(by)
+			n += int64(nn)
+			if err != nil {
+				return n, err
+			}
+			b.Next = nil
+		}
+	}
 	if b.Next != nil {
 		by, err := b.TmpFrame.Marshal(b.Reader.By)
 		nn, err = w.Write(by)
@@ -0,0 +1,242 @@
+package tm
+
+import (
+	"container/heap"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Reorderer puts a nearly sorted stream back into timestamp order,
+// as long as no frame arrives more than Lateness behind the newest
+// timestamp seen so far. Frames are held in a min-heap until the
+// watermark, the newest timestamp less Lateness, passes them.
+// Frames arriving behind the watermark are late: they cannot be
+// put in order, so they are counted and handed to the Late
+// callback, if one is set, instead of being released.
+//
+// Released frames are always in non-decreasing Tm() order, and
+// frames with equal timestamps keep their arrival order.
+type Reorderer struct {
+	Lateness time.Duration
+
+	// MaxHeld bounds the number of frames held. When it is
+	// exceeded, the oldest frame is released early, and the
+	// watermark moves up to it.
+	MaxHeld int
+
+	// Late, if not nil, receives each late frame.
+	Late func(f *Frame)
+
+	LateCount int64
+	Released  int64
+
+	h       reorderHeap
+	seq     int64
+	mark    int64 // watermark: frames with Tm() < mark are late
+	started bool
+}
+
+// NewReorderer makes a Reorderer that tolerates frames up to
+// lateness out of order. maxHeld <= 0 means a bound of 1,000,000.
+func NewReorderer(lateness time.Duration, maxHeld int) *Reorderer {
+	if maxHeld <= 0 {
+		maxHeld = 1000000
+	}
+	return &Reorderer{
+		Lateness: lateness,
+		MaxHeld:  maxHeld,
+	}
+}
+
+type heldFrame struct {
+	f   *Frame
+	seq int64
+}
+
+type reorderHeap []heldFrame
+
+func (h reorderHeap) Len() int { return len(h) }
+func (h reorderHeap) Less(i, j int) bool {
+	ti, tj := h[i].f.Tm(), h[j].f.Tm()
+	if ti != tj {
+		return ti < tj
+	}
+	return h[i].seq < h[j].seq
+}
+func (h reorderHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *reorderHeap) Push(x interface{}) { *h = append(*h, x.(heldFrame)) }
+func (h *reorderHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	old[n-1] = heldFrame{}
+	*h = old[:n-1]
+	return x
+}
+
+// Add takes ownership of f, and returns the frames that are now
+// ready, in timestamp order. The result is often empty.
+func (r *Reorderer) Add(f *Frame) []*Frame {
+	tm := f.Tm()
+	if r.started && tm < r.mark {
+		r.LateCount++
+		if r.Late != nil {
+			r.Late(f)
+		}
+		return nil
+	}
+	if !r.started {
+		r.started = true
+		r.mark = tm - int64(r.Lateness)
+	}
+	heap.Push(&r.h, heldFrame{f: f, seq: r.seq})
+	r.seq++
+
+	var out []*Frame
+	if m := tm - int64(r.Lateness); m > r.mark {
+		out = r.AdvanceTo(m)
+	}
+	for len(r.h) > r.MaxHeld {
+		hf := heap.Pop(&r.h).(heldFrame)
+		if t := hf.f.Tm(); t > r.mark {
+			r.mark = t
+		}
+		out = append(out, hf.f)
+		r.Released++
+	}
+	return out
+}
+
+// AdvanceTo raises the watermark to tm, if it is not already
+// past it, and returns the frames released: those before tm.
+// A live feed can call it with the wall clock less Lateness, to
+// release frames when input goes quiet.
+func (r *Reorderer) AdvanceTo(tm int64) []*Frame {
+	if tm > r.mark {
+		r.mark = tm
+		r.started = true
+	}
+	var out []*Frame
+	for len(r.h) > 0 && r.h[0].f.Tm() < r.mark {
+		out = append(out, heap.Pop(&r.h).(heldFrame).f)
+		r.Released++
+	}
+	return out
+}
+
+// Flush releases all held frames, in timestamp order. Frames
+// that arrive afterwards are late if they are before the last
+// frame released.
+func (r *Reorderer) Flush() []*Frame {
+	var out []*Frame
+	for len(r.h) > 0 {
+		f := heap.Pop(&r.h).(heldFrame).f
+		if t := f.Tm(); t > r.mark {
+			r.mark = t
+		}
+		out = append(out, f)
+		r.Released++
+	}
+	return out
+}
+
+// Held returns the number of frames waiting for the watermark.
+func (r *Reorderer) Held() int {
+	return len(r.h)
+}
+
+// Watermark returns the current watermark. Frames
+// before it are late.
+func (r *Reorderer) Watermark() int64 {
+	return r.mark
+}
+
+// AppendReordered passes f through ro, appending to
+// fw whatever frames ro releases.
+func (fw *FrameWriter) AppendReordered(ro *Reorderer, f *Frame) {
+	for _, g := range ro.Add(f) {
+		fw.Append(g)
+	}
+}
+
+// AppendHeld flushes the frames still held in ro onto fw.
+// Call it at the end of the stream.
+func (fw *FrameWriter) AppendHeld(ro *Reorderer) {
+	for _, g := range ro.Flush() {
+		fw.Append(g)
+	}
+}
+
+// ReorderStats reports the results of Reorder.
+type ReorderStats struct {
+	Frames      int64
+	Late        int64
+	MaxHeld     int
+	MaxLateness time.Duration // largest distance seen behind the newest frame
+}
+
+// Reorder reads frames from r, puts them back in timestamp order
+// using a Reorderer with the given lateness, and writes them to w.
+// Late frames are written to late, if it is not nil, and
+// otherwise dropped.
+func Reorder(r io.Reader, w io.Writer, lateness time.Duration, late io.Writer) (*ReorderStats, error) {
+	fr := NewFrameReader(r, 1024*1024)
+	fw := NewFrameWriter(w, 1024*1024)
+	var lw *FrameWriter
+	if late != nil {
+		lw = NewFrameWriter(late, 1024*1024)
+	}
+	ro := NewReorderer(lateness, 0)
+	if lw != nil {
+		ro.Late = func(f *Frame) { lw.Append(f) }
+	}
+	stats := &ReorderStats{}
+
+	var newest int64
+	var err error
+	for i := 0; err == nil; i++ {
+		frame := &Frame{}
+		_, _, err, _ = fr.NextFrame(frame)
+		if err != nil {
+			if err != io.EOF {
+				return stats, fmt.Errorf("Reorder error from fr.NextFrame() at i=%v: '%v'", i, err)
+			}
+			break
+		}
+		stats.Frames++
+		tm := frame.Tm()
+		if i == 0 || tm > newest {
+			newest = tm
+		} else if d := time.Duration(newest - tm); d > stats.MaxLateness {
+			stats.MaxLateness = d
+		}
+		fw.AppendReordered(ro, frame)
+		if h := ro.Held(); h > stats.MaxHeld {
+			stats.MaxHeld = h
+		}
+		if len(fw.Frames) >= 1000 {
+			if err = fw.Flush(); err != nil {
+				return stats, err
+			}
+		}
+		if lw != nil && len(lw.Frames) >= 1000 {
+			if err = lw.Flush(); err != nil {
+				return stats, err
+			}
+		}
+	}
+	fw.AppendHeld(ro)
+	stats.Late = ro.LateCount
+	if err = fw.Flush(); err != nil {
+		return stats, err
+	}
+	fw.Sync()
+	if lw != nil {
+		if err = lw.Flush(); err != nil {
+			return stats, err
+		}
+		lw.Sync()
+	}
+	return stats, nil
+}
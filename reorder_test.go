@@ -0,0 +1,73 @@
+package tm
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test150ReorderWithinLateness(t *testing.T) {
+
+	mk := func(sec int, tag int64) *Frame { return GenTestFrame(sec, EvOneInt64, 0, tag, nil) }
+	// arrival order of timestamps (seconds); the 1 arrives
+	// 6 seconds behind the newest (7), so is late for a 5s window.
+	arrive := []int{0, 2, 1, 4, 3, 3, 7, 5, 1, 6, 9, 8}
+
+	cv.Convey("A Reorderer should release frames in timestamp order once the watermark passes, counting frames that are too late", t, func() {
+		ro := NewReorderer(5*time.Second, 0)
+		var late []*Frame
+		ro.Late = func(f *Frame) { late = append(late, f) }
+
+		var out []*Frame
+		for i, sec := range arrive {
+			out = append(out, ro.Add(mk(sec, int64(i)))...)
+		}
+		// nothing before 9-5 = 4s has been held back beyond the watermark
+		cv.So(ro.Watermark(), cv.ShouldEqual, GenTestTime(4).UnixNano())
+		out = append(out, ro.Flush()...)
+
+		cv.So(ro.LateCount, cv.ShouldEqual, 1)
+		cv.So(len(late), cv.ShouldEqual, 1)
+		cv.So(late[0].Ude, cv.ShouldEqual, 8)
+
+		cv.So(len(out), cv.ShouldEqual, len(arrive)-1)
+		for i := 1; i < len(out); i++ {
+			cv.So(out[i-1].Tm(), cv.ShouldBeLessThanOrEqualTo, out[i].Tm())
+		}
+		// the two frames at 3s keep their arrival order.
+		cv.So(out[3].Ude, cv.ShouldEqual, 4)
+		cv.So(out[4].Ude, cv.ShouldEqual, 5)
+	})
+
+	cv.Convey("MaxHeld should bound memory by releasing the oldest frame early", t, func() {
+		ro := NewReorderer(time.Hour, 3)
+		var out []*Frame
+		for i, sec := range []int{5, 4, 3, 2, 1} {
+			out = append(out, ro.Add(mk(sec, int64(i)))...)
+		}
+		cv.So(ro.Held(), cv.ShouldEqual, 3)
+		cv.So(len(out), cv.ShouldEqual, 1)
+		cv.So(out[0].Tm(), cv.ShouldEqual, GenTestTime(2).UnixNano())
+		// 1s arrived after 2s was forced out, so it was late.
+		cv.So(ro.LateCount, cv.ShouldEqual, 1)
+	})
+
+	cv.Convey("Reorder should stream frames through, routing late frames to their own writer", t, func() {
+		frames := make([]*Frame, len(arrive))
+		for i, sec := range arrive {
+			frames[i] = mk(sec, int64(i))
+		}
+		var in, out, late bytes.Buffer
+		panicOn(writeFrames(&in, frames))
+
+		stats, err := Reorder(&in, &out, 5*time.Second, &late)
+		panicOn(err)
+		cv.So(stats.Frames, cv.ShouldEqual, len(arrive))
+		cv.So(stats.Late, cv.ShouldEqual, 1)
+		cv.So(stats.MaxLateness, cv.ShouldEqual, 6*time.Second)
+		cv.So(out.Len(), cv.ShouldEqual, 16*(len(arrive)-1))
+		cv.So(late.Len(), cv.ShouldEqual, 16)
+	})
+}
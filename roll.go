@@ -0,0 +1,438 @@
+package tm
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RollStat selects the statistic that a Roller computes
+// over its rolling window.
+type RollStat int
+
+const (
+	RollMean   RollStat = 0
+	RollVar    RollStat = 1
+	RollStddev RollStat = 2
+	RollMin    RollStat = 3
+	RollMax    RollStat = 4
+	RollEwma   RollStat = 5
+	RollZscore RollStat = 6
+	RollRoc    RollStat = 7
+	RollSum    RollStat = 8
+	RollCount  RollStat = 9
+)
+
+// String stringifies the RollStat, for printing.
+func (s RollStat) String() string {
+	switch s {
+	case RollMean:
+		return "mean"
+	case RollVar:
+		return "var"
+	case RollStddev:
+		return "sd"
+	case RollMin:
+		return "min"
+	case RollMax:
+		return "max"
+	case RollEwma:
+		return "ewma"
+	case RollZscore:
+		return "zscore"
+	case RollRoc:
+		return "roc"
+	case RollSum:
+		return "sum"
+	case RollCount:
+		return "count"
+	}
+	return fmt.Sprintf("RollStat.%d", int(s))
+}
+
+// ParseRollStat converts a name such as "mean" or "zscore"
+// into the corresponding RollStat.
+func ParseRollStat(name string) (RollStat, error) {
+	switch strings.ToLower(name) {
+	case "mean", "avg":
+		return RollMean, nil
+	case "var", "variance":
+		return RollVar, nil
+	case "sd", "stddev":
+		return RollStddev, nil
+	case "min":
+		return RollMin, nil
+	case "max":
+		return RollMax, nil
+	case "ewma":
+		return RollEwma, nil
+	case "zscore", "z":
+		return RollZscore, nil
+	case "roc":
+		return RollRoc, nil
+	case "sum":
+		return RollSum, nil
+	case "count", "n":
+		return RollCount, nil
+	}
+	return RollMean, fmt.Errorf("unknown rolling statistic '%s'", name)
+}
+
+// RollWindow describes the extent of a rolling window: either
+// the last Count frames, or all frames within the last Dur
+// duration. Exactly one of Count or Dur should be positive.
+type RollWindow struct {
+	Count int
+	Dur   time.Duration
+}
+
+// ParseRollWindow parses "100" as a window of the last 100 frames,
+// and "5m" or "1h30m" (anything time.ParseDuration accepts) as
+// a window of the last 5 minutes or 90 minutes.
+func ParseRollWindow(s string) (RollWindow, error) {
+	n, err := strconv.Atoi(s)
+	if err == nil {
+		if n <= 0 {
+			return RollWindow{}, fmt.Errorf("bad window '%s': frame count must be positive", s)
+		}
+		return RollWindow{Count: n}, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return RollWindow{}, fmt.Errorf("bad window '%s': neither a frame count nor a duration", s)
+	}
+	if d <= 0 {
+		return RollWindow{}, fmt.Errorf("bad window '%s': duration must be positive", s)
+	}
+	return RollWindow{Dur: d}, nil
+}
+
+// String displays the window as ParseRollWindow would accept it.
+func (w RollWindow) String() string {
+	if w.Count > 0 {
+		return fmt.Sprintf("%d", w.Count)
+	}
+	return w.Dur.String()
+}
+
+// FrameValue returns the numeric value that the streaming
+// operators use for f: V0 for PtiOneFloat64 and PtiTwo64,
+// V1 for PtiOneInt64, and 0 for PtiZero. The second return
+// value is false for the frames that carry no number
+// (PtiNull, PtiNA, PtiNaN, and PtiUDE).
+func FrameValue(f *Frame) (float64, bool) {
+	switch f.GetPTI() {
+	case PtiZero:
+		return 0, true
+	case PtiOneInt64:
+		return float64(f.Ude), true
+	case PtiOneFloat64, PtiTwo64:
+		if math.IsNaN(f.V0) {
+			return f.V0, false
+		}
+		return f.V0, true
+	}
+	return MyNaN, false
+}
+
+// NewValueFrame makes the output frame for a streaming operator:
+// an EvOneFloat64 frame holding v at tm, or an EvNaN frame
+// if v is NaN.
+func NewValueFrame(tm int64, v float64) *Frame {
+	if math.IsNaN(v) {
+		return &Frame{Prim: (tm &^ 7) | int64(PtiNaN)}
+	}
+	return &Frame{Prim: (tm &^ 7) | int64(PtiOneFloat64), V0: v}
+}
+
+// Roller is a streaming operator that keeps a rolling statistic
+// over a window of recent frames. Feed it frames in time order
+// with Process(); each numeric input frame yields one output
+// frame carrying the statistic in V0 at the input's timestamp.
+//
+// Frames without a numeric value (see FrameValue) are not added
+// to the window. They produce an NA frame at their timestamp,
+// so that the output stays aligned with the input.
+//
+// The window's frames are held in a FrameRingBuf. Count windows
+// use a ring of exactly Count frames; duration windows start
+// small and double the ring when it fills.
+type Roller struct {
+	Stat   RollStat
+	Window RollWindow
+
+	ring *FrameRingBuf
+
+	sum   float64
+	sumsq float64
+
+	// monotone deques of window values, for min and max.
+	minq []rollPoint
+	maxq []rollPoint
+
+	seq     int64 // sequence number of the next frame in
+	evicted int64 // sequence number of the oldest frame in the window
+
+	ewma     float64
+	ewmaInit bool
+	lastTm   int64
+}
+
+type rollPoint struct {
+	seq int64
+	val float64
+}
+
+// NewRoller makes a Roller computing stat over window.
+func NewRoller(stat RollStat, window RollWindow) (*Roller, error) {
+	if window.Count <= 0 && window.Dur <= 0 {
+		return nil, fmt.Errorf("NewRoller: window must have a positive Count or Dur")
+	}
+	n := window.Count
+	if n <= 0 {
+		n = 64
+	}
+	return &Roller{
+		Stat:   stat,
+		Window: window,
+		ring:   NewFrameRingBuf(n),
+	}, nil
+}
+
+// Avail returns the number of frames presently in the window.
+func (r *Roller) Avail() int {
+	return r.ring.Avail()
+}
+
+// Process adds f to the window, evicts any frames that have
+// fallen out of it, and returns the frame holding the updated
+// statistic. f should not be modified afterwards, as the
+// window may retain it.
+func (r *Roller) Process(f *Frame) (*Frame, error) {
+	tm := f.Tm()
+	if r.seq > 0 && tm < r.lastTm {
+		return nil, fmt.Errorf("Roller.Process: frame at %v is before the previous frame; input must be in time order", f.TmTime())
+	}
+
+	x, ok := FrameValue(f)
+	if !ok {
+		r.lastTm = tm
+		return &Frame{Prim: tm | int64(PtiNA)}, nil
+	}
+
+	if r.Window.Dur > 0 {
+		r.evictOlderThan(tm - int64(r.Window.Dur))
+	} else if r.ring.Avail() == r.Window.Count {
+		r.evictOne()
+	}
+	r.push(f, x)
+
+	var v float64
+	switch r.Stat {
+	case RollEwma:
+		v = r.updateEwma(tm, x)
+	default:
+		v = r.Value()
+	}
+	r.lastTm = tm
+	return NewValueFrame(tm, v), nil
+}
+
+// Value returns the statistic over the frames currently in
+// the window. For RollEwma it returns the latest average,
+// and for RollZscore the z-score of the newest frame.
+func (r *Roller) Value() float64 {
+	n := r.ring.Avail()
+	switch r.Stat {
+	case RollMean:
+		return r.mean()
+	case RollVar:
+		return r.variance()
+	case RollStddev:
+		return math.Sqrt(r.variance())
+	case RollMin:
+		if len(r.minq) == 0 {
+			return MyNaN
+		}
+		return r.minq[0].val
+	case RollMax:
+		if len(r.maxq) == 0 {
+			return MyNaN
+		}
+		return r.maxq[0].val
+	case RollEwma:
+		if !r.ewmaInit {
+			return MyNaN
+		}
+		return r.ewma
+	case RollZscore:
+		if n == 0 {
+			return MyNaN
+		}
+		sd := math.Sqrt(r.variance())
+		if sd == 0 {
+			return MyNaN
+		}
+		x, _ := FrameValue(r.ring.Kth(n - 1))
+		return (x - r.mean()) / sd
+	case RollRoc:
+		// fractional change from the oldest to the newest value in the window.
+		if n == 0 {
+			return MyNaN
+		}
+		first, _ := FrameValue(r.ring.Kth(0))
+		last, _ := FrameValue(r.ring.Kth(n - 1))
+		if first == 0 {
+			return MyNaN
+		}
+		return (last - first) / first
+	case RollSum:
+		return r.sum
+	case RollCount:
+		return float64(n)
+	}
+	panic(fmt.Sprintf("unknown RollStat %d", int(r.Stat)))
+}
+
+func (r *Roller) mean() float64 {
+	n := r.ring.Avail()
+	if n == 0 {
+		return MyNaN
+	}
+	return r.sum / float64(n)
+}
+
+// variance returns the sample (n-1) variance of the window.
+func (r *Roller) variance() float64 {
+	n := float64(r.ring.Avail())
+	if n < 2 {
+		return MyNaN
+	}
+	v := (r.sumsq - r.sum*r.sum/n) / (n - 1)
+	if v < 0 {
+		// guard against round-off
+		v = 0
+	}
+	return v
+}
+
+func (r *Roller) updateEwma(tm int64, x float64) float64 {
+	if !r.ewmaInit {
+		r.ewma = x
+		r.ewmaInit = true
+		return x
+	}
+	var alpha float64
+	if r.Window.Count > 0 {
+		alpha = 2 / (float64(r.Window.Count) + 1)
+	} else {
+		// decay by elapsed time, so irregular spacing is handled.
+		dt := float64(tm - r.lastTm)
+		alpha = 1 - math.Exp(-dt/float64(r.Window.Dur))
+	}
+	r.ewma += alpha * (x - r.ewma)
+	return r.ewma
+}
+
+func (r *Roller) push(f *Frame, x float64) {
+	if r.ring.WriteCapacity() == 0 {
+		r.grow()
+	}
+	r.ring.WriteFrames([]*Frame{f})
+	r.sum += x
+	r.sumsq += x * x
+
+	pt := rollPoint{seq: r.seq, val: x}
+	for len(r.minq) > 0 && r.minq[len(r.minq)-1].val >= x {
+		r.minq = r.minq[:len(r.minq)-1]
+	}
+	r.minq = append(r.minq, pt)
+	for len(r.maxq) > 0 && r.maxq[len(r.maxq)-1].val <= x {
+		r.maxq = r.maxq[:len(r.maxq)-1]
+	}
+	r.maxq = append(r.maxq, pt)
+	r.seq++
+}
+
+func (r *Roller) evictOne() {
+	f := r.ring.Kth(0)
+	if f == nil {
+		return
+	}
+	x, _ := FrameValue(f)
+	r.ring.A[r.ring.Beg] = nil
+	r.ring.Advance(1)
+	r.sum -= x
+	r.sumsq -= x * x
+	if len(r.minq) > 0 && r.minq[0].seq == r.evicted {
+		r.minq = r.minq[1:]
+	}
+	if len(r.maxq) > 0 && r.maxq[0].seq == r.evicted {
+		r.maxq = r.maxq[1:]
+	}
+	r.evicted++
+	if r.ring.Avail() == 0 {
+		// start fresh to shed accumulated round-off.
+		r.sum = 0
+		r.sumsq = 0
+	}
+}
+
+// evictOlderThan drops frames with Tm() <= cutoff.
+func (r *Roller) evictOlderThan(cutoff int64) {
+	for r.ring.Avail() > 0 && r.ring.Kth(0).Tm() <= cutoff {
+		r.evictOne()
+	}
+}
+
+// grow doubles the ring, keeping the frames in order.
+func (r *Roller) grow() {
+	a, b := r.ring.TwoContig(false)
+	bigger := make([]*Frame, 0, 2*r.ring.N)
+	bigger = append(bigger, a...)
+	bigger = append(bigger, b...)
+	r.ring.Adopt(bigger[:cap(bigger)])
+	r.ring.Readable = len(a) + len(b)
+}
+
+// Roll reads frames from r, passes each through the rollers in
+// sequence (the output of one feeding the next), and writes the
+// final output frames to w. With a single Roller this is a plain
+// rolling statistic; chaining lets one compute, say, the
+// rolling max of an ewma.
+func Roll(r io.Reader, w io.Writer, rollers ...*Roller) error {
+	fr := NewFrameReader(r, 1024*1024)
+	fw := NewFrameWriter(w, 1024*1024)
+
+	var err error
+	for i := 0; err == nil; i++ {
+		frame := &Frame{}
+		_, _, err, _ = fr.NextFrame(frame)
+		if err != nil {
+			if err != io.EOF {
+				return fmt.Errorf("Roll error from fr.NextFrame() at i=%v: '%v'", i, err)
+			}
+			break
+		}
+		out := frame
+		for _, ro := range rollers {
+			out, err = ro.Process(out)
+			if err != nil {
+				return err
+			}
+		}
+		fw.Append(out)
+		if i%1000 == 999 {
+			err = fw.Flush()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	err = fw.Flush()
+	fw.Sync()
+	return err
+}
@@ -0,0 +1,126 @@
+package tm
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test070RollingWindowStats(t *testing.T) {
+
+	cv.Convey("A Roller over the last 3 frames should report the mean, min, max, and variance of just those frames, at each input timestamp", t, func() {
+		frames, tms, _ := GenTestFramesSequence(6, nil)
+
+		mean, err := NewRoller(RollMean, RollWindow{Count: 3})
+		panicOn(err)
+		min, err := NewRoller(RollMin, RollWindow{Count: 3})
+		panicOn(err)
+		max, err := NewRoller(RollMax, RollWindow{Count: 3})
+		panicOn(err)
+		vari, err := NewRoller(RollVar, RollWindow{Count: 3})
+		panicOn(err)
+
+		expMean := []float64{0, 0.5, 1, 2, 3, 4}
+		expMin := []float64{0, 0, 0, 1, 2, 3}
+		expMax := []float64{0, 1, 2, 3, 4, 5}
+		for i, f := range frames {
+			o, err := mean.Process(f)
+			panicOn(err)
+			cv.So(o.TmTime(), cv.ShouldResemble, tms[i])
+			cv.So(o.GetPTI(), cv.ShouldEqual, PtiOneFloat64)
+			cv.So(o.V0, cv.ShouldEqual, expMean[i])
+
+			o, err = min.Process(f)
+			panicOn(err)
+			cv.So(o.V0, cv.ShouldEqual, expMin[i])
+
+			o, err = max.Process(f)
+			panicOn(err)
+			cv.So(o.V0, cv.ShouldEqual, expMax[i])
+
+			o, err = vari.Process(f)
+			panicOn(err)
+			if i == 0 {
+				// one sample has no variance
+				cv.So(o.GetPTI(), cv.ShouldEqual, PtiNaN)
+			} else if i >= 2 {
+				cv.So(o.V0, cv.ShouldEqual, 1)
+			}
+		}
+		cv.So(mean.Avail(), cv.ShouldEqual, 3)
+	})
+
+	cv.Convey("A duration window should evict frames by age, not count, and grow its ring as needed", t, func() {
+		frames, _, _ := GenTestFramesSequence(200, nil)
+
+		// frames are 1 second apart, so 100s holds the latest 100 frames.
+		sum, err := NewRoller(RollSum, RollWindow{Dur: 100 * time.Second})
+		panicOn(err)
+		var o *Frame
+		for _, f := range frames {
+			o, err = sum.Process(f)
+			panicOn(err)
+		}
+		cv.So(sum.Avail(), cv.ShouldEqual, 100)
+		// sum of 100..199
+		cv.So(o.V0, cv.ShouldEqual, float64(100*(100+199)/2))
+	})
+
+	cv.Convey("Frames without a number should yield NA frames and stay out of the window; out of order input is an error", t, func() {
+		t0 := time.Date(2016, 2, 16, 0, 0, 0, 0, time.UTC)
+		a, _ := NewFrame(t0, EvOneFloat64, 2, 0, nil)
+		b, _ := NewFrame(t0.Add(time.Second), EvNA, 0, 0, nil)
+		c, _ := NewFrame(t0.Add(2*time.Second), EvOneInt64, 0, 4, nil)
+
+		mean, err := NewRoller(RollMean, RollWindow{Count: 10})
+		panicOn(err)
+		mean.Process(a)
+		o, err := mean.Process(b)
+		panicOn(err)
+		cv.So(o.GetPTI(), cv.ShouldEqual, PtiNA)
+		o, err = mean.Process(c)
+		panicOn(err)
+		cv.So(o.V0, cv.ShouldEqual, 3)
+		cv.So(mean.Avail(), cv.ShouldEqual, 2)
+
+		_, err = mean.Process(a)
+		cv.So(err, cv.ShouldNotBeNil)
+	})
+
+	cv.Convey("Roll() should chain operators over a stream, the z-score of a constant-step series being steady", t, func() {
+		_, _, by := GenTestFramesSequence(20, nil)
+
+		z, err := NewRoller(RollZscore, RollWindow{Count: 5})
+		panicOn(err)
+		var out bytes.Buffer
+		err = Roll(bytes.NewBuffer(by), &out, z)
+		panicOn(err)
+
+		fr := NewFrameReader(&out, 1024)
+		n := 0
+		var f Frame
+		for {
+			_, _, err, _ = fr.NextFrame(&f)
+			if err != nil {
+				break
+			}
+			if n >= 5 {
+				// newest of 5 evenly spaced values is 2/sd(1..5) above the mean
+				cv.So(math.Abs(f.V0-2/math.Sqrt(2.5)) < 1e-9, cv.ShouldBeTrue)
+			}
+			n++
+		}
+		cv.So(n, cv.ShouldEqual, 20)
+
+		// chained: ewma then max
+		e, _ := NewRoller(RollEwma, RollWindow{Count: 3})
+		m, _ := NewRoller(RollMax, RollWindow{Count: 3})
+		out.Reset()
+		err = Roll(bytes.NewBuffer(by), &out, e, m)
+		panicOn(err)
+		cv.So(out.Len(), cv.ShouldEqual, 20*16)
+	})
+}
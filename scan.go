@@ -0,0 +1,386 @@
+package tm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+)
+
+// Shard is a byte range [Start, End) of a TMFRAME
+// file, starting and ending on frame boundaries.
+type Shard struct {
+	Index int
+	Start int64
+	End   int64
+}
+
+// ScanConfig configures a parallel scan of one file.
+type ScanConfig struct {
+	// Workers is the number of goroutines. Default runtime.NumCPU().
+	Workers int
+
+	// Shards is the number of pieces to cut the file into.
+	// Default 4*Workers, or more so that shards are at most 64MB.
+	Shards int
+
+	// MaxFrameBytes bounds the size of one frame. Default 1MB.
+	MaxFrameBytes int64
+
+	// NoIndex ignores any .idx file, resyncing instead.
+	NoIndex bool
+}
+
+func (c *ScanConfig) setDefaults(size int64) {
+	if c.Workers <= 0 {
+		c.Workers = runtime.NumCPU()
+	}
+	if c.Shards <= 0 {
+		c.Shards = 4 * c.Workers
+		if n := int(size / (64 << 20)); n > c.Shards {
+			c.Shards = n
+		}
+	}
+	if c.MaxFrameBytes <= 0 {
+		c.MaxFrameBytes = 1024 * 1024
+	}
+}
+
+// ResyncFrames is the number of frames in a row that must
+// decode cleanly, with plausible timestamps, before
+// FindFrameBoundary accepts an offset as a frame boundary.
+const ResyncFrames = 8
+
+// ShardFile cuts the TMFRAME file path into about n shards, of
+// about equal size, at frame boundaries. The boundaries come from
+// the file's .idx when there is an up to date one (see ReadIndexFor),
+// and are otherwise found by FindFrameBoundary. Fewer than n shards
+// may result, but never an empty one.
+func ShardFile(path string, n int, cfg ScanConfig) ([]Shard, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := fi.Size()
+	cfg.setDefaults(size)
+	if n < 1 {
+		n = 1
+	}
+
+	var idx []IndexEntry
+	if !cfg.NoIndex {
+		idx, err = ReadIndexFor(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cuts := []int64{0}
+	for k := 1; k < n; k++ {
+		target := size * int64(k) / int64(n)
+		var cut int64
+		if len(idx) > 0 {
+			cut = indexCut(idx, target, size)
+		} else {
+			cut, err = FindFrameBoundary(f, target, size, cfg.MaxFrameBytes)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if cut > cuts[len(cuts)-1] && cut < size {
+			cuts = append(cuts, cut)
+		}
+	}
+	cuts = append(cuts, size)
+
+	var shards []Shard
+	for i := 1; i < len(cuts); i++ {
+		if cuts[i] > cuts[i-1] {
+			shards = append(shards, Shard{Index: len(shards), Start: cuts[i-1], End: cuts[i]})
+		}
+	}
+	return shards, nil
+}
+
+// indexCut returns the last index offset at or before target.
+func indexCut(idx []IndexEntry, target, size int64) int64 {
+	var cut int64
+	for _, e := range idx {
+		if e.Offset > target || e.Offset >= size {
+			break
+		}
+		if e.Offset > cut {
+			cut = e.Offset
+		}
+	}
+	return cut
+}
+
+// FindFrameBoundary returns the first offset at or after off where
+// ResyncFrames frames in a row (or all the frames up to size) decode
+// cleanly: PTIs and evtnums that agree, zero terminated payloads no
+// bigger than maxFrameBytes, and timestamps within ten years of the
+// file's first frame. It returns size if it finds none. The file
+// must start with a frame.
+func FindFrameBoundary(ra io.ReaderAt, off, size, maxFrameBytes int64) (int64, error) {
+	if off <= 0 {
+		return 0, nil
+	}
+	rs := &resyncer{ra: ra, size: size, maxFrame: maxFrameBytes}
+	var first Frame
+	hdr, err := rs.get(0, 16)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := first.Unmarshal(hdr, false); err != nil && err != TooShortErr {
+		return 0, err
+	}
+	const tenYears = 10 * 366 * 24 * 3600 * 1e9
+	rs.lo = first.Tm() - tenYears
+	rs.hi = first.Tm() + tenYears
+
+	for p := off; p < size; p++ {
+		ok, err := rs.plausibleRun(p)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			return p, nil
+		}
+	}
+	return size, nil
+}
+
+type resyncer struct {
+	ra       io.ReaderAt
+	size     int64
+	maxFrame int64
+	lo, hi   int64
+
+	buf    []byte
+	bufOff int64
+}
+
+// get returns n bytes at off, or fewer at the end of the file.
+func (rs *resyncer) get(off int64, n int64) ([]byte, error) {
+	if off+n > rs.size {
+		n = rs.size - off
+	}
+	if off >= rs.bufOff && off+n <= rs.bufOff+int64(len(rs.buf)) {
+		return rs.buf[off-rs.bufOff : off-rs.bufOff+n], nil
+	}
+	want := n
+	if want < 1<<20 {
+		want = 1 << 20
+	}
+	if off+want > rs.size {
+		want = rs.size - off
+	}
+	if int64(cap(rs.buf)) < want {
+		rs.buf = make([]byte, want)
+	}
+	rs.buf = rs.buf[:want]
+	m, err := rs.ra.ReadAt(rs.buf, off)
+	rs.buf = rs.buf[:m]
+	rs.bufOff = off
+	if int64(m) < n {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return rs.buf[:n], nil
+}
+
+// plausibleRun checks for ResyncFrames good frames starting at p.
+func (rs *resyncer) plausibleRun(p int64) (bool, error) {
+	for k := 0; k < ResyncFrames; k++ {
+		if p == rs.size {
+			return true, nil
+		}
+		n, err := rs.plausibleFrame(p)
+		if err != nil || n == 0 {
+			return false, err
+		}
+		p += n
+	}
+	return true, nil
+}
+
+// plausibleFrame returns the size of the frame at p, or 0 if
+// there does not seem to be one there.
+func (rs *resyncer) plausibleFrame(p int64) (int64, error) {
+	if rs.size-p < 8 {
+		return 0, nil
+	}
+	hdr, err := rs.get(p, 16)
+	if err != nil {
+		return 0, err
+	}
+	prim := int64(binary.LittleEndian.Uint64(hdr[:8]))
+	if tm := prim &^ 7; tm < rs.lo || tm > rs.hi {
+		return 0, nil
+	}
+	var n int64
+	switch PTI(prim & 7) {
+	case PtiZero, PtiNull, PtiNA, PtiNaN:
+		return 8, nil
+	case PtiOneInt64, PtiOneFloat64:
+		n = 16
+	case PtiTwo64:
+		n = 24
+	case PtiUDE:
+		if len(hdr) < 16 {
+			return 0, nil
+		}
+		ude := binary.LittleEndian.Uint64(hdr[8:16])
+		evtnum := Evtnum(int64(ude) >> 43)
+		if evtnum >= 0 && evtnum < 8 {
+			return 0, nil
+		}
+		ucount := int64(ude & KeepLow43Bits)
+		if ucount == 1 || 16+ucount > rs.maxFrame {
+			return 0, nil
+		}
+		n = 16 + ucount
+		if ucount > 0 {
+			if p+n > rs.size {
+				return 0, nil
+			}
+			term, err := rs.get(p+n-1, 1)
+			if err != nil {
+				return 0, err
+			}
+			if term[0] != 0 {
+				return 0, nil
+			}
+		}
+	}
+	if p+n > rs.size {
+		return 0, nil
+	}
+	return n, nil
+}
+
+// ScanFile cuts path into shards (see ShardFile), and calls work
+// on each shard, cfg.Workers at a time, with a FrameReader over
+// just that shard. Each result is passed to emit in shard order,
+// so a filter can write its output in file order, and an
+// aggregate can combine the results as they come. At most
+// 2*cfg.Workers results are held waiting for emit.
+//
+// The first error from work or emit stops the scan, and
+// is returned.
+func ScanFile(path string, cfg ScanConfig, work func(s Shard, fr *FrameReader) (interface{}, error), emit func(s Shard, res interface{}) error) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	cfg.setDefaults(fi.Size())
+	shards, err := ShardFile(path, cfg.Shards, cfg)
+	if err != nil {
+		return err
+	}
+
+	type result struct {
+		res interface{}
+		err error
+	}
+	results := make([]chan result, len(shards))
+	for i := range results {
+		results[i] = make(chan result, 1)
+	}
+	tokens := make(chan struct{}, 2*cfg.Workers)
+	work1 := make(chan Shard)
+	halt := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for w := 0; w < cfg.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f, err := os.Open(path)
+			if err != nil {
+				for s := range work1 {
+					results[s.Index] <- result{err: err}
+				}
+				return
+			}
+			defer f.Close()
+			for s := range work1 {
+				fr := NewFrameReader(io.NewSectionReader(f, s.Start, s.End-s.Start), cfg.MaxFrameBytes)
+				res, err := work(s, fr)
+				results[s.Index] <- result{res: res, err: err}
+			}
+		}()
+	}
+	go func() {
+		defer close(work1)
+		for _, s := range shards {
+			select {
+			case tokens <- struct{}{}:
+			case <-halt:
+				return
+			}
+			select {
+			case work1 <- s:
+			case <-halt:
+				return
+			}
+		}
+	}()
+
+	for _, s := range shards {
+		r := <-results[s.Index]
+		<-tokens
+		err = r.err
+		if err == nil {
+			err = emit(s, r.res)
+		}
+		if err != nil {
+			close(halt)
+			break
+		}
+	}
+	wg.Wait()
+	return err
+}
+
+// ScanFilter is a ScanFile for filters and other per-frame
+// transforms. It calls each on every frame, with the output
+// buffer of the frame's shard; the buffers are written to w in
+// file order. each is called from cfg.Workers goroutines at once,
+// so must be safe for concurrent use. The frame and raw bytes are
+// reused once it returns.
+func ScanFilter(path string, cfg ScanConfig, w io.Writer, each func(f *Frame, raw []byte, out *bytes.Buffer) error) error {
+	work := func(s Shard, fr *FrameReader) (interface{}, error) {
+		var out bytes.Buffer
+		var frame Frame
+		for i := 0; ; i++ {
+			_, _, err, raw := fr.NextFrame(&frame)
+			if err == io.EOF {
+				return &out, nil
+			}
+			if err != nil {
+				return nil, fmt.Errorf("shard %v [%v, %v) error at frame %v: '%v'", s.Index, s.Start, s.End, i, err)
+			}
+			err = each(&frame, raw, &out)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	emit := func(s Shard, res interface{}) error {
+		_, err := res.(*bytes.Buffer).WriteTo(w)
+		return err
+	}
+	return ScanFile(path, cfg, work, emit)
+}
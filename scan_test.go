@@ -0,0 +1,127 @@
+package tm
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test180ParallelShardedScan(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "tfscan")
+	panicOn(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "scan.tf")
+
+	// a mix of frame kinds and payload sizes, noting the true boundaries.
+	var frames []*Frame
+	bounds := map[int64]bool{}
+	var off int64
+	for i := 0; i < 5000; i++ {
+		tm := GenTestTime(i)
+		var f *Frame
+		switch i % 4 {
+		case 0:
+			f, err = NewFrame(tm, EvOneInt64, 0, int64(i), nil)
+		case 1:
+			f, err = NewFrame(tm, EvTwo64, float64(i), int64(i), nil)
+		case 2:
+			f, err = NewFrame(tm, EvJson, 0, 0, []byte(fmt.Sprintf(`{"i":%d,"pad":"%s"}`, i, bytes.Repeat([]byte("x"), i%97))))
+		case 3:
+			f, err = NewFrame(tm, EvNull, 0, 0, nil)
+		}
+		panicOn(err)
+		frames = append(frames, f)
+		bounds[off] = true
+		off += f.NumBytes()
+	}
+	bounds[off] = true
+	var all bytes.Buffer
+	panicOn(writeFrames(&all, frames))
+	panicOn(ioutil.WriteFile(path, all.Bytes(), 0644))
+
+	keep := func(f *Frame, raw []byte, out *bytes.Buffer) error {
+		if f.GetEvtnum() == EvJson {
+			out.Write(raw)
+		}
+		return nil
+	}
+	var want bytes.Buffer
+	for _, f := range frames {
+		if f.GetEvtnum() == EvJson {
+			b, err := f.Marshal(nil)
+			panicOn(err)
+			want.Write(b)
+		}
+	}
+
+	cv.Convey("Without an index, ShardFile should resync to true frame boundaries, and ScanFilter should keep file order", t, func() {
+		shards, err := ShardFile(path, 16, ScanConfig{})
+		panicOn(err)
+		cv.So(len(shards), cv.ShouldEqual, 16)
+		for i, s := range shards {
+			cv.So(bounds[s.Start], cv.ShouldBeTrue)
+			cv.So(bounds[s.End], cv.ShouldBeTrue)
+			if i > 0 {
+				cv.So(s.Start, cv.ShouldEqual, shards[i-1].End)
+			}
+		}
+		cv.So(shards[15].End, cv.ShouldEqual, off)
+
+		var got bytes.Buffer
+		panicOn(ScanFilter(path, ScanConfig{Workers: 4, Shards: 16}, &got, keep))
+		cv.So(bytes.Equal(got.Bytes(), want.Bytes()), cv.ShouldBeTrue)
+	})
+
+	cv.Convey("With a .idx, ShardFile should cut at indexed offsets, and ScanFile results should combine into an aggregate", t, func() {
+		var idx []*Frame
+		var o int64
+		for i, f := range frames {
+			if i%600 == 0 {
+				e, err := NewFrame(time.Unix(0, f.Tm()), EvOneInt64, 0, o, nil)
+				panicOn(err)
+				idx = append(idx, e)
+			}
+			o += f.NumBytes()
+		}
+		var ib bytes.Buffer
+		panicOn(writeFrames(&ib, idx))
+		panicOn(ioutil.WriteFile(IndexPath(path), ib.Bytes(), 0644))
+
+		shards, err := ShardFile(path, 4, ScanConfig{})
+		panicOn(err)
+		cv.So(len(shards), cv.ShouldEqual, 4)
+		for _, s := range shards[1:] {
+			cv.So(s.Start, cv.ShouldBeIn, []int64{idx[1].Ude, idx[2].Ude, idx[3].Ude, idx[4].Ude, idx[5].Ude, idx[6].Ude, idx[7].Ude})
+		}
+
+		var total, last int64
+		work := func(s Shard, fr *FrameReader) (interface{}, error) {
+			var n int64
+			var frame Frame
+			for {
+				_, _, err, _ := fr.NextFrame(&frame)
+				if err != nil {
+					return n, nil
+				}
+				n++
+			}
+		}
+		emit := func(s Shard, res interface{}) error {
+			if s.Index != 0 && s.Index != int(last)+1 {
+				return fmt.Errorf("out of order")
+			}
+			last = int64(s.Index)
+			total += res.(int64)
+			return nil
+		}
+		panicOn(ScanFile(path, ScanConfig{Workers: 3}, work, emit))
+		cv.So(total, cv.ShouldEqual, len(frames))
+	})
+}
@@ -218,10 +218,13 @@ func (s *Series) LastAtOrBefore(tm time.Time) (*Frame, SearchStatus, int) {
 
 	// i is the smallest Frame such that itm >= utm.
 	itm := s.Frames[i].Tm()
-	if i == 0 {
-		if itm > utm {
+	if itm > utm {
+		if i == 0 {
 			return nil, InPast, -1
 		}
+		// nothing at utm itself, so the last Frame before
+		// it is in force; i-1 is already the last of its ties.
+		return s.Frames[i-1], Avail, i - 1
 	}
 	// But: there can be many at itm and we want the largest index that ties.
 
@@ -660,3 +660,15 @@ func Test018ExtendedRepetitionTestFirstInForceBefore(t *testing.T) {
 
 	})
 }
+
+func Test019LastAtOrBeforeBetweenTimestamps(t *testing.T) {
+	cv.Convey("Given a Series ser, the call ser.LastAtOrBefore(t) for a t that falls strictly between two Frame timestamps should return the earlier Frame, not the later one", t, func() {
+		frames, tms, _ := GenTestFramesSequence(5, nil)
+		ser := NewSeriesFromFrames(frames)
+
+		at, status, i := ser.LastAtOrBefore(tms[2].Add(500 * time.Millisecond))
+		cv.So(status, cv.ShouldEqual, Avail)
+		cv.So(i, cv.ShouldEqual, 2)
+		cv.So(at.TmTime(), cv.ShouldResemble, tms[2])
+	})
+}
@@ -0,0 +1,151 @@
+package tm
+
+import (
+	"math"
+	"sort"
+)
+
+// QuantileSketch estimates the quantiles of a stream of numbers in
+// bounded memory. It keeps counts in logarithmic buckets, so a
+// quantile it returns is within RelAcc (relative error) of a value
+// of the right rank. Sketches of parts of a stream can be merged,
+// as for a parallel scan. The zero value is not usable; call
+// NewQuantileSketch.
+type QuantileSketch struct {
+	RelAcc float64
+
+	lnGamma float64
+	pos     map[int]int64 // bucket counts of the positive values
+	neg     map[int]int64 // and of the negated negative values
+	zero    int64
+	count   int64
+	min     float64
+	max     float64
+}
+
+// NewQuantileSketch makes a sketch with relative accuracy relAcc,
+// such as 0.01 for 1%. Memory grows with the log of the range
+// of the values: about 1000 buckets per factor of 1e9 at 1%.
+func NewQuantileSketch(relAcc float64) *QuantileSketch {
+	if relAcc <= 0 || relAcc >= 1 {
+		relAcc = 0.01
+	}
+	gamma := (1 + relAcc) / (1 - relAcc)
+	return &QuantileSketch{
+		RelAcc:  relAcc,
+		lnGamma: math.Log(gamma),
+		pos:     make(map[int]int64),
+		neg:     make(map[int]int64),
+		min:     math.Inf(1),
+		max:     math.Inf(-1),
+	}
+}
+
+// smallest magnitude kept apart from zero.
+const sketchMinMag = 1e-9
+
+func (s *QuantileSketch) index(mag float64) int {
+	return int(math.Ceil(math.Log(mag) / s.lnGamma))
+}
+
+func (s *QuantileSketch) value(idx int) float64 {
+	// the middle, in relative terms, of (gamma^(idx-1), gamma^idx].
+	return 2 * math.Exp(float64(idx)*s.lnGamma) / (1 + math.Exp(s.lnGamma))
+}
+
+// Add adds x. NaNs are ignored.
+func (s *QuantileSketch) Add(x float64) {
+	if math.IsNaN(x) {
+		return
+	}
+	s.count++
+	if x < s.min {
+		s.min = x
+	}
+	if x > s.max {
+		s.max = x
+	}
+	switch {
+	case x > sketchMinMag:
+		s.pos[s.index(x)]++
+	case x < -sketchMinMag:
+		s.neg[s.index(-x)]++
+	default:
+		s.zero++
+	}
+}
+
+// Merge adds the values of o, which must have the same RelAcc, to s.
+func (s *QuantileSketch) Merge(o *QuantileSketch) {
+	for k, n := range o.pos {
+		s.pos[k] += n
+	}
+	for k, n := range o.neg {
+		s.neg[k] += n
+	}
+	s.zero += o.zero
+	s.count += o.count
+	if o.min < s.min {
+		s.min = o.min
+	}
+	if o.max > s.max {
+		s.max = o.max
+	}
+}
+
+// Count returns the number of values added.
+func (s *QuantileSketch) Count() int64 {
+	return s.count
+}
+
+// Quantile returns an estimate of the q quantile, 0 <= q <= 1,
+// such as 0.5 for the median. It is NaN if s is empty.
+func (s *QuantileSketch) Quantile(q float64) float64 {
+	if s.count == 0 {
+		return MyNaN
+	}
+	switch {
+	case q <= 0:
+		return s.min
+	case q >= 1:
+		return s.max
+	}
+	rank := int64(q * float64(s.count-1))
+
+	var x float64
+	seen := int64(0)
+	found := false
+	// negatives from the most negative, then zeros, then positives.
+	negIdx := sortedKeys(s.neg)
+	for i := len(negIdx) - 1; i >= 0 && !found; i-- {
+		seen += s.neg[negIdx[i]]
+		if seen > rank {
+			x, found = -s.value(negIdx[i]), true
+		}
+	}
+	if !found {
+		seen += s.zero
+		if seen > rank {
+			x, found = 0, true
+		}
+	}
+	if !found {
+		for _, k := range sortedKeys(s.pos) {
+			seen += s.pos[k]
+			if seen > rank {
+				x = s.value(k)
+				break
+			}
+		}
+	}
+	return math.Max(s.min, math.Min(s.max, x))
+}
+
+func sortedKeys(m map[int]int64) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
@@ -0,0 +1,256 @@
+package tm
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ClockKnot says that at source time At, the source
+// clock must have Offset added to agree with the reference.
+type ClockKnot struct {
+	At     int64
+	Offset time.Duration
+}
+
+// ClockAdjust corrects the timestamps of one source for clock skew.
+// With one knot, it is a fixed offset. With more, the offset is
+// interpolated linearly between knots, modeling drift, and held
+// at the first and last knot's offset beyond them. Knots are
+// kept sorted by At.
+//
+// As long as the offset changes by less than a second per second,
+// adjusting keeps a time ordered source in time order.
+type ClockAdjust struct {
+	Knots []ClockKnot
+}
+
+// FixedClockAdjust makes a ClockAdjust that adds off to every timestamp.
+func FixedClockAdjust(off time.Duration) *ClockAdjust {
+	return &ClockAdjust{Knots: []ClockKnot{{Offset: off}}}
+}
+
+// ParseClockAdjust parses a fixed offset such as "+15ms" or "-2.5ms",
+// or a comma separated list of offset@time knots for drift, such as
+// "15ms@2016-03-10T00:00:00Z,22ms@2016-03-11T00:00:00Z". The times are
+// RFC3339, with optional fractional seconds.
+func ParseClockAdjust(s string) (*ClockAdjust, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, fmt.Errorf("empty clock adjustment")
+	}
+	if !strings.Contains(s, "@") {
+		off, err := time.ParseDuration(strings.TrimPrefix(s, "+"))
+		if err != nil {
+			return nil, fmt.Errorf("bad clock offset '%s': %v", s, err)
+		}
+		return FixedClockAdjust(off), nil
+	}
+	c := &ClockAdjust{}
+	for _, k := range strings.Split(s, ",") {
+		parts := strings.SplitN(strings.TrimSpace(k), "@", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("bad clock knot '%s': use offset@time", k)
+		}
+		off, err := time.ParseDuration(strings.TrimPrefix(parts[0], "+"))
+		if err != nil {
+			return nil, fmt.Errorf("bad clock knot offset '%s': %v", parts[0], err)
+		}
+		at, err := time.Parse(time.RFC3339Nano, parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("bad clock knot time '%s': %v", parts[1], err)
+		}
+		c.Knots = append(c.Knots, ClockKnot{At: at.UnixNano(), Offset: off})
+	}
+	c.sortKnots()
+	return c, nil
+}
+
+func (c *ClockAdjust) sortKnots() {
+	sort.Stable(knotsByAt(c.Knots))
+}
+
+type knotsByAt []ClockKnot
+
+func (k knotsByAt) Len() int           { return len(k) }
+func (k knotsByAt) Less(i, j int) bool { return k[i].At < k[j].At }
+func (k knotsByAt) Swap(i, j int)      { k[i], k[j] = k[j], k[i] }
+
+// String renders c in the form ParseClockAdjust reads.
+func (c *ClockAdjust) String() string {
+	if len(c.Knots) == 1 {
+		return c.Knots[0].Offset.String()
+	}
+	parts := make([]string, len(c.Knots))
+	for i, k := range c.Knots {
+		parts[i] = fmt.Sprintf("%v@%s", k.Offset, time.Unix(0, k.At).UTC().Format(time.RFC3339Nano))
+	}
+	return strings.Join(parts, ",")
+}
+
+// Offset returns the correction for source time tm.
+func (c *ClockAdjust) Offset(tm int64) time.Duration {
+	n := len(c.Knots)
+	switch {
+	case n == 0:
+		return 0
+	case n == 1 || tm <= c.Knots[0].At:
+		return c.Knots[0].Offset
+	case tm >= c.Knots[n-1].At:
+		return c.Knots[n-1].Offset
+	}
+	i := sort.Search(n, func(i int) bool { return c.Knots[i].At > tm })
+	a, b := c.Knots[i-1], c.Knots[i]
+	frac := float64(tm-a.At) / float64(b.At-a.At)
+	return a.Offset + time.Duration(frac*float64(b.Offset-a.Offset))
+}
+
+// Adjust returns the corrected time for source time tm.
+func (c *ClockAdjust) Adjust(tm int64) int64 {
+	return IntToPrimTm(tm + int64(c.Offset(tm)))
+}
+
+// Apply corrects the timestamp of f in place. If keepOriginal
+// is set, f becomes an EvAdjusted envelope stamped with the
+// corrected time, holding the original frame unchanged, so
+// nothing is lost. Otherwise the primary word is rewritten.
+func (c *ClockAdjust) Apply(f *Frame, keepOriginal bool) error {
+	tm := c.Adjust(f.Tm())
+	if !keepOriginal {
+		f.SetTm(tm)
+		return nil
+	}
+	env, err := WrapAdjusted(f, tm)
+	if err != nil {
+		return err
+	}
+	*f = *env
+	return nil
+}
+
+// Transform returns a BufferedFrameReader.Transform that applies c.
+func (c *ClockAdjust) Transform(keepOriginal bool) func(f *Frame) error {
+	return func(f *Frame) error {
+		return c.Apply(f, keepOriginal)
+	}
+}
+
+// WrapAdjusted wraps f in an EvAdjusted envelope stamped tm.
+func WrapAdjusted(f *Frame, tm int64) (*Frame, error) {
+	inner, err := f.Marshal(nil)
+	if err != nil {
+		return nil, err
+	}
+	return NewFrame(time.Unix(0, tm), EvAdjusted, 0, 0, inner)
+}
+
+// UnwrapAdjusted returns the original frame from an EvAdjusted envelope.
+// The corrected time is the envelope's Tm().
+func UnwrapAdjusted(f *Frame) (*Frame, error) {
+	if f.GetEvtnum() != EvAdjusted {
+		return nil, fmt.Errorf("UnwrapAdjusted: frame has evtnum %v, not EvAdjusted", f.GetEvtnum())
+	}
+	var inner Frame
+	_, err := inner.Unmarshal(f.Data, true)
+	if err != nil {
+		return nil, err
+	}
+	return &inner, nil
+}
+
+// SkewEstimate reports how EstimateClockAdjust arrived at its answer.
+type SkewEstimate struct {
+	Adjust  *ClockAdjust
+	Samples int // reference events found in both streams
+	Buckets int // knots fit
+}
+
+// EstimateClockAdjust estimates the correction for the clock of src
+// relative to that of ref, from reference events: frames that share a
+// key, under key, in both streams, such as a message id logged by both
+// hosts. Each pair gives one offset sample, the ref time less the src
+// time. With bucket 0, the result is the median of all samples: a
+// fixed offset. Otherwise the samples are grouped into buckets of src
+// time, and the median of each becomes a knot, modeling drift.
+//
+// The first frame for each key is used on both sides. All of ref's
+// keys are held in memory.
+func EstimateClockAdjust(ref, src io.Reader, key KeyFunc, bucket time.Duration) (*SkewEstimate, error) {
+	refTm := make(map[string]int64)
+	err := eachFrame(ref, func(f *Frame) {
+		if k, ok := key(f); ok {
+			if _, dup := refTm[k]; !dup {
+				refTm[k] = f.Tm()
+			}
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("EstimateClockAdjust reading ref: %v", err)
+	}
+
+	var samples []ClockKnot
+	used := make(map[string]bool)
+	err = eachFrame(src, func(f *Frame) {
+		k, ok := key(f)
+		if !ok || used[k] {
+			return
+		}
+		if rt, ok := refTm[k]; ok {
+			used[k] = true
+			samples = append(samples, ClockKnot{At: f.Tm(), Offset: time.Duration(rt - f.Tm())})
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("EstimateClockAdjust reading src: %v", err)
+	}
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("EstimateClockAdjust: no reference events in common")
+	}
+
+	est := &SkewEstimate{Samples: len(samples), Adjust: &ClockAdjust{}}
+	sort.Stable(knotsByAt(samples))
+	median := func(ss []ClockKnot) (at int64, off time.Duration) {
+		offs := make([]float64, len(ss))
+		for i := range ss {
+			offs[i] = float64(ss[i].Offset)
+		}
+		sort.Float64s(offs)
+		return ss[len(ss)/2].At, time.Duration(offs[len(offs)/2])
+	}
+	if bucket <= 0 {
+		_, off := median(samples)
+		est.Adjust.Knots = []ClockKnot{{Offset: off}}
+		est.Buckets = 1
+		return est, nil
+	}
+	for i := 0; i < len(samples); {
+		b := samples[i].At / int64(bucket)
+		j := i
+		for j < len(samples) && samples[j].At/int64(bucket) == b {
+			j++
+		}
+		at, off := median(samples[i:j])
+		est.Adjust.Knots = append(est.Adjust.Knots, ClockKnot{At: at, Offset: off})
+		i = j
+	}
+	est.Buckets = len(est.Adjust.Knots)
+	return est, nil
+}
+
+// eachFrame calls fn on each frame read from r.
+func eachFrame(r io.Reader, fn func(f *Frame)) error {
+	fr := NewFrameReader(r, 1024*1024)
+	var frame Frame
+	for {
+		_, _, err, _ := fr.NextFrame(&frame)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		fn(&frame)
+	}
+}
@@ -0,0 +1,95 @@
+package tm
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test190ClockSkewCorrection(t *testing.T) {
+
+	msg := func(tm time.Time, id int, host string) *Frame {
+		f, err := NewFrame(tm, EvJson, 0, 0, []byte(fmt.Sprintf(`{"id":%d,"host":"%s"}`, id, host)))
+		panicOn(err)
+		return f
+	}
+
+	cv.Convey("ParseClockAdjust should read fixed offsets and drift knots, interpolating between knots", t, func() {
+		a, err := ParseClockAdjust("-15ms")
+		panicOn(err)
+		cv.So(a.Offset(GenTestT0.UnixNano()), cv.ShouldEqual, -15*time.Millisecond)
+
+		a, err = ParseClockAdjust("30ms@2016-03-10T02:00:00Z,10ms@2016-03-10T00:00:00Z")
+		panicOn(err)
+		cv.So(a.Knots[0].Offset, cv.ShouldEqual, 10*time.Millisecond)
+		cv.So(a.Offset(GenTestT0.Add(-time.Hour).UnixNano()), cv.ShouldEqual, 10*time.Millisecond)
+		cv.So(a.Offset(GenTestT0.Add(time.Hour).UnixNano()), cv.ShouldEqual, 20*time.Millisecond)
+		cv.So(a.Offset(GenTestT0.Add(5*time.Hour).UnixNano()), cv.ShouldEqual, 30*time.Millisecond)
+		b, err := ParseClockAdjust(a.String())
+		panicOn(err)
+		cv.So(b.Knots, cv.ShouldResemble, a.Knots)
+
+		_, err = ParseClockAdjust("15ms@yesterday")
+		cv.So(err, cv.ShouldNotBeNil)
+	})
+
+	// host B's clock runs 40ms slow, so its record of each
+	// request lands before host A's send of it.
+	var aFrames, bFrames []*Frame
+	for i := 0; i < 20; i++ {
+		sent := GenTestTime(i)
+		aFrames = append(aFrames, msg(sent, i, "a"))
+		bFrames = append(bFrames, msg(sent.Add(5*time.Millisecond-40*time.Millisecond), i, "b"))
+	}
+	var aBuf, bBuf bytes.Buffer
+	panicOn(writeFrames(&aBuf, aFrames))
+	panicOn(writeFrames(&bBuf, bFrames))
+
+	cv.Convey("EstimateClockAdjust should recover the offset from shared reference events", t, func() {
+		key, err := PayloadFieldKey("id", nil)
+		panicOn(err)
+		est, err := EstimateClockAdjust(bytes.NewReader(aBuf.Bytes()), bytes.NewReader(bBuf.Bytes()), key, 0)
+		panicOn(err)
+		cv.So(est.Samples, cv.ShouldEqual, 20)
+		// the 5ms of real latency can't be told apart from skew.
+		cv.So(est.Adjust.Offset(0), cv.ShouldEqual, 35*time.Millisecond)
+
+		est, err = EstimateClockAdjust(bytes.NewReader(aBuf.Bytes()), bytes.NewReader(bBuf.Bytes()), key, 10*time.Second)
+		panicOn(err)
+		cv.So(est.Buckets, cv.ShouldBeGreaterThanOrEqualTo, 2)
+	})
+
+	cv.Convey("A merge with a corrected source should put causally related events back in order, rewriting or keeping the original time", t, func() {
+		adj := FixedClockAdjust(40 * time.Millisecond)
+		for _, keep := range []bool{false, true} {
+			sa := NewBufferedFrameReader(bytes.NewReader(aBuf.Bytes()), 1024, "a")
+			sb := NewBufferedFrameReader(bytes.NewReader(bBuf.Bytes()), 1024, "b")
+			sb.Transform = adj.Transform(keep)
+			var out bytes.Buffer
+			panicOn(NewFrameWriter(&out, 1024).Merge(sa, sb))
+
+			var got []*Frame
+			panicOn(eachFrame(&out, func(f *Frame) {
+				cp := *f
+				got = append(got, &cp)
+			}))
+			cv.So(len(got), cv.ShouldEqual, 40)
+			for i := 0; i < 40; i += 2 {
+				cv.So(string(got[i].Data), cv.ShouldContainSubstring, `"a"`)
+				if keep {
+					cv.So(got[i+1].GetEvtnum(), cv.ShouldEqual, EvAdjusted)
+					inner, err := UnwrapAdjusted(got[i+1])
+					panicOn(err)
+					cv.So(FramesEqual(inner, bFrames[i/2]), cv.ShouldBeTrue)
+					cv.So(got[i+1].Tm()-inner.Tm(), cv.ShouldEqual, int64(40*time.Millisecond))
+				} else {
+					cv.So(string(got[i+1].Data), cv.ShouldContainSubstring, `"b"`)
+					cv.So(got[i+1].Tm()-got[i].Tm(), cv.ShouldEqual, int64(5*time.Millisecond))
+				}
+			}
+		}
+	})
+}
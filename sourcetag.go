@@ -0,0 +1,197 @@
+package tm
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Source-tagged merging records which input each merged frame
+// came from. The output starts with an EvHeader frame whose JSON
+// payload lists the input names, {"sources":["a.tf","b.tf"]}, and
+// each merged frame is then wrapped in an EvSourced envelope: a
+// uvarint index into that list, followed by the complete inner
+// frame. The envelope keeps the inner frame's timestamp, so the
+// tagged output is still in time order. Demux reverses the process.
+
+// SourceHeader is the JSON payload of the EvHeader frame
+// that starts a source-tagged stream.
+type SourceHeader struct {
+	Sources []string `json:"sources"`
+}
+
+// NewSourceHeaderFrame makes the EvHeader frame listing names, at time tm.
+func NewSourceHeaderFrame(tm int64, names []string) (*Frame, error) {
+	by, err := json.Marshal(&SourceHeader{Sources: names})
+	if err != nil {
+		return nil, err
+	}
+	return NewFrame(time.Unix(0, tm), EvHeader, 0, 0, by)
+}
+
+// ParseSourceHeader returns the source names listed in f, or
+// false if f is not a source table header frame.
+func ParseSourceHeader(f *Frame) ([]string, bool) {
+	if f.GetEvtnum() != EvHeader {
+		return nil, false
+	}
+	var h SourceHeader
+	err := json.Unmarshal(f.Data, &h)
+	if err != nil || h.Sources == nil {
+		return nil, false
+	}
+	return h.Sources, true
+}
+
+// WrapSourced wraps f in an EvSourced envelope tagged with source id src.
+func WrapSourced(f *Frame, src int) (*Frame, error) {
+	if src < 0 {
+		return nil, fmt.Errorf("WrapSourced: negative source id %d", src)
+	}
+	inner, err := f.Marshal(nil)
+	if err != nil {
+		return nil, err
+	}
+	by := make([]byte, binary.MaxVarintLen64+len(inner))
+	n := binary.PutUvarint(by, uint64(src))
+	n += copy(by[n:], inner)
+	return NewFrame(f.TmTime(), EvSourced, 0, 0, by[:n])
+}
+
+// UnwrapSourced extracts the inner frame and source id from an
+// EvSourced envelope.
+func UnwrapSourced(f *Frame) (*Frame, int, error) {
+	if f.GetEvtnum() != EvSourced {
+		return nil, -1, fmt.Errorf("UnwrapSourced: frame has evtnum %v, not EvSourced", f.GetEvtnum())
+	}
+	src, n := binary.Uvarint(f.Data)
+	if n <= 0 {
+		return nil, -1, fmt.Errorf("UnwrapSourced: bad source id")
+	}
+	var inner Frame
+	_, err := inner.Unmarshal(f.Data[n:], true)
+	if err != nil {
+		return nil, -1, err
+	}
+	return &inner, int(src), nil
+}
+
+// MergeTagged is MergeBy, writing a source-tagged stream: a header
+// frame listing the Name of each of strms, then every merged frame
+// wrapped in an EvSourced envelope carrying its input index.
+func (fw *FrameWriter) MergeTagged(tie TieBreaker, strms ...*BufferedFrameReader) error {
+	m, err := NewFrameMerger(tie, strms...)
+	if err != nil {
+		return err
+	}
+	names := make([]string, len(strms))
+	for i, s := range strms {
+		names[i] = s.Name
+	}
+	for i := 0; ; i++ {
+		f, src, err := m.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		if i == 0 {
+			h, err := NewSourceHeaderFrame(f.Tm(), names)
+			if err != nil {
+				return err
+			}
+			fw.Append(h)
+		}
+		env, err := WrapSourced(f, src)
+		if err != nil {
+			return err
+		}
+		fw.Append(env)
+		if len(fw.Frames) >= 1000 {
+			if err = fw.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return fw.Flush()
+}
+
+// DemuxStats reports what Demux found.
+type DemuxStats struct {
+	Sources  []string      // from the header, if any
+	Counts   map[int]int64 // frames written, by source id
+	Untagged int64         // frames that were not in an envelope
+}
+
+// Demux splits a source-tagged stream read from r back into its
+// sources. For each source id first seen, open is called with the
+// id and the name from the header table ("" if the header has no
+// such entry, or is absent) and returns the writer for that source.
+// Frames not in an envelope are passed to open with id -1.
+func Demux(r io.Reader, open func(src int, name string) (io.Writer, error)) (*DemuxStats, error) {
+	stats := &DemuxStats{Counts: make(map[int]int64)}
+	fr := NewFrameReader(r, 1024*1024)
+	outs := make(map[int]*FrameWriter)
+
+	emit := func(src int, f *Frame) error {
+		fw, ok := outs[src]
+		if !ok {
+			name := ""
+			if src >= 0 && src < len(stats.Sources) {
+				name = stats.Sources[src]
+			}
+			w, err := open(src, name)
+			if err != nil {
+				return err
+			}
+			fw = NewFrameWriter(w, 1024*1024)
+			outs[src] = fw
+		}
+		fw.Append(f)
+		if len(fw.Frames) >= 1000 {
+			return fw.Flush()
+		}
+		return nil
+	}
+
+	var err error
+	for i := 0; err == nil; i++ {
+		frame := &Frame{}
+		_, _, err, _ = fr.NextFrame(frame)
+		if err != nil {
+			if err != io.EOF {
+				return stats, fmt.Errorf("Demux error from fr.NextFrame() at i=%v: '%v'", i, err)
+			}
+			break
+		}
+		if i == 0 {
+			if names, ok := ParseSourceHeader(frame); ok {
+				stats.Sources = names
+				continue
+			}
+		}
+		src := -1
+		if frame.GetEvtnum() == EvSourced {
+			frame, src, err = UnwrapSourced(frame)
+			if err != nil {
+				return stats, fmt.Errorf("Demux error at i=%v: '%v'", i, err)
+			}
+			stats.Counts[src]++
+		} else {
+			stats.Untagged++
+		}
+		if err = emit(src, frame); err != nil {
+			return stats, err
+		}
+	}
+	for _, fw := range outs {
+		if err = fw.Flush(); err != nil {
+			return stats, err
+		}
+		fw.Sync()
+	}
+	return stats, nil
+}
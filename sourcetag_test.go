@@ -0,0 +1,71 @@
+package tm
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test140SourceTaggedMergeAndDemux(t *testing.T) {
+
+	cv.Convey("MergeTagged should record the source of every frame, and Demux should recover the original streams", t, func() {
+		frames, _, _ := GenTestFrames(30, nil)
+
+		// deal round robin into 3 inputs
+		k := 3
+		piles := make([]bytes.Buffer, k)
+		for i, f := range frames {
+			by, err := f.Marshal(nil)
+			panicOn(err)
+			piles[i%k].Write(by)
+		}
+		orig := make([][]byte, k)
+		strms := make([]*BufferedFrameReader, k)
+		for i := range piles {
+			orig[i] = append([]byte{}, piles[i].Bytes()...)
+			strms[i] = NewBufferedFrameReader(&piles[i], 1024*1024, []string{"a.tf", "b.tf", "c.tf"}[i])
+		}
+
+		var merged bytes.Buffer
+		fw := NewFrameWriter(&merged, 1024*1024)
+		panicOn(fw.MergeTagged(nil, strms...))
+
+		// the first frame is the source table; the rest are envelopes in time order.
+		fr := NewFrameReader(bytes.NewBuffer(merged.Bytes()), 1024*1024)
+		h, _, err, _ := fr.NextFrame(nil)
+		panicOn(err)
+		names, ok := ParseSourceHeader(h)
+		cv.So(ok, cv.ShouldBeTrue)
+		cv.So(names, cv.ShouldResemble, []string{"a.tf", "b.tf", "c.tf"})
+		cv.So(h.Tm(), cv.ShouldEqual, frames[0].Tm())
+
+		for i := range frames {
+			env, _, err, _ := fr.NextFrame(nil)
+			panicOn(err)
+			cv.So(env.GetEvtnum(), cv.ShouldEqual, EvSourced)
+			cv.So(env.Tm(), cv.ShouldEqual, frames[i].Tm())
+			inner, src, err := UnwrapSourced(env)
+			panicOn(err)
+			cv.So(src, cv.ShouldEqual, i%k)
+			cv.So(FramesEqual(inner, frames[i]), cv.ShouldBeTrue)
+		}
+		_, _, err, _ = fr.NextFrame(nil)
+		cv.So(err, cv.ShouldEqual, io.EOF)
+
+		outs := make(map[string]*bytes.Buffer)
+		stats, err := Demux(&merged, func(src int, name string) (io.Writer, error) {
+			b := &bytes.Buffer{}
+			outs[name] = b
+			return b, nil
+		})
+		panicOn(err)
+		cv.So(stats.Untagged, cv.ShouldEqual, 0)
+		cv.So(len(outs), cv.ShouldEqual, k)
+		for i, name := range names {
+			cv.So(stats.Counts[i], cv.ShouldEqual, 10)
+			cv.So(bytes.Equal(outs[name].Bytes(), orig[i]), cv.ShouldBeTrue)
+		}
+	})
+}
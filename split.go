@@ -0,0 +1,350 @@
+package tm
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SplitPart identifies one output file of a Splitter.
+type SplitPart struct {
+	Stream string
+	Start  time.Time // start of the period, or the time of the first frame
+	Key    string
+	Seq    int // chunk number, from 0, when splitting by size
+}
+
+// Splitter splits one stream of frames into many files: by time
+// period, by key (such as evtnum or a payload field), into chunks
+// of at most MaxBytes, or by any combination of these. Each file
+// gets the frames of one period and key, in their order in the
+// stream.
+//
+// Files are named by Template, relative to Dir. Template may use
+//
+//	{stream}  Stream
+//	{key}     the key
+//	{seq}     the chunk number, as 0000, 0001, ...
+//	{yyyy} {mm} {dd} {hh} {min}
+//	          parts of the start time, in Loc
+//	{start}   the start time, as 20060102T150405Z, in UTC
+//
+// Without a Template, files are named in the archiver's layout
+// (see DateNameString), YYYY/MM/DD/name by the UTC day of their
+// start. The name is Stream, followed by .key when splitting by
+// key, by .HHMM of the start when the Interval is shorter than a
+// day, and by .seq when splitting by size.
+type Splitter struct {
+	Dir    string
+	Stream string // default "stream"
+
+	// Interval, if set, starts a new file for each period.
+	// Periods are aligned as by a Grouper, in Loc.
+	Interval *GroupInterval
+	Loc      *time.Location
+
+	// Key, if set, starts a new file for each key. Frames that
+	// Key cannot key go to the key NoKey (default "nokey").
+	Key   KeyFunc
+	NoKey string
+
+	// MaxBytes, if positive, starts a new file when the
+	// next frame would take a file over MaxBytes.
+	MaxBytes int64
+
+	Template string
+
+	// Index writes a .idx beside each file, as tfindex would.
+	Index bool
+
+	// MaxOpen limits the number of files held open. Files closed
+	// early are reopened to append to, if their part comes again,
+	// as out of order frames do. Default 64.
+	MaxOpen int
+
+	// Paths lists the files written, in the order they were created.
+	Paths []string
+
+	groups map[string]*splitGroup
+	paths  map[string]string // path -> group, to catch collisions
+	open   []*splitOut       // least recently used first
+}
+
+type splitGroup struct {
+	part SplitPart
+	out  *splitOut
+}
+
+type splitOut struct {
+	path string
+	fd   *os.File
+	w    *bufio.Writer
+	size int64
+	idx  IndexBuilder
+}
+
+// NewSplitter makes a Splitter that writes under dir.
+func NewSplitter(dir string) *Splitter {
+	return &Splitter{
+		Dir:    dir,
+		groups: make(map[string]*splitGroup),
+		paths:  make(map[string]string),
+	}
+}
+
+// Write adds f to its file. raw, if not nil, holds f as it
+// was read, and is written in place of re-marshalling f.
+func (s *Splitter) Write(f *Frame, raw []byte) error {
+	if raw == nil {
+		var err error
+		raw, err = f.Marshal(nil)
+		if err != nil {
+			return err
+		}
+	}
+	tm := f.Tm()
+	loc := s.loc()
+
+	part := SplitPart{Stream: s.stream(), Start: time.Unix(0, tm).In(loc)}
+	id := ""
+	if s.Interval != nil {
+		part.Start = time.Unix(0, s.Interval.Start(tm, loc)).In(loc)
+		id = fmt.Sprintf("%d", part.Start.UnixNano())
+	}
+	if s.Key != nil {
+		key, ok := s.Key(f)
+		if !ok {
+			key = s.NoKey
+			if key == "" {
+				key = "nokey"
+			}
+		}
+		part.Key = key
+		id += "/" + key
+	}
+
+	g, ok := s.groups[id]
+	if !ok {
+		g = &splitGroup{part: part}
+		s.groups[id] = g
+	}
+	nbytes := int64(len(raw))
+	if g.out != nil && s.MaxBytes > 0 && g.out.size > 0 && g.out.size+nbytes > s.MaxBytes {
+		if err := s.finish(g.out); err != nil {
+			return err
+		}
+		g.out = nil
+		g.part.Seq++
+		g.part.Start = part.Start
+	}
+	if g.out == nil {
+		out, err := s.create(id, g.part)
+		if err != nil {
+			return err
+		}
+		g.out = out
+	}
+	out := g.out
+	if err := s.reopen(out); err != nil {
+		return err
+	}
+	if _, err := out.w.Write(raw); err != nil {
+		return fmt.Errorf("Splitter error writing '%s': '%v'", out.path, err)
+	}
+	out.idx.Add(tm, nbytes)
+	out.size += nbytes
+	return nil
+}
+
+// Close finishes all the files.
+func (s *Splitter) Close() error {
+	var first error
+	for _, g := range s.groups {
+		if g.out == nil {
+			continue
+		}
+		if err := s.finish(g.out); err != nil && first == nil {
+			first = err
+		}
+		g.out = nil
+	}
+	return first
+}
+
+// PartPath gives the path, below Dir, of the file for part.
+func (s *Splitter) PartPath(part SplitPart) string {
+	if s.Template != "" {
+		st := part.Start.In(s.loc())
+		r := strings.NewReplacer(
+			"{stream}", part.Stream,
+			"{key}", sanitizePathPart(part.Key),
+			"{seq}", fmt.Sprintf("%04d", part.Seq),
+			"{yyyy}", fmt.Sprintf("%04d", st.Year()),
+			"{mm}", fmt.Sprintf("%02d", int(st.Month())),
+			"{dd}", fmt.Sprintf("%02d", st.Day()),
+			"{hh}", fmt.Sprintf("%02d", st.Hour()),
+			"{min}", fmt.Sprintf("%02d", st.Minute()),
+			"{start}", part.Start.UTC().Format("20060102T150405Z"),
+		)
+		return r.Replace(s.Template)
+	}
+	name := part.Stream
+	if s.Key != nil {
+		name += "." + sanitizePathPart(part.Key)
+	}
+	if iv := s.Interval; iv != nil && iv.Dur > 0 && iv.Dur < 24*time.Hour {
+		name += "." + part.Start.UTC().Format("1504")
+	}
+	if s.MaxBytes > 0 {
+		name += fmt.Sprintf(".%04d", part.Seq)
+	}
+	return DateNameString(part.Start, name)
+}
+
+// sanitizePathPart keeps keys from making directories.
+func sanitizePathPart(key string) string {
+	key = strings.Replace(key, "/", "_", -1)
+	key = strings.Replace(key, string(os.PathSeparator), "_", -1)
+	if key == "" || key == "." || key == ".." {
+		key = "_" + key
+	}
+	return key
+}
+
+func (s *Splitter) stream() string {
+	if s.Stream == "" {
+		return "stream"
+	}
+	return s.Stream
+}
+
+func (s *Splitter) loc() *time.Location {
+	if s.Loc == nil {
+		return time.UTC
+	}
+	return s.Loc
+}
+
+func (s *Splitter) create(id string, part SplitPart) (*splitOut, error) {
+	rel := s.PartPath(part)
+	p := filepath.Join(s.Dir, filepath.FromSlash(rel))
+	if other, dup := s.paths[p]; dup && other != id {
+		return nil, fmt.Errorf("Splitter: two outputs would share the path '%s'; add {key}, {seq}, or a finer time to the template", p)
+	}
+	s.paths[p] = id
+	if err := os.MkdirAll(filepath.Dir(p), 0775); err != nil {
+		return nil, err
+	}
+	fd, err := os.OpenFile(p, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0664)
+	if err != nil {
+		return nil, fmt.Errorf("Splitter: output '%s' already exists, or cannot be created: '%v'", p, err)
+	}
+	out := &splitOut{path: p, fd: fd, w: bufio.NewWriter(fd)}
+	s.Paths = append(s.Paths, p)
+	s.touch(out)
+	return out, s.limitOpen()
+}
+
+// reopen makes sure out is open, and marks it most recently used.
+func (s *Splitter) reopen(out *splitOut) error {
+	if out.fd == nil {
+		fd, err := os.OpenFile(out.path, os.O_WRONLY|os.O_APPEND, 0664)
+		if err != nil {
+			return err
+		}
+		out.fd = fd
+		out.w = bufio.NewWriter(fd)
+		s.touch(out)
+		return s.limitOpen()
+	}
+	s.touch(out)
+	return nil
+}
+
+func (s *Splitter) touch(out *splitOut) {
+	for i, o := range s.open {
+		if o == out {
+			copy(s.open[i:], s.open[i+1:])
+			s.open[len(s.open)-1] = out
+			return
+		}
+	}
+	s.open = append(s.open, out)
+}
+
+func (s *Splitter) limitOpen() error {
+	max := s.MaxOpen
+	if max <= 0 {
+		max = 64
+	}
+	for len(s.open) > max {
+		if err := s.closeFd(s.open[0]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Splitter) closeFd(out *splitOut) error {
+	for i, o := range s.open {
+		if o == out {
+			s.open = append(s.open[:i], s.open[i+1:]...)
+			break
+		}
+	}
+	if out.fd == nil {
+		return nil
+	}
+	err := out.w.Flush()
+	if cerr := out.fd.Close(); err == nil {
+		err = cerr
+	}
+	out.fd, out.w = nil, nil
+	if err != nil {
+		return fmt.Errorf("Splitter error closing '%s': '%v'", out.path, err)
+	}
+	return nil
+}
+
+// finish closes out for good, and writes its index.
+func (s *Splitter) finish(out *splitOut) error {
+	if err := s.closeFd(out); err != nil {
+		return err
+	}
+	if s.Index {
+		return WriteIndex(IndexPath(out.path), out.idx.Entries)
+	}
+	return nil
+}
+
+// ParseByteSize parses a size such as "1048576", "64K", "500MB",
+// or "1G", with units of 1024.
+func ParseByteSize(s string) (int64, error) {
+	t := strings.ToUpper(strings.TrimSpace(s))
+	t = strings.TrimSuffix(t, "B")
+	mult := int64(1)
+	if n := len(t); n > 0 {
+		switch t[n-1] {
+		case 'K':
+			mult = 1 << 10
+		case 'M':
+			mult = 1 << 20
+		case 'G':
+			mult = 1 << 30
+		case 'T':
+			mult = 1 << 40
+		}
+		if mult > 1 {
+			t = t[:n-1]
+		}
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(t), 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("bad size '%s': use bytes, or a count with K, M, G, or T", s)
+	}
+	return n * mult, nil
+}
@@ -0,0 +1,118 @@
+package tm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test270SplitByPeriodKeyAndSize(t *testing.T) {
+
+	// 3 hours of frames from 22:30, every 10 minutes, alternating evtnums.
+	start := 22*3600 + 30*60
+	var frames []*Frame
+	for i := 0; i < 18; i++ {
+		evtnum := EvOneFloat64
+		if i%2 == 1 {
+			evtnum = EvTwo64
+		}
+		frames = append(frames, GenTestFrame(start+i*600, evtnum, float64(i), int64(i), nil))
+	}
+	readAll := func(path string) []*Frame {
+		got, err := ReadAllFrames(path)
+		panicOn(err)
+		return got
+	}
+	split := func(setup func(s *Splitter)) (string, *Splitter) {
+		dir, err := ioutil.TempDir("", "tfsplit")
+		panicOn(err)
+		s := NewSplitter(dir)
+		setup(s)
+		for _, f := range frames {
+			panicOn(s.Write(f, nil))
+		}
+		panicOn(s.Close())
+		return dir, s
+	}
+
+	cv.Convey("Splitter by hour and evtnum should write the archiver's YYYY/MM/DD/stream layout, with indexes", t, func() {
+		iv, err := ParseGroupInterval("hour")
+		panicOn(err)
+		dir, s := split(func(s *Splitter) {
+			s.Stream = "ticks"
+			s.Interval = &iv
+			s.Key = EvtnumKey
+			s.Index = true
+		})
+		defer os.RemoveAll(dir)
+		cv.So(len(s.Paths), cv.ShouldEqual, 8)
+		cv.So(s.Paths[0], cv.ShouldEqual, filepath.Join(dir, "2016/03/10/ticks.2.2200"))
+		cv.So(s.Paths[1], cv.ShouldEqual, filepath.Join(dir, "2016/03/10/ticks.3.2200"))
+		cv.So(s.Paths[2], cv.ShouldEqual, filepath.Join(dir, "2016/03/10/ticks.3.2300"))
+		cv.So(s.Paths[4], cv.ShouldEqual, filepath.Join(dir, "2016/03/11/ticks.3.0000"))
+
+		got := readAll(filepath.Join(dir, "2016/03/11/ticks.3.0000"))
+		cv.So(len(got), cv.ShouldEqual, 3)
+		cv.So(got[0].V0, cv.ShouldEqual, 9)
+		total := 0
+		for _, p := range s.Paths {
+			total += len(readAll(p))
+			idx, err := ReadIndexFor(p)
+			panicOn(err)
+			cv.So(len(idx), cv.ShouldBeGreaterThan, 0)
+			cv.So(idx[0].Offset, cv.ShouldEqual, 0)
+		}
+		cv.So(total, cv.ShouldEqual, len(frames))
+	})
+
+	cv.Convey("Splitter by size should start a new chunk before a file would exceed MaxBytes, and name files by template", t, func() {
+		dir, s := split(func(s *Splitter) {
+			s.MaxBytes = 5 * 24 // EvTwo64 frames are 24 bytes, EvOneFloat64 16.
+			s.Template = "{stream}-{yyyy}{mm}{dd}-{seq}.tf"
+		})
+		defer os.RemoveAll(dir)
+		cv.So(len(s.Paths), cv.ShouldEqual, 3)
+		cv.So(s.Paths[0], cv.ShouldEqual, filepath.Join(dir, "stream-20160310-0000.tf"))
+		cv.So(s.Paths[2], cv.ShouldEqual, filepath.Join(dir, "stream-20160311-0002.tf"))
+		n := 0
+		for _, p := range s.Paths {
+			fi, err := os.Stat(p)
+			panicOn(err)
+			cv.So(fi.Size(), cv.ShouldBeLessThanOrEqualTo, 5*24)
+			for _, f := range readAll(p) {
+				cv.So(f.V0, cv.ShouldEqual, n)
+				n++
+			}
+		}
+		cv.So(n, cv.ShouldEqual, len(frames))
+	})
+
+	cv.Convey("Splitter should refuse to give two outputs the same path, or to overwrite a file", t, func() {
+		dir, err := ioutil.TempDir("", "tfsplit")
+		panicOn(err)
+		defer os.RemoveAll(dir)
+		s := NewSplitter(dir)
+		s.Key = EvtnumKey
+		s.Template = "same.tf"
+		panicOn(s.Write(frames[0], nil))
+		cv.So(s.Write(frames[1], nil), cv.ShouldNotBeNil)
+		panicOn(s.Close())
+
+		s = NewSplitter(dir)
+		s.Template = "same.tf"
+		cv.So(s.Write(frames[0], nil), cv.ShouldNotBeNil)
+	})
+
+	cv.Convey("ParseByteSize should take units of 1024", t, func() {
+		for in, want := range map[string]int64{"100": 100, "64K": 64 << 10, "500MB": 500 << 20, "1g": 1 << 30} {
+			n, err := ParseByteSize(in)
+			cv.So(err, cv.ShouldBeNil)
+			cv.So(n, cv.ShouldEqual, want)
+		}
+		_, err := ParseByteSize("lots")
+		cv.So(err, cv.ShouldNotBeNil)
+	})
+}
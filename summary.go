@@ -0,0 +1,298 @@
+package tm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+// SummaryQuantiles are the quantiles that a SummaryReport gives.
+var SummaryQuantiles = []float64{0.01, 0.05, 0.25, 0.5, 0.75, 0.95, 0.99}
+
+// Summary accumulates the statistics of a stream of frames, for
+// data-quality checks: see Report. Summaries of consecutive parts
+// of a stream can be combined with Merge, as for a parallel scan.
+type Summary struct {
+	Frames int64
+	Bytes  int64
+
+	First int64 // timestamp of the first frame
+	Last  int64 // and of the last
+	MinTm int64
+	MaxTm int64
+
+	// OutOfOrder counts frames earlier than the frame before them,
+	// and DupTimestamps frames at the same time as the one before.
+	OutOfOrder    int64
+	DupTimestamps int64
+
+	Evtnums map[Evtnum]int64
+	PTIs    map[PTI]int64
+
+	// Payload sizes, of the PtiUDE frames.
+	PayloadSizes *QuantileSketch
+
+	// V0 of the PtiOneFloat64 and PtiTwo64 frames; NaNs are
+	// counted in V0NaN, and infinities in V0Inf, not in the
+	// statistics.
+	V0    *QuantileSketch
+	V0NaN int64
+	V0Inf int64
+	v0M   float64 // Welford running mean
+	v0S   float64 // and sum of squared deviations
+}
+
+// NewSummary makes an empty Summary.
+func NewSummary() *Summary {
+	return &Summary{
+		Evtnums:      make(map[Evtnum]int64),
+		PTIs:         make(map[PTI]int64),
+		PayloadSizes: NewQuantileSketch(0.01),
+		V0:           NewQuantileSketch(0.01),
+	}
+}
+
+// Add accounts for f, which was nbytes long in the stream.
+func (s *Summary) Add(f *Frame, nbytes int64) {
+	tm := f.Tm()
+	if s.Frames == 0 {
+		s.First, s.MinTm, s.MaxTm = tm, tm, tm
+	} else {
+		switch {
+		case tm < s.Last:
+			s.OutOfOrder++
+		case tm == s.Last:
+			s.DupTimestamps++
+		}
+		if tm < s.MinTm {
+			s.MinTm = tm
+		}
+		if tm > s.MaxTm {
+			s.MaxTm = tm
+		}
+	}
+	s.Last = tm
+	s.Frames++
+	s.Bytes += nbytes
+
+	pti := f.GetPTI()
+	s.PTIs[pti]++
+	s.Evtnums[f.GetEvtnum()]++
+	switch pti {
+	case PtiUDE:
+		s.PayloadSizes.Add(float64(len(f.Data)))
+	case PtiOneFloat64, PtiTwo64:
+		if math.IsNaN(f.V0) {
+			s.V0NaN++
+			return
+		}
+		if math.IsInf(f.V0, 0) {
+			s.V0Inf++
+			return
+		}
+		s.V0.Add(f.V0)
+		n := float64(s.V0.Count())
+		d := f.V0 - s.v0M
+		s.v0M += d / n
+		s.v0S += d * (f.V0 - s.v0M)
+	}
+}
+
+// Merge adds o, the summary of the frames following those of s, to s.
+func (s *Summary) Merge(o *Summary) {
+	if o.Frames == 0 {
+		return
+	}
+	if s.Frames == 0 {
+		s.First, s.MinTm, s.MaxTm = o.First, o.MinTm, o.MaxTm
+	} else {
+		switch {
+		case o.First < s.Last:
+			s.OutOfOrder++
+		case o.First == s.Last:
+			s.DupTimestamps++
+		}
+		if o.MinTm < s.MinTm {
+			s.MinTm = o.MinTm
+		}
+		if o.MaxTm > s.MaxTm {
+			s.MaxTm = o.MaxTm
+		}
+	}
+	s.Last = o.Last
+	s.Frames += o.Frames
+	s.Bytes += o.Bytes
+	s.OutOfOrder += o.OutOfOrder
+	s.DupTimestamps += o.DupTimestamps
+	for k, n := range o.Evtnums {
+		s.Evtnums[k] += n
+	}
+	for k, n := range o.PTIs {
+		s.PTIs[k] += n
+	}
+	s.PayloadSizes.Merge(o.PayloadSizes)
+
+	// combine the running moments (Chan et al.).
+	na, nb := float64(s.V0.Count()), float64(o.V0.Count())
+	if nb > 0 {
+		d := o.v0M - s.v0M
+		s.v0M += d * nb / (na + nb)
+		s.v0S += o.v0S + d*d*na*nb/(na+nb)
+	}
+	s.V0.Merge(o.V0)
+	s.V0NaN += o.V0NaN
+	s.V0Inf += o.V0Inf
+}
+
+// SummaryReport is the report of a Summary, ready for
+// printing as text with WriteText, or as JSON.
+type SummaryReport struct {
+	Frames        int64            `json:"frames"`
+	Bytes         int64            `json:"bytes"`
+	First         string           `json:"first,omitempty"`
+	Last          string           `json:"last,omitempty"`
+	Min           string           `json:"min_time,omitempty"`
+	Max           string           `json:"max_time,omitempty"`
+	OutOfOrder    int64            `json:"out_of_order"`
+	DupTimestamps int64            `json:"dup_timestamps"`
+	Evtnums       map[string]int64 `json:"evtnums"`
+	PTIs          map[string]int64 `json:"ptis"`
+
+	PayloadFrames    int64              `json:"payload_frames"`
+	PayloadQuantiles map[string]float64 `json:"payload_size_quantiles,omitempty"`
+
+	V0 *V0Report `json:"v0,omitempty"`
+}
+
+// V0Report gives the statistics of V0 in a SummaryReport.
+// The quantiles are estimates, to 1%.
+type V0Report struct {
+	Count     int64              `json:"count"`
+	NaN       int64              `json:"nan"`
+	Inf       int64              `json:"inf"`
+	Min       float64            `json:"min"`
+	Max       float64            `json:"max"`
+	Mean      float64            `json:"mean"`
+	Stddev    float64            `json:"stddev"`
+	Quantiles map[string]float64 `json:"quantiles"`
+}
+
+func quantileName(q float64) string {
+	return fmt.Sprintf("p%v", q*100)
+}
+
+func sketchQuantiles(sk *QuantileSketch) map[string]float64 {
+	m := make(map[string]float64)
+	for _, q := range SummaryQuantiles {
+		m[quantileName(q)] = sk.Quantile(q)
+	}
+	return m
+}
+
+// Report computes the report of s.
+func (s *Summary) Report() *SummaryReport {
+	r := &SummaryReport{
+		Frames:        s.Frames,
+		Bytes:         s.Bytes,
+		OutOfOrder:    s.OutOfOrder,
+		DupTimestamps: s.DupTimestamps,
+		Evtnums:       make(map[string]int64),
+		PTIs:          make(map[string]int64),
+		PayloadFrames: s.PayloadSizes.Count(),
+	}
+	if s.Frames > 0 {
+		show := func(tm int64) string { return time.Unix(0, tm).UTC().Format(time.RFC3339Nano) }
+		r.First, r.Last, r.Min, r.Max = show(s.First), show(s.Last), show(s.MinTm), show(s.MaxTm)
+	}
+	for k, n := range s.Evtnums {
+		r.Evtnums[k.String()] = n
+	}
+	for k, n := range s.PTIs {
+		r.PTIs[k.String()] = n
+	}
+	if s.PayloadSizes.Count() > 0 {
+		r.PayloadQuantiles = sketchQuantiles(s.PayloadSizes)
+	}
+	if n := s.V0.Count(); n > 0 || s.V0NaN > 0 || s.V0Inf > 0 {
+		r.V0 = &V0Report{Count: n, NaN: s.V0NaN, Inf: s.V0Inf}
+		if n > 0 {
+			r.V0.Min = s.V0.Quantile(0)
+			r.V0.Max = s.V0.Quantile(1)
+			r.V0.Mean = s.v0M
+			r.V0.Stddev = math.Sqrt(s.v0S / float64(n))
+			r.V0.Quantiles = sketchQuantiles(s.V0)
+		}
+	}
+	return r
+}
+
+// WriteText prints r for people.
+func (r *SummaryReport) WriteText(w io.Writer) error {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "frames:         %d\n", r.Frames)
+	fmt.Fprintf(&b, "bytes:          %d\n", r.Bytes)
+	if r.Frames > 0 {
+		fmt.Fprintf(&b, "first:          %s\n", r.First)
+		fmt.Fprintf(&b, "last:           %s\n", r.Last)
+		if r.OutOfOrder > 0 {
+			fmt.Fprintf(&b, "min time:       %s\n", r.Min)
+			fmt.Fprintf(&b, "max time:       %s\n", r.Max)
+		}
+	}
+	fmt.Fprintf(&b, "out of order:   %d\n", r.OutOfOrder)
+	fmt.Fprintf(&b, "dup timestamps: %d\n", r.DupTimestamps)
+	writeCounts(&b, "evtnums", r.Evtnums)
+	writeCounts(&b, "ptis", r.PTIs)
+	if r.PayloadFrames > 0 {
+		fmt.Fprintf(&b, "payload sizes (%d frames):%s\n", r.PayloadFrames, quantileLine(r.PayloadQuantiles))
+	}
+	if v := r.V0; v != nil {
+		fmt.Fprintf(&b, "v0 (%d values, %d NaN, %d Inf):\n", v.Count, v.NaN, v.Inf)
+		if v.Count > 0 {
+			fmt.Fprintf(&b, "  min %v  max %v  mean %v  stddev %v\n", v.Min, v.Max, v.Mean, v.Stddev)
+			fmt.Fprintf(&b, " %s\n", quantileLine(v.Quantiles))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeCounts prints a histogram, most frequent first.
+func writeCounts(b *bytes.Buffer, title string, m map[string]int64) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Sort(&byCountDesc{keys: keys, m: m})
+	fmt.Fprintf(b, "%s:\n", title)
+	for _, k := range keys {
+		fmt.Fprintf(b, "  %-16s %d\n", k, m[k])
+	}
+}
+
+type byCountDesc struct {
+	keys []string
+	m    map[string]int64
+}
+
+func (s *byCountDesc) Len() int      { return len(s.keys) }
+func (s *byCountDesc) Swap(i, j int) { s.keys[i], s.keys[j] = s.keys[j], s.keys[i] }
+func (s *byCountDesc) Less(i, j int) bool {
+	a, b := s.m[s.keys[i]], s.m[s.keys[j]]
+	if a != b {
+		return a > b
+	}
+	return s.keys[i] < s.keys[j]
+}
+
+func quantileLine(m map[string]float64) string {
+	var b bytes.Buffer
+	for _, q := range SummaryQuantiles {
+		name := quantileName(q)
+		fmt.Fprintf(&b, " %s %v", name, m[name])
+	}
+	return b.String()
+}
@@ -0,0 +1,132 @@
+package tm
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test240SummaryAndQuantileSketch(t *testing.T) {
+
+	cv.Convey("QuantileSketch quantiles should be within RelAcc of the exact ones, also after Merge", t, func() {
+		rnd := rand.New(rand.NewSource(7))
+		var xs []float64
+		a := NewQuantileSketch(0.01)
+		b := NewQuantileSketch(0.01)
+		for i := 0; i < 20000; i++ {
+			x := rnd.NormFloat64()*100 + 20
+			if i%10 == 0 {
+				x = 0
+			}
+			xs = append(xs, x)
+			if i%2 == 0 {
+				a.Add(x)
+			} else {
+				b.Add(x)
+			}
+		}
+		a.Add(MyNaN)
+		a.Merge(b)
+		sort.Float64s(xs)
+		cv.So(a.Count(), cv.ShouldEqual, len(xs))
+		cv.So(a.Quantile(0), cv.ShouldEqual, xs[0])
+		cv.So(a.Quantile(1), cv.ShouldEqual, xs[len(xs)-1])
+		for _, q := range []float64{0.01, 0.1, 0.25, 0.5, 0.75, 0.9, 0.99} {
+			exact := xs[int(q*float64(len(xs)-1))]
+			cv.So(math.Abs(a.Quantile(q)-exact), cv.ShouldBeLessThanOrEqualTo, 0.01*math.Abs(exact)+1e-9)
+		}
+		cv.So(math.IsNaN(NewQuantileSketch(0.01).Quantile(0.5)), cv.ShouldBeTrue)
+	})
+
+	var frames []*Frame
+	add := func(f *Frame) { frames = append(frames, f) }
+	// times 0, 1, 1 (dup), 3, 2 (out of order), 4, 5, ...
+	secs := []int{0, 1, 1, 3, 2, 4, 5, 6, 7, 8}
+	for i, sec := range secs {
+		switch i % 3 {
+		case 0:
+			add(GenTestFrame(sec, EvOneFloat64, float64(i), 0, nil))
+		case 1:
+			add(GenTestFrame(sec, EvJson, 0, 0, []byte(`{"a":1}`)))
+		case 2:
+			add(GenTestFrame(sec, EvTwo64, float64(-i), int64(i), nil))
+		}
+	}
+	add(GenTestFrame(9, EvNaN, 0, 0, nil))
+	add(GenTestFrame(9, EvOneFloat64, MyNaN, 0, nil))
+
+	summarize := func(frames []*Frame) *Summary {
+		s := NewSummary()
+		for _, f := range frames {
+			by, err := f.Marshal(nil)
+			panicOn(err)
+			s.Add(f, int64(len(by)))
+		}
+		return s
+	}
+
+	cv.Convey("Summary should count frames, bytes, disorder, duplicate timestamps, evtnums, and PTIs, and give V0 statistics", t, func() {
+		r := summarize(frames).Report()
+		cv.So(r.Frames, cv.ShouldEqual, 12)
+		cv.So(r.First, cv.ShouldEqual, "2016-03-10T00:00:00Z")
+		cv.So(r.Last, cv.ShouldEqual, "2016-03-10T00:00:09Z")
+		cv.So(r.OutOfOrder, cv.ShouldEqual, 1)
+		cv.So(r.DupTimestamps, cv.ShouldEqual, 2)
+		cv.So(r.Evtnums["EvJson"], cv.ShouldEqual, 3)
+		cv.So(r.Evtnums["EvNaN"], cv.ShouldEqual, 1)
+		cv.So(r.PTIs["PtiUDE"], cv.ShouldEqual, 3)
+		cv.So(r.PTIs["PtiOneFloat64"], cv.ShouldEqual, 5)
+		cv.So(r.PayloadFrames, cv.ShouldEqual, 3)
+		cv.So(r.PayloadQuantiles["p50"], cv.ShouldAlmostEqual, 7, 0.07)
+
+		// V0 values: 0, -2, 3, -5, 6, -8, 9; and one NaN.
+		cv.So(r.V0.Count, cv.ShouldEqual, 7)
+		cv.So(r.V0.NaN, cv.ShouldEqual, 1)
+		cv.So(r.V0.Min, cv.ShouldEqual, -8)
+		cv.So(r.V0.Max, cv.ShouldEqual, 9)
+		cv.So(r.V0.Mean, cv.ShouldAlmostEqual, 3.0/7, 1e-12)
+		var ss float64
+		for _, x := range []float64{0, -2, 3, -5, 6, -8, 9} {
+			ss += (x - 3.0/7) * (x - 3.0/7)
+		}
+		cv.So(r.V0.Stddev, cv.ShouldAlmostEqual, math.Sqrt(ss/7), 1e-12)
+	})
+
+	cv.Convey("Summaries of consecutive parts should Merge into the summary of the whole", t, func() {
+		whole := summarize(frames).Report()
+		for cut := 0; cut <= len(frames); cut++ {
+			s := summarize(frames[:cut])
+			s.Merge(summarize(frames[cut:]))
+			r := s.Report()
+			cv.So(r.OutOfOrder, cv.ShouldEqual, whole.OutOfOrder)
+			cv.So(r.DupTimestamps, cv.ShouldEqual, whole.DupTimestamps)
+			cv.So(r.First, cv.ShouldEqual, whole.First)
+			cv.So(r.Bytes, cv.ShouldEqual, whole.Bytes)
+			cv.So(r.Evtnums, cv.ShouldResemble, whole.Evtnums)
+			cv.So(r.V0.Mean, cv.ShouldAlmostEqual, whole.V0.Mean, 1e-12)
+			cv.So(r.V0.Stddev, cv.ShouldAlmostEqual, whole.V0.Stddev, 1e-12)
+		}
+	})
+
+	cv.Convey("Infinite V0s should be counted apart, leaving the statistics finite and the report valid JSON", t, func() {
+		s := summarize(append(frames,
+			GenTestFrame(10, EvOneFloat64, math.Inf(1), 0, nil),
+			GenTestFrame(11, EvTwo64, math.Inf(-1), 1, nil)))
+		r := s.Report()
+		cv.So(r.V0.Count, cv.ShouldEqual, 7)
+		cv.So(r.V0.Inf, cv.ShouldEqual, 2)
+		cv.So(r.V0.Max, cv.ShouldEqual, 9)
+		cv.So(r.V0.Mean, cv.ShouldAlmostEqual, 3.0/7, 1e-12)
+		_, err := json.Marshal(r)
+		cv.So(err, cv.ShouldBeNil)
+
+		var b bytes.Buffer
+		panicOn(r.WriteText(&b))
+		cv.So(b.String(), cv.ShouldContainSubstring, "v0 (7 values, 1 NaN, 2 Inf)")
+	})
+}
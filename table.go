@@ -0,0 +1,241 @@
+package tm
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/glycerine/zebrapack/zebra"
+)
+
+// TableColumns are the columns that a TableWriter always writes,
+// in order. Field columns follow them.
+var TableColumns = []string{"time", "pti", "evtnum", "v0", "v1", "payload"}
+
+// TableWriter writes frames as rows of CSV, TSV, or JSON Lines, with
+// the stable columns of TableColumns, for loading into tools such as
+// pandas, DuckDB, or a spreadsheet:
+//
+//	time     RFC3339Nano in UTC, or with EpochTime, integer nanoseconds
+//	pti      the PTI, as a number
+//	evtnum   the evtnum, as a number
+//	v0       for PtiZero, PtiOneFloat64, and PtiTwo64; else empty
+//	v1       for PtiOneInt64 and PtiTwo64; else empty
+//	payload  JSON, msgpack, and ZebraPack payloads as JSON text; other
+//	         payloads as text if they are UTF-8, and as base64 if not
+//
+// Each of Fields adds a column, named for its path, holding the
+// value found there in the decoded payload (see ExtractFields),
+// flattening the payload into columns. Absent values are empty
+// in CSV and TSV, and null in JSON Lines.
+//
+// CSV and TSV start with a header row. TSV escapes tabs, newlines,
+// and backslashes with backslashes. JSON Lines embeds decoded
+// payloads as JSON rather than as text, and writes NaN as null.
+type TableWriter struct {
+	Format    string // "csv", "tsv", or "jsonl"
+	EpochTime bool
+	Fields    []*FieldPath
+	ZSchema   *zebra.Schema
+
+	w      io.Writer
+	csv    *csv.Writer
+	header bool
+	buf    bytes.Buffer
+}
+
+// NewTableWriter makes a TableWriter of format onto w.
+func NewTableWriter(w io.Writer, format string) (*TableWriter, error) {
+	t := &TableWriter{Format: format, w: w}
+	switch format {
+	case "csv":
+		t.csv = csv.NewWriter(w)
+	case "tsv", "jsonl":
+	default:
+		return nil, fmt.Errorf("unknown table format '%s': use csv, tsv, or jsonl", format)
+	}
+	return t, nil
+}
+
+// cell is one value of a row. For JSON Lines, json holds it
+// rendered as JSON; text is the CSV and TSV form.
+type cell struct {
+	text string
+	json string
+}
+
+func textCell(s string) cell { return cell{text: s, json: jsonString(s)} }
+
+func jsonString(s string) string {
+	by, _ := json.Marshal(s)
+	return string(by)
+}
+
+func numCell(x float64) cell {
+	s := strconv.FormatFloat(x, 'g', -1, 64)
+	if math.IsNaN(x) || math.IsInf(x, 0) {
+		return cell{text: s, json: "null"}
+	}
+	return cell{text: s, json: s}
+}
+
+func intCell(x int64) cell {
+	s := strconv.FormatInt(x, 10)
+	return cell{text: s, json: s}
+}
+
+var nullCell = cell{json: "null"}
+
+// valueCell renders a decoded payload value.
+func valueCell(v interface{}) cell {
+	if x, ok := PayloadFloat(v); ok {
+		return numCell(x)
+	}
+	if v == nil {
+		return nullCell
+	}
+	by, err := PayloadJSON(v)
+	if err != nil {
+		return textCell(PayloadString(v))
+	}
+	return cell{text: PayloadString(v), json: string(by)}
+}
+
+func (t *TableWriter) row(f *Frame) []cell {
+	cells := make([]cell, 0, len(TableColumns)+len(t.Fields))
+	if t.EpochTime {
+		cells = append(cells, intCell(f.Tm()))
+	} else {
+		cells = append(cells, textCell(time.Unix(0, f.Tm()).UTC().Format(time.RFC3339Nano)))
+	}
+	pti := f.GetPTI()
+	cells = append(cells, intCell(int64(pti)), intCell(int64(f.GetEvtnum())))
+	switch pti {
+	case PtiZero, PtiOneFloat64, PtiTwo64:
+		cells = append(cells, numCell(f.GetV0()))
+	default:
+		cells = append(cells, nullCell)
+	}
+	switch pti {
+	case PtiOneInt64, PtiTwo64:
+		cells = append(cells, intCell(f.Ude))
+	default:
+		cells = append(cells, nullCell)
+	}
+
+	var decoded interface{}
+	structured := false
+	switch {
+	case pti != PtiUDE || len(f.Data) == 0:
+		cells = append(cells, nullCell)
+	case HasStructuredPayload(f.GetEvtnum()):
+		v, err := f.DecodePayload(t.ZSchema)
+		if err == nil {
+			decoded, structured = v, true
+			by, err := PayloadJSON(v)
+			if err == nil {
+				cells = append(cells, cell{text: string(by), json: string(by)})
+				break
+			}
+		}
+		fallthrough
+	default:
+		if utf8.Valid(f.Data) {
+			cells = append(cells, textCell(string(f.Data)))
+		} else {
+			cells = append(cells, textCell(base64.StdEncoding.EncodeToString(f.Data)))
+		}
+	}
+
+	for _, p := range t.Fields {
+		if !structured {
+			cells = append(cells, nullCell)
+			continue
+		}
+		x, ok := p.Lookup(decoded)
+		if !ok {
+			cells = append(cells, nullCell)
+			continue
+		}
+		cells = append(cells, valueCell(x))
+	}
+	return cells
+}
+
+func (t *TableWriter) columns() []string {
+	cols := append([]string{}, TableColumns...)
+	for _, p := range t.Fields {
+		cols = append(cols, p.String())
+	}
+	return cols
+}
+
+var tsvEscaper = strings.NewReplacer("\\", "\\\\", "\t", "\\t", "\n", "\\n", "\r", "\\r")
+
+// Write writes f as one row.
+func (t *TableWriter) Write(f *Frame) error {
+	cells := t.row(f)
+	switch t.Format {
+	case "csv":
+		if !t.header {
+			t.header = true
+			if err := t.csv.Write(t.columns()); err != nil {
+				return err
+			}
+		}
+		rec := make([]string, len(cells))
+		for i, c := range cells {
+			rec[i] = c.text
+		}
+		return t.csv.Write(rec)
+
+	case "tsv":
+		t.buf.Reset()
+		if !t.header {
+			t.header = true
+			t.buf.WriteString(strings.Join(t.columns(), "\t"))
+			t.buf.WriteString("\n")
+		}
+		for i, c := range cells {
+			if i > 0 {
+				t.buf.WriteString("\t")
+			}
+			t.buf.WriteString(tsvEscaper.Replace(c.text))
+		}
+		t.buf.WriteString("\n")
+		_, err := t.buf.WriteTo(t.w)
+		return err
+	}
+
+	// jsonl
+	t.buf.Reset()
+	t.buf.WriteString("{")
+	for i, col := range t.columns() {
+		if i > 0 {
+			t.buf.WriteString(",")
+		}
+		t.buf.WriteString(jsonString(col))
+		t.buf.WriteString(":")
+		t.buf.WriteString(cells[i].json)
+	}
+	t.buf.WriteString("}\n")
+	_, err := t.buf.WriteTo(t.w)
+	return err
+}
+
+// Flush writes any buffered rows.
+func (t *TableWriter) Flush() error {
+	if t.csv != nil {
+		t.csv.Flush()
+		return t.csv.Error()
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package tm
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test250TableWriterFormats(t *testing.T) {
+
+	mk := func(evtnum Evtnum, v0 float64, v1 int64, data []byte) *Frame {
+		f, err := NewFrame(GenTestT0.Add(time.Second/2), evtnum, v0, v1, data)
+		panicOn(err)
+		return f
+	}
+	frames := []*Frame{
+		mk(EvTwo64, 1.5, -3, nil),
+		mk(EvJson, 0, 0, []byte(`{"a":{"b":[1,2,{"c":"x\ty"}]},"n":null}`)),
+		mk(EvNA, 0, 0, nil),
+		mk(Evtnum(20), 0, 0, []byte{0xff, 0x01}),
+	}
+	fields := []*FieldPath{}
+	for _, src := range []string{"a.b[2].c", "a.b[0]", "n"} {
+		fp, err := ParseFieldPath(src)
+		panicOn(err)
+		fields = append(fields, fp)
+	}
+	write := func(format string, epoch bool) string {
+		var buf bytes.Buffer
+		tw, err := NewTableWriter(&buf, format)
+		panicOn(err)
+		tw.EpochTime = epoch
+		tw.Fields = fields
+		for _, f := range frames {
+			panicOn(tw.Write(f))
+		}
+		panicOn(tw.Flush())
+		return buf.String()
+	}
+
+	cv.Convey("TableWriter CSV should have the stable columns, then the field columns", t, func() {
+		recs, err := csv.NewReader(strings.NewReader(write("csv", false))).ReadAll()
+		panicOn(err)
+		cv.So(recs, cv.ShouldResemble, [][]string{
+			{"time", "pti", "evtnum", "v0", "v1", "payload", "a.b[2].c", "a.b[0]", "n"},
+			{"2016-03-10T00:00:00.5Z", "3", "3", "1.5", "-3", "", "", "", ""},
+			{"2016-03-10T00:00:00.5Z", "7", "14", "", "", `{"a":{"b":[1,2,{"c":"x\ty"}]},"n":null}`, "x\ty", "1", ""},
+			{"2016-03-10T00:00:00.5Z", "5", "5", "", "", "", "", "", ""},
+			{"2016-03-10T00:00:00.5Z", "7", "20", "", "", "/wE=", "", "", ""},
+		})
+	})
+
+	cv.Convey("TableWriter TSV should escape tabs, and give epoch times on request", t, func() {
+		lines := strings.Split(write("tsv", true), "\n")
+		cv.So(lines[0], cv.ShouldEqual, "time\tpti\tevtnum\tv0\tv1\tpayload\ta.b[2].c\ta.b[0]\tn")
+		cv.So(lines[1], cv.ShouldEqual, "1457568000500000000\t3\t3\t1.5\t-3\t\t\t\t")
+		cv.So(strings.Split(lines[2], "\t")[6], cv.ShouldEqual, `x\ty`)
+		cv.So(len(lines), cv.ShouldEqual, 6)
+	})
+
+	cv.Convey("TableWriter JSON Lines should embed payloads as JSON, with nulls for absent values", t, func() {
+		lines := strings.Split(strings.TrimSpace(write("jsonl", false)), "\n")
+		cv.So(len(lines), cv.ShouldEqual, 4)
+		var row map[string]interface{}
+		panicOn(json.Unmarshal([]byte(lines[1]), &row))
+		cv.So(row["time"], cv.ShouldEqual, "2016-03-10T00:00:00.5Z")
+		cv.So(row["evtnum"], cv.ShouldEqual, 14)
+		cv.So(row["v0"], cv.ShouldBeNil)
+		cv.So(row["a.b[2].c"], cv.ShouldEqual, "x\ty")
+		cv.So(row["a.b[0]"], cv.ShouldEqual, 1)
+		cv.So(row["payload"].(map[string]interface{})["a"], cv.ShouldNotBeNil)
+		panicOn(json.Unmarshal([]byte(lines[0]), &row))
+		cv.So(row["v1"], cv.ShouldEqual, -3)
+	})
+
+	cv.Convey("NewTableWriter should reject unknown formats", t, func() {
+		_, err := NewTableWriter(&bytes.Buffer{}, "xml")
+		cv.So(err, cv.ShouldNotBeNil)
+	})
+}
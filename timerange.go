@@ -0,0 +1,217 @@
+package tm
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimeRange selects the frames with Start <= Tm() < End. Without
+// HasStart or HasEnd, that side of the range is open.
+type TimeRange struct {
+	Start    int64
+	End      int64
+	HasStart bool
+	HasEnd   bool
+}
+
+// IsSet reports whether r limits anything.
+func (r *TimeRange) IsSet() bool {
+	return r.HasStart || r.HasEnd
+}
+
+// Contains reports whether tm is in r.
+func (r *TimeRange) Contains(tm int64) bool {
+	return (!r.HasStart || tm >= r.Start) && (!r.HasEnd || tm < r.End)
+}
+
+// Past reports whether tm is at or after the end of r, so
+// that no later frame of a time ordered stream is in r.
+func (r *TimeRange) Past(tm int64) bool {
+	return r.HasEnd && tm >= r.End
+}
+
+// ParseTimeRange parses the bounds of a TimeRange. An empty bound
+// leaves that side open. Each bound is one of
+//
+//	a timestamp, as RFC3339 or a prefix of it, in UTC if
+//	    no zone is given: 2016-05-01T09:30:00.5Z, 2016-05-01T09:30,
+//	    2016-05-01
+//	a date, as ParseDate takes it: 2016/05/01, meaning its
+//	    first instant, UTC
+//	now
+//	a duration relative to now, with a sign: -2h, -90m, -3d
+//
+// end may also be a duration after start, as in +1h.
+func ParseTimeRange(start, end string, now time.Time) (TimeRange, error) {
+	var r TimeRange
+	var err error
+	if start != "" {
+		if strings.HasPrefix(start, "+") {
+			return r, fmt.Errorf("start '%s': only the end can be relative to the start", start)
+		}
+		r.Start, err = parseTimeBound(start, now)
+		if err != nil {
+			return r, fmt.Errorf("bad start: %v", err)
+		}
+		r.HasStart = true
+	}
+	if end != "" {
+		if strings.HasPrefix(end, "+") {
+			if !r.HasStart {
+				return r, fmt.Errorf("end '%s' is relative to the start, but no start was given", end)
+			}
+			d, err := parseSignedDuration(end)
+			if err != nil {
+				return r, fmt.Errorf("bad end: %v", err)
+			}
+			r.End = r.Start + int64(d)
+		} else {
+			r.End, err = parseTimeBound(end, now)
+			if err != nil {
+				return r, fmt.Errorf("bad end: %v", err)
+			}
+		}
+		r.HasEnd = true
+	}
+	if r.HasStart && r.HasEnd && r.End <= r.Start {
+		return r, fmt.Errorf("end %v is not after start %v",
+			time.Unix(0, r.End).UTC().Format(time.RFC3339Nano), time.Unix(0, r.Start).UTC().Format(time.RFC3339Nano))
+	}
+	return r, nil
+}
+
+func parseTimeBound(s string, now time.Time) (int64, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case s == "now":
+		return now.UnixNano(), nil
+	case strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+"):
+		d, err := parseSignedDuration(s)
+		if err != nil {
+			return 0, err
+		}
+		return now.Add(d).UnixNano(), nil
+	case strings.Count(s, "/") == 2:
+		d, err := ParseDate(s)
+		if err != nil {
+			return 0, err
+		}
+		return d.ToGoTime().UnixNano(), nil
+	}
+	return parsePredTime(s)
+}
+
+// parseSignedDuration parses what time.ParseDuration does, plus
+// a whole number of days, as in -3d.
+func parseSignedDuration(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		n, err := strconv.ParseInt(strings.TrimSuffix(s, "d"), 10, 64)
+		if err == nil {
+			return time.Duration(n) * 24 * time.Hour, nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("bad time '%s': use a timestamp, a date, now, or a duration such as -2h", s)
+	}
+	return d, nil
+}
+
+// IndexOffset returns the offset of the last index entry before
+// tm: in a time ordered file, every frame before it is earlier than
+// tm. It returns 0 if there is none, or if idx is not in time order.
+func IndexOffset(idx []IndexEntry, tm int64) int64 {
+	var off int64
+	for i, e := range idx {
+		if i > 0 && (e.Tm < idx[i-1].Tm || e.Offset < idx[i-1].Offset) {
+			return 0
+		}
+		if e.Tm < tm {
+			off = e.Offset
+		}
+	}
+	return off
+}
+
+// timeRangeReader passes on the raw bytes of the frames within tr.
+type timeRangeReader struct {
+	fr     *FrameReader
+	tr     TimeRange
+	sorted bool
+	frame  Frame
+	rest   []byte
+	err    error
+}
+
+// NewTimeRangeReader reads the frames of r, and passes on the raw
+// bytes of those within tr. If r is sorted by time, it stops at
+// the first frame past the end of tr, without reading the rest.
+func NewTimeRangeReader(r io.Reader, tr TimeRange, sorted bool) io.Reader {
+	return &timeRangeReader{fr: NewFrameReader(r, 1024*1024), tr: tr, sorted: sorted}
+}
+
+func (t *timeRangeReader) Read(p []byte) (int, error) {
+	for len(t.rest) == 0 {
+		if t.err != nil {
+			return 0, t.err
+		}
+		_, _, err, raw := t.fr.NextFrame(&t.frame)
+		if err != nil {
+			t.err = err
+			continue
+		}
+		tm := t.frame.Tm()
+		if t.sorted && t.tr.Past(tm) {
+			t.err = io.EOF
+			continue
+		}
+		if t.tr.Contains(tm) {
+			t.rest = raw
+		}
+	}
+	n := copy(p, t.rest)
+	t.rest = t.rest[n:]
+	return n, nil
+}
+
+// OpenTimeRange opens the TMFRAME file path for reading the frames
+// within tr. If the file is sorted by time, and has an up to date
+// .idx index (see ReadIndexFor), it starts reading at the index entry
+// nearest before tr.Start, instead of at the beginning. If tr is not
+// set, it returns the file itself.
+func OpenTimeRange(path string, tr TimeRange, sorted bool) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !tr.IsSet() {
+		return f, nil
+	}
+	if sorted && tr.HasStart {
+		idx, err := ReadIndexFor(path)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if off := IndexOffset(idx, tr.Start); off > 0 {
+			if _, err = f.Seek(off, 0); err != nil {
+				f.Close()
+				return nil, err
+			}
+		}
+	}
+	return &timeRangeFile{Reader: NewTimeRangeReader(f, tr, sorted), f: f}, nil
+}
+
+type timeRangeFile struct {
+	io.Reader
+	f *os.File
+}
+
+func (t *timeRangeFile) Close() error {
+	return t.f.Close()
+}
@@ -0,0 +1,185 @@
+package tm
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test290TimeRangeParsingAndReading(t *testing.T) {
+
+	now, err := time.Parse(time.RFC3339, "2016-05-01T12:00:00Z")
+	panicOn(err)
+	at := func(s string) int64 {
+		tm, err := time.Parse(time.RFC3339Nano, s)
+		panicOn(err)
+		return tm.UnixNano()
+	}
+
+	cv.Convey("ParseTimeRange should take timestamps, prefixes of them, dates, now, and relative durations", t, func() {
+		r, err := ParseTimeRange("2016-05-01T09:30:00.5Z", "2016-05-01T10", now)
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(r.Start, cv.ShouldEqual, at("2016-05-01T09:30:00.5Z"))
+		cv.So(r.End, cv.ShouldEqual, at("2016-05-01T10:00:00Z"))
+
+		r, err = ParseTimeRange("2016/04/30", "2016-05-01", now)
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(r.Start, cv.ShouldEqual, at("2016-04-30T00:00:00Z"))
+		cv.So(r.End, cv.ShouldEqual, at("2016-05-01T00:00:00Z"))
+
+		r, err = ParseTimeRange("-2h", "now", now)
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(r.Start, cv.ShouldEqual, at("2016-05-01T10:00:00Z"))
+		cv.So(r.End, cv.ShouldEqual, now.UnixNano())
+
+		r, err = ParseTimeRange("-1d", "", now)
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(r.Start, cv.ShouldEqual, at("2016-04-30T12:00:00Z"))
+		cv.So(r.HasEnd, cv.ShouldBeFalse)
+
+		r, err = ParseTimeRange("2016-05-01T09:00", "+90m", now)
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(r.End, cv.ShouldEqual, at("2016-05-01T10:30:00Z"))
+
+		r, err = ParseTimeRange("", "", now)
+		cv.So(err, cv.ShouldBeNil)
+		cv.So(r.IsSet(), cv.ShouldBeFalse)
+		cv.So(r.Contains(0), cv.ShouldBeTrue)
+	})
+
+	cv.Convey("ParseTimeRange should reject bad bounds, an empty range, and +end without a start", t, func() {
+		_, err := ParseTimeRange("yesterday", "", now)
+		cv.So(err, cv.ShouldNotBeNil)
+		_, err = ParseTimeRange("2016-05-01T10", "2016-05-01T09", now)
+		cv.So(err, cv.ShouldNotBeNil)
+		_, err = ParseTimeRange("", "+1h", now)
+		cv.So(err, cv.ShouldNotBeNil)
+		_, err = ParseTimeRange("+1h", "", now)
+		cv.So(err, cv.ShouldNotBeNil)
+	})
+
+	// 100 frames, 1 second apart
+	tm0 := time.Unix(0, at("2016-05-01T09:00:00Z"))
+	var buf bytes.Buffer
+	var offsets []int64
+	var ib IndexBuilder
+	for i := 0; i < 100; i++ {
+		f, err := NewFrame(tm0.Add(time.Duration(i)*time.Second), EvOneFloat64, float64(i), 0, nil)
+		panicOn(err)
+		b, err := f.Marshal(nil)
+		panicOn(err)
+		offsets = append(offsets, int64(buf.Len()))
+		ib.Add(f.Tm(), int64(len(b)))
+		buf.Write(b)
+	}
+	tr := TimeRange{
+		Start: tm0.Add(20 * time.Second).UnixNano(), HasStart: true,
+		End: tm0.Add(30 * time.Second).UnixNano(), HasEnd: true,
+	}
+	values := func(by []byte) []float64 {
+		var vs []float64
+		fr := NewFrameReader(bytes.NewBuffer(by), 1024*1024)
+		var frame Frame
+		for {
+			_, _, err, _ := fr.NextFrame(&frame)
+			if err != nil {
+				break
+			}
+			vs = append(vs, frame.V0)
+		}
+		return vs
+	}
+
+	cv.Convey("NewTimeRangeReader should pass on only the frames in range, and stop early on sorted input", t, func() {
+		src := bytes.NewReader(buf.Bytes())
+		by, err := ioutil.ReadAll(NewTimeRangeReader(src, tr, true))
+		cv.So(err, cv.ShouldBeNil)
+		vs := values(by)
+		cv.So(len(vs), cv.ShouldEqual, 10)
+		cv.So(vs[0], cv.ShouldEqual, 20)
+		cv.So(vs[9], cv.ShouldEqual, 29)
+		cv.So(src.Len(), cv.ShouldBeGreaterThan, 0)
+
+		// out of order: a late frame is still found when unsorted
+		late, err := NewFrame(tm0.Add(25*time.Second), EvOneFloat64, 1000, 0, nil)
+		panicOn(err)
+		lb, err := late.Marshal(nil)
+		panicOn(err)
+		mixed := append(append([]byte{}, buf.Bytes()...), lb...)
+		by, err = ioutil.ReadAll(NewTimeRangeReader(bytes.NewReader(mixed), tr, false))
+		cv.So(err, cv.ShouldBeNil)
+		vs = values(by)
+		cv.So(len(vs), cv.ShouldEqual, 11)
+		cv.So(vs[10], cv.ShouldEqual, 1000)
+	})
+
+	cv.Convey("IndexOffset should give the last entry before the time, and 0 for an index out of order", t, func() {
+		idx := []IndexEntry{{Tm: 10, Offset: 0}, {Tm: 20, Offset: 100}, {Tm: 30, Offset: 200}}
+		cv.So(IndexOffset(idx, 5), cv.ShouldEqual, 0)
+		cv.So(IndexOffset(idx, 20), cv.ShouldEqual, 0)
+		cv.So(IndexOffset(idx, 21), cv.ShouldEqual, 100)
+		cv.So(IndexOffset(idx, 99), cv.ShouldEqual, 200)
+		idx[2].Tm = 15
+		cv.So(IndexOffset(idx, 99), cv.ShouldEqual, 0)
+	})
+
+	cv.Convey("OpenTimeRange should pass the file through unchanged without a range, and seek by the .idx of a sorted file", t, func() {
+		dir, err := ioutil.TempDir("", "timerange")
+		panicOn(err)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "ticks")
+		panicOn(ioutil.WriteFile(path, buf.Bytes(), 0644))
+
+		// no range: the file itself, bytes unchanged
+		r, err := OpenTimeRange(path, TimeRange{}, true)
+		panicOn(err)
+		_, isFile := r.(*os.File)
+		cv.So(isFile, cv.ShouldBeTrue)
+		by, err := ioutil.ReadAll(r)
+		panicOn(err)
+		r.Close()
+		cv.So(bytes.Equal(by, buf.Bytes()), cv.ShouldBeTrue)
+
+		// no index: reads from the start
+		r, err = OpenTimeRange(path, tr, true)
+		panicOn(err)
+		by, err = ioutil.ReadAll(r)
+		panicOn(err)
+		r.Close()
+		cv.So(len(values(by)), cv.ShouldEqual, 10)
+
+		// with the real index
+		panicOn(WriteIndex(IndexPath(path), ib.Entries))
+		r, err = OpenTimeRange(path, tr, true)
+		panicOn(err)
+		by, err = ioutil.ReadAll(r)
+		panicOn(err)
+		r.Close()
+		cv.So(len(values(by)), cv.ShouldEqual, 10)
+
+		// an index that points past frame 25 shows the seek happened,
+		// and -unsorted ignores it.
+		bogus := []IndexEntry{{Tm: tm0.UnixNano(), Offset: offsets[25]}}
+		panicOn(WriteIndex(IndexPath(path), bogus))
+		r, err = OpenTimeRange(path, tr, true)
+		panicOn(err)
+		by, err = ioutil.ReadAll(r)
+		panicOn(err)
+		r.Close()
+		vs := values(by)
+		cv.So(len(vs), cv.ShouldEqual, 5)
+		cv.So(vs[0], cv.ShouldEqual, 25)
+
+		r, err = OpenTimeRange(path, tr, false)
+		panicOn(err)
+		by, err = ioutil.ReadAll(r)
+		panicOn(err)
+		r.Close()
+		cv.So(len(values(by)), cv.ShouldEqual, 10)
+	})
+}
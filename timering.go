@@ -0,0 +1,264 @@
+package tm
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// ErrTimeRingClosed is returned by Append and the
+// Wait methods once a TimeFrameRingBuf is closed.
+var ErrTimeRingClosed = fmt.Errorf("TimeFrameRingBuf closed")
+
+// ErrTimeRingCanceled is returned by the Wait
+// methods when their cancel channel is closed.
+var ErrTimeRingCanceled = fmt.Errorf("TimeFrameRingBuf wait canceled")
+
+// TimeFrameRingBuf holds the last Dur of a stream of frames, for
+// live displays. It is safe for concurrent use: writers Append,
+// and readers take Snapshots, or Wait for new frames to arrive.
+//
+// Frames are held in Tm() order in a FrameRingBuf that grows as
+// needed. A frame leaves once it is more than Dur behind the
+// newest frame appended, or behind the wall clock given to Evict.
+// Frames that arrive out of order are slotted into place; those
+// already older than the window on arrival are counted in Late
+// and dropped.
+type TimeFrameRingBuf struct {
+	Dur time.Duration
+
+	// MaxFrames, if > 0, bounds the number of frames held.
+	// Beyond it, the oldest frames are evicted early.
+	MaxFrames int
+
+	mu       sync.Mutex
+	ring     *FrameRingBuf
+	newest   int64
+	cutoff   int64 // frames with Tm() < cutoff are evicted
+	version  uint64
+	changed  chan struct{} // closed and replaced on each change
+	closed   bool
+	late     int64
+	overflow int64
+}
+
+// NewTimeFrameRingBuf makes a TimeFrameRingBuf holding frames
+// up to dur behind the newest. maxFrames <= 0 means no bound.
+func NewTimeFrameRingBuf(dur time.Duration, maxFrames int) *TimeFrameRingBuf {
+	n := 64
+	if maxFrames > 0 && maxFrames < n {
+		n = maxFrames
+	}
+	return &TimeFrameRingBuf{
+		Dur:       dur,
+		MaxFrames: maxFrames,
+		ring:      NewFrameRingBuf(n),
+		cutoff:    math.MinInt64,
+		changed:   make(chan struct{}),
+	}
+}
+
+// Append adds frames, evicting those that have
+// aged out. The frames must not be modified afterwards,
+// as Snapshots share them.
+func (b *TimeFrameRingBuf) Append(frames ...*Frame) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrTimeRingClosed
+	}
+	for _, f := range frames {
+		tm := f.Tm()
+		if tm < b.cutoff {
+			b.late++
+			continue
+		}
+		if b.ring.Readable == 0 || tm > b.newest {
+			b.newest = tm
+		}
+		if b.ring.WriteCapacity() == 0 {
+			if b.MaxFrames > 0 && b.ring.Readable >= b.MaxFrames {
+				b.advance(1)
+				b.overflow++
+			} else {
+				b.grow()
+			}
+		}
+		b.ring.WriteFrames([]*Frame{f})
+		b.slot()
+		b.version++
+		if c := b.newest - int64(b.Dur); c > b.cutoff {
+			b.cutoff = c
+		}
+		b.evict()
+	}
+	b.notify()
+	return nil
+}
+
+// Evict drops the frames more than Dur behind now, so that a
+// quiet stream still ages out on a display. It returns the
+// number of frames evicted.
+func (b *TimeFrameRingBuf) Evict(now time.Time) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c := now.UnixNano() - int64(b.Dur); c > b.cutoff {
+		b.cutoff = c
+	}
+	n := b.evict()
+	if n > 0 {
+		b.version++
+		b.notify()
+	}
+	return n
+}
+
+// Snapshot returns a copy of the frames held, in Tm() order, and
+// the version it reflects. The frames themselves are shared.
+func (b *TimeFrameRingBuf) Snapshot() ([]*Frame, uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.snapshot(), b.version
+}
+
+// SnapshotSeries returns a Snapshot as a Series,
+// ready for the Series search methods.
+func (b *TimeFrameRingBuf) SnapshotSeries() *Series {
+	frames, _ := b.Snapshot()
+	return NewSeriesFromFrames(frames)
+}
+
+// Version counts the changes so far: each frame appended,
+// and each Evict that dropped frames. It is the version
+// a Snapshot taken now would have.
+func (b *TimeFrameRingBuf) Version() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.version
+}
+
+// Wait blocks until the version has moved beyond since, as
+// frames are appended or evicted, returning the new version. Closing cancel, if not
+// nil, abandons the wait with ErrTimeRingCanceled.
+func (b *TimeFrameRingBuf) Wait(since uint64, cancel <-chan struct{}) (uint64, error) {
+	_, v, err := b.wait(since, cancel, false)
+	return v, err
+}
+
+// WaitSnapshot is Wait followed by a Snapshot, taken together
+// so that no frames slip in between.
+func (b *TimeFrameRingBuf) WaitSnapshot(since uint64, cancel <-chan struct{}) ([]*Frame, uint64, error) {
+	return b.wait(since, cancel, true)
+}
+
+func (b *TimeFrameRingBuf) wait(since uint64, cancel <-chan struct{}, snap bool) ([]*Frame, uint64, error) {
+	for {
+		b.mu.Lock()
+		if b.version > since {
+			var frames []*Frame
+			if snap {
+				frames = b.snapshot()
+			}
+			v := b.version
+			b.mu.Unlock()
+			return frames, v, nil
+		}
+		if b.closed {
+			v := b.version
+			b.mu.Unlock()
+			return nil, v, ErrTimeRingClosed
+		}
+		ch := b.changed
+		b.mu.Unlock()
+
+		select {
+		case <-ch:
+		case <-cancel:
+			return nil, since, ErrTimeRingCanceled
+		}
+	}
+}
+
+// Close wakes all waiters, and refuses further Appends.
+// Snapshots can still be taken.
+func (b *TimeFrameRingBuf) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.closed {
+		b.closed = true
+		b.notify()
+	}
+}
+
+// Len returns the number of frames held.
+func (b *TimeFrameRingBuf) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.ring.Readable
+}
+
+// Late returns the number of frames dropped because they
+// were already out of the window when they arrived, and
+// Overflow the number evicted early because of MaxFrames.
+func (b *TimeFrameRingBuf) Late() (late int64, overflow int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.late, b.overflow
+}
+
+// the rest are called with b.mu held.
+
+func (b *TimeFrameRingBuf) snapshot() []*Frame {
+	out := make([]*Frame, b.ring.Readable)
+	first, second := b.ring.TwoContig(false)
+	n := copy(out, first)
+	copy(out[n:], second)
+	return out
+}
+
+func (b *TimeFrameRingBuf) notify() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// slot moves the frame just written back into Tm() order.
+func (b *TimeFrameRingBuf) slot() {
+	r := b.ring
+	for k := r.Readable - 1; k > 0; k-- {
+		i := (r.Beg + k) % r.N
+		j := (r.Beg + k - 1) % r.N
+		if r.A[j].Tm() <= r.A[i].Tm() {
+			return
+		}
+		r.A[i], r.A[j] = r.A[j], r.A[i]
+	}
+}
+
+func (b *TimeFrameRingBuf) evict() int {
+	n := 0
+	for b.ring.Readable > 0 && b.ring.Kth(0).Tm() < b.cutoff {
+		b.advance(1)
+		n++
+	}
+	return n
+}
+
+// advance drops the oldest n frames, releasing them to the GC.
+func (b *TimeFrameRingBuf) advance(n int) {
+	r := b.ring
+	for k := 0; k < n && k < r.Readable; k++ {
+		r.A[(r.Beg+k)%r.N] = nil
+	}
+	r.Advance(n)
+}
+
+func (b *TimeFrameRingBuf) grow() {
+	n := 2 * b.ring.N
+	if b.MaxFrames > 0 && n > b.MaxFrames {
+		n = b.MaxFrames
+	}
+	bigger := NewFrameRingBuf(n)
+	bigger.WriteFrames(b.snapshot())
+	b.ring = bigger
+}
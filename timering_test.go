@@ -0,0 +1,113 @@
+package tm
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	cv "github.com/glycerine/goconvey/convey"
+)
+
+func Test170TimeFrameRingBufEvictsByAge(t *testing.T) {
+
+	mk := func(sec int) *Frame { return GenTestFrame(sec, EvOneInt64, 0, int64(sec), nil) }
+
+	cv.Convey("A TimeFrameRingBuf should hold only the last Dur of frames, in time order, growing as needed", t, func() {
+		b := NewTimeFrameRingBuf(100*time.Second, 0)
+		for i := 0; i < 300; i++ {
+			panicOn(b.Append(mk(i)))
+		}
+		frames, v := b.Snapshot()
+		cv.So(v, cv.ShouldEqual, 300)
+		cv.So(len(frames), cv.ShouldEqual, 101)
+		cv.So(frames[0].Ude, cv.ShouldEqual, 199)
+		cv.So(frames[100].Ude, cv.ShouldEqual, 299)
+
+		// out of order frames are slotted in; those already too old are dropped.
+		panicOn(b.Append(mk(250), mk(150)))
+		late, _ := b.Late()
+		cv.So(late, cv.ShouldEqual, 1)
+		s := b.SnapshotSeries()
+		cv.So(len(s.Frames), cv.ShouldEqual, 102)
+		f, status, _ := s.LastAtOrBefore(GenTestTime(250))
+		cv.So(status, cv.ShouldEqual, Avail)
+		cv.So(f.Ude, cv.ShouldEqual, 250)
+		for i := 1; i < len(s.Frames); i++ {
+			cv.So(s.Frames[i-1].Tm(), cv.ShouldBeLessThanOrEqualTo, s.Frames[i].Tm())
+		}
+
+		// a quiet stream ages out by the wall clock, as a new version.
+		_, v = b.Snapshot()
+		cv.So(b.Evict(GenTestTime(350)), cv.ShouldEqual, 51)
+		cv.So(b.Len(), cv.ShouldEqual, 51)
+		v2, err := b.Wait(v, nil)
+		panicOn(err)
+		cv.So(v2, cv.ShouldEqual, v+1)
+		cv.So(b.Evict(GenTestTime(350)), cv.ShouldEqual, 0)
+		cv.So(b.Version(), cv.ShouldEqual, v2)
+	})
+
+	cv.Convey("An Evict before the first Append should keep its cutoff", t, func() {
+		b := NewTimeFrameRingBuf(100*time.Second, 0)
+		cv.So(b.Evict(GenTestTime(200)), cv.ShouldEqual, 0)
+		panicOn(b.Append(mk(50), mk(150), mk(120), mk(80)))
+		frames, _ := b.Snapshot()
+		cv.So(len(frames), cv.ShouldEqual, 2)
+		cv.So(frames[0].Ude, cv.ShouldEqual, 120)
+		cv.So(frames[1].Ude, cv.ShouldEqual, 150)
+		late, _ := b.Late()
+		cv.So(late, cv.ShouldEqual, 2)
+	})
+
+	cv.Convey("MaxFrames should bound a TimeFrameRingBuf", t, func() {
+		b := NewTimeFrameRingBuf(time.Hour, 10)
+		for i := 0; i < 25; i++ {
+			panicOn(b.Append(mk(i)))
+		}
+		frames, _ := b.Snapshot()
+		cv.So(len(frames), cv.ShouldEqual, 10)
+		cv.So(frames[0].Ude, cv.ShouldEqual, 15)
+		_, overflow := b.Late()
+		cv.So(overflow, cv.ShouldEqual, 15)
+	})
+
+	cv.Convey("Readers should be able to wait for new frames, concurrently with a writer, and be released by Close", t, func() {
+		b := NewTimeFrameRingBuf(time.Hour, 0)
+		var wg sync.WaitGroup
+		var seen []int
+		var waitErr error
+		consistent := true
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var v uint64
+			for {
+				frames, nv, err := b.WaitSnapshot(v, nil)
+				if err != nil {
+					waitErr = err
+					return
+				}
+				if nv <= v || len(frames) != int(nv) {
+					consistent = false
+				}
+				seen = append(seen, len(frames))
+				v = nv
+			}
+		}()
+		for i := 0; i < 50; i++ {
+			panicOn(b.Append(mk(i)))
+		}
+		b.Close()
+		wg.Wait()
+		cv.So(waitErr, cv.ShouldEqual, ErrTimeRingClosed)
+		cv.So(consistent, cv.ShouldBeTrue)
+		cv.So(seen[len(seen)-1], cv.ShouldEqual, 50)
+		cv.So(b.Append(mk(51)), cv.ShouldEqual, ErrTimeRingClosed)
+
+		cancel := make(chan struct{})
+		close(cancel)
+		b2 := NewTimeFrameRingBuf(time.Hour, 0)
+		_, err := b2.Wait(0, cancel)
+		cv.So(err, cv.ShouldEqual, ErrTimeRingCanceled)
+	})
+}
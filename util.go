@@ -61,6 +61,23 @@ func GenTestFrames(n int, outpath *string) (frames []*Frame, tms []time.Time, by
 	return
 }
 
+// GenTestT0 is the time, 2016-03-10T00:00:00Z, that GenTestTime
+// and GenTestFrame count their seconds from.
+var GenTestT0 = time.Date(2016, 3, 10, 0, 0, 0, 0, time.UTC)
+
+// GenTestTime returns the time sec seconds after GenTestT0.
+func GenTestTime(sec int) time.Time {
+	return GenTestT0.Add(time.Duration(sec) * time.Second)
+}
+
+// GenTestFrame makes a single test Frame, sec seconds after
+// GenTestT0, and panics on error.
+func GenTestFrame(sec int, evtnum Evtnum, v0 float64, v1 int64, data []byte) *Frame {
+	f, err := NewFrame(GenTestTime(sec), evtnum, v0, v1, data)
+	panicOn(err)
+	return f
+}
+
 func cryptoRandNonNegInt() int {
 	b := make([]byte, 8)
 	_, err := cryptorand.Read(b)